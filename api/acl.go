@@ -0,0 +1,185 @@
+package api
+
+import (
+	"americanas/auth"
+	"americanas/storagedata"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// aclRequest is the body of PUT /v2/acls/*path.
+type aclRequest struct {
+	Grants map[string][]storagedata.Permission `json:"grants"`
+}
+
+func (api *Api) registerACLRouters(router routes) {
+	router.GET("/v2/acls", api.listACLs)
+	router.PUT("/v2/acls/*path", api.setACL)
+	router.DELETE("/v2/acls/*path", api.deleteACL)
+}
+
+// listACLs answers the ACLs the caller may edit, sorted by path.
+func (api *Api) listACLs(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	statusCode, acc, err := api.access(r)
+	if err != nil {
+		fmt.Printf("[listACLs] Error in access with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+
+	list := make([]storagedata.ACL, 0, len(acc.acls))
+	for _, acl := range acc.acls {
+		if acc.can(storagedata.PermAdmin, acl.Path) {
+			list = append(list, acl)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Path < list[j].Path
+	})
+	api.send(w, http.StatusOK, list)
+}
+
+func (api *Api) setACL(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var req aclRequest
+	err := json.NewDecoder(limitBody(r.Body, IOMaxBufferSize)).Decode(&req)
+	if err != nil {
+		fmt.Printf("[setACL] Error in readBody. error %v", err.Error())
+		api.send(w, http.StatusBadRequest, invalidBody(err))
+		return
+	}
+
+	api.saveACL(w, r, "setACL", storagedata.ACL{Path: dirParam(ps), Grants: req.Grants})
+}
+
+func (api *Api) deleteACL(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	api.saveACL(w, r, "deleteACL", storagedata.ACL{Path: dirParam(ps)})
+}
+
+// saveACL stores acl, removing it when it has no grants, if the caller is an
+// admin of its path.
+func (api *Api) saveACL(w http.ResponseWriter, r *http.Request, handler string, acl storagedata.ACL) {
+	if statusCode, err := api.authorize(r, storagedata.PermAdmin, acl.Path); err != nil {
+		fmt.Printf("[%s] Error in authorize with statusCode: %v - error %v", handler, statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+
+	statusCode, acl, err := api.storageDocument.SetACL(acl)
+	if err != nil {
+		fmt.Printf("[%s] Error in %s with statusCode: %v - error %v", handler, handler, statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+
+	if len(acl.Grants) == 0 {
+		api.send(w, http.StatusNoContent, nil)
+		return
+	}
+	api.send(w, http.StatusOK, acl)
+}
+
+// access is what the caller of a request may do.
+type access struct {
+	owner string
+	acls  storagedata.ACLs
+}
+
+func (api *Api) access(r *http.Request) (int, access, error) {
+	statusCode, acls, err := api.storageDocument.ACLs()
+	if err != nil {
+		return statusCode, access{}, err
+	}
+	return http.StatusOK, access{owner: auth.Owner(r.Context()), acls: acls}, nil
+}
+
+func (acc access) can(perm storagedata.Permission, p string) bool {
+	return acc.acls.Allows(acc.owner, p, perm)
+}
+
+func (acc access) canRead(entry storagedata.FileMetadata) bool {
+	return acc.can(storagedata.PermRead, entry.Path)
+}
+
+// readable keeps the files the caller may read.
+func (acc access) readable(files map[string]storagedata.FileMetadata) map[string]storagedata.FileMetadata {
+	return acc.acls.Readable(acc.owner, files)
+}
+
+// visibleListing drops from listing the files the caller may not read and
+// the subdirectories leading to nothing they may read.
+func (acc access) visibleListing(listing storagedata.DirListing) storagedata.DirListing {
+	if len(acc.acls) == 0 {
+		return listing
+	}
+
+	dirs := make([]string, 0, len(listing.Directories))
+	for _, d := range listing.Directories {
+		if acc.acls.Reaches(acc.owner, path.Join(listing.Path, d)) {
+			dirs = append(dirs, d)
+		}
+	}
+	files := make([]storagedata.FileMetadata, 0, len(listing.Files))
+	for _, file := range listing.Files {
+		if acc.canRead(file) {
+			files = append(files, file)
+		}
+	}
+	listing.Directories, listing.Files = dirs, files
+	return listing
+}
+
+func (acc access) forbidden(perm storagedata.Permission, p string) error {
+	return storagedata.Forbidden(acc.owner, perm, p)
+}
+
+// authorize fails with storagedata.ErrForbidden unless the caller holds perm
+// on every one of paths.
+func (api *Api) authorize(r *http.Request, perm storagedata.Permission, paths ...string) (int, error) {
+	return api.storageDocument.Authorize(auth.Owner(r.Context()), perm, paths...)
+}
+
+// authorizeTree is authorize on dir and every directory below it.
+func (api *Api) authorizeTree(r *http.Request, perm storagedata.Permission, dir string) (int, error) {
+	return api.storageDocument.AuthorizeTree(auth.Owner(r.Context()), perm, dir)
+}
+
+// authorizeFile is authorize on the path of the file id, which is returned.
+func (api *Api) authorizeFile(r *http.Request, perm storagedata.Permission, id string) (int, storagedata.FileMetadata, error) {
+	statusCode, file, err := api.storageDocument.ByID(id)
+	if err != nil {
+		return statusCode, file, err
+	}
+
+	statusCode, err = api.authorize(r, perm, file.Path)
+	return statusCode, file, err
+}
+
+// authorizeTrashed is authorize on the path the trashed file id is restored
+// to.
+func (api *Api) authorizeTrashed(r *http.Request, perm storagedata.Permission, id string) (int, error) {
+	statusCode, files, err := api.storageDocument.Trash()
+	if err != nil {
+		return statusCode, err
+	}
+
+	for _, item := range files {
+		if item.File.ID == id {
+			return api.authorize(r, perm, item.File.Path)
+		}
+	}
+	return http.StatusNotFound, fmt.Errorf("trashed file %s: %w", id, storagedata.ErrNotFound)
+}
+
+// targetDir is the directory a file is moved or copied to: toDir, or the one
+// it is in when toDir is empty.
+func targetDir(file storagedata.FileMetadata, toDir string) string {
+	if toDir != "" {
+		return toDir
+	}
+	return path.Dir(file.Path)
+}
@@ -0,0 +1,145 @@
+package api_test
+
+import (
+	"americanas/api"
+	"americanas/storagedata"
+	"americanas/test"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// aclFixture lets everyone read, the admin administer everything, and keeps
+// finance to alice and secret to bob.
+func aclFixture(t *testing.T) *fixture {
+	fixture := setup(t, api.WithAuthenticator(fakeAuthenticator{}))
+	fixture.storage.status = http.StatusOK
+	fixture.storage.acls = storagedata.ACLs{
+		"": {Path: "", Grants: map[string][]storagedata.Permission{
+			"admin":              {storagedata.PermAdmin},
+			storagedata.Everyone: {storagedata.PermRead},
+		}},
+		"finance": {Path: "finance", Grants: map[string][]storagedata.Permission{
+			"alice": {storagedata.PermRead, storagedata.PermWrite},
+		}},
+		"secret": {Path: "secret", Grants: map[string][]storagedata.Permission{
+			"bob": {storagedata.PermRead},
+		}},
+	}
+	fixture.storage.file = storagedata.FileMetadata{ID: "aab053840116dacaf13a062d909e5761", Name: "report.pdf", Path: "finance/report.pdf"}
+	return fixture
+}
+
+func (f *fixture) requestAs(url, method string, body io.Reader) (int, string) {
+	req := f.createRequest(url, method, body)
+	req.Header.Set("X-API-Key", "k3y")
+	status, returnBody, _ := f.sendRequest(req)
+	return status, returnBody
+}
+
+func TestACLEnforced(t *testing.T) {
+	testCase := "test-acl-enforced"
+	fixture := aclFixture(t)
+
+	status, _ := fixture.requestAs("v2/files/aab053840116dacaf13a062d909e5761", "GET", nil)
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+
+	status, returnBody := fixture.requestAs("v2/files/aab053840116dacaf13a062d909e5761", "DELETE", nil)
+	test.AssertEqual(t, testCase, status, http.StatusForbidden)
+	test.AssertEqual(t, testCase, strings.Contains(returnBody, `"code":"forbidden"`), true)
+
+	status, _ = fixture.requestAs("v2/files/aab053840116dacaf13a062d909e5761/copy", "POST", strings.NewReader(`{"directory":"space"}`))
+	test.AssertEqual(t, testCase, status, http.StatusForbidden)
+	status, _ = fixture.requestAs("v2/files/aab053840116dacaf13a062d909e5761/copy", "POST", strings.NewReader(`{"name":"report-2.pdf"}`))
+	test.AssertEqual(t, testCase, status, http.StatusCreated)
+
+	status, _ = fixture.requestAs("v2/dirs", "POST", strings.NewReader(`{"path":"space/comets"}`))
+	test.AssertEqual(t, testCase, status, http.StatusForbidden)
+	status, _ = fixture.requestAs("v2/dirs/finance", "DELETE", nil)
+	test.AssertEqual(t, testCase, status, http.StatusForbidden)
+
+	fixture.storage.file.Path = "secret/plans.txt"
+	status, _ = fixture.requestAs("v2/files/aab053840116dacaf13a062d909e5761/content", "GET", nil)
+	test.AssertEqual(t, testCase, status, http.StatusForbidden)
+	status, _ = fixture.requestAs("v2/scrub", "GET", nil)
+	test.AssertEqual(t, testCase, status, http.StatusForbidden)
+}
+
+func TestACLFiltersListings(t *testing.T) {
+	testCase := "test-acl-filters-listings"
+	fixture := aclFixture(t)
+	fixture.storage.files = map[string]storagedata.FileMetadata{
+		"1": {ID: "1", Name: "report.pdf", Path: "finance/report.pdf"},
+		"2": {ID: "2", Name: "mars.png", Path: "space/mars.png"},
+		"3": {ID: "3", Name: "plans.txt", Path: "secret/plans.txt"},
+	}
+
+	status, returnBody := fixture.requestAs("allfiles", "GET", nil)
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+	var files map[string]storagedata.FileMetadata
+	json.Unmarshal([]byte(returnBody), &files)
+	test.AssertEqual(t, testCase, len(files), 2)
+	test.AssertEqual(t, testCase, files["3"].ID, "")
+
+	fixture.requestAs("v2/files", "GET", nil)
+	test.AssertEqual(t, testCase, fixture.storage.list.Visible(files["1"]), true)
+	test.AssertEqual(t, testCase, fixture.storage.list.Visible(storagedata.FileMetadata{Path: "secret/plans.txt"}), false)
+
+	fixture.storage.acls["secret/monthly"] = storagedata.ACL{Path: "secret/monthly", Grants: map[string][]storagedata.Permission{
+		"bob": {storagedata.PermRead},
+	}}
+	status, _ = fixture.requestAs("v2/dirs/secret/monthly", "GET", nil)
+	test.AssertEqual(t, testCase, status, http.StatusForbidden)
+	status, _ = fixture.requestAs("v2/dirs/secret", "GET", nil)
+	test.AssertEqual(t, testCase, status, http.StatusForbidden)
+	status, returnBody = fixture.requestAs("v2/dirs/space", "GET", nil)
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+	var listing storagedata.DirListing
+	json.Unmarshal([]byte(returnBody), &listing)
+	test.AssertEqual(t, testCase, listing.Directories, []string{"monthly"})
+	test.AssertEqual(t, testCase, len(listing.Files), 2)
+}
+
+func TestEditACLs(t *testing.T) {
+	testCase := "test-edit-acls"
+	fixture := aclFixture(t)
+	body := `{"grants":{"alice":["read"],"carol":["read","write"]}}`
+
+	status, _ := fixture.requestAs("v2/acls/finance/2020", "PUT", strings.NewReader(body))
+	test.AssertEqual(t, testCase, status, http.StatusForbidden)
+
+	fixture.storage.acls["finance"].Grants["alice"] = []storagedata.Permission{storagedata.PermAdmin}
+	status, returnBody := fixture.requestAs("v2/acls/finance/2020", "PUT", strings.NewReader(body))
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+	test.AssertEqual(t, testCase, returnBody, `{"path":"finance/2020","grants":{"alice":["read"],"carol":["read","write"]}}`)
+
+	status, returnBody = fixture.requestAs("v2/acls", "GET", nil)
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+	var acls []storagedata.ACL
+	json.Unmarshal([]byte(returnBody), &acls)
+	test.AssertEqual(t, testCase, len(acls), 2)
+	test.AssertEqual(t, testCase, acls[0].Path, "finance")
+	test.AssertEqual(t, testCase, acls[1].Path, "finance/2020")
+
+	status, _ = fixture.requestAs("v2/acls/finance/2020", "DELETE", nil)
+	test.AssertEqual(t, testCase, status, http.StatusNoContent)
+	_, ok := fixture.storage.acls["finance/2020"]
+	test.AssertEqual(t, testCase, ok, false)
+
+	status, _ = fixture.requestAs("v2/acls/", "DELETE", nil)
+	test.AssertEqual(t, testCase, status, http.StatusForbidden)
+}
+
+func TestFirstRootACL(t *testing.T) {
+	testCase := "test-first-root-acl"
+	fixture := authedFixture(t)
+
+	// With no ACL at all, no caller may make themselves admin.
+	status, _ := fixture.requestAs("v2/acls/", "PUT", strings.NewReader(`{"grants":{"alice":["admin"]}}`))
+	test.AssertEqual(t, testCase, status, http.StatusForbidden)
+	test.AssertEqual(t, testCase, len(fixture.storage.acls), 0)
+	status, _ = fixture.requestAs("v2/quotas", "PUT", strings.NewReader(`{"owner":"alice","maxBytes":1}`))
+	test.AssertEqual(t, testCase, status, http.StatusForbidden)
+}
@@ -1,36 +1,96 @@
 package api
 
 import (
-	"bytes"
+	"americanas/auth"
+	"americanas/storagedata"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
+	"os"
+	"path"
 	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 )
 
 type Api struct {
 	storageDocument Storage
+	maxUploadSize   int64
+	spoolDir        string
+	authenticator   Authenticator
+	presignKey      []byte
+}
+
+type Option func(*Api)
+
+// WithMaxUploadSize caps the size of an upload; 0 means no limit.
+func WithMaxUploadSize(n int64) Option {
+	return func(api *Api) {
+		api.maxUploadSize = n
+	}
+}
+
+// WithSpoolDir sets where uploads whose file part arrives before the fields
+// it depends on are kept; the system temporary directory is used otherwise.
+func WithSpoolDir(dir string) Option {
+	return func(api *Api) {
+		api.spoolDir = dir
+	}
 }
 
 type Storage interface {
-	StorageFile(body map[string]interface{}) (int, []byte, map[string]interface{}, error)
-	AllFiles() (int, []byte, error)
-	UnderDir(dir string) (int, []byte, error)
-	ByID(id string) (int, []byte, error)
+	StorageFile(req storagedata.UploadRequest) (int, storagedata.FileMetadata, error)
+	AllFiles() (int, map[string]storagedata.FileMetadata, error)
+	UnderDir(dir string) (int, map[string]storagedata.FileMetadata, error)
+	List(opts storagedata.ListOptions) (int, storagedata.ListResult, error)
+	ListDir(dir string) (int, storagedata.DirListing, error)
+	CreateDir(dir string) (int, error)
+	MoveDir(dir, toDir string) (int, error)
+	DeleteDir(dir string, dryRun bool) (int, []storagedata.FileMetadata, error)
+	ByID(id string) (int, storagedata.FileMetadata, error)
 	MoveFile(id, toDir string) (int, error)
+	RenameFile(id, toDir, name string, policy storagedata.ConflictPolicy) (int, storagedata.FileMetadata, error)
+	CopyFile(id, toDir, name, owner string, policy storagedata.ConflictPolicy) (int, storagedata.FileMetadata, error)
 	DeleteByID(id string) (int, error)
-	OverwriteFile(id string, body map[string]interface{}) (int, []byte, error)
+	OverwriteFile(id string, req storagedata.UploadRequest) (int, storagedata.FileMetadata, error)
+	OpenByPath(path string) (int, *os.File, storagedata.FileMetadata, error)
+	OpenByID(id string) (int, *os.File, storagedata.FileMetadata, error)
+	Versions(id string) (int, []storagedata.FileVersion, error)
+	OpenVersion(id string, version int) (int, *os.File, storagedata.FileMetadata, error)
+	RestoreVersion(id string, version int) (int, storagedata.FileMetadata, error)
+	PruneVersions(id string, keep int, olderThan time.Duration) (int, []storagedata.FileVersion, error)
+	Trash() (int, []storagedata.TrashedFile, error)
+	RestoreFile(id string, policy storagedata.ConflictPolicy) (int, storagedata.FileMetadata, error)
+	PurgeTrash(id string) (int, error)
+	Scrub() (int, storagedata.ScrubReport, error)
+	ACLs() (int, storagedata.ACLs, error)
+	SetACL(acl storagedata.ACL) (int, storagedata.ACL, error)
+	Authorize(owner string, perm storagedata.Permission, paths ...string) (int, error)
+	AuthorizeTree(owner string, perm storagedata.Permission, dir string) (int, error)
+	CreateShare(req storagedata.ShareRequest) (int, storagedata.Share, error)
+	Shares() (int, []storagedata.Share, error)
+	RevokeShare(token string) (int, error)
+	OpenShare(token, password string) (int, storagedata.Share, error)
+	Quotas() (int, []storagedata.QuotaUsage, error)
+	SetQuota(q storagedata.Quota) (int, storagedata.Quota, error)
+	Usage(owner string) (int, storagedata.Usage, error)
+	CreateUpload(length int64, metadata map[string]string) (int, storagedata.Upload, error)
+	GetUpload(id string) (int, storagedata.Upload, error)
+	WriteUpload(id string, offset int64, r io.Reader) (int, storagedata.Upload, error)
+	DeleteUpload(id string) (int, error)
 }
 
 var (
 	IOMaxBufferSize = int64(16000000)
 )
 
-func (api *Api) RegisterRouters(router *httprouter.Router) {
+func (api *Api) RegisterRouters(r *httprouter.Router) {
+	router := routes{Router: r, api: api}
 	router.POST("/sendfile", api.sendFile)
 	router.GET("/allfiles", api.allFiles)
 	router.GET("/underdir", api.underDir)
@@ -38,23 +98,39 @@ func (api *Api) RegisterRouters(router *httprouter.Router) {
 	router.POST("/movefile", api.moveFile)
 	router.POST("/delete", api.delete)
 	router.POST("/overwrite", api.overwrite)
-	router.ServeFiles("/storagedata/*filepath", http.Dir("/home/mateus-mello/go/src/americanas/storagedata"))
+	router.GET("/storagedata/*filepath", api.download)
+	router.GET("/files/:id/content", api.content)
+	router.HEAD("/files/:id/content", api.content)
+	api.registerTusRouters(router)
+	api.registerV2Routers(router)
+	api.registerVersionRouters(router)
+	api.registerTrashRouters(router)
+	api.registerACLRouters(router)
+	api.registerPresignRouters(router)
+	api.registerShareRouters(router)
+	api.registerQuotaRouters(router)
+	router.GET("/v2/scrub", api.scrub)
 
 }
 
 func (api *Api) sendFile(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	record, err := api.readBodyMultiPart(w, r)
+	req, cleanup, err := api.readBodyMultiPart(w, r, "path")
 	if err != nil {
 		fmt.Println("[sendFile] Error in read body:", err.Error())
-		errMap := map[string]interface{}{"error": "Invalid body"}
-		api.send(w, http.StatusBadRequest, errMap)
+		api.send(w, http.StatusBadRequest, invalidBody(err))
+		return
+	}
+	defer cleanup()
+	statusCode, err := api.authorize(r, storagedata.PermWrite, req.Path)
+	if err != nil {
+		fmt.Printf("[sendFile] Error in authorize with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
 		return
 	}
-	statusCode, _, _, err := api.storageDocument.StorageFile(record)
-	if statusCode != http.StatusOK {
-		fmt.Printf("[sendFile] Error in sendFile with statusCode: %v - error %v", statusCode, err.Error())
-		errMap := map[string]interface{}{"error": err.Error()}
-		api.send(w, http.StatusBadRequest, errMap)
+	statusCode, _, err = api.storageDocument.StorageFile(req)
+	if err != nil {
+		fmt.Printf("[sendFile] Error in sendFile with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
 		return
 	}
 
@@ -64,49 +140,53 @@ func (api *Api) sendFile(w http.ResponseWriter, r *http.Request, ps httprouter.P
 }
 
 func (api *Api) allFiles(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	statusCode, body, err := api.storageDocument.AllFiles()
-	if statusCode != http.StatusOK {
-		fmt.Printf("[allFiles] Error in allFiles with statusCode: %v - error %v", statusCode, err.Error())
-		errMap := map[string]interface{}{"error": err.Error()}
-		api.send(w, http.StatusBadRequest, errMap)
+	statusCode, acc, err := api.access(r)
+	if err != nil {
+		fmt.Printf("[allFiles] Error in access with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+	statusCode, files, err := api.storageDocument.AllFiles()
+	if err != nil {
+		fmt.Printf("[allFiles] Error in allFiles with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
 		return
 	}
-	var responseMap map[string]interface{}
-	_ = json.Unmarshal(body, &responseMap)
 
 	w.Header().Set("Location", "/allfiles")
-	api.send(w, statusCode, responseMap)
+	api.send(w, statusCode, acc.readable(files))
 }
 
 func (api *Api) underDir(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	url := api.getKeyFromURL(*r.URL)
-	statusCode, body, err := api.storageDocument.UnderDir(url)
-	if statusCode != http.StatusOK {
-		fmt.Printf("[underDir] Error in underDir with statusCode: %v - error %v", statusCode, err.Error())
-		errMap := map[string]interface{}{"error": err.Error()}
-		api.send(w, http.StatusBadRequest, errMap)
+	statusCode, acc, err := api.access(r)
+	if err != nil {
+		fmt.Printf("[underDir] Error in access with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+	statusCode, files, err := api.storageDocument.UnderDir(url)
+	if err != nil {
+		fmt.Printf("[underDir] Error in underDir with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
 		return
 	}
 
 	w.Header().Set("Location", "/underdir?data="+url)
-	api.send(w, statusCode, body)
+	api.send(w, statusCode, acc.readable(files))
 }
 
 func (api *Api) byID(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	url := api.getKeyFromURL(*r.URL)
-	statusCode, body, err := api.storageDocument.ByID(url)
-	if statusCode != http.StatusOK {
-		fmt.Printf("[byID] Error in byID with statusCode: %v - error %v", statusCode, err.Error())
-		errMap := map[string]interface{}{"error": err.Error()}
-		api.send(w, http.StatusBadRequest, errMap)
+	statusCode, file, err := api.authorizeFile(r, storagedata.PermRead, url)
+	if err != nil {
+		fmt.Printf("[byID] Error in byID with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
 		return
 	}
 
-	var responseMap map[string]interface{}
-	_ = json.Unmarshal(body, &responseMap)
-
 	w.Header().Set("Location", "/byid?data="+url)
-	api.send(w, statusCode, responseMap)
+	api.send(w, statusCode, file)
 }
 
 func (api *Api) moveFile(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
@@ -114,22 +194,33 @@ func (api *Api) moveFile(w http.ResponseWriter, r *http.Request, ps httprouter.P
 	body, err := api.readBody(w, r.Body)
 	if err != nil {
 		fmt.Printf("[moveFile] Error in readBody. error %v", err.Error())
-		errMap := map[string]interface{}{"error": err.Error()}
-		api.send(w, http.StatusBadRequest, errMap)
+		api.send(w, http.StatusBadRequest, invalidBody(err))
 		return
 	}
 	toDir, ok := body["directory"].(string)
 	if !ok {
+		err = fmt.Errorf("missing field directory: %w", storagedata.ErrInvalidPath)
 		fmt.Printf("[moveFile] Error in body. error %v", err.Error())
-		errMap := map[string]interface{}{"error": err.Error()}
-		api.send(w, http.StatusBadRequest, errMap)
+		api.send(w, http.StatusBadRequest, err)
+		return
+	}
+	statusCode, _, err := api.authorizeFile(r, storagedata.PermDelete, id)
+	if err == nil {
+		statusCode, err = api.authorize(r, storagedata.PermWrite, toDir)
+	}
+	if err != nil {
+		fmt.Printf("[moveFile] Error in authorize with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
 		return
 	}
-	statusCode, err := api.storageDocument.MoveFile(id, toDir)
-	if statusCode != http.StatusOK {
-		fmt.Printf("[moveFile] Error in moveFile with statusCode: %v - error %v", statusCode, err.Error())
-		errMap := map[string]interface{}{"error": err.Error()}
-		api.send(w, http.StatusBadRequest, errMap)
+	if conflict, ok := body["conflict"].(string); ok {
+		statusCode, _, err = api.storageDocument.RenameFile(id, toDir, "", storagedata.ConflictPolicy(conflict))
+	} else {
+		statusCode, err = api.storageDocument.MoveFile(id, toDir)
+	}
+	if err != nil {
+		fmt.Printf("[moveFile] Error in moveFile with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
 		return
 	}
 	w.Header().Set("Location", "/movefile?data="+id)
@@ -140,11 +231,16 @@ func (api *Api) moveFile(w http.ResponseWriter, r *http.Request, ps httprouter.P
 func (api *Api) delete(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	id := api.getKeyFromURL(*r.URL)
 
-	statusCode, err := api.storageDocument.DeleteByID(id)
-	if statusCode != http.StatusOK {
-		fmt.Printf("[delete] Error in delete with statusCode: %v - error %v", statusCode, err.Error())
-		errMap := map[string]interface{}{"error": err.Error()}
-		api.send(w, http.StatusBadRequest, errMap)
+	statusCode, _, err := api.authorizeFile(r, storagedata.PermDelete, id)
+	if err != nil {
+		fmt.Printf("[delete] Error in authorize with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+	statusCode, err = api.storageDocument.DeleteByID(id)
+	if err != nil {
+		fmt.Printf("[delete] Error in delete with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
 		return
 	}
 
@@ -155,28 +251,25 @@ func (api *Api) delete(w http.ResponseWriter, r *http.Request, ps httprouter.Par
 
 func (api *Api) overwrite(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	id := api.getKeyFromURL(*r.URL)
-	body, err := api.readBodyMultiPart(w, r)
+	req, cleanup, err := api.readBodyMultiPart(w, r)
 	if err != nil {
 		fmt.Printf("[overwrite] Error in readBody. error %v", err.Error())
-		errMap := map[string]interface{}{"error": fmt.Errorf("[overwrite] Erro in API %s", err.Error())}
-		api.send(w, http.StatusBadRequest, errMap)
+		api.send(w, http.StatusBadRequest, invalidBody(err))
 		return
 	}
+	defer cleanup()
 
-	_, file, _ := api.storageDocument.ByID(id)
-	var m map[string]interface{}
-	if err := json.Unmarshal(file, &m); err != nil {
-		errMap := map[string]interface{}{"error": fmt.Errorf("[overwrite] Erro in API %s", err.Error())}
-		api.send(w, http.StatusBadRequest, errMap)
+	statusCode, file, err := api.authorizeFile(r, storagedata.PermWrite, id)
+	if err != nil {
+		fmt.Printf("[overwrite] Error in byID with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
 		return
 	}
-	hh := strings.Split(m["path"].(string), "/")
-	body["path"] = strings.Join(hh[:len(hh)-1], "/")
-	statusCode, ret, err := api.storageDocument.OverwriteFile(id, body)
-	if statusCode != http.StatusOK {
-		fmt.Printf("[overwrite] Error in overwrite with statusCode: %v - error %v", statusCode, err.Error())
-		errMap := map[string]interface{}{"error": err.Error()}
-		api.send(w, http.StatusBadRequest, errMap)
+	req.Path = path.Dir(file.Path)
+	statusCode, ret, err := api.storageDocument.OverwriteFile(id, req)
+	if err != nil {
+		fmt.Printf("[overwrite] Error in overwrite with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
 		return
 	}
 
@@ -184,6 +277,25 @@ func (api *Api) overwrite(w http.ResponseWriter, r *http.Request, ps httprouter.
 	api.send(w, statusCode, ret)
 }
 
+func (api *Api) download(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	path := ps.ByName("filepath")
+	statusCode, err := api.authorize(r, storagedata.PermRead, strings.TrimPrefix(path, "/"))
+	if err != nil {
+		fmt.Printf("[download] Error in authorize with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+	statusCode, file, metadata, err := api.storageDocument.OpenByPath(path)
+	if err != nil {
+		fmt.Printf("[download] Error in download with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+	defer file.Close()
+
+	serveEntry(w, r, file, metadata)
+}
+
 func (api *Api) getKeyFromURL(url url.URL) string {
 	keys, ok := url.Query()["data"]
 	if !ok || len(keys[0]) < 1 {
@@ -193,42 +305,119 @@ func (api *Api) getKeyFromURL(url url.URL) string {
 	return keys[0]
 }
 
-func (api *Api) readBodyMultiPart(w http.ResponseWriter, r *http.Request) (map[string]interface{}, error) {
-	// 10 << 20 specifies a maximum upload of 10 MB files
-	r.ParseMultipartForm(10 << 20)
-
-	file, handler, err := r.FormFile("file")
-	var buf bytes.Buffer
-	io.Copy(&buf, file)
+// readBodyMultiPart streams the "file" part instead of buffering it in
+// memory. The part is handed over as soon as every field in required has been
+// read; when the file comes first it is spooled to disk until they arrive.
+// Checksums sent in a Content-MD5 or Digest header are checked against the
+// file. cleanup must be called once the record has been consumed.
+func (api *Api) readBodyMultiPart(w http.ResponseWriter, r *http.Request, required ...string) (storagedata.UploadRequest, func(), error) {
+	if api.maxUploadSize > 0 {
+		r.Body = limitBody(r.Body, api.maxUploadSize)
+	}
 
+	var req storagedata.UploadRequest
+	digest, err := storagedata.DigestFromHeaders(r.Header)
 	if err != nil {
-		return nil, err
+		return req, nil, err
+	}
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return req, nil, err
 	}
-	defer file.Close()
 
-	record := make(map[string]interface{})
+	fields := make(map[string]string)
+	cleanup := func() {}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			cleanup()
+			return req, nil, err
+		}
+
+		if part.FormName() != "file" {
+			value, err := ioutil.ReadAll(io.LimitReader(part, IOMaxBufferSize))
+			if err != nil {
+				cleanup()
+				return req, nil, err
+			}
+			fields[part.FormName()] = string(value)
+			continue
+		}
+
+		req.Name = part.FileName()
+		req.ContentType = part.Header.Get("Content-Type")
+		if hasFields(fields, required) {
+			req.Path = fields["path"]
+			req.Conflict = storagedata.ConflictPolicy(fields["conflict"])
+			req.Content = part
+			req.Digest = digest
+			req.Owner = auth.Owner(r.Context())
+			return req, cleanup, nil
+		}
+
+		spool, err := ioutil.TempFile(api.spoolDir, "upload-")
+		if err != nil {
+			cleanup()
+			return req, nil, err
+		}
+		cleanup = func() {
+			spool.Close()
+			os.Remove(spool.Name())
+		}
+		if _, err := io.Copy(spool, part); err != nil {
+			cleanup()
+			return req, nil, err
+		}
+		if _, err := spool.Seek(0, io.SeekStart); err != nil {
+			cleanup()
+			return req, nil, err
+		}
+		req.Content = spool
+	}
 
-	record["name"] = handler.Filename
-	record["size"] = handler.Size
-	record["type"] = handler.Header.Get("Content-Type")
-	record["file"] = buf
-	record["path"] = r.FormValue("path")
-	return record, err
+	if req.Content == nil {
+		cleanup()
+		return req, nil, http.ErrMissingFile
+	}
+	req.Path = fields["path"]
+	req.Conflict = storagedata.ConflictPolicy(fields["conflict"])
+	req.Digest = digest
+	req.Owner = auth.Owner(r.Context())
+	return req, cleanup, nil
+}
+
+func hasFields(record map[string]string, fields []string) bool {
+	for _, field := range fields {
+		if _, ok := record[field]; !ok {
+			return false
+		}
+	}
+	return true
 }
 
 func (api *Api) readBody(w http.ResponseWriter, body io.ReadCloser) (map[string]interface{}, error) {
-	body = http.MaxBytesReader(w, body, IOMaxBufferSize)
+	body = limitBody(body, IOMaxBufferSize)
 	var record map[string]interface{}
 	err := json.NewDecoder(body).Decode(&record)
 	return record, err
 }
 
+// send writes value as JSON. An error is written as an errorResponse, with
+// the status taken from the storagedata error it matches; statusCode is only
+// used for errors that match none.
 func (api *Api) send(w http.ResponseWriter, statusCode int, value interface{}) {
-	w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, TRACE, GET, HEAD, POST, PUT")
+	if err, ok := value.(error); ok {
+		statusCode, value = errorBody(statusCode, err)
+	}
+
+	w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, TRACE, GET, HEAD, POST, PUT, PATCH, DELETE")
 	w.Header().Set("Access-Control-Allow-Credentials", "true")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Accept, X-Requested-With")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key, Accept, X-Requested-With")
 	w.WriteHeader(statusCode)
 
 	if value != nil {
@@ -236,9 +425,93 @@ func (api *Api) send(w http.ResponseWriter, statusCode int, value interface{}) {
 	}
 }
 
-func New(storageDocument Storage) *Api {
+// errorResponse is the body of every failed request. Quota is the quota a
+// refused write didn't fit in.
+type errorResponse struct {
+	Code    string                  `json:"code"`
+	Message string                  `json:"message"`
+	Quota   *storagedata.QuotaUsage `json:"quota,omitempty"`
+}
+
+var errorCodes = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{storagedata.ErrNotFound, http.StatusNotFound, "not_found"},
+	{storagedata.ErrConflict, http.StatusConflict, "conflict"},
+	{storagedata.ErrInvalidPath, http.StatusBadRequest, "invalid_path"},
+	{storagedata.ErrInvalidRequest, http.StatusBadRequest, "invalid_request"},
+	{storagedata.ErrTooLarge, http.StatusRequestEntityTooLarge, "too_large"},
+	{storagedata.ErrChecksumMismatch, http.StatusBadRequest, "checksum_mismatch"},
+	{auth.ErrUnauthorized, http.StatusUnauthorized, "unauthorized"},
+	{storagedata.ErrForbidden, http.StatusForbidden, "forbidden"},
+	{storagedata.ErrQuotaExceeded, http.StatusInsufficientStorage, "quota_exceeded"},
+	{storagedata.ErrStorageUnavailable, http.StatusServiceUnavailable, "storage_unavailable"},
+}
+
+func errorBody(statusCode int, err error) (int, errorResponse) {
+	for _, c := range errorCodes {
+		if errors.Is(err, c.err) {
+			resp := errorResponse{Code: c.code, Message: err.Error()}
+			var quotaErr *storagedata.QuotaError
+			if errors.As(err, &quotaErr) {
+				resp.Quota = &quotaErr.Quota
+			}
+			return c.status, resp
+		}
+	}
+
+	if statusCode < http.StatusBadRequest {
+		statusCode = http.StatusInternalServerError
+	}
+	code := strings.ToLower(strings.Replace(http.StatusText(statusCode), " ", "_", -1))
+	return statusCode, errorResponse{Code: code, Message: err.Error()}
+}
+
+// invalidBody reports a request body that could not be read.
+func invalidBody(err error) error {
+	return storagedata.WrapError(storagedata.ErrInvalidRequest, err)
+}
+
+// limitedBody fails with storagedata.ErrTooLarge once more than remaining
+// bytes are read.
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func limitBody(body io.ReadCloser, limit int64) io.ReadCloser {
+	return &limitedBody{ReadCloser: body, remaining: limit}
+}
+
+func (l *limitedBody) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, storagedata.ErrTooLarge
+	}
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+
+	n, err := l.ReadCloser.Read(p)
+	if int64(n) > l.remaining {
+		n = int(l.remaining)
+		l.remaining = -1
+		return n, storagedata.ErrTooLarge
+	}
+	l.remaining -= int64(n)
+	return n, err
+}
+
+func New(storageDocument Storage, opts ...Option) *Api {
 	api := Api{
 		storageDocument: storageDocument,
 	}
+	for _, opt := range opts {
+		opt(&api)
+	}
+	if len(api.presignKey) == 0 {
+		api.presignKey = randomKey()
+	}
 	return &api
 }
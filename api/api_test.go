@@ -2,12 +2,17 @@ package api_test
 
 import (
 	"americanas/api"
+	"americanas/storagedata"
 	"americanas/test"
 	"bufio"
 	"bytes"
+	"crypto/md5"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
@@ -15,6 +20,7 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 )
@@ -26,43 +32,290 @@ type fixture struct {
 }
 
 type StorageFake struct {
-	status int
-	err    error
-	body   []byte
+	status   int
+	err      error
+	file     storagedata.FileMetadata
+	files    map[string]storagedata.FileMetadata
+	uploaded int64
+	path     string
+	upload   storagedata.Upload
+	list     storagedata.ListOptions
+	conflict storagedata.ConflictPolicy
+	version  int
+	prune    []interface{}
+	digest   storagedata.Digest
+	owner    string
+	acls     storagedata.ACLs
+	share    storagedata.Share
+	shares   []storagedata.Share
+	shareReq storagedata.ShareRequest
+	revoked  string
+	quotas   []storagedata.QuotaUsage
+	quota    storagedata.Quota
+	usage    storagedata.Usage
 }
 
-func (s *StorageFake) StorageFile(body map[string]interface{}) (int, []byte, map[string]interface{}, error) {
-	return s.status, s.body, nil, s.err
+func (s *StorageFake) StorageFile(req storagedata.UploadRequest) (int, storagedata.FileMetadata, error) {
+	s.path = req.Path
+	s.conflict = req.Conflict
+	s.digest = req.Digest
+	s.owner = req.Owner
+	if req.Content != nil {
+		n, err := io.Copy(ioutil.Discard, req.Content)
+		s.uploaded = n
+		if err != nil {
+			return http.StatusBadRequest, storagedata.FileMetadata{}, storagedata.WrapError(storagedata.ErrInvalidRequest, err)
+		}
+	}
+	return s.status, s.file, s.err
+}
+
+func (s *StorageFake) AllFiles() (int, map[string]storagedata.FileMetadata, error) {
+	return s.status, s.files, s.err
+}
+
+func (s *StorageFake) UnderDir(dir string) (int, map[string]storagedata.FileMetadata, error) {
+	return s.status, s.files, s.err
+}
+
+func (s *StorageFake) List(opts storagedata.ListOptions) (int, storagedata.ListResult, error) {
+	s.list = opts
+	result := storagedata.ListResult{}
+	for _, file := range s.files {
+		result.Files = append(result.Files, file)
+	}
+	return s.status, result, s.err
+}
+
+func (s *StorageFake) ListDir(dir string) (int, storagedata.DirListing, error) {
+	s.path = dir
+	listing := storagedata.DirListing{Path: dir, Directories: []string{"monthly"}}
+	for _, file := range s.files {
+		listing.Files = append(listing.Files, file)
+	}
+	return s.status, listing, s.err
 }
 
-func (s *StorageFake) AllFiles() (int, []byte, error) {
-	return s.status, s.body, s.err
+func (s *StorageFake) CreateDir(dir string) (int, error) {
+	s.path = dir
+	return s.status, s.err
+}
+
+func (s *StorageFake) MoveDir(dir, toDir string) (int, error) {
+	s.path = dir + " -> " + toDir
+	return s.status, s.err
 }
 
-func (s *StorageFake) UnderDir(dir string) (int, []byte, error) {
-	return s.status, s.body, s.err
+func (s *StorageFake) DeleteDir(dir string, dryRun bool) (int, []storagedata.FileMetadata, error) {
+	s.path = dir
+	var files []storagedata.FileMetadata
+	for _, file := range s.files {
+		files = append(files, file)
+	}
+	return s.status, files, s.err
 }
 
-func (s *StorageFake) ByID(id string) (int, []byte, error) {
-	return s.status, s.body, s.err
+func (s *StorageFake) ByID(id string) (int, storagedata.FileMetadata, error) {
+	return s.status, s.file, s.err
 }
 
 func (s *StorageFake) MoveFile(id, toDir string) (int, error) {
 	return s.status, s.err
 }
 
+func (s *StorageFake) RenameFile(id, toDir, name string, policy storagedata.ConflictPolicy) (int, storagedata.FileMetadata, error) {
+	s.path = toDir
+	s.conflict = policy
+	file := s.file
+	if name != "" {
+		file.Name = name
+	}
+	return s.status, file, s.err
+}
+
+func (s *StorageFake) CopyFile(id, toDir, name, owner string, policy storagedata.ConflictPolicy) (int, storagedata.FileMetadata, error) {
+	s.path = toDir
+	s.owner = owner
+	s.conflict = policy
+	file := s.file
+	file.ID = "0cb90ac871279cc942de976882b71a00"
+	if name != "" {
+		file.Name = name
+	}
+	return s.status, file, s.err
+}
+
 func (s *StorageFake) DeleteByID(id string) (int, error) {
 	return s.status, s.err
 }
 
-func (s *StorageFake) OverwriteFile(id string, body map[string]interface{}) (int, []byte, error) {
-	return s.status, s.body, s.err
+func (s *StorageFake) OverwriteFile(id string, req storagedata.UploadRequest) (int, storagedata.FileMetadata, error) {
+	s.path = req.Path
+	s.digest = req.Digest
+	return s.status, s.file, s.err
+}
+
+func (s *StorageFake) OpenByPath(path string) (int, *os.File, storagedata.FileMetadata, error) {
+	file, err := os.Open(filepath.Join("../storagedata", path))
+	if err != nil {
+		return http.StatusNotFound, nil, storagedata.FileMetadata{}, err
+	}
+	return s.status, file, storagedata.FileMetadata{Name: filepath.Base(path)}, s.err
+}
+
+func (s *StorageFake) OpenByID(id string) (int, *os.File, storagedata.FileMetadata, error) {
+	if s.err != nil {
+		return s.status, nil, storagedata.FileMetadata{}, s.err
+	}
+	file, err := os.Open(filepath.Join("../test_files", s.file.Name))
+	if err != nil {
+		return http.StatusNotFound, nil, storagedata.FileMetadata{}, err
+	}
+	return s.status, file, s.file, nil
+}
+
+func (s *StorageFake) Versions(id string) (int, []storagedata.FileVersion, error) {
+	versions := []storagedata.FileVersion{
+		{Version: 2, Name: s.file.Name, SHA256: s.file.SHA256, Current: true},
+		{Version: 1, Name: s.file.Name, SHA256: "0a1b"},
+	}
+	return s.status, versions, s.err
+}
+
+func (s *StorageFake) OpenVersion(id string, version int) (int, *os.File, storagedata.FileMetadata, error) {
+	s.version = version
+	return s.OpenByID(id)
+}
+
+func (s *StorageFake) RestoreVersion(id string, version int) (int, storagedata.FileMetadata, error) {
+	s.version = version
+	return s.status, s.file, s.err
+}
+
+func (s *StorageFake) PruneVersions(id string, keep int, olderThan time.Duration) (int, []storagedata.FileVersion, error) {
+	s.prune = []interface{}{keep, olderThan}
+	return s.status, []storagedata.FileVersion{}, s.err
+}
+
+func (s *StorageFake) Trash() (int, []storagedata.TrashedFile, error) {
+	return s.status, []storagedata.TrashedFile{{File: s.file}}, s.err
+}
+
+func (s *StorageFake) RestoreFile(id string, policy storagedata.ConflictPolicy) (int, storagedata.FileMetadata, error) {
+	s.conflict = policy
+	return s.status, s.file, s.err
+}
+
+func (s *StorageFake) PurgeTrash(id string) (int, error) {
+	return s.status, s.err
+}
+
+func (s *StorageFake) Scrub() (int, storagedata.ScrubReport, error) {
+	return s.status, storagedata.ScrubReport{
+		Checked:  1,
+		Missing:  []storagedata.ScrubProblem{},
+		Modified: []storagedata.ScrubProblem{{ID: s.file.ID, Path: s.file.Path, Version: 1, SHA256: s.file.SHA256, Actual: "0a1b"}},
+		Orphaned: []string{"blobs/ab/abcd"},
+	}, s.err
+}
+
+func (s *StorageFake) ACLs() (int, storagedata.ACLs, error) {
+	return http.StatusOK, s.acls, nil
+}
+
+func (s *StorageFake) Authorize(owner string, perm storagedata.Permission, paths ...string) (int, error) {
+	return aclStatus(s.acls.Check(owner, perm, paths...))
+}
+
+func (s *StorageFake) AuthorizeTree(owner string, perm storagedata.Permission, dir string) (int, error) {
+	return aclStatus(s.acls.CheckBelow(owner, perm, dir))
+}
+
+func aclStatus(err error) (int, error) {
+	switch {
+	case err == nil:
+		return http.StatusOK, nil
+	case errors.Is(err, storagedata.ErrForbidden):
+		return http.StatusForbidden, err
+	default:
+		return http.StatusBadRequest, err
+	}
+}
+
+func (s *StorageFake) SetACL(acl storagedata.ACL) (int, storagedata.ACL, error) {
+	if s.acls == nil {
+		s.acls = make(storagedata.ACLs)
+	}
+	if len(acl.Grants) == 0 {
+		delete(s.acls, acl.Path)
+	} else {
+		s.acls[acl.Path] = acl
+	}
+	return http.StatusOK, acl, nil
+}
+
+func (s *StorageFake) CreateShare(req storagedata.ShareRequest) (int, storagedata.Share, error) {
+	s.shareReq = req
+	return http.StatusCreated, storagedata.Share{Token: "t0k3n", FileID: req.FileID, Dir: req.Dir, Owner: req.Owner, Protected: req.Password != ""}, nil
+}
+
+func (s *StorageFake) Shares() (int, []storagedata.Share, error) {
+	return http.StatusOK, s.shares, nil
+}
+
+func (s *StorageFake) RevokeShare(token string) (int, error) {
+	s.revoked = token
+	return http.StatusOK, nil
+}
+
+func (s *StorageFake) OpenShare(token, password string) (int, storagedata.Share, error) {
+	if token != s.share.Token {
+		return http.StatusNotFound, storagedata.Share{}, storagedata.ErrNotFound
+	}
+	if s.share.Protected && password != "pw" {
+		return http.StatusForbidden, storagedata.Share{}, storagedata.ErrForbidden
+	}
+	return http.StatusOK, s.share, nil
+}
+
+func (s *StorageFake) Quotas() (int, []storagedata.QuotaUsage, error) {
+	return http.StatusOK, s.quotas, nil
+}
+
+func (s *StorageFake) SetQuota(q storagedata.Quota) (int, storagedata.Quota, error) {
+	s.quota = q
+	return http.StatusOK, q, nil
+}
+
+func (s *StorageFake) Usage(owner string) (int, storagedata.Usage, error) {
+	s.owner = owner
+	return http.StatusOK, s.usage, nil
+}
+
+func (s *StorageFake) CreateUpload(length int64, metadata map[string]string) (int, storagedata.Upload, error) {
+	s.upload.Length = length
+	s.upload.Metadata = metadata
+	return s.status, s.upload, s.err
+}
+
+func (s *StorageFake) GetUpload(id string) (int, storagedata.Upload, error) {
+	return s.status, s.upload, s.err
+}
+
+func (s *StorageFake) WriteUpload(id string, offset int64, r io.Reader) (int, storagedata.Upload, error) {
+	n, _ := io.Copy(ioutil.Discard, r)
+	s.upload.Offset = offset + n
+	return s.status, s.upload, s.err
 }
 
-func setup(t *testing.T) *fixture {
+func (s *StorageFake) DeleteUpload(id string) (int, error) {
+	return s.status, s.err
+}
+
+func setup(t *testing.T, opts ...api.Option) *fixture {
 	s := &StorageFake{}
 	router := httprouter.New()
-	api := api.New(s)
+	api := api.New(s, opts...)
 	api.RegisterRouters(router)
 	return &fixture{
 		api:     api,
@@ -71,6 +324,27 @@ func setup(t *testing.T) *fixture {
 	}
 }
 
+// seededFixture holds ht/monthly/mars.png.
+func seededFixture(t *testing.T, opts ...api.Option) *fixture {
+	fixture := setup(t, opts...)
+	fixture.storage.status = http.StatusOK
+	fixture.storage.file = storagedata.FileMetadata{
+		ID:          "aab053840116dacaf13a062d909e5761",
+		Name:        "mars.png",
+		Path:        "ht/monthly/mars.png",
+		ContentType: "image/png",
+		Size:        338135,
+		ModTime:     time.Date(2021, 9, 12, 2, 7, 39, 0, time.UTC),
+		SHA256:      "b6c691ec3385ea16ed14e87556de551fefb59f5b60832a15844890cb63ed4b7c",
+	}
+	return fixture
+}
+
+// authedFixture is seededFixture authenticating the API key "k3y" as alice.
+func authedFixture(t *testing.T, opts ...api.Option) *fixture {
+	return seededFixture(t, append([]api.Option{api.WithAuthenticator(fakeAuthenticator{})}, opts...)...)
+}
+
 func TestPOSTSendFile(t *testing.T) {
 	testCase := "test-post-send-file-with-sucess"
 	url := "/sendfile"
@@ -91,9 +365,69 @@ func TestPOSTSendFile(t *testing.T) {
 	test.AssertEqual(t, testCase, status, http.StatusOK)
 	test.AssertEqual(t, testCase, returnBody, `{"status":"success"}`)
 	test.AssertEqual(t, testCase, header.Get("Location"), "/sendfile")
+	test.AssertEqual(t, testCase, fixture.storage.path, "ht/monthly")
+
+}
+
+func TestPOSTSendFileStreamed(t *testing.T) {
+	testCase := "test-post-send-file-streamed-with-sucess"
+	url := "/sendfile"
+	content := getFileTest("mars.png")
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	writer.WriteField("path", "ht/monthly")
+	part, _ := writer.CreateFormFile("file", "mars.png")
+	part.Write(content)
+	writer.Close()
+
+	fixture := setup(t)
+	fixture.storage.status = http.StatusOK
+
+	status, returnBody, _ := fixture.requestMultiPart(url, "POST", body, *writer)
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+	test.AssertEqual(t, testCase, returnBody, `{"status":"success"}`)
+	test.AssertEqual(t, testCase, fixture.storage.uploaded, int64(len(content)))
+	test.AssertEqual(t, testCase, fixture.storage.path, "ht/monthly")
 
 }
 
+func TestPOSTSendFileDigest(t *testing.T) {
+	testCase := "test-post-send-file-digest"
+	content := getFileTest("mars.png")
+	sum := md5.Sum(content)
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	writer.WriteField("path", "ht/monthly")
+	part, _ := writer.CreateFormFile("file", "mars.png")
+	part.Write(content)
+	writer.Close()
+
+	fixture := setup(t)
+	fixture.storage.status = http.StatusOK
+
+	req := fixture.createRequest("/sendfile", "POST", bytes.NewReader(body.Bytes()))
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+	status, _, _ := fixture.sendRequest(req)
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+	test.AssertEqual(t, testCase, fixture.storage.digest.MD5, sum[:])
+
+	req = fixture.createRequest("/sendfile", "POST", bytes.NewReader(body.Bytes()))
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Content-MD5", "d41d8cd98f00b204")
+	status, returnBody, _ := fixture.sendRequest(req)
+	test.AssertEqual(t, testCase, status, http.StatusBadRequest)
+	test.AssertEqual(t, testCase, strings.Contains(returnBody, `"code":"invalid_request"`), true)
+
+	fixture.storage.status = http.StatusBadRequest
+	fixture.storage.err = fmt.Errorf("content doesn't match: %w", storagedata.ErrChecksumMismatch)
+	req = fixture.createRequest("/sendfile", "POST", bytes.NewReader(body.Bytes()))
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	status, returnBody, _ = fixture.sendRequest(req)
+	test.AssertEqual(t, testCase, status, http.StatusBadRequest)
+	test.AssertEqual(t, testCase, strings.Contains(returnBody, `"code":"checksum_mismatch"`), true)
+}
+
 func TestGETGetFile(t *testing.T) {
 	testCase := "test-get-get-file-with-sucess"
 	url := "storagedata/test/mars.png"
@@ -116,15 +450,14 @@ func TestGETAllFiles(t *testing.T) {
 	url := "/allfiles"
 	fixture := setup(t)
 	fixture.storage.status = http.StatusOK
-	fixture.storage.body = fakeBodyResult()
+	fixture.storage.files = fakeBodyResult()
 
 	status, body, header := fixture.request(url, "GET", nil)
 
-	var actual map[string]interface{}
+	var actual map[string]storagedata.FileMetadata
 	json.Unmarshal([]byte(body), &actual)
 
-	var expected map[string]interface{}
-	json.Unmarshal(fakeBodyResult(), &expected)
+	expected := fakeBodyResult()
 
 	test.AssertEqual(t, testCase, status, http.StatusOK)
 	test.AssertEqual(t, testCase, actual, expected)
@@ -137,17 +470,16 @@ func TestGETUnderDir(t *testing.T) {
 	url := "/underdir?data=%s"
 	fixture := setup(t)
 	fixture.storage.status = http.StatusOK
-	fixture.storage.body = underDirFakeResult()
+	fixture.storage.files = underDirFakeResult()
 
 	dir := "ht/monthly"
 	url = fmt.Sprintf(url, dir)
 	status, body, header := fixture.request(url, "GET", nil)
 
-	var actual map[string]interface{}
+	var actual map[string]storagedata.FileMetadata
 	json.Unmarshal([]byte(body), &actual)
 
-	var expected map[string]interface{}
-	json.Unmarshal(underDirFakeResult(), &expected)
+	expected := underDirFakeResult()
 
 	test.AssertEqual(t, testCase, status, http.StatusOK)
 	test.AssertEqual(t, testCase, actual, expected)
@@ -160,17 +492,16 @@ func TestGETByID(t *testing.T) {
 	url := "/byid?data=%s"
 	fixture := setup(t)
 	fixture.storage.status = http.StatusOK
-	fixture.storage.body = byIDFakeResult()
+	fixture.storage.file = byIDFakeResult()
 
 	id := "aab053840116dacaf13a062d909e5761"
 	url = fmt.Sprintf(url, id)
 	status, body, header := fixture.request(url, "GET", nil)
 
-	var actual map[string]interface{}
+	var actual storagedata.FileMetadata
 	json.Unmarshal([]byte(body), &actual)
 
-	var expected map[string]interface{}
-	json.Unmarshal(byIDFakeResult(), &expected)
+	expected := byIDFakeResult()
 
 	test.AssertEqual(t, testCase, status, http.StatusOK)
 	test.AssertEqual(t, testCase, actual, expected)
@@ -184,7 +515,6 @@ func TestPOSTMoveFile(t *testing.T) {
 
 	fixture := setup(t)
 	fixture.storage.status = http.StatusOK
-	fixture.storage.body = directory()
 
 	id := "aab053840116dacaf13a062d909e5761"
 	url = fmt.Sprintf(url, id)
@@ -193,6 +523,10 @@ func TestPOSTMoveFile(t *testing.T) {
 	test.AssertEqual(t, testCase, returnBody, `{"status":"success"}`)
 	test.AssertEqual(t, testCase, header.Get("Location"), url)
 
+	status, _, _ = fixture.request(url, "POST", strings.NewReader(`{"directory": "ht/monthly", "conflict": "rename"}`))
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+	test.AssertEqual(t, testCase, fixture.storage.conflict, storagedata.ConflictRename)
+
 }
 
 func TestPOSTDeleteFile(t *testing.T) {
@@ -211,6 +545,71 @@ func TestPOSTDeleteFile(t *testing.T) {
 
 }
 
+func TestGETByIDNotFound(t *testing.T) {
+	testCase := "test-get-by-id-not-found"
+	url := "/byid?data=unknown"
+	fixture := setup(t)
+	fixture.storage.status = http.StatusNotFound
+	fixture.storage.err = fmt.Errorf("file unknown: %w", storagedata.ErrNotFound)
+
+	status, body, _ := fixture.request(url, "GET", nil)
+	test.AssertEqual(t, testCase, status, http.StatusNotFound)
+	test.AssertEqual(t, testCase, body, `{"code":"not_found","message":"file unknown: not found"}`)
+
+}
+
+func TestErrorStatusMapping(t *testing.T) {
+	testCase := "test-error-status-mapping"
+	cases := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{storagedata.ErrConflict, http.StatusConflict, "conflict"},
+		{storagedata.ErrInvalidPath, http.StatusBadRequest, "invalid_path"},
+		{storagedata.ErrTooLarge, http.StatusRequestEntityTooLarge, "too_large"},
+		{&storagedata.Error{Kind: storagedata.ErrStorageUnavailable, Err: os.ErrPermission}, http.StatusServiceUnavailable, "storage_unavailable"},
+		{errors.New("unexpected"), http.StatusInternalServerError, "internal_server_error"},
+	}
+
+	for _, c := range cases {
+		fixture := setup(t)
+		fixture.storage.status = http.StatusBadRequest
+		fixture.storage.err = c.err
+		if c.code == "internal_server_error" {
+			fixture.storage.status = http.StatusOK
+		}
+
+		status, body, _ := fixture.request("/delete?data=id", "POST", nil)
+
+		var actual map[string]string
+		json.Unmarshal([]byte(body), &actual)
+		test.AssertEqual(t, testCase, status, c.status)
+		test.AssertEqual(t, testCase, actual["code"], c.code)
+		test.AssertEqual(t, testCase, actual["message"], c.err.Error())
+	}
+
+}
+
+func TestPOSTSendFileTooLarge(t *testing.T) {
+	testCase := "test-post-send-file-too-large"
+	url := "/sendfile"
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	writer.WriteField("path", "ht/monthly")
+	part, _ := writer.CreateFormFile("file", "mars.png")
+	part.Write(getFileTest("mars.png"))
+	writer.Close()
+
+	fixture := setup(t, api.WithMaxUploadSize(1024))
+	fixture.storage.status = http.StatusOK
+
+	status, returnBody, _ := fixture.requestMultiPart(url, "POST", body, *writer)
+	test.AssertEqual(t, testCase, status, http.StatusRequestEntityTooLarge)
+	test.AssertEqual(t, testCase, strings.Contains(returnBody, `"code":"too_large"`), true)
+
+}
+
 func (f *fixture) createRequest(url string, method string, body io.Reader) *http.Request {
 	server := httptest.NewServer(f.router)
 	url = fmt.Sprintf("%v/%v", server.URL, url)
@@ -261,26 +660,21 @@ func getFileTest(filename string) []byte {
 
 }
 
-func underDirFakeResult() []byte {
-	return []byte(`{
-		"aab053840116dacaf13a062d909e5761": {
-			"modificationTime": "2021-09-11T23:07:39.696063108-03:00",
-			"name": "golang.png",
-			"path": "ht/monthly/golang.png",
-			"size": 24357,
-			"type": "png"
-		},
-	}`)
+func underDirFakeResult() map[string]storagedata.FileMetadata {
+	return map[string]storagedata.FileMetadata{
+		"aab053840116dacaf13a062d909e5761": byIDFakeResult(),
+	}
 }
 
-func byIDFakeResult() []byte {
-	return []byte(`{
-		"modificationTime": "2021-09-11T23:07:39.696063108-03:00",
-		"name": "golang.png",
-		"path": "ht/monthly/golang.png",
-		"size": 24357,
-		"type": "png"
-	}`)
+func byIDFakeResult() storagedata.FileMetadata {
+	return storagedata.FileMetadata{
+		ID:          "aab053840116dacaf13a062d909e5761",
+		ModTime:     time.Date(2021, 9, 12, 2, 7, 39, 696063108, time.UTC),
+		Name:        "golang.png",
+		Path:        "ht/monthly/golang.png",
+		Size:        24357,
+		ContentType: "png",
+	}
 }
 
 func directory() []byte {
@@ -289,41 +683,16 @@ func directory() []byte {
 	}`)
 }
 
-func fakeBodyResult() []byte {
-
-	return []byte(`{
-		"ht/mar.png": {
-			"name": "mar.png",
-			"path": "ht/",								
-			"type": "png",
-			"dateCreated": "",
-			"dateModify": "2021-09-09T08:20:27Z",
-			"size": "24357",
-		},
-		"ht/mar.png": {
-			"name": "mar.png",
-			"path": "",								
-			"type": "",
-			"dateCreated": "",
-			"dateModify": "",
-			"size": "",
-		},
-		"ht/mar.png": {
-			"name": "mar.png",
-			"path": "",								
-			"type": "",
-			"dateCreated": "",
-			"dateModify": "",
-			"size": "",
+func fakeBodyResult() map[string]storagedata.FileMetadata {
+	return map[string]storagedata.FileMetadata{
+		"aab053840116dacaf13a062d909e5761": byIDFakeResult(),
+		"0cb90ac871279cc942de976882b71a00": {
+			ID:          "0cb90ac871279cc942de976882b71a00",
+			ModTime:     time.Date(2021, 9, 9, 8, 20, 27, 0, time.UTC),
+			Name:        "mar.png",
+			Path:        "ht/mar.png",
+			Size:        338135,
+			ContentType: "png",
 		},
-		"ht/mar.png": {
-			"name": "mar.png",
-			"path": "",								
-			"type": "",
-			"dateCreated": "",
-			"dateModify": "",
-			"size": "",
-		},
-	}`)
-
+	}
 }
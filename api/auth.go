@@ -0,0 +1,78 @@
+package api
+
+import (
+	"americanas/auth"
+	"fmt"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Authenticator returns the owner a request acts as, or an error matching
+// auth.ErrUnauthorized. *auth.Authenticator is one.
+type Authenticator interface {
+	Authenticate(r *http.Request) (string, error)
+}
+
+// WithAuthenticator makes every route but CORS and tus OPTIONS requests
+// require credentials. Without it the API is open to anyone.
+func WithAuthenticator(a Authenticator) Option {
+	return func(api *Api) {
+		api.authenticator = a
+	}
+}
+
+// routes registers handlers on the router behind the authentication
+// middleware.
+type routes struct {
+	*httprouter.Router
+	api *Api
+}
+
+func (r routes) GET(path string, handle httprouter.Handle) {
+	r.Router.GET(path, r.api.authenticate(handle))
+}
+
+func (r routes) HEAD(path string, handle httprouter.Handle) {
+	r.Router.HEAD(path, r.api.authenticate(handle))
+}
+
+func (r routes) OPTIONS(path string, handle httprouter.Handle) {
+	r.Router.OPTIONS(path, handle)
+}
+
+func (r routes) POST(path string, handle httprouter.Handle) {
+	r.Router.POST(path, r.api.authenticate(handle))
+}
+
+func (r routes) PUT(path string, handle httprouter.Handle) {
+	r.Router.PUT(path, r.api.authenticate(handle))
+}
+
+func (r routes) PATCH(path string, handle httprouter.Handle) {
+	r.Router.PATCH(path, r.api.authenticate(handle))
+}
+
+func (r routes) DELETE(path string, handle httprouter.Handle) {
+	r.Router.DELETE(path, r.api.authenticate(handle))
+}
+
+// authenticate answers 401 to requests without valid credentials and passes
+// the others on with their owner in the context, see auth.Owner.
+func (api *Api) authenticate(handle httprouter.Handle) httprouter.Handle {
+	if api.authenticator == nil {
+		return handle
+	}
+
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		owner, err := api.authenticator.Authenticate(r)
+		if err != nil {
+			fmt.Printf("[authenticate] %s %s rejected. error %v\n", r.Method, r.URL.Path, err)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="americanas"`)
+			api.send(w, http.StatusUnauthorized, err)
+			return
+		}
+
+		handle(w, r.WithContext(auth.WithOwner(r.Context(), owner)), ps)
+	}
+}
@@ -0,0 +1,108 @@
+package api_test
+
+import (
+	"americanas/api"
+	"americanas/auth"
+	"americanas/test"
+	"bytes"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// fakeAuthenticator lets in requests with the X-API-Key "k3y" as alice.
+type fakeAuthenticator struct{}
+
+func (fakeAuthenticator) Authenticate(r *http.Request) (string, error) {
+	if r.Header.Get("X-API-Key") != "k3y" {
+		return "", fmt.Errorf("unknown API key: %w", auth.ErrUnauthorized)
+	}
+	return "alice", nil
+}
+
+func TestAuthenticationRequired(t *testing.T) {
+	testCase := "test-authentication-required"
+	fixture := setup(t, api.WithAuthenticator(fakeAuthenticator{}))
+	fixture.storage.status = http.StatusOK
+
+	for _, route := range []struct{ method, url string }{
+		{"GET", "allfiles"},
+		{"POST", "delete?data=aab053840116dacaf13a062d909e5761"},
+		{"GET", "v2/files/aab053840116dacaf13a062d909e5761/content"},
+		{"DELETE", "v2/trash/aab053840116dacaf13a062d909e5761"},
+		{"POST", "uploads"},
+	} {
+		req := fixture.createRequest(route.url, route.method, nil)
+		req.Header.Set("X-API-Key", "guess")
+		status, returnBody, header := fixture.sendRequest(req)
+		test.AssertEqual(t, testCase, status, http.StatusUnauthorized)
+		test.AssertEqual(t, testCase, strings.Contains(returnBody, `"code":"unauthorized"`), true)
+		test.AssertEqual(t, testCase, header.Get("WWW-Authenticate"), `Bearer realm="americanas"`)
+	}
+
+	req := fixture.createRequest("allfiles", "GET", nil)
+	req.Header.Set("X-API-Key", "k3y")
+	status, _, _ := fixture.sendRequest(req)
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+
+	// tus discovery stays open.
+	status, _, _ = fixture.request("uploads", "OPTIONS", nil)
+	test.AssertEqual(t, testCase, status, http.StatusNoContent)
+}
+
+func TestUploadRecordsOwner(t *testing.T) {
+	testCase := "test-upload-records-owner"
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	writer.WriteField("path", "ht/monthly")
+	part, _ := writer.CreateFormFile("file", "mars.png")
+	part.Write(getFileTest("mars.png"))
+	writer.Close()
+
+	fixture := setup(t, api.WithAuthenticator(fakeAuthenticator{}))
+	fixture.storage.status = http.StatusOK
+
+	req := fixture.createRequest("v2/files", "POST", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("X-API-Key", "k3y")
+	status, _, _ := fixture.sendRequest(req)
+	test.AssertEqual(t, testCase, status, http.StatusCreated)
+	test.AssertEqual(t, testCase, fixture.storage.owner, "alice")
+}
+
+func TestCopyRecordsOwner(t *testing.T) {
+	testCase := "test-copy-records-owner"
+	fixture := authedFixture(t)
+	fixture.storage.status = http.StatusCreated
+
+	status, _ := fixture.requestAs("/v2/files/aab053840116dacaf13a062d909e5761/copy", "POST", strings.NewReader(`{"directory":"ht/backup"}`))
+	test.AssertEqual(t, testCase, status, http.StatusCreated)
+	test.AssertEqual(t, testCase, fixture.storage.owner, "alice")
+}
+
+func TestTusUploadOwner(t *testing.T) {
+	testCase := "test-tus-upload-owner"
+	fixture := setup(t, api.WithAuthenticator(fakeAuthenticator{}))
+	fixture.storage.status = http.StatusCreated
+
+	// The owner claimed in the metadata is replaced by the caller.
+	req := fixture.createRequest("/uploads", "POST", nil)
+	req.Header.Set("X-API-Key", "k3y")
+	req.Header.Set("Tus-Resumable", "1.0.0")
+	req.Header.Set("Upload-Length", "338135")
+	req.Header.Set("Upload-Metadata", "filename bWFycy5wbmc=,path aHQvbW9udGhseQ==,owner Ym9i")
+	status, _, _ := fixture.sendRequest(req)
+	test.AssertEqual(t, testCase, status, http.StatusCreated)
+	test.AssertEqual(t, testCase, fixture.storage.upload.Metadata["owner"], "alice")
+}
+
+func TestNoAuthenticator(t *testing.T) {
+	testCase := "test-no-authenticator"
+	fixture := setup(t)
+	fixture.storage.status = http.StatusOK
+
+	status, _, _ := fixture.request("allfiles", "GET", nil)
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+}
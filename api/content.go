@@ -0,0 +1,87 @@
+package api
+
+import (
+	"americanas/storagedata"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// content serves the bytes of a file by ID. http.ServeContent takes care of
+// Range, If-Range, If-None-Match, If-Modified-Since and HEAD from the ETag and
+// modification time set here. The file is sent as an attachment unless
+// ?disposition=inline is given.
+func (api *Api) content(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id := ps.ByName("id")
+	statusCode, _, err := api.authorizeFile(r, storagedata.PermRead, id)
+	if err != nil {
+		fmt.Printf("[content] Error in authorize with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+	statusCode, file, entry, err := api.storageDocument.OpenByID(id)
+	if err != nil {
+		fmt.Printf("[content] Error in content with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+	defer file.Close()
+
+	serveDownload(w, r, file, entry)
+}
+
+// serveDownload is serveEntry with the Content-Disposition asked for.
+func serveDownload(w http.ResponseWriter, r *http.Request, file *os.File, entry storagedata.FileMetadata) {
+	disposition := "attachment"
+	if r.URL.Query().Get("disposition") == "inline" {
+		disposition = "inline"
+	}
+	w.Header().Set("Content-Disposition", contentDisposition(disposition, entry.Name))
+	serveEntry(w, r, file, entry)
+}
+
+// serveEntry writes file with the caching and type headers of entry.
+func serveEntry(w http.ResponseWriter, r *http.Request, file *os.File, entry storagedata.FileMetadata) {
+	w.Header().Set("ETag", etag(entry))
+	if contentType := mimeType(entry); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	http.ServeContent(w, r, entry.Name, entry.ModTime, file)
+}
+
+// etag is the quoted content hash. Entries stored before hashes were recorded
+// get a weak tag from their size and modification time instead.
+func etag(entry storagedata.FileMetadata) string {
+	if entry.SHA256 != "" {
+		return `"` + entry.SHA256 + `"`
+	}
+	return `W/"` + strconv.FormatInt(entry.Size, 16) + "-" + strconv.FormatInt(entry.ModTime.UnixNano(), 16) + `"`
+}
+
+// mimeType is the type the file was uploaded with. Older entries only kept
+// an extension such as "png", which is looked up instead; when nothing is
+// known http.ServeContent sniffs the content.
+func mimeType(entry storagedata.FileMetadata) string {
+	if strings.Contains(entry.ContentType, "/") {
+		return entry.ContentType
+	}
+	if entry.ContentType != "" {
+		if t := mime.TypeByExtension("." + entry.ContentType); t != "" {
+			return t
+		}
+	}
+	return mime.TypeByExtension(path.Ext(entry.Name))
+}
+
+func contentDisposition(disposition, name string) string {
+	if header := mime.FormatMediaType(disposition, map[string]string{"filename": name}); header != "" {
+		return header
+	}
+	return disposition
+}
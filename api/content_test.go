@@ -0,0 +1,108 @@
+package api_test
+
+import (
+	"americanas/storagedata"
+	"americanas/test"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func contentFixture(t *testing.T) (*fixture, *httptest.Server) {
+	fixture := seededFixture(t)
+	return fixture, httptest.NewServer(fixture.router)
+}
+
+func getContent(t *testing.T, server *httptest.Server, method, url string, headers map[string]string) (*http.Response, []byte) {
+	req, _ := http.NewRequest(method, server.URL+url, nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	return resp, body
+}
+
+func TestGETContent(t *testing.T) {
+	testCase := "test-get-content-with-sucess"
+	_, server := contentFixture(t)
+	defer server.Close()
+
+	resp, body := getContent(t, server, "GET", "/files/aab053840116dacaf13a062d909e5761/content", nil)
+	test.AssertEqual(t, testCase, resp.StatusCode, http.StatusOK)
+	test.AssertEqual(t, testCase, body, getFileTest("mars.png"))
+	test.AssertEqual(t, testCase, resp.Header.Get("ETag"), `"b6c691ec3385ea16ed14e87556de551fefb59f5b60832a15844890cb63ed4b7c"`)
+	test.AssertEqual(t, testCase, resp.Header.Get("Last-Modified"), "Sun, 12 Sep 2021 02:07:39 GMT")
+	test.AssertEqual(t, testCase, resp.Header.Get("Content-Type"), "image/png")
+	test.AssertEqual(t, testCase, resp.Header.Get("Content-Disposition"), `attachment; filename=mars.png`)
+	test.AssertEqual(t, testCase, resp.Header.Get("Accept-Ranges"), "bytes")
+
+	resp, _ = getContent(t, server, "GET", "/files/aab053840116dacaf13a062d909e5761/content?disposition=inline", nil)
+	test.AssertEqual(t, testCase, resp.Header.Get("Content-Disposition"), `inline; filename=mars.png`)
+}
+
+func TestGETContentRange(t *testing.T) {
+	testCase := "test-get-content-range"
+	fixture, server := contentFixture(t)
+	defer server.Close()
+	url := "/files/aab053840116dacaf13a062d909e5761/content"
+	content := getFileTest("mars.png")
+
+	resp, body := getContent(t, server, "GET", url, map[string]string{"Range": "bytes=100-199"})
+	test.AssertEqual(t, testCase, resp.StatusCode, http.StatusPartialContent)
+	test.AssertEqual(t, testCase, body, content[100:200])
+	test.AssertEqual(t, testCase, resp.Header.Get("Content-Range"), fmt.Sprintf("bytes 100-199/%d", len(content)))
+
+	// A range for an entity that has changed since is ignored.
+	resp, body = getContent(t, server, "GET", url, map[string]string{"Range": "bytes=100-199", "If-Range": `"stale"`})
+	test.AssertEqual(t, testCase, resp.StatusCode, http.StatusOK)
+	test.AssertEqual(t, testCase, len(body), len(content))
+
+	resp, body = getContent(t, server, "GET", url, map[string]string{"Range": "bytes=100-199", "If-Range": etagOf(fixture)})
+	test.AssertEqual(t, testCase, resp.StatusCode, http.StatusPartialContent)
+	test.AssertEqual(t, testCase, len(body), 100)
+}
+
+func TestGETContentConditional(t *testing.T) {
+	testCase := "test-get-content-conditional"
+	fixture, server := contentFixture(t)
+	defer server.Close()
+	url := "/files/aab053840116dacaf13a062d909e5761/content"
+
+	resp, body := getContent(t, server, "GET", url, map[string]string{"If-None-Match": etagOf(fixture)})
+	test.AssertEqual(t, testCase, resp.StatusCode, http.StatusNotModified)
+	test.AssertEqual(t, testCase, len(body), 0)
+
+	resp, _ = getContent(t, server, "GET", url, map[string]string{"If-Modified-Since": "Mon, 13 Sep 2021 00:00:00 GMT"})
+	test.AssertEqual(t, testCase, resp.StatusCode, http.StatusNotModified)
+
+	resp, _ = getContent(t, server, "GET", url, map[string]string{"If-Modified-Since": "Sat, 11 Sep 2021 00:00:00 GMT"})
+	test.AssertEqual(t, testCase, resp.StatusCode, http.StatusOK)
+
+	resp, body = getContent(t, server, "HEAD", url, nil)
+	test.AssertEqual(t, testCase, resp.StatusCode, http.StatusOK)
+	test.AssertEqual(t, testCase, resp.ContentLength, int64(len(getFileTest("mars.png"))))
+	test.AssertEqual(t, testCase, len(body), 0)
+}
+
+func TestGETContentNotFound(t *testing.T) {
+	testCase := "test-get-content-not-found"
+	fixture, server := contentFixture(t)
+	defer server.Close()
+	fixture.storage.status = http.StatusNotFound
+	fixture.storage.err = fmt.Errorf("file unknown: %w", storagedata.ErrNotFound)
+
+	resp, body := getContent(t, server, "GET", "/files/unknown/content", nil)
+	test.AssertEqual(t, testCase, resp.StatusCode, http.StatusNotFound)
+	test.AssertEqual(t, testCase, string(body), `{"code":"not_found","message":"file unknown: not found"}`+"\n")
+}
+
+func etagOf(f *fixture) string {
+	return `"` + f.storage.file.SHA256 + `"`
+}
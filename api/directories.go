@@ -0,0 +1,147 @@
+package api
+
+import (
+	"americanas/storagedata"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// dirRequest is the body of POST /v2/dirs and PATCH /v2/dirs/*path.
+type dirRequest struct {
+	Path string `json:"path"`
+}
+
+// dirDeleteResponse lists the files a directory delete removed, or would
+// remove on a dry run.
+type dirDeleteResponse struct {
+	DryRun bool                       `json:"dryRun"`
+	Files  []storagedata.FileMetadata `json:"files"`
+}
+
+// listDir answers the subdirectories and files of a directory the caller
+// may read, or that lead to something they may read.
+func (api *Api) listDir(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	statusCode, acc, err := api.access(r)
+	if err != nil {
+		fmt.Printf("[listDir] Error in access with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+	statusCode, listing, err := api.storageDocument.ListDir(dirParam(ps))
+	if err != nil {
+		fmt.Printf("[listDir] Error in listDir with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+	if !acc.acls.Reaches(acc.owner, listing.Path) {
+		api.send(w, http.StatusForbidden, acc.forbidden(storagedata.PermRead, listing.Path))
+		return
+	}
+
+	api.send(w, http.StatusOK, acc.visibleListing(listing))
+}
+
+func (api *Api) createDir(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	req, err := readDirRequest(r)
+	if err != nil {
+		fmt.Printf("[createDir] Error in readBody. error %v", err.Error())
+		api.send(w, http.StatusBadRequest, err)
+		return
+	}
+
+	statusCode, err := api.authorize(r, storagedata.PermWrite, req.Path)
+	if err != nil {
+		fmt.Printf("[createDir] Error in authorize with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+	statusCode, err = api.storageDocument.CreateDir(req.Path)
+	if err != nil {
+		fmt.Printf("[createDir] Error in createDir with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+
+	w.Header().Set("Location", "/v2/dirs/"+strings.Trim(req.Path, "/"))
+	api.send(w, http.StatusCreated, req)
+}
+
+// moveDir renames or moves a directory, with everything in it, to the path
+// given in the body.
+func (api *Api) moveDir(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	req, err := readDirRequest(r)
+	if err != nil {
+		fmt.Printf("[moveDir] Error in readBody. error %v", err.Error())
+		api.send(w, http.StatusBadRequest, err)
+		return
+	}
+
+	statusCode, err := api.authorizeTree(r, storagedata.PermDelete, dirParam(ps))
+	if err == nil {
+		statusCode, err = api.authorize(r, storagedata.PermWrite, req.Path)
+	}
+	if err != nil {
+		fmt.Printf("[moveDir] Error in authorize with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+	statusCode, err = api.storageDocument.MoveDir(dirParam(ps), req.Path)
+	if err != nil {
+		fmt.Printf("[moveDir] Error in moveDir with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+
+	w.Header().Set("Location", "/v2/dirs/"+strings.Trim(req.Path, "/"))
+	api.send(w, http.StatusOK, req)
+}
+
+// deleteDir deletes a directory and every file below it. With ?dryRun=true
+// it only answers what would be deleted.
+func (api *Api) deleteDir(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	dryRun := false
+	if value := r.URL.Query().Get("dryRun"); value != "" {
+		var err error
+		dryRun, err = strconv.ParseBool(value)
+		if err != nil {
+			api.send(w, http.StatusBadRequest, fmt.Errorf("invalid dryRun %q: %w", value, storagedata.ErrInvalidRequest))
+			return
+		}
+	}
+
+	statusCode, err := api.authorizeTree(r, storagedata.PermDelete, dirParam(ps))
+	if err != nil {
+		fmt.Printf("[deleteDir] Error in authorize with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+	statusCode, files, err := api.storageDocument.DeleteDir(dirParam(ps), dryRun)
+	if err != nil {
+		fmt.Printf("[deleteDir] Error in deleteDir with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+
+	api.send(w, http.StatusOK, dirDeleteResponse{DryRun: dryRun, Files: files})
+}
+
+func dirParam(ps httprouter.Params) string {
+	return strings.TrimPrefix(ps.ByName("path"), "/")
+}
+
+func readDirRequest(r *http.Request) (dirRequest, error) {
+	var req dirRequest
+	err := json.NewDecoder(limitBody(r.Body, IOMaxBufferSize)).Decode(&req)
+	if err != nil {
+		return req, invalidBody(err)
+	}
+	if req.Path == "" {
+		return req, fmt.Errorf("missing field path: %w", storagedata.ErrInvalidPath)
+	}
+	return req, nil
+}
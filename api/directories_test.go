@@ -0,0 +1,81 @@
+package api_test
+
+import (
+	"americanas/storagedata"
+	"americanas/test"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestListDir(t *testing.T) {
+	testCase := "test-list-dir"
+	fixture := setup(t)
+	fixture.storage.status = http.StatusOK
+	fixture.storage.files = underDirFakeResult()
+
+	status, returnBody, _ := fixture.request("/v2/dirs/ht", "GET", nil)
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+	test.AssertEqual(t, testCase, strings.HasPrefix(returnBody, `{"path":"ht","directories":["monthly"],"files":[{"id":"aab053840116dacaf13a062d909e5761"`), true)
+	test.AssertEqual(t, testCase, fixture.storage.path, "ht")
+
+	fixture.request("/v2/dirs/", "GET", nil)
+	test.AssertEqual(t, testCase, fixture.storage.path, "")
+
+	fixture.storage.status = http.StatusNotFound
+	fixture.storage.err = fmt.Errorf("directory unknown: %w", storagedata.ErrNotFound)
+	status, _, _ = fixture.request("/v2/dirs/unknown", "GET", nil)
+	test.AssertEqual(t, testCase, status, http.StatusNotFound)
+}
+
+func TestCreateDir(t *testing.T) {
+	testCase := "test-create-dir"
+	fixture := setup(t)
+	fixture.storage.status = http.StatusCreated
+
+	status, returnBody, header := fixture.request("/v2/dirs", "POST", strings.NewReader(`{"path":"ht/weekly"}`))
+	test.AssertEqual(t, testCase, status, http.StatusCreated)
+	test.AssertEqual(t, testCase, returnBody, `{"path":"ht/weekly"}`)
+	test.AssertEqual(t, testCase, header.Get("Location"), "/v2/dirs/ht/weekly")
+	test.AssertEqual(t, testCase, fixture.storage.path, "ht/weekly")
+
+	status, returnBody, _ = fixture.request("/v2/dirs", "POST", strings.NewReader(`{}`))
+	test.AssertEqual(t, testCase, status, http.StatusBadRequest)
+	test.AssertEqual(t, testCase, strings.Contains(returnBody, `"code":"invalid_path"`), true)
+
+	fixture.storage.status = http.StatusConflict
+	fixture.storage.err = fmt.Errorf("directory ht/weekly already exists: %w", storagedata.ErrConflict)
+	status, _, _ = fixture.request("/v2/dirs", "POST", strings.NewReader(`{"path":"ht/weekly"}`))
+	test.AssertEqual(t, testCase, status, http.StatusConflict)
+}
+
+func TestMoveDir(t *testing.T) {
+	testCase := "test-move-dir"
+	fixture := setup(t)
+	fixture.storage.status = http.StatusOK
+
+	status, _, header := fixture.request("/v2/dirs/ht/monthly", "PATCH", strings.NewReader(`{"path":"archive/monthly"}`))
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+	test.AssertEqual(t, testCase, header.Get("Location"), "/v2/dirs/archive/monthly")
+	test.AssertEqual(t, testCase, fixture.storage.path, "ht/monthly -> archive/monthly")
+}
+
+func TestDeleteDir(t *testing.T) {
+	testCase := "test-delete-dir"
+	fixture := setup(t)
+	fixture.storage.status = http.StatusOK
+	fixture.storage.files = underDirFakeResult()
+
+	status, returnBody, _ := fixture.request("/v2/dirs/ht?dryRun=true", "DELETE", nil)
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+	test.AssertEqual(t, testCase, strings.HasPrefix(returnBody, `{"dryRun":true,"files":[{"id":"aab053840116dacaf13a062d909e5761"`), true)
+	test.AssertEqual(t, testCase, fixture.storage.path, "ht")
+
+	status, returnBody, _ = fixture.request("/v2/dirs/ht", "DELETE", nil)
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+	test.AssertEqual(t, testCase, strings.HasPrefix(returnBody, `{"dryRun":false`), true)
+
+	status, _, _ = fixture.request("/v2/dirs/ht?dryRun=perhaps", "DELETE", nil)
+	test.AssertEqual(t, testCase, status, http.StatusBadRequest)
+}
@@ -0,0 +1,282 @@
+package api
+
+import (
+	"americanas/auth"
+	"americanas/storagedata"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Pre-signed URLs let whoever holds them download a file or upload one to a
+// given path without credentials. Everything they allow is in their query,
+// signed with HMAC-SHA256, so nothing is kept on the server: a URL is valid
+// until it expires and can't be revoked before, short of changing the key.
+
+const (
+	defaultPresignExpiry = 15 * time.Minute
+	maxPresignExpiry     = 7 * 24 * time.Hour
+)
+
+// signedParams are the query parameters covered by the signature. Others,
+// such as disposition on downloads, are left to the holder of the URL.
+var signedParams = []string{"path", "owner", "expires", "maxSize", "type"}
+
+// WithPresignKey sets the key pre-signed URLs are signed with. Without it a
+// random key is used, and URLs stop working when the process restarts.
+func WithPresignKey(key []byte) Option {
+	return func(api *Api) {
+		api.presignKey = key
+	}
+}
+
+// presignRequest is the body of POST /v2/presign. FileID asks for a download
+// URL, Path, the path of the file to store, for an upload URL. ExpiresIn is
+// a duration such as "1h".
+type presignRequest struct {
+	FileID      string `json:"fileId"`
+	Path        string `json:"path"`
+	ExpiresIn   string `json:"expiresIn"`
+	MaxSize     int64  `json:"maxSize"`
+	ContentType string `json:"contentType"`
+}
+
+// presignResponse is a pre-signed URL, relative to the API address, and the
+// method it is meant for.
+type presignResponse struct {
+	Method    string    `json:"method"`
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+func (api *Api) registerPresignRouters(router routes) {
+	router.POST("/v2/presign", api.presign)
+	// The signature stands for credentials on these.
+	router.Router.GET("/v2/presigned/files/:id", api.presignedDownload)
+	router.Router.HEAD("/v2/presigned/files/:id", api.presignedDownload)
+	router.Router.PUT("/v2/presigned/upload", api.presignedUpload)
+}
+
+// presign issues a URL for what the caller may do now: reading the file, or
+// writing where the upload goes.
+func (api *Api) presign(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var req presignRequest
+	err := json.NewDecoder(limitBody(r.Body, IOMaxBufferSize)).Decode(&req)
+	if err != nil {
+		fmt.Printf("[presign] Error in readBody. error %v", err.Error())
+		api.send(w, http.StatusBadRequest, invalidBody(err))
+		return
+	}
+
+	expiresIn := defaultPresignExpiry
+	if req.ExpiresIn != "" {
+		expiresIn, err = time.ParseDuration(req.ExpiresIn)
+	}
+	switch {
+	case err != nil:
+		err = fmt.Errorf("invalid expiresIn %q: %w", req.ExpiresIn, storagedata.ErrInvalidRequest)
+	case (req.FileID == "") == (req.Path == ""):
+		err = fmt.Errorf("expected either fileId or path: %w", storagedata.ErrInvalidRequest)
+	case expiresIn <= 0 || expiresIn > maxPresignExpiry:
+		err = fmt.Errorf("expiresIn must be between 0 and %s: %w", maxPresignExpiry, storagedata.ErrInvalidRequest)
+	case req.MaxSize < 0:
+		err = fmt.Errorf("negative maxSize: %w", storagedata.ErrInvalidRequest)
+	case req.FileID != "" && (req.MaxSize > 0 || req.ContentType != ""):
+		err = fmt.Errorf("maxSize and contentType only apply to uploads: %w", storagedata.ErrInvalidRequest)
+	}
+	if err != nil {
+		api.send(w, http.StatusBadRequest, err)
+		return
+	}
+
+	expiresAt := time.Now().Add(expiresIn).Truncate(time.Second)
+	query := url.Values{"expires": {strconv.FormatInt(expiresAt.Unix(), 10)}}
+	var statusCode int
+	resp := presignResponse{ExpiresAt: expiresAt}
+	if req.FileID != "" {
+		statusCode, _, err = api.authorizeFile(r, storagedata.PermRead, req.FileID)
+		resp.Method = http.MethodGet
+		resp.URL = "/v2/presigned/files/" + url.PathEscape(req.FileID)
+	} else {
+		statusCode, err = api.presignUpload(r, req, query)
+		resp.Method = http.MethodPut
+		resp.URL = "/v2/presigned/upload"
+	}
+	if err != nil {
+		fmt.Printf("[presign] Error in presign with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+
+	query.Set("sig", base64.RawURLEncoding.EncodeToString(api.signature(resp.Method, resp.URL, query)))
+	resp.URL += "?" + query.Encode()
+	api.send(w, http.StatusCreated, resp)
+}
+
+// presignUpload checks the target of an upload URL and adds its constraints
+// to query. The file will belong to the caller.
+func (api *Api) presignUpload(r *http.Request, req presignRequest, query url.Values) (int, error) {
+	target, err := storagedata.CleanPath(req.Path)
+	if err == nil && target == "" {
+		err = fmt.Errorf("missing file name: %w", storagedata.ErrInvalidPath)
+	}
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	if req.ContentType != "" {
+		if _, _, err := mime.ParseMediaType(req.ContentType); err != nil {
+			return http.StatusBadRequest, fmt.Errorf("invalid contentType %q: %w", req.ContentType, storagedata.ErrInvalidRequest)
+		}
+	}
+
+	statusCode, err := api.authorize(r, storagedata.PermWrite, path.Dir(target))
+	if err != nil {
+		return statusCode, err
+	}
+
+	query.Set("path", target)
+	if owner := auth.Owner(r.Context()); owner != "" {
+		query.Set("owner", owner)
+	}
+	if req.MaxSize > 0 {
+		query.Set("maxSize", strconv.FormatInt(req.MaxSize, 10))
+	}
+	if req.ContentType != "" {
+		query.Set("type", req.ContentType)
+	}
+	return http.StatusOK, nil
+}
+
+func (api *Api) presignedDownload(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id := ps.ByName("id")
+	if err := api.checkSignature(http.MethodGet, "/v2/presigned/files/"+url.PathEscape(id), r.URL.Query()); err != nil {
+		fmt.Printf("[presignedDownload] Error in checkSignature. error %v", err)
+		api.send(w, http.StatusForbidden, err)
+		return
+	}
+
+	statusCode, file, entry, err := api.storageDocument.OpenByID(id)
+	if err != nil {
+		fmt.Printf("[presignedDownload] Error in presignedDownload with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+	defer file.Close()
+
+	serveDownload(w, r, file, entry)
+}
+
+// presignedUpload stores the request body at the signed path, renamed if the
+// path is taken, within the signed size and type.
+func (api *Api) presignedUpload(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	query := r.URL.Query()
+	if err := api.checkSignature(http.MethodPut, "/v2/presigned/upload", query); err != nil {
+		fmt.Printf("[presignedUpload] Error in checkSignature. error %v", err)
+		api.send(w, http.StatusForbidden, err)
+		return
+	}
+
+	limit := api.maxUploadSize
+	if maxSize, _ := strconv.ParseInt(query.Get("maxSize"), 10, 64); maxSize > 0 && (limit == 0 || maxSize < limit) {
+		limit = maxSize
+	}
+	if limit > 0 {
+		if r.ContentLength > limit {
+			api.send(w, http.StatusRequestEntityTooLarge, fmt.Errorf("upload larger than %d bytes: %w", limit, storagedata.ErrTooLarge))
+			return
+		}
+		r.Body = limitBody(r.Body, limit)
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	if want := query.Get("type"); want != "" && !sameMediaType(contentType, want) {
+		api.send(w, http.StatusBadRequest, fmt.Errorf("Content-Type must be %s: %w", want, storagedata.ErrInvalidRequest))
+		return
+	}
+
+	target := query.Get("path")
+	req := storagedata.UploadRequest{
+		Path:        path.Dir(target),
+		Name:        path.Base(target),
+		ContentType: contentType,
+		Content:     r.Body,
+		Owner:       query.Get("owner"),
+		Size:        r.ContentLength,
+	}
+	var err error
+	req.Digest, err = storagedata.DigestFromHeaders(r.Header)
+	if err != nil {
+		api.send(w, http.StatusBadRequest, err)
+		return
+	}
+
+	statusCode, file, err := api.storageDocument.StorageFile(req)
+	if err != nil {
+		fmt.Printf("[presignedUpload] Error in presignedUpload with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+
+	w.Header().Set("Location", "/v2/files/"+file.ID)
+	api.send(w, http.StatusCreated, file)
+}
+
+// signature is the HMAC of the method, the path and the signed parameters of
+// query.
+func (api *Api) signature(method, urlPath string, query url.Values) []byte {
+	signed := url.Values{}
+	for _, name := range signedParams {
+		if values, ok := query[name]; ok {
+			signed[name] = values
+		}
+	}
+
+	mac := hmac.New(sha256.New, api.presignKey)
+	io.WriteString(mac, method+"\n"+urlPath+"\n"+signed.Encode())
+	return mac.Sum(nil)
+}
+
+// checkSignature fails with storagedata.ErrForbidden unless query carries a
+// valid signature for the request and hasn't expired.
+func (api *Api) checkSignature(method, urlPath string, query url.Values) error {
+	sig, err := base64.RawURLEncoding.DecodeString(query.Get("sig"))
+	if err != nil || !hmac.Equal(sig, api.signature(method, urlPath, query)) {
+		return fmt.Errorf("invalid signature: %w", storagedata.ErrForbidden)
+	}
+
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil || time.Now().Unix() >= expires {
+		return fmt.Errorf("URL expired: %w", storagedata.ErrForbidden)
+	}
+	return nil
+}
+
+// randomKey is the pre-sign key used when none is configured.
+func randomKey() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+func sameMediaType(got, want string) bool {
+	gotType, _, err := mime.ParseMediaType(got)
+	if err != nil {
+		return false
+	}
+	wantType, _, _ := mime.ParseMediaType(want)
+	return gotType == wantType
+}
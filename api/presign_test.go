@@ -0,0 +1,108 @@
+package api_test
+
+import (
+	"americanas/api"
+	"americanas/storagedata"
+	"americanas/test"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var presignKey = []byte("s3cr3t")
+
+// presignURL asks the API for a pre-signed URL.
+func (f *fixture) presignURL(body string) (int, string) {
+	status, returnBody := f.requestAs("v2/presign", "POST", strings.NewReader(body))
+	var resp struct {
+		URL string `json:"url"`
+	}
+	json.Unmarshal([]byte(returnBody), &resp)
+	return status, strings.TrimPrefix(resp.URL, "/")
+}
+
+func TestPresignedDownload(t *testing.T) {
+	testCase := "test-presigned-download"
+	fixture := authedFixture(t, api.WithPresignKey(presignKey))
+
+	status, link := fixture.presignURL(`{"fileId": "aab053840116dacaf13a062d909e5761", "expiresIn": "1h"}`)
+	test.AssertEqual(t, testCase, status, http.StatusCreated)
+	test.AssertEqual(t, testCase, strings.HasPrefix(link, "v2/presigned/files/aab053840116dacaf13a062d909e5761?"), true)
+
+	// No credentials needed, the signature stands for them.
+	status, _, header := fixture.request(link, "GET", nil)
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+	test.AssertEqual(t, testCase, header.Get("Content-Length"), "338135")
+	status, _, _ = fixture.request(link+"&disposition=inline", "HEAD", nil)
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+
+	status, returnBody, _ := fixture.request(strings.Replace(link, "aab053840116dacaf13a062d909e5761", "0cb90ac871279cc942de976882b71a00", 1), "GET", nil)
+	test.AssertEqual(t, testCase, status, http.StatusForbidden)
+	test.AssertEqual(t, testCase, strings.Contains(returnBody, `"code":"forbidden"`), true)
+
+	expired := signedURL("GET", "/v2/presigned/files/aab053840116dacaf13a062d909e5761", url.Values{
+		"expires": {strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)},
+	})
+	status, returnBody, _ = fixture.request(expired, "GET", nil)
+	test.AssertEqual(t, testCase, status, http.StatusForbidden)
+	test.AssertEqual(t, testCase, strings.Contains(returnBody, "URL expired"), true)
+}
+
+func TestPresignedUpload(t *testing.T) {
+	testCase := "test-presigned-upload"
+	fixture := authedFixture(t, api.WithPresignKey(presignKey))
+	fixture.storage.status = http.StatusCreated
+
+	status, link := fixture.presignURL(`{"path": "ht/monthly/mars.png", "maxSize": 10, "contentType": "image/png"}`)
+	test.AssertEqual(t, testCase, status, http.StatusCreated)
+
+	put := func(link, contentType, body string) int {
+		req := fixture.createRequest(link, "PUT", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		status, _, _ := fixture.sendRequest(req)
+		return status
+	}
+	test.AssertEqual(t, testCase, put(link, "image/png", "12345"), http.StatusCreated)
+	test.AssertEqual(t, testCase, fixture.storage.path, "ht/monthly")
+	test.AssertEqual(t, testCase, fixture.storage.owner, "alice")
+
+	test.AssertEqual(t, testCase, put(link, "image/png", "12345678901"), http.StatusRequestEntityTooLarge)
+	test.AssertEqual(t, testCase, put(link, "text/plain", "12345"), http.StatusBadRequest)
+	test.AssertEqual(t, testCase, put(strings.Replace(link, "maxSize=10", "maxSize=10000", 1), "image/png", "12345"), http.StatusForbidden)
+}
+
+func TestPresignChecksAccess(t *testing.T) {
+	testCase := "test-presign-checks-access"
+	fixture := authedFixture(t, api.WithPresignKey(presignKey))
+	fixture.storage.acls = storagedata.ACLs{
+		"": {Path: "", Grants: map[string][]storagedata.Permission{
+			"admin":              {storagedata.PermAdmin},
+			storagedata.Everyone: {storagedata.PermRead},
+		}},
+	}
+
+	status, _ := fixture.presignURL(`{"fileId": "aab053840116dacaf13a062d909e5761"}`)
+	test.AssertEqual(t, testCase, status, http.StatusCreated)
+	status, _ = fixture.presignURL(`{"path": "ht/monthly/mars.png"}`)
+	test.AssertEqual(t, testCase, status, http.StatusForbidden)
+
+	status, _ = fixture.presignURL(`{"fileId": "aab053840116dacaf13a062d909e5761", "path": "ht/monthly/mars.png"}`)
+	test.AssertEqual(t, testCase, status, http.StatusBadRequest)
+	status, _ = fixture.presignURL(`{"fileId": "aab053840116dacaf13a062d909e5761", "expiresIn": "720h"}`)
+	test.AssertEqual(t, testCase, status, http.StatusBadRequest)
+}
+
+// signedURL signs a URL the way the API does, for what it wouldn't issue.
+func signedURL(method, urlPath string, query url.Values) string {
+	mac := hmac.New(sha256.New, presignKey)
+	mac.Write([]byte(method + "\n" + urlPath + "\n" + query.Encode()))
+	query.Set("sig", base64.RawURLEncoding.EncodeToString(mac.Sum(nil)))
+	return strings.TrimPrefix(urlPath, "/") + "?" + query.Encode()
+}
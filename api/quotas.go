@@ -0,0 +1,124 @@
+package api
+
+import (
+	"americanas/auth"
+	"americanas/storagedata"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// usageResponse is the body of GET /v2/usage: what the files of Owner take,
+// and the quotas that apply to them.
+type usageResponse struct {
+	Owner  string                   `json:"owner,omitempty"`
+	Used   storagedata.Usage        `json:"used"`
+	Quotas []storagedata.QuotaUsage `json:"quotas"`
+}
+
+func (api *Api) registerQuotaRouters(router routes) {
+	router.GET("/v2/quotas", api.listQuotas)
+	router.PUT("/v2/quotas", api.setQuota)
+	router.GET("/v2/usage", api.usage)
+}
+
+// listQuotas answers the quotas the caller may edit with their usage.
+func (api *Api) listQuotas(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	statusCode, acc, err := api.access(r)
+	if err != nil {
+		fmt.Printf("[listQuotas] Error in access with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+
+	statusCode, quotas, err := api.storageDocument.Quotas()
+	if err != nil {
+		fmt.Printf("[listQuotas] Error in listQuotas with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+
+	list := make([]storagedata.QuotaUsage, 0, len(quotas))
+	for _, q := range quotas {
+		if acc.can(storagedata.PermAdmin, q.Path) {
+			list = append(list, q)
+		}
+	}
+	api.send(w, http.StatusOK, list)
+}
+
+// setQuota replaces a quota, removing it when it has no limits. Quotas of
+// owners take admin on the root, those of directories admin on them.
+func (api *Api) setQuota(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var q storagedata.Quota
+	err := json.NewDecoder(limitBody(r.Body, IOMaxBufferSize)).Decode(&q)
+	if err != nil {
+		fmt.Printf("[setQuota] Error in readBody. error %v", err.Error())
+		api.send(w, http.StatusBadRequest, invalidBody(err))
+		return
+	}
+
+	if statusCode, err := api.authorize(r, storagedata.PermAdmin, q.Path); err != nil {
+		fmt.Printf("[setQuota] Error in authorize with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+
+	statusCode, q, err := api.storageDocument.SetQuota(q)
+	if err != nil {
+		fmt.Printf("[setQuota] Error in setQuota with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+
+	if q.MaxBytes == 0 && q.MaxFiles == 0 {
+		api.send(w, http.StatusNoContent, nil)
+		return
+	}
+	api.send(w, http.StatusOK, q)
+}
+
+// usage answers what the caller's files take, with the quota of the caller
+// and those of the directories they can see. Admins of the root may ask for
+// another owner with ?owner=.
+func (api *Api) usage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	statusCode, acc, err := api.access(r)
+	if err != nil {
+		fmt.Printf("[usage] Error in access with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+
+	owner := auth.Owner(r.Context())
+	if other := r.URL.Query().Get("owner"); other != "" && other != owner {
+		if !acc.can(storagedata.PermAdmin, "") {
+			api.send(w, http.StatusForbidden, acc.forbidden(storagedata.PermAdmin, ""))
+			return
+		}
+		owner = other
+		acc.owner = other
+	}
+
+	statusCode, used, err := api.storageDocument.Usage(owner)
+	if err != nil {
+		fmt.Printf("[usage] Error in usage with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+	statusCode, quotas, err := api.storageDocument.Quotas()
+	if err != nil {
+		fmt.Printf("[usage] Error in Quotas with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+
+	resp := usageResponse{Owner: owner, Used: used, Quotas: make([]storagedata.QuotaUsage, 0, len(quotas))}
+	for _, q := range quotas {
+		if (q.Owner != "" && q.Owner == owner) || (q.Path != "" && acc.acls.Reaches(owner, q.Path)) {
+			resp.Quotas = append(resp.Quotas, q)
+		}
+	}
+	api.send(w, http.StatusOK, resp)
+}
@@ -0,0 +1,99 @@
+package api_test
+
+import (
+	"americanas/storagedata"
+	"americanas/test"
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// quotaFixture is aclFixture with alice administering finance.
+func quotaFixture(t *testing.T) *fixture {
+	fixture := aclFixture(t)
+	fixture.storage.acls["finance"].Grants["alice"] = []storagedata.Permission{storagedata.PermAdmin}
+	fixture.storage.quotas = []storagedata.QuotaUsage{
+		{Quota: storagedata.Quota{Owner: "alice", MaxBytes: 100}, Used: storagedata.Usage{Bytes: 60, Files: 2}},
+		{Quota: storagedata.Quota{Owner: "bob", MaxFiles: 10}},
+		{Quota: storagedata.Quota{Path: "finance", MaxFiles: 10}},
+		{Quota: storagedata.Quota{Path: "secret", MaxFiles: 10}},
+	}
+	return fixture
+}
+
+func TestUsage(t *testing.T) {
+	testCase := "test-usage"
+	fixture := quotaFixture(t)
+	fixture.storage.usage = storagedata.Usage{Bytes: 60, Files: 2}
+
+	status, returnBody := fixture.requestAs("v2/usage", "GET", nil)
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+	var usage struct {
+		Owner  string                   `json:"owner"`
+		Used   storagedata.Usage        `json:"used"`
+		Quotas []storagedata.QuotaUsage `json:"quotas"`
+	}
+	json.Unmarshal([]byte(returnBody), &usage)
+	test.AssertEqual(t, testCase, fixture.storage.owner, "alice")
+	test.AssertEqual(t, testCase, usage.Owner, "alice")
+	test.AssertEqual(t, testCase, usage.Used, storagedata.Usage{Bytes: 60, Files: 2})
+	test.AssertEqual(t, testCase, len(usage.Quotas), 2)
+	test.AssertEqual(t, testCase, usage.Quotas[0].Owner, "alice")
+	test.AssertEqual(t, testCase, usage.Quotas[1].Path, "finance")
+
+	status, _ = fixture.requestAs("v2/usage?owner=bob", "GET", nil)
+	test.AssertEqual(t, testCase, status, http.StatusForbidden)
+}
+
+func TestEditQuotas(t *testing.T) {
+	testCase := "test-edit-quotas"
+	fixture := quotaFixture(t)
+
+	status, returnBody := fixture.requestAs("v2/quotas", "GET", nil)
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+	var quotas []storagedata.QuotaUsage
+	json.Unmarshal([]byte(returnBody), &quotas)
+	test.AssertEqual(t, testCase, len(quotas), 1)
+	test.AssertEqual(t, testCase, quotas[0].Path, "finance")
+
+	status, returnBody = fixture.requestAs("v2/quotas", "PUT", strings.NewReader(`{"path": "finance/2020", "maxBytes": 1000}`))
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+	test.AssertEqual(t, testCase, returnBody, `{"path":"finance/2020","maxBytes":1000}`)
+	test.AssertEqual(t, testCase, fixture.storage.quota, storagedata.Quota{Path: "finance/2020", MaxBytes: 1000})
+
+	status, _ = fixture.requestAs("v2/quotas", "PUT", strings.NewReader(`{"path": "finance/2020"}`))
+	test.AssertEqual(t, testCase, status, http.StatusNoContent)
+
+	status, _ = fixture.requestAs("v2/quotas", "PUT", strings.NewReader(`{"owner": "alice", "maxBytes": 1000000}`))
+	test.AssertEqual(t, testCase, status, http.StatusForbidden)
+}
+
+func TestQuotaExceeded(t *testing.T) {
+	testCase := "test-quota-exceeded"
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	writer.WriteField("path", "ht/monthly")
+	part, _ := writer.CreateFormFile("file", "golang.png")
+	part.Write(getFileTest("mars.png"))
+	writer.Close()
+
+	remaining := int64(40)
+	fixture := setup(t)
+	fixture.storage.status = http.StatusInsufficientStorage
+	fixture.storage.err = &storagedata.QuotaError{
+		Quota: storagedata.QuotaUsage{
+			Quota:          storagedata.Quota{Path: "ht", MaxBytes: 400000},
+			Used:           storagedata.Usage{Bytes: 399960, Files: 3},
+			RemainingBytes: &remaining,
+		},
+		Size: 338135,
+	}
+
+	status, returnBody, _ := fixture.requestMultiPart("/v2/files", "POST", body, *writer)
+	test.AssertEqual(t, testCase, status, http.StatusInsufficientStorage)
+	test.AssertEqual(t, testCase, strings.Contains(returnBody, `"code":"quota_exceeded"`), true)
+	test.AssertEqual(t, testCase, strings.Contains(returnBody, `"remainingBytes":40`), true)
+}
@@ -0,0 +1,29 @@
+package api
+
+import (
+	"americanas/storagedata"
+	"fmt"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// scrub rehashes every stored content and reports the missing, modified and
+// orphaned files. It reads the whole store, so it can take a while, and is
+// only open to admins of the root.
+func (api *Api) scrub(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	statusCode, err := api.authorize(r, storagedata.PermAdmin, "")
+	if err != nil {
+		fmt.Printf("[scrub] Error in authorize with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+	statusCode, report, err := api.storageDocument.Scrub()
+	if err != nil {
+		fmt.Printf("[scrub] Error in scrub with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+
+	api.send(w, http.StatusOK, report)
+}
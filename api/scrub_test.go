@@ -0,0 +1,22 @@
+package api_test
+
+import (
+	"americanas/storagedata"
+	"americanas/test"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestGETScrub(t *testing.T) {
+	testCase := "test-get-scrub"
+	fixture, server := contentFixture(t)
+	defer server.Close()
+	// Without authentication, the storage makes everyone an admin.
+	fixture.storage.acls = storagedata.ACLs{"": {Path: "", Grants: map[string][]storagedata.Permission{"": {storagedata.PermAdmin}}}}
+
+	resp, body := getContent(t, server, "GET", "/v2/scrub", nil)
+	test.AssertEqual(t, testCase, resp.StatusCode, http.StatusOK)
+	test.AssertEqual(t, testCase, strings.Contains(string(body), `"modified":[{"id":"aab053840116dacaf13a062d909e5761","path":"ht/monthly/mars.png","version":1`), true)
+	test.AssertEqual(t, testCase, strings.Contains(string(body), `"orphaned":["blobs/ab/abcd"]`), true)
+}
@@ -0,0 +1,266 @@
+package api
+
+import (
+	"americanas/auth"
+	"americanas/storagedata"
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Share links give anyone holding them a file, or a directory as a zip, with
+// the access their creator has when they are opened. Unlike pre-signed URLs
+// they are kept by the storage, so they can be listed, revoked, protected by
+// a password and limited in downloads.
+
+// shareRequest is the body of POST /v2/shares. ExpiresIn is a duration such
+// as "24h"; without it the link doesn't expire.
+type shareRequest struct {
+	FileID       string `json:"fileId"`
+	Dir          string `json:"dir"`
+	Password     string `json:"password"`
+	ExpiresIn    string `json:"expiresIn"`
+	MaxDownloads int    `json:"maxDownloads"`
+}
+
+// shareResponse is a share with its URL, relative to the API address.
+type shareResponse struct {
+	storagedata.Share
+	URL string `json:"url"`
+}
+
+func (api *Api) registerShareRouters(router routes) {
+	router.POST("/v2/shares", api.createShare)
+	router.GET("/v2/shares", api.listShares)
+	router.DELETE("/v2/shares/:token", api.revokeShare)
+	// The token stands for credentials here.
+	router.Router.GET("/s/:token", api.openShare)
+}
+
+// createShare shares what the caller may read.
+func (api *Api) createShare(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var req shareRequest
+	err := json.NewDecoder(limitBody(r.Body, IOMaxBufferSize)).Decode(&req)
+	if err != nil {
+		fmt.Printf("[createShare] Error in readBody. error %v", err.Error())
+		api.send(w, http.StatusBadRequest, invalidBody(err))
+		return
+	}
+
+	var expiresAt time.Time
+	if req.ExpiresIn != "" {
+		expiresIn, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || expiresIn <= 0 {
+			api.send(w, http.StatusBadRequest, fmt.Errorf("invalid expiresIn %q: %w", req.ExpiresIn, storagedata.ErrInvalidRequest))
+			return
+		}
+		expiresAt = time.Now().Add(expiresIn).Truncate(time.Second)
+	}
+
+	var statusCode int
+	switch {
+	case req.FileID != "" && req.Dir == "":
+		statusCode, _, err = api.authorizeFile(r, storagedata.PermRead, req.FileID)
+	case req.Dir != "" && req.FileID == "":
+		statusCode, err = api.authorize(r, storagedata.PermRead, req.Dir)
+	default:
+		statusCode, err = http.StatusBadRequest, fmt.Errorf("expected either fileId or dir: %w", storagedata.ErrInvalidRequest)
+	}
+	if err != nil {
+		fmt.Printf("[createShare] Error in authorize with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+
+	statusCode, share, err := api.storageDocument.CreateShare(storagedata.ShareRequest{
+		FileID:       req.FileID,
+		Dir:          req.Dir,
+		Owner:        auth.Owner(r.Context()),
+		Password:     req.Password,
+		ExpiresAt:    expiresAt,
+		MaxDownloads: req.MaxDownloads,
+	})
+	if err != nil {
+		fmt.Printf("[createShare] Error in createShare with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+
+	api.send(w, http.StatusCreated, shareResponse{Share: share, URL: shareURL(share)})
+}
+
+// listShares answers the caller's shares, or every share to admins of the
+// root.
+func (api *Api) listShares(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	statusCode, acc, err := api.access(r)
+	if err != nil {
+		fmt.Printf("[listShares] Error in access with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+
+	statusCode, shares, err := api.storageDocument.Shares()
+	if err != nil {
+		fmt.Printf("[listShares] Error in listShares with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+
+	list := make([]shareResponse, 0, len(shares))
+	for _, share := range shares {
+		if acc.mayManage(share) {
+			list = append(list, shareResponse{Share: share, URL: shareURL(share)})
+		}
+	}
+	api.send(w, http.StatusOK, list)
+}
+
+func (api *Api) revokeShare(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	token := ps.ByName("token")
+	statusCode, acc, err := api.access(r)
+	if err != nil {
+		fmt.Printf("[revokeShare] Error in access with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+
+	statusCode, shares, err := api.storageDocument.Shares()
+	if err != nil {
+		fmt.Printf("[revokeShare] Error in revokeShare with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+
+	// Shares of others are reported as missing, as they aren't listed either.
+	statusCode, err = http.StatusNotFound, fmt.Errorf("share %s: %w", token, storagedata.ErrNotFound)
+	for _, share := range shares {
+		if share.Token == token && acc.mayManage(share) {
+			statusCode, err = api.storageDocument.RevokeShare(token)
+			break
+		}
+	}
+	if err != nil {
+		fmt.Printf("[revokeShare] Error in revokeShare with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+
+	api.send(w, http.StatusNoContent, nil)
+}
+
+// openShare serves a share link. The password of protected links is taken
+// from Basic credentials, whatever the user name.
+func (api *Api) openShare(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	_, password, _ := r.BasicAuth()
+	statusCode, share, err := api.storageDocument.OpenShare(ps.ByName("token"), password)
+	if statusCode == http.StatusForbidden {
+		w.Header().Set("WWW-Authenticate", `Basic realm="share"`)
+		statusCode, err = http.StatusUnauthorized, fmt.Errorf("%v: %w", err, auth.ErrUnauthorized)
+	}
+	if err != nil {
+		fmt.Printf("[openShare] Error in openShare with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+
+	statusCode, acls, err := api.storageDocument.ACLs()
+	if err != nil {
+		fmt.Printf("[openShare] Error in ACLs with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+	acc := access{owner: share.Owner, acls: acls}
+
+	if share.Dir != "" {
+		api.serveShareDir(w, acc, share.Dir)
+		return
+	}
+
+	statusCode, file, entry, err := api.storageDocument.OpenByID(share.FileID)
+	if err == nil && !acc.canRead(entry) {
+		file.Close()
+		statusCode, err = http.StatusForbidden, acc.forbidden(storagedata.PermRead, entry.Path)
+	}
+	if err != nil {
+		fmt.Printf("[openShare] Error in openShare with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+	defer file.Close()
+
+	serveDownload(w, r, file, entry)
+}
+
+// serveShareDir streams a zip of the files below dir that acc may read, named
+// by their path relative to dir.
+func (api *Api) serveShareDir(w http.ResponseWriter, acc access, dir string) {
+	if !acc.acls.Reaches(acc.owner, dir) {
+		api.send(w, http.StatusForbidden, acc.forbidden(storagedata.PermRead, dir))
+		return
+	}
+
+	statusCode, files, err := api.storageDocument.UnderDir(dir)
+	if err != nil {
+		fmt.Printf("[serveShareDir] Error in UnderDir with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+	entries := make([]storagedata.FileMetadata, 0, len(files))
+	for _, entry := range acc.readable(files) {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", contentDisposition("attachment", path.Base(dir)+".zip"))
+	w.WriteHeader(http.StatusOK)
+
+	archive := zip.NewWriter(w)
+	for _, entry := range entries {
+		if err := api.zipEntry(archive, dir, entry); err != nil {
+			fmt.Printf("[serveShareDir] Skipping %s. error %v\n", entry.Path, err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		fmt.Printf("[serveShareDir] Error in Close. error %v\n", err)
+	}
+}
+
+func (api *Api) zipEntry(archive *zip.Writer, dir string, entry storagedata.FileMetadata) error {
+	_, file, entry, err := api.storageDocument.OpenByID(entry.ID)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	header := &zip.FileHeader{
+		Name:     strings.TrimPrefix(entry.Path, dir+"/"),
+		Method:   zip.Deflate,
+		Modified: entry.ModTime,
+	}
+	out, err := archive.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, file)
+	return err
+}
+
+// mayManage tells whether the caller may see and revoke share.
+func (acc access) mayManage(share storagedata.Share) bool {
+	return share.Owner == acc.owner || (len(acc.acls) > 0 && acc.can(storagedata.PermAdmin, ""))
+}
+
+func shareURL(share storagedata.Share) string {
+	return "/s/" + share.Token
+}
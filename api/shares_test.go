@@ -0,0 +1,124 @@
+package api_test
+
+import (
+	"americanas/storagedata"
+	"americanas/test"
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCreateShare(t *testing.T) {
+	testCase := "test-create-share"
+	fixture := authedFixture(t)
+
+	status, returnBody := fixture.requestAs("v2/shares", "POST", strings.NewReader(`{"fileId": "aab053840116dacaf13a062d909e5761", "password": "pw", "expiresIn": "24h", "maxDownloads": 3}`))
+	test.AssertEqual(t, testCase, status, http.StatusCreated)
+	test.AssertEqual(t, testCase, strings.Contains(returnBody, `"url":"/s/t0k3n"`), true)
+	test.AssertEqual(t, testCase, fixture.storage.shareReq.Owner, "alice")
+	test.AssertEqual(t, testCase, fixture.storage.shareReq.Password, "pw")
+	test.AssertEqual(t, testCase, fixture.storage.shareReq.MaxDownloads, 3)
+	test.AssertEqual(t, testCase, fixture.storage.shareReq.ExpiresAt.IsZero(), false)
+
+	status, _ = fixture.requestAs("v2/shares", "POST", strings.NewReader(`{"fileId": "aab053840116dacaf13a062d909e5761", "dir": "ht"}`))
+	test.AssertEqual(t, testCase, status, http.StatusBadRequest)
+	status, _ = fixture.requestAs("v2/shares", "POST", strings.NewReader(`{"dir": "ht", "expiresIn": "soon"}`))
+	test.AssertEqual(t, testCase, status, http.StatusBadRequest)
+
+	fixture.storage.acls = storagedata.ACLs{
+		"ht": {Path: "ht", Grants: map[string][]storagedata.Permission{"bob": {storagedata.PermRead}}},
+	}
+	status, _ = fixture.requestAs("v2/shares", "POST", strings.NewReader(`{"dir": "ht"}`))
+	test.AssertEqual(t, testCase, status, http.StatusForbidden)
+}
+
+func TestOpenShare(t *testing.T) {
+	testCase := "test-open-share"
+	fixture := authedFixture(t)
+	fixture.storage.share = storagedata.Share{Token: "t0k3n", FileID: "aab053840116dacaf13a062d909e5761", Owner: "alice", Protected: true}
+
+	status, _, header := fixture.request("s/t0k3n", "GET", nil)
+	test.AssertEqual(t, testCase, status, http.StatusUnauthorized)
+	test.AssertEqual(t, testCase, header.Get("WWW-Authenticate"), `Basic realm="share"`)
+
+	req := fixture.createRequest("s/t0k3n", "GET", nil)
+	req.SetBasicAuth("", "pw")
+	status, _, header = fixture.sendRequest(req)
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+	test.AssertEqual(t, testCase, header.Get("Content-Length"), "338135")
+	test.AssertEqual(t, testCase, header.Get("Content-Disposition"), `attachment; filename=mars.png`)
+
+	status, _, _ = fixture.request("s/0th3r", "GET", nil)
+	test.AssertEqual(t, testCase, status, http.StatusNotFound)
+
+	// The link stops working when its owner loses access to the file.
+	fixture.storage.share.Protected = false
+	fixture.storage.acls = storagedata.ACLs{
+		"ht": {Path: "ht", Grants: map[string][]storagedata.Permission{"bob": {storagedata.PermRead}}},
+	}
+	status, _, _ = fixture.request("s/t0k3n", "GET", nil)
+	test.AssertEqual(t, testCase, status, http.StatusForbidden)
+}
+
+func TestOpenDirShare(t *testing.T) {
+	testCase := "test-open-dir-share"
+	fixture := authedFixture(t)
+	fixture.storage.share = storagedata.Share{Token: "t0k3n", Dir: "ht", Owner: "alice"}
+	fixture.storage.files = map[string]storagedata.FileMetadata{
+		"1": {ID: "1", Name: "mars.png", Path: "ht/monthly/mars.png"},
+		"2": {ID: "2", Name: "earth.png", Path: "ht/secret/earth.png"},
+	}
+	fixture.storage.acls = storagedata.ACLs{
+		"ht/secret": {Path: "ht/secret", Grants: map[string][]storagedata.Permission{"bob": {storagedata.PermRead}}},
+	}
+
+	resp, err := http.DefaultClient.Do(fixture.createRequest("s/t0k3n", "GET", nil))
+	test.AssertNoError(t, testCase, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	test.AssertEqual(t, testCase, resp.StatusCode, http.StatusOK)
+	test.AssertEqual(t, testCase, resp.Header.Get("Content-Type"), "application/zip")
+	test.AssertEqual(t, testCase, resp.Header.Get("Content-Disposition"), `attachment; filename=ht.zip`)
+
+	archive, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, len(archive.File), 1)
+	test.AssertEqual(t, testCase, archive.File[0].Name, "monthly/mars.png")
+	test.AssertEqual(t, testCase, archive.File[0].UncompressedSize64, uint64(338135))
+}
+
+func TestListAndRevokeShares(t *testing.T) {
+	testCase := "test-list-and-revoke-shares"
+	fixture := authedFixture(t)
+	fixture.storage.shares = []storagedata.Share{
+		{Token: "b0b", Dir: "ht", Owner: "bob"},
+		{Token: "al1ce", FileID: "aab053840116dacaf13a062d909e5761", Owner: "alice"},
+	}
+
+	status, returnBody := fixture.requestAs("v2/shares", "GET", nil)
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+	var shares []storagedata.Share
+	json.Unmarshal([]byte(returnBody), &shares)
+	test.AssertEqual(t, testCase, len(shares), 1)
+	test.AssertEqual(t, testCase, shares[0].Token, "al1ce")
+
+	status, _ = fixture.requestAs("v2/shares/b0b", "DELETE", nil)
+	test.AssertEqual(t, testCase, status, http.StatusNotFound)
+	test.AssertEqual(t, testCase, fixture.storage.revoked, "")
+	status, _ = fixture.requestAs("v2/shares/al1ce", "DELETE", nil)
+	test.AssertEqual(t, testCase, status, http.StatusNoContent)
+	test.AssertEqual(t, testCase, fixture.storage.revoked, "al1ce")
+
+	// Admins of the root manage every share.
+	fixture.storage.acls = storagedata.ACLs{
+		"": {Path: "", Grants: map[string][]storagedata.Permission{"alice": {storagedata.PermAdmin}}},
+	}
+	status, returnBody = fixture.requestAs("v2/shares", "GET", nil)
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+	json.Unmarshal([]byte(returnBody), &shares)
+	test.AssertEqual(t, testCase, len(shares), 2)
+}
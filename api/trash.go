@@ -0,0 +1,86 @@
+package api
+
+import (
+	"americanas/storagedata"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func (api *Api) registerTrashRouters(router routes) {
+	router.GET("/v2/trash", api.trash)
+	router.POST("/v2/trash/:id/restore", api.restoreFile)
+	router.DELETE("/v2/trash/:id", api.purgeTrash)
+}
+
+// trash answers the deleted files the caller may read.
+func (api *Api) trash(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	statusCode, acc, err := api.access(r)
+	if err != nil {
+		fmt.Printf("[trash] Error in access with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+	statusCode, files, err := api.storageDocument.Trash()
+	if err != nil {
+		fmt.Printf("[trash] Error in trash with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+
+	visible := make([]storagedata.TrashedFile, 0, len(files))
+	for _, item := range files {
+		if acc.canRead(item.File) {
+			visible = append(visible, item)
+		}
+	}
+	api.send(w, http.StatusOK, visible)
+}
+
+// restoreFile puts a deleted file back at its original path. The body is
+// optional; {"conflict": "..."} picks what happens when the path is taken.
+func (api *Api) restoreFile(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var target fileUpdate
+	err := json.NewDecoder(limitBody(r.Body, IOMaxBufferSize)).Decode(&target)
+	if err != nil && err != io.EOF {
+		fmt.Printf("[restoreFile] Error in readBody. error %v", err.Error())
+		api.send(w, http.StatusBadRequest, invalidBody(err))
+		return
+	}
+
+	statusCode, err := api.authorizeTrashed(r, storagedata.PermWrite, ps.ByName("id"))
+	if err != nil {
+		fmt.Printf("[restoreFile] Error in authorize with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+	statusCode, file, err := api.storageDocument.RestoreFile(ps.ByName("id"), target.Conflict)
+	if err != nil {
+		fmt.Printf("[restoreFile] Error in restoreFile with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+
+	api.send(w, http.StatusOK, file)
+}
+
+// purgeTrash deletes a file from the trash for good.
+func (api *Api) purgeTrash(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	statusCode, err := api.authorizeTrashed(r, storagedata.PermDelete, ps.ByName("id"))
+	if err != nil {
+		fmt.Printf("[purgeTrash] Error in authorize with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+	statusCode, err = api.storageDocument.PurgeTrash(ps.ByName("id"))
+	if err != nil {
+		fmt.Printf("[purgeTrash] Error in purgeTrash with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+
+	api.send(w, http.StatusNoContent, nil)
+}
@@ -0,0 +1,57 @@
+package api_test
+
+import (
+	"americanas/storagedata"
+	"americanas/test"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestGETTrash(t *testing.T) {
+	testCase := "test-get-trash"
+	_, server := contentFixture(t)
+	defer server.Close()
+
+	resp, body := getContent(t, server, "GET", "/v2/trash", nil)
+	test.AssertEqual(t, testCase, resp.StatusCode, http.StatusOK)
+	test.AssertEqual(t, testCase, strings.HasPrefix(string(body), `[{"file":{"id":"aab053840116dacaf13a062d909e5761"`), true)
+	test.AssertEqual(t, testCase, strings.Contains(string(body), `"deletedAt":`), true)
+}
+
+func TestPOSTRestoreFile(t *testing.T) {
+	testCase := "test-post-restore-file"
+	fixture, server := contentFixture(t)
+	defer server.Close()
+
+	resp, body := getContent(t, server, "POST", "/v2/trash/aab053840116dacaf13a062d909e5761/restore", nil)
+	test.AssertEqual(t, testCase, resp.StatusCode, http.StatusOK)
+	test.AssertEqual(t, testCase, strings.Contains(string(body), `"path":"ht/monthly/mars.png"`), true)
+	test.AssertEqual(t, testCase, fixture.storage.conflict, storagedata.ConflictPolicy(""))
+
+	status, _, _ := fixture.request("/v2/trash/aab053840116dacaf13a062d909e5761/restore", "POST", strings.NewReader(`{"conflict":"overwrite"}`))
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+	test.AssertEqual(t, testCase, fixture.storage.conflict, storagedata.ConflictOverwrite)
+}
+
+func TestPOSTRestoreFileNotInTrash(t *testing.T) {
+	testCase := "test-post-restore-file-not-in-trash"
+	fixture, server := contentFixture(t)
+	defer server.Close()
+	fixture.storage.status = http.StatusNotFound
+	fixture.storage.err = storagedata.ErrNotFound
+
+	resp, body := getContent(t, server, "POST", "/v2/trash/unknown/restore", nil)
+	test.AssertEqual(t, testCase, resp.StatusCode, http.StatusNotFound)
+	test.AssertEqual(t, testCase, strings.Contains(string(body), `"code":"not_found"`), true)
+}
+
+func TestDELETETrash(t *testing.T) {
+	testCase := "test-delete-trash"
+	_, server := contentFixture(t)
+	defer server.Close()
+
+	resp, body := getContent(t, server, "DELETE", "/v2/trash/aab053840116dacaf13a062d909e5761", nil)
+	test.AssertEqual(t, testCase, resp.StatusCode, http.StatusNoContent)
+	test.AssertEqual(t, testCase, len(body), 0)
+}
@@ -0,0 +1,245 @@
+package api
+
+import (
+	"americanas/auth"
+	"americanas/storagedata"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Resumable uploads follow the tus 1.0 core protocol
+// (https://tus.io/protocols/resumable-upload) with the creation, expiration
+// and termination extensions. The upload must carry "path" and "filename"
+// in its Upload-Metadata; once the last byte arrives the file is stored and
+// its ID is returned in the File-Id header.
+
+const tusVersion = "1.0.0"
+
+func (api *Api) registerTusRouters(router routes) {
+	router.OPTIONS("/uploads", api.tusOptions)
+	router.POST("/uploads", api.tusCreate)
+	router.HEAD("/uploads/:id", api.tusHead)
+	router.PATCH("/uploads/:id", api.tusPatch)
+	router.DELETE("/uploads/:id", api.tusDelete)
+}
+
+func (api *Api) tusOptions(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	tusHeaders(w)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", "creation,expiration,termination")
+	if api.maxUploadSize > 0 {
+		w.Header().Set("Tus-Max-Size", strconv.FormatInt(api.maxUploadSize, 10))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (api *Api) tusCreate(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if !api.tusResumable(w, r) {
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		api.tusError(w, http.StatusBadRequest, fmt.Errorf("invalid Upload-Length: %w", storagedata.ErrInvalidRequest))
+		return
+	}
+	if api.maxUploadSize > 0 && length > api.maxUploadSize {
+		api.tusError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("upload exceeds Tus-Max-Size: %w", storagedata.ErrTooLarge))
+		return
+	}
+
+	metadata, err := decodeUploadMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		api.tusError(w, http.StatusBadRequest, invalidBody(err))
+		return
+	}
+	// The file belongs to whoever created the upload, not to what the
+	// client claims.
+	delete(metadata, "owner")
+	if owner := auth.Owner(r.Context()); owner != "" {
+		metadata["owner"] = owner
+	}
+
+	statusCode, err := api.authorize(r, storagedata.PermWrite, metadata["path"])
+	if err != nil {
+		fmt.Printf("[tusCreate] Error in authorize with statusCode: %v - error %v", statusCode, err.Error())
+		api.tusError(w, statusCode, err)
+		return
+	}
+	statusCode, upload, err := api.storageDocument.CreateUpload(length, metadata)
+	if err != nil {
+		fmt.Printf("[tusCreate] Error in CreateUpload with statusCode: %v - error %v", statusCode, err.Error())
+		api.tusError(w, statusCode, err)
+		return
+	}
+
+	uploadHeaders(w, upload)
+	w.Header().Set("Location", "/uploads/"+upload.ID)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (api *Api) tusHead(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if !api.tusResumable(w, r) {
+		return
+	}
+
+	statusCode, upload, err := api.authorizeUpload(r, ps.ByName("id"))
+	if err != nil {
+		tusHeaders(w)
+		w.WriteHeader(statusCode)
+		return
+	}
+
+	uploadHeaders(w, upload)
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	w.Header().Set("Upload-Metadata", encodeUploadMetadata(upload.Metadata))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (api *Api) tusPatch(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if !api.tusResumable(w, r) {
+		return
+	}
+
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		api.tusError(w, http.StatusUnsupportedMediaType, errors.New("Content-Type must be application/offset+octet-stream"))
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		api.tusError(w, http.StatusBadRequest, fmt.Errorf("invalid Upload-Offset: %w", storagedata.ErrInvalidRequest))
+		return
+	}
+
+	statusCode, _, err := api.authorizeUpload(r, ps.ByName("id"))
+	if err != nil {
+		fmt.Printf("[tusPatch] Error in authorize with statusCode: %v - error %v", statusCode, err.Error())
+		api.tusError(w, statusCode, err)
+		return
+	}
+	statusCode, upload, err := api.storageDocument.WriteUpload(ps.ByName("id"), offset, r.Body)
+	if err != nil {
+		fmt.Printf("[tusPatch] Error in WriteUpload with statusCode: %v - error %v", statusCode, err.Error())
+		api.tusError(w, statusCode, err)
+		return
+	}
+
+	uploadHeaders(w, upload)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (api *Api) tusDelete(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if !api.tusResumable(w, r) {
+		return
+	}
+
+	statusCode, _, err := api.authorizeUpload(r, ps.ByName("id"))
+	if err != nil {
+		fmt.Printf("[tusDelete] Error in authorize with statusCode: %v - error %v", statusCode, err.Error())
+		api.tusError(w, statusCode, err)
+		return
+	}
+	statusCode, err = api.storageDocument.DeleteUpload(ps.ByName("id"))
+	if err != nil {
+		fmt.Printf("[tusDelete] Error in DeleteUpload with statusCode: %v - error %v", statusCode, err.Error())
+		api.tusError(w, statusCode, err)
+		return
+	}
+
+	tusHeaders(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authorizeUpload returns the upload id if the caller may write where it is
+// going to be stored.
+func (api *Api) authorizeUpload(r *http.Request, id string) (int, storagedata.Upload, error) {
+	statusCode, upload, err := api.storageDocument.GetUpload(id)
+	if err != nil {
+		return statusCode, upload, err
+	}
+
+	statusCode, err = api.authorize(r, storagedata.PermWrite, upload.Metadata["path"])
+	return statusCode, upload, err
+}
+
+// tusResumable rejects requests from clients speaking another protocol
+// version.
+func (api *Api) tusResumable(w http.ResponseWriter, r *http.Request) bool {
+	if r.Header.Get("Tus-Resumable") == tusVersion {
+		return true
+	}
+
+	w.Header().Set("Tus-Version", tusVersion)
+	api.tusError(w, http.StatusPreconditionFailed, errors.New("unsupported Tus-Resumable version"))
+	return false
+}
+
+func (api *Api) tusError(w http.ResponseWriter, statusCode int, err error) {
+	tusHeaders(w)
+	api.send(w, statusCode, err)
+}
+
+func tusHeaders(w http.ResponseWriter) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Expose-Headers", "Location, Tus-Resumable, Tus-Version, Tus-Extension, Tus-Max-Size, Upload-Offset, Upload-Length, Upload-Metadata, Upload-Expires, File-Id")
+}
+
+func uploadHeaders(w http.ResponseWriter, upload storagedata.Upload) {
+	tusHeaders(w)
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	if upload.FileID != "" {
+		w.Header().Set("File-Id", upload.FileID)
+	}
+}
+
+// decodeUploadMetadata parses "key base64value,key2 base64value2".
+func decodeUploadMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		fields := strings.Fields(pair)
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("invalid Upload-Metadata pair %q", pair)
+		}
+
+		value := ""
+		if len(fields) == 2 {
+			decoded, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid Upload-Metadata value for %q", fields[0])
+			}
+			value = string(decoded)
+		}
+		metadata[fields[0]] = value
+	}
+	return metadata, nil
+}
+
+func encodeUploadMetadata(metadata map[string]string) string {
+	keys := make([]string, 0, len(metadata))
+	for k := range metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+" "+base64.StdEncoding.EncodeToString([]byte(metadata[k])))
+	}
+	return strings.Join(pairs, ",")
+}
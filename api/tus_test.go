@@ -0,0 +1,68 @@
+package api_test
+
+import (
+	"americanas/test"
+	"bytes"
+	"net/http"
+	"testing"
+)
+
+func TestTusCreateUpload(t *testing.T) {
+	testCase := "test-tus-create-upload-with-sucess"
+	fixture := setup(t)
+	fixture.storage.status = http.StatusCreated
+	fixture.storage.upload.ID = "aab053840116dacaf13a062d909e5761"
+
+	req := fixture.createRequest("/uploads", "POST", nil)
+	req.Header.Set("Tus-Resumable", "1.0.0")
+	req.Header.Set("Upload-Length", "338135")
+	req.Header.Set("Upload-Metadata", "filename bWFycy5wbmc=,path aHQvbW9udGhseQ==")
+	status, _, header := fixture.sendRequest(req)
+
+	test.AssertEqual(t, testCase, status, http.StatusCreated)
+	test.AssertEqual(t, testCase, header.Get("Location"), "/uploads/aab053840116dacaf13a062d909e5761")
+	test.AssertEqual(t, testCase, header.Get("Tus-Resumable"), "1.0.0")
+	test.AssertEqual(t, testCase, fixture.storage.upload.Length, int64(338135))
+	test.AssertEqual(t, testCase, fixture.storage.upload.Metadata, map[string]string{"filename": "mars.png", "path": "ht/monthly"})
+}
+
+func TestTusPatchUpload(t *testing.T) {
+	testCase := "test-tus-patch-upload-with-sucess"
+	fixture := setup(t)
+	fixture.storage.status = http.StatusOK
+
+	req := fixture.createRequest("/uploads/aab053840116dacaf13a062d909e5761", "PATCH", bytes.NewReader([]byte("planet")))
+	req.Header.Set("Tus-Resumable", "1.0.0")
+	req.Header.Set("Upload-Offset", "10")
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	status, _, header := fixture.sendRequest(req)
+
+	test.AssertEqual(t, testCase, status, http.StatusNoContent)
+	test.AssertEqual(t, testCase, header.Get("Upload-Offset"), "16")
+}
+
+func TestTusRejectsWrongContentType(t *testing.T) {
+	testCase := "test-tus-patch-upload-with-wrong-content-type"
+	fixture := setup(t)
+	fixture.storage.status = http.StatusOK
+
+	req := fixture.createRequest("/uploads/aab053840116dacaf13a062d909e5761", "PATCH", bytes.NewReader([]byte("planet")))
+	req.Header.Set("Tus-Resumable", "1.0.0")
+	req.Header.Set("Upload-Offset", "0")
+	status, _, _ := fixture.sendRequest(req)
+
+	test.AssertEqual(t, testCase, status, http.StatusUnsupportedMediaType)
+}
+
+func TestTusRejectsUnknownVersion(t *testing.T) {
+	testCase := "test-tus-create-upload-without-tus-resumable"
+	fixture := setup(t)
+	fixture.storage.status = http.StatusCreated
+
+	req := fixture.createRequest("/uploads", "POST", nil)
+	req.Header.Set("Upload-Length", "10")
+	status, _, header := fixture.sendRequest(req)
+
+	test.AssertEqual(t, testCase, status, http.StatusPreconditionFailed)
+	test.AssertEqual(t, testCase, header.Get("Tus-Version"), "1.0.0")
+}
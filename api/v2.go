@@ -0,0 +1,266 @@
+package api
+
+import (
+	"americanas/auth"
+	"americanas/storagedata"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// The /v2 routes address files as resources by ID. They answer with the
+// entry itself instead of {"status": "success"} and use the status codes of
+// the operation: 201 with a Location for a new file and 204 for a delete.
+func (api *Api) registerV2Routers(router routes) {
+	router.GET("/v2/files", api.listFilesV2)
+	router.POST("/v2/files", api.createFileV2)
+	router.GET("/v2/files/:id", api.getFileV2)
+	router.PATCH("/v2/files/:id", api.updateFileV2)
+	router.DELETE("/v2/files/:id", api.deleteFileV2)
+	router.GET("/v2/files/:id/content", api.content)
+	router.HEAD("/v2/files/:id/content", api.content)
+	router.PUT("/v2/files/:id/content", api.putContentV2)
+	router.POST("/v2/files/:id/copy", api.copyFileV2)
+
+	router.POST("/v2/dirs", api.createDir)
+	router.GET("/v2/dirs/*path", api.listDir)
+	router.PATCH("/v2/dirs/*path", api.moveDir)
+	router.DELETE("/v2/dirs/*path", api.deleteDir)
+}
+
+// fileUpdate is the body of PATCH /v2/files/:id and POST /v2/files/:id/copy.
+// A field left out keeps the value of the file.
+type fileUpdate struct {
+	Name      string                     `json:"name"`
+	Directory string                     `json:"directory"`
+	Conflict  storagedata.ConflictPolicy `json:"conflict"`
+}
+
+// listFilesV2 answers one page of the listing described by the query:
+// dir, recursive, sort (name, size or modificationTime), order (asc or desc),
+// cursor, limit, type, minSize, maxSize, modifiedAfter and modifiedBefore
+// (RFC 3339).
+func (api *Api) listFilesV2(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	opts, err := listOptions(r.URL.Query())
+	if err != nil {
+		fmt.Printf("[listFilesV2] Error in query. error %v", err.Error())
+		api.send(w, http.StatusBadRequest, err)
+		return
+	}
+	statusCode, acc, err := api.access(r)
+	if err != nil {
+		fmt.Printf("[listFilesV2] Error in access with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+	if len(acc.acls) > 0 {
+		opts.Visible = acc.canRead
+	}
+
+	statusCode, result, err := api.storageDocument.List(opts)
+	if err != nil {
+		fmt.Printf("[listFilesV2] Error in listFilesV2 with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+
+	api.send(w, http.StatusOK, result)
+}
+
+func listOptions(query url.Values) (storagedata.ListOptions, error) {
+	opts := storagedata.ListOptions{
+		Dir:         query.Get("dir"),
+		Sort:        query.Get("sort"),
+		Cursor:      query.Get("cursor"),
+		ContentType: query.Get("type"),
+	}
+
+	var err error
+	parse := func(name string, set func(string) error) {
+		if value := query.Get(name); value != "" && err == nil {
+			if set(value) != nil {
+				err = fmt.Errorf("invalid %s %q: %w", name, value, storagedata.ErrInvalidRequest)
+			}
+		}
+	}
+	parse("recursive", func(v string) (e error) { opts.Recursive, e = strconv.ParseBool(v); return })
+	parse("limit", func(v string) (e error) { opts.Limit, e = strconv.Atoi(v); return })
+	parse("minSize", func(v string) (e error) { opts.MinSize, e = strconv.ParseInt(v, 10, 64); return })
+	parse("maxSize", func(v string) (e error) { opts.MaxSize, e = strconv.ParseInt(v, 10, 64); return })
+	parse("modifiedAfter", func(v string) (e error) { opts.ModifiedAfter, e = time.Parse(time.RFC3339, v); return })
+	parse("modifiedBefore", func(v string) (e error) { opts.ModifiedBefore, e = time.Parse(time.RFC3339, v); return })
+	parse("order", func(v string) error {
+		if v != "asc" && v != "desc" {
+			return storagedata.ErrInvalidRequest
+		}
+		opts.Desc = v == "desc"
+		return nil
+	})
+	return opts, err
+}
+
+func (api *Api) createFileV2(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	req, cleanup, err := api.readBodyMultiPart(w, r, "path")
+	if err != nil {
+		fmt.Println("[createFileV2] Error in read body:", err.Error())
+		api.send(w, http.StatusBadRequest, invalidBody(err))
+		return
+	}
+	defer cleanup()
+
+	statusCode, err := api.authorize(r, storagedata.PermWrite, req.Path)
+	if err != nil {
+		fmt.Printf("[createFileV2] Error in authorize with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+	statusCode, file, err := api.storageDocument.StorageFile(req)
+	if err != nil {
+		fmt.Printf("[createFileV2] Error in createFileV2 with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+
+	w.Header().Set("Location", "/v2/files/"+file.ID)
+	api.send(w, http.StatusCreated, file)
+}
+
+func (api *Api) getFileV2(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	statusCode, file, err := api.authorizeFile(r, storagedata.PermRead, ps.ByName("id"))
+	if err != nil {
+		fmt.Printf("[getFileV2] Error in getFileV2 with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+
+	api.send(w, http.StatusOK, file)
+}
+
+func (api *Api) updateFileV2(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var update fileUpdate
+	err := json.NewDecoder(limitBody(r.Body, IOMaxBufferSize)).Decode(&update)
+	if err != nil {
+		fmt.Printf("[updateFileV2] Error in readBody. error %v", err.Error())
+		api.send(w, http.StatusBadRequest, invalidBody(err))
+		return
+	}
+	if update.Name == "" && update.Directory == "" {
+		err = fmt.Errorf("nothing to update, expected name or directory: %w", storagedata.ErrInvalidRequest)
+		api.send(w, http.StatusBadRequest, err)
+		return
+	}
+
+	statusCode, file, err := api.authorizeFile(r, storagedata.PermDelete, ps.ByName("id"))
+	if err == nil {
+		statusCode, err = api.authorize(r, storagedata.PermWrite, targetDir(file, update.Directory))
+	}
+	if err != nil {
+		fmt.Printf("[updateFileV2] Error in authorize with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+
+	statusCode, file, err = api.storageDocument.RenameFile(ps.ByName("id"), update.Directory, update.Name, update.Conflict)
+	if err != nil {
+		fmt.Printf("[updateFileV2] Error in updateFileV2 with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+
+	api.send(w, http.StatusOK, file)
+}
+
+// copyFileV2 stores a copy of the file under a new ID.
+func (api *Api) copyFileV2(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var target fileUpdate
+	err := json.NewDecoder(limitBody(r.Body, IOMaxBufferSize)).Decode(&target)
+	if err != nil {
+		fmt.Printf("[copyFileV2] Error in readBody. error %v", err.Error())
+		api.send(w, http.StatusBadRequest, invalidBody(err))
+		return
+	}
+
+	statusCode, file, err := api.authorizeFile(r, storagedata.PermRead, ps.ByName("id"))
+	if err == nil {
+		statusCode, err = api.authorize(r, storagedata.PermWrite, targetDir(file, target.Directory))
+	}
+	if err != nil {
+		fmt.Printf("[copyFileV2] Error in authorize with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+
+	statusCode, file, err = api.storageDocument.CopyFile(ps.ByName("id"), target.Directory, target.Name, auth.Owner(r.Context()), target.Conflict)
+	if err != nil {
+		fmt.Printf("[copyFileV2] Error in copyFileV2 with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+
+	w.Header().Set("Location", "/v2/files/"+file.ID)
+	api.send(w, http.StatusCreated, file)
+}
+
+// putContentV2 replaces the content of a file with the request body. The
+// name and directory are kept; the type is taken from the Content-Type header
+// when there is one.
+func (api *Api) putContentV2(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id := ps.ByName("id")
+	statusCode, file, err := api.authorizeFile(r, storagedata.PermWrite, id)
+	if err != nil {
+		fmt.Printf("[putContentV2] Error in byID with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+
+	if api.maxUploadSize > 0 {
+		r.Body = limitBody(r.Body, api.maxUploadSize)
+	}
+	req := storagedata.UploadRequest{
+		Path:        path.Dir(file.Path),
+		Name:        file.Name,
+		ContentType: file.ContentType,
+		Content:     r.Body,
+		Size:        r.ContentLength,
+	}
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		req.ContentType = contentType
+	}
+	req.Digest, err = storagedata.DigestFromHeaders(r.Header)
+	if err != nil {
+		api.send(w, http.StatusBadRequest, err)
+		return
+	}
+
+	statusCode, file, err = api.storageDocument.OverwriteFile(id, req)
+	if err != nil {
+		fmt.Printf("[putContentV2] Error in putContentV2 with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+
+	api.send(w, http.StatusOK, file)
+}
+
+func (api *Api) deleteFileV2(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	statusCode, _, err := api.authorizeFile(r, storagedata.PermDelete, ps.ByName("id"))
+	if err != nil {
+		fmt.Printf("[deleteFileV2] Error in authorize with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+	statusCode, err = api.storageDocument.DeleteByID(ps.ByName("id"))
+	if err != nil {
+		fmt.Printf("[deleteFileV2] Error in deleteFileV2 with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+
+	api.send(w, http.StatusNoContent, nil)
+}
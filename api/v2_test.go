@@ -0,0 +1,147 @@
+package api_test
+
+import (
+	"americanas/storagedata"
+	"americanas/test"
+	"bytes"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestV2CreateFile(t *testing.T) {
+	testCase := "test-v2-create-file"
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	writer.WriteField("path", "ht/monthly")
+	writer.WriteField("conflict", "overwrite")
+	part, _ := writer.CreateFormFile("file", "golang.png")
+	part.Write(getFileTest("mars.png"))
+	writer.Close()
+
+	fixture := setup(t)
+	fixture.storage.status = http.StatusOK
+	fixture.storage.file = byIDFakeResult()
+
+	status, returnBody, header := fixture.requestMultiPart("/v2/files", "POST", body, *writer)
+	test.AssertEqual(t, testCase, status, http.StatusCreated)
+	test.AssertEqual(t, testCase, header.Get("Location"), "/v2/files/aab053840116dacaf13a062d909e5761")
+	test.AssertEqual(t, testCase, strings.Contains(returnBody, `"path":"ht/monthly/golang.png"`), true)
+	test.AssertEqual(t, testCase, fixture.storage.path, "ht/monthly")
+	test.AssertEqual(t, testCase, fixture.storage.conflict, storagedata.ConflictOverwrite)
+}
+
+func TestV2GetFile(t *testing.T) {
+	testCase := "test-v2-get-file"
+	fixture := setup(t)
+	fixture.storage.status = http.StatusOK
+	fixture.storage.file = byIDFakeResult()
+
+	status, returnBody, _ := fixture.request("/v2/files/aab053840116dacaf13a062d909e5761", "GET", nil)
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+	test.AssertEqual(t, testCase, strings.Contains(returnBody, `"id":"aab053840116dacaf13a062d909e5761"`), true)
+
+	fixture.storage.status = http.StatusNotFound
+	fixture.storage.err = fmt.Errorf("file unknown: %w", storagedata.ErrNotFound)
+	status, returnBody, _ = fixture.request("/v2/files/unknown", "GET", nil)
+	test.AssertEqual(t, testCase, status, http.StatusNotFound)
+	test.AssertEqual(t, testCase, returnBody, `{"code":"not_found","message":"file unknown: not found"}`)
+}
+
+func TestV2UpdateFile(t *testing.T) {
+	testCase := "test-v2-update-file"
+	fixture := setup(t)
+	fixture.storage.status = http.StatusOK
+	fixture.storage.file = byIDFakeResult()
+	url := "/v2/files/aab053840116dacaf13a062d909e5761"
+
+	status, returnBody, _ := fixture.request(url, "PATCH", strings.NewReader(`{"name":"gopher.png","directory":"ht/weekly"}`))
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+	test.AssertEqual(t, testCase, strings.Contains(returnBody, `"name":"gopher.png"`), true)
+	test.AssertEqual(t, testCase, fixture.storage.path, "ht/weekly")
+
+	status, returnBody, _ = fixture.request(url, "PATCH", strings.NewReader(`{}`))
+	test.AssertEqual(t, testCase, status, http.StatusBadRequest)
+	test.AssertEqual(t, testCase, strings.Contains(returnBody, `"code":"invalid_request"`), true)
+
+	fixture.storage.status = http.StatusConflict
+	fixture.storage.err = fmt.Errorf("file exists: %w", storagedata.ErrConflict)
+	status, _, _ = fixture.request(url, "PATCH", strings.NewReader(`{"name":"mars.png"}`))
+	test.AssertEqual(t, testCase, status, http.StatusConflict)
+}
+
+func TestV2PutContent(t *testing.T) {
+	testCase := "test-v2-put-content"
+	fixture := setup(t)
+	fixture.storage.status = http.StatusOK
+	fixture.storage.file = byIDFakeResult()
+
+	req := fixture.createRequest("/v2/files/aab053840116dacaf13a062d909e5761/content", "PUT", bytes.NewReader(getFileTest("mars.png")))
+	req.Header.Set("Content-Type", "image/png")
+	req.Header.Set("Digest", "sha-256=tsaR7DOF6hbtFOh1Vt5VH++1n1tggyoVhEiQy2PtS3w=")
+	status, _, _ := fixture.sendRequest(req)
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+	test.AssertEqual(t, testCase, fixture.storage.path, "ht/monthly")
+	test.AssertEqual(t, testCase, fmt.Sprintf("%x", fixture.storage.digest.SHA256), "b6c691ec3385ea16ed14e87556de551fefb59f5b60832a15844890cb63ed4b7c")
+}
+
+func TestV2DeleteFile(t *testing.T) {
+	testCase := "test-v2-delete-file"
+	fixture := setup(t)
+	fixture.storage.status = http.StatusOK
+
+	status, returnBody, _ := fixture.request("/v2/files/aab053840116dacaf13a062d909e5761", "DELETE", nil)
+	test.AssertEqual(t, testCase, status, http.StatusNoContent)
+	test.AssertEqual(t, testCase, returnBody, "")
+}
+
+func TestV2ListFiles(t *testing.T) {
+	testCase := "test-v2-list-files"
+	fixture := setup(t)
+	fixture.storage.status = http.StatusOK
+	fixture.storage.files = underDirFakeResult()
+
+	url := "/v2/files?dir=ht&recursive=true&sort=size&order=desc&limit=10&type=image/*&minSize=1&maxSize=100&modifiedAfter=2021-09-01T00:00:00Z"
+	status, returnBody, _ := fixture.request(url, "GET", nil)
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+	test.AssertEqual(t, testCase, strings.HasPrefix(returnBody, `{"files":[{"id":"aab053840116dacaf13a062d909e5761"`), true)
+	test.AssertEqual(t, testCase, fixture.storage.list, storagedata.ListOptions{
+		Dir:           "ht",
+		Recursive:     true,
+		Sort:          "size",
+		Desc:          true,
+		Limit:         10,
+		ContentType:   "image/*",
+		MinSize:       1,
+		MaxSize:       100,
+		ModifiedAfter: time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC),
+	})
+
+	for _, query := range []string{"limit=ten", "recursive=maybe", "order=up", "modifiedBefore=yesterday"} {
+		status, returnBody, _ = fixture.request("/v2/files?"+query, "GET", nil)
+		test.AssertEqual(t, testCase, status, http.StatusBadRequest)
+		test.AssertEqual(t, testCase, strings.Contains(returnBody, `"code":"invalid_request"`), true)
+	}
+}
+
+func TestV2CopyFile(t *testing.T) {
+	testCase := "test-v2-copy-file"
+	fixture := setup(t)
+	fixture.storage.status = http.StatusCreated
+	fixture.storage.file = byIDFakeResult()
+
+	status, returnBody, header := fixture.request("/v2/files/aab053840116dacaf13a062d909e5761/copy", "POST", strings.NewReader(`{"directory":"ht/backup","name":"gopher.png","conflict":"keep-both"}`))
+	test.AssertEqual(t, testCase, status, http.StatusCreated)
+	test.AssertEqual(t, testCase, header.Get("Location"), "/v2/files/0cb90ac871279cc942de976882b71a00")
+	test.AssertEqual(t, testCase, strings.Contains(returnBody, `"name":"gopher.png"`), true)
+	test.AssertEqual(t, testCase, fixture.storage.path, "ht/backup")
+	test.AssertEqual(t, testCase, fixture.storage.conflict, storagedata.ConflictKeepBoth)
+
+	fixture.storage.status = http.StatusNotFound
+	fixture.storage.err = fmt.Errorf("file unknown: %w", storagedata.ErrNotFound)
+	status, _, _ = fixture.request("/v2/files/unknown/copy", "POST", strings.NewReader(`{}`))
+	test.AssertEqual(t, testCase, status, http.StatusNotFound)
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"americanas/storagedata"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func (api *Api) registerVersionRouters(router routes) {
+	router.GET("/v2/files/:id/versions", api.versions)
+	router.DELETE("/v2/files/:id/versions", api.pruneVersions)
+	router.GET("/v2/files/:id/versions/:version/content", api.versionContent)
+	router.HEAD("/v2/files/:id/versions/:version/content", api.versionContent)
+	router.POST("/v2/files/:id/versions/:version/restore", api.restoreVersion)
+}
+
+func (api *Api) versions(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	statusCode, _, err := api.authorizeFile(r, storagedata.PermRead, ps.ByName("id"))
+	if err != nil {
+		fmt.Printf("[versions] Error in authorize with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+	statusCode, versions, err := api.storageDocument.Versions(ps.ByName("id"))
+	if err != nil {
+		fmt.Printf("[versions] Error in versions with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+
+	api.send(w, http.StatusOK, versions)
+}
+
+func (api *Api) versionContent(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	version, err := versionParam(ps)
+	if err != nil {
+		api.send(w, http.StatusBadRequest, err)
+		return
+	}
+
+	statusCode, _, err := api.authorizeFile(r, storagedata.PermRead, ps.ByName("id"))
+	if err != nil {
+		fmt.Printf("[versionContent] Error in authorize with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+	statusCode, file, entry, err := api.storageDocument.OpenVersion(ps.ByName("id"), version)
+	if err != nil {
+		fmt.Printf("[versionContent] Error in versionContent with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+	defer file.Close()
+
+	serveDownload(w, r, file, entry)
+}
+
+func (api *Api) restoreVersion(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	version, err := versionParam(ps)
+	if err != nil {
+		api.send(w, http.StatusBadRequest, err)
+		return
+	}
+
+	statusCode, file, err := api.authorizeFile(r, storagedata.PermWrite, ps.ByName("id"))
+	if err != nil {
+		fmt.Printf("[restoreVersion] Error in authorize with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+	statusCode, file, err = api.storageDocument.RestoreVersion(ps.ByName("id"), version)
+	if err != nil {
+		fmt.Printf("[restoreVersion] Error in restoreVersion with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+
+	api.send(w, http.StatusOK, file)
+}
+
+// pruneVersions deletes previous versions beyond the ?keep newest ones or
+// older than ?olderThan, a duration such as "720h".
+func (api *Api) pruneVersions(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	query := r.URL.Query()
+	keep, olderThan := 0, time.Duration(0)
+	var err error
+	if value := query.Get("keep"); value != "" {
+		keep, err = strconv.Atoi(value)
+	}
+	if value := query.Get("olderThan"); value != "" && err == nil {
+		olderThan, err = time.ParseDuration(value)
+	}
+	if err != nil {
+		api.send(w, http.StatusBadRequest, storagedata.WrapError(storagedata.ErrInvalidRequest, err))
+		return
+	}
+
+	statusCode, _, err := api.authorizeFile(r, storagedata.PermDelete, ps.ByName("id"))
+	if err != nil {
+		fmt.Printf("[pruneVersions] Error in authorize with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+	statusCode, pruned, err := api.storageDocument.PruneVersions(ps.ByName("id"), keep, olderThan)
+	if err != nil {
+		fmt.Printf("[pruneVersions] Error in pruneVersions with statusCode: %v - error %v", statusCode, err)
+		api.send(w, statusCode, err)
+		return
+	}
+
+	api.send(w, http.StatusOK, pruned)
+}
+
+func versionParam(ps httprouter.Params) (int, error) {
+	version, err := strconv.Atoi(ps.ByName("version"))
+	if err != nil || version < 1 {
+		return 0, fmt.Errorf("invalid version %q: %w", ps.ByName("version"), storagedata.ErrInvalidRequest)
+	}
+	return version, nil
+}
@@ -0,0 +1,54 @@
+package api_test
+
+import (
+	"americanas/test"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGETVersions(t *testing.T) {
+	testCase := "test-get-versions"
+	fixture, server := contentFixture(t)
+	defer server.Close()
+
+	resp, body := getContent(t, server, "GET", "/v2/files/aab053840116dacaf13a062d909e5761/versions", nil)
+	test.AssertEqual(t, testCase, resp.StatusCode, http.StatusOK)
+	test.AssertEqual(t, testCase, strings.HasPrefix(string(body), `[{"version":2,"name":"mars.png"`), true)
+	test.AssertEqual(t, testCase, strings.Contains(string(body), `"current":true`), true)
+
+	resp, body = getContent(t, server, "GET", "/v2/files/aab053840116dacaf13a062d909e5761/versions/1/content", nil)
+	test.AssertEqual(t, testCase, resp.StatusCode, http.StatusOK)
+	test.AssertEqual(t, testCase, body, getFileTest("mars.png"))
+	test.AssertEqual(t, testCase, fixture.storage.version, 1)
+
+	resp, body = getContent(t, server, "GET", "/v2/files/aab053840116dacaf13a062d909e5761/versions/first/content", nil)
+	test.AssertEqual(t, testCase, resp.StatusCode, http.StatusBadRequest)
+	test.AssertEqual(t, testCase, strings.Contains(string(body), `"code":"invalid_request"`), true)
+}
+
+func TestPOSTRestoreVersion(t *testing.T) {
+	testCase := "test-post-restore-version"
+	fixture, server := contentFixture(t)
+	defer server.Close()
+
+	resp, body := getContent(t, server, "POST", "/v2/files/aab053840116dacaf13a062d909e5761/versions/3/restore", nil)
+	test.AssertEqual(t, testCase, resp.StatusCode, http.StatusOK)
+	test.AssertEqual(t, testCase, strings.Contains(string(body), `"id":"aab053840116dacaf13a062d909e5761"`), true)
+	test.AssertEqual(t, testCase, fixture.storage.version, 3)
+}
+
+func TestDELETEPruneVersions(t *testing.T) {
+	testCase := "test-delete-prune-versions"
+	fixture, server := contentFixture(t)
+	defer server.Close()
+
+	resp, body := getContent(t, server, "DELETE", "/v2/files/aab053840116dacaf13a062d909e5761/versions?keep=5&olderThan=720h", nil)
+	test.AssertEqual(t, testCase, resp.StatusCode, http.StatusOK)
+	test.AssertEqual(t, testCase, string(body), "[]\n")
+	test.AssertEqual(t, testCase, fixture.storage.prune, []interface{}{5, 720 * time.Hour})
+
+	resp, _ = getContent(t, server, "DELETE", "/v2/files/aab053840116dacaf13a062d909e5761/versions?olderThan=month", nil)
+	test.AssertEqual(t, testCase, resp.StatusCode, http.StatusBadRequest)
+}
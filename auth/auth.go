@@ -0,0 +1,172 @@
+// Package auth checks the credentials of API requests: static API keys sent
+// in the X-API-Key header, HMAC signed JWTs sent as bearer tokens and, for
+// API keys with an access key ID, AWS Signature Version 4 as S3 clients
+// send it. They are configured in a local JSON keys file, which is read again
+// whenever it changes, so keys can be added or revoked without a restart:
+//
+//	{
+//	  "apiKeys": [{"key": "4f1c...", "owner": "alice", "accessKeyId": "AKALICE"}],
+//	  "jwtSecrets": ["..."]
+//	}
+//
+// The owner of a request is the owner of its API key or the subject of its
+// token.
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrUnauthorized is matched by every error returned for bad credentials.
+var ErrUnauthorized = errors.New("unauthorized")
+
+// APIKey is a static key and the owner requests made with it act as. With an
+// AccessKeyID, the key is also the secret of AWS signed requests.
+type APIKey struct {
+	Key         string `json:"key"`
+	Owner       string `json:"owner"`
+	AccessKeyID string `json:"accessKeyId,omitempty"`
+}
+
+// Keys is the content of the keys file. A token is accepted when it is
+// signed with any of JWTSecrets, so secrets can be rotated.
+type Keys struct {
+	APIKeys    []APIKey `json:"apiKeys"`
+	JWTSecrets []string `json:"jwtSecrets"`
+}
+
+// Authenticator checks requests against the keys file at path.
+type Authenticator struct {
+	path string
+
+	mu      sync.Mutex
+	keys    Keys
+	modTime time.Time
+	size    int64
+}
+
+// New loads the keys file at path.
+func New(path string) (*Authenticator, error) {
+	a := &Authenticator{path: path}
+	if _, err := a.current(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Authenticate returns the owner the request acts as.
+func (a *Authenticator) Authenticate(r *http.Request) (string, error) {
+	keys, err := a.current()
+	if err != nil {
+		return "", err
+	}
+
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return keys.owner(key)
+	}
+	if header := r.Header.Get("Authorization"); isSigV4(header) {
+		return keys.verifySigV4(r, time.Now())
+	} else if header != "" {
+		const prefix = "bearer "
+		if len(header) <= len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+			return "", fmt.Errorf("unsupported Authorization scheme: %w", ErrUnauthorized)
+		}
+		claims, err := keys.verify(strings.TrimSpace(header[len(prefix):]), time.Now())
+		if err != nil {
+			return "", err
+		}
+		return claims.Subject, nil
+	}
+	return "", fmt.Errorf("missing X-API-Key header, bearer token or AWS signature: %w", ErrUnauthorized)
+}
+
+// owner compares key with every API key, in constant time.
+func (k Keys) owner(key string) (string, error) {
+	owner, found := "", false
+	for _, apiKey := range k.APIKeys {
+		if subtle.ConstantTimeCompare([]byte(apiKey.Key), []byte(key)) == 1 {
+			owner, found = apiKey.Owner, true
+		}
+	}
+	if !found {
+		return "", fmt.Errorf("unknown API key: %w", ErrUnauthorized)
+	}
+	return owner, nil
+}
+
+// current returns the keys, reading the file again when it has changed. A
+// file that can't be read anymore keeps the keys loaded last.
+func (a *Authenticator) current() (Keys, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	info, err := os.Stat(a.path)
+	if err == nil && info.ModTime().Equal(a.modTime) && info.Size() == a.size {
+		return a.keys, nil
+	}
+	var keys Keys
+	if err == nil {
+		keys, err = readKeys(a.path)
+	}
+	if err != nil {
+		if a.modTime.IsZero() {
+			return Keys{}, err
+		}
+		fmt.Printf("[auth] Error reading keys file %s, keeping the loaded keys. Error: %s\n", a.path, err)
+		return a.keys, nil
+	}
+
+	a.keys, a.modTime, a.size = keys, info.ModTime(), info.Size()
+	return keys, nil
+}
+
+func readKeys(path string) (Keys, error) {
+	var keys Keys
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return keys, err
+	}
+	if err := json.Unmarshal(b, &keys); err != nil {
+		return keys, fmt.Errorf("keys file %s: %v", path, err)
+	}
+	accessKeyIDs := make(map[string]bool)
+	for _, k := range keys.APIKeys {
+		if k.Key == "" || k.Owner == "" {
+			return keys, fmt.Errorf("keys file %s: every API key needs a key and an owner", path)
+		}
+		if k.AccessKeyID != "" && accessKeyIDs[k.AccessKeyID] {
+			return keys, fmt.Errorf("keys file %s: access key ID %s given twice", path, k.AccessKeyID)
+		}
+		accessKeyIDs[k.AccessKeyID] = true
+	}
+	for _, s := range keys.JWTSecrets {
+		if s == "" {
+			return keys, fmt.Errorf("keys file %s: empty JWT secret", path)
+		}
+	}
+	return keys, nil
+}
+
+type ownerKey struct{}
+
+// WithOwner returns ctx carrying the owner of the request.
+func WithOwner(ctx context.Context, owner string) context.Context {
+	return context.WithValue(ctx, ownerKey{}, owner)
+}
+
+// Owner returns the owner stored in ctx by WithOwner, or "" for requests
+// that weren't authenticated.
+func Owner(ctx context.Context) string {
+	owner, _ := ctx.Value(ownerKey{}).(string)
+	return owner
+}
@@ -0,0 +1,134 @@
+package auth_test
+
+import (
+	"americanas/auth"
+	"americanas/test"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type fixture struct {
+	dir  string
+	path string
+}
+
+func setup(t *testing.T, keys string) *fixture {
+	dir, err := ioutil.TempDir("", "auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fixture{dir: dir, path: filepath.Join(dir, "keys.json")}
+	f.write(keys)
+	return f
+}
+
+func (f *fixture) write(keys string) {
+	ioutil.WriteFile(f.path, []byte(keys), 0600)
+}
+
+func (f *fixture) close() {
+	os.RemoveAll(f.dir)
+}
+
+func request(headers map[string]string) *http.Request {
+	r, _ := http.NewRequest("GET", "/allfiles", nil)
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+	return r
+}
+
+const keys = `{
+	"apiKeys": [{"key": "k3y-alice", "owner": "alice"}, {"key": "k3y-bob", "owner": "bob"}],
+	"jwtSecrets": ["old-secret", "new-secret"]
+}`
+
+func TestAuthenticateAPIKey(t *testing.T) {
+	testCase := "TestAuthenticateAPIKey"
+
+	f := setup(t, keys)
+	defer f.close()
+	a, err := auth.New(f.path)
+	test.AssertNoError(t, testCase, err)
+
+	owner, err := a.Authenticate(request(map[string]string{"X-API-Key": "k3y-bob"}))
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, owner, "bob")
+
+	_, err = a.Authenticate(request(map[string]string{"X-API-Key": "k3y-eve"}))
+	test.AssertEqual(t, testCase, errors.Is(err, auth.ErrUnauthorized), true)
+
+	_, err = a.Authenticate(request(nil))
+	test.AssertEqual(t, testCase, errors.Is(err, auth.ErrUnauthorized), true)
+
+	_, err = a.Authenticate(request(map[string]string{"Authorization": "Basic YWxpY2U6c2VjcmV0"}))
+	test.AssertEqual(t, testCase, errors.Is(err, auth.ErrUnauthorized), true)
+}
+
+func TestAuthenticateBearerToken(t *testing.T) {
+	testCase := "TestAuthenticateBearerToken"
+
+	f := setup(t, keys)
+	defer f.close()
+	a, _ := auth.New(f.path)
+
+	token, err := auth.SignToken("old-secret", auth.Claims{Subject: "carol", ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	test.AssertNoError(t, testCase, err)
+	owner, err := a.Authenticate(request(map[string]string{"Authorization": "Bearer " + token}))
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, owner, "carol")
+
+	token, _ = auth.SignToken("other-secret", auth.Claims{Subject: "carol"})
+	_, err = a.Authenticate(request(map[string]string{"Authorization": "Bearer " + token}))
+	test.AssertEqual(t, testCase, errors.Is(err, auth.ErrUnauthorized), true)
+}
+
+func TestKeysFileIsReloaded(t *testing.T) {
+	testCase := "TestKeysFileIsReloaded"
+
+	f := setup(t, keys)
+	defer f.close()
+	a, _ := auth.New(f.path)
+
+	f.write(`{"apiKeys": [{"key": "k3y-dave", "owner": "dave"}]}`)
+	os.Chtimes(f.path, time.Now().Add(time.Minute), time.Now().Add(time.Minute))
+
+	owner, err := a.Authenticate(request(map[string]string{"X-API-Key": "k3y-dave"}))
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, owner, "dave")
+	_, err = a.Authenticate(request(map[string]string{"X-API-Key": "k3y-alice"}))
+	test.AssertEqual(t, testCase, errors.Is(err, auth.ErrUnauthorized), true)
+
+	// A broken file keeps the keys loaded last.
+	f.write(`{"apiKeys": [`)
+	os.Chtimes(f.path, time.Now().Add(2*time.Minute), time.Now().Add(2*time.Minute))
+	owner, err = a.Authenticate(request(map[string]string{"X-API-Key": "k3y-dave"}))
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, owner, "dave")
+}
+
+func TestNewRejectsBadKeysFile(t *testing.T) {
+	testCase := "TestNewRejectsBadKeysFile"
+
+	f := setup(t, `{"apiKeys": [{"key": "k3y"}]}`)
+	defer f.close()
+
+	_, err := auth.New(f.path)
+	test.AssertError(t, testCase, err)
+
+	_, err = auth.New(filepath.Join(f.dir, "missing.json"))
+	test.AssertError(t, testCase, err)
+}
+
+func TestOwnerContext(t *testing.T) {
+	testCase := "TestOwnerContext"
+
+	test.AssertEqual(t, testCase, auth.Owner(context.Background()), "")
+	test.AssertEqual(t, testCase, auth.Owner(auth.WithOwner(context.Background(), "alice")), "alice")
+}
@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"strings"
+	"time"
+)
+
+// Claims are the JWT claims a token is checked against. Subject names the
+// owner and is required; ExpiresAt and NotBefore are Unix times and
+// optional.
+type Claims struct {
+	Subject   string `json:"sub"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	NotBefore int64  `json:"nbf,omitempty"`
+}
+
+var algorithms = map[string]func() hash.Hash{
+	"HS256": sha256.New,
+	"HS384": sha512.New384,
+	"HS512": sha512.New,
+}
+
+// SignToken returns claims as a JWT signed with secret using HS256.
+func SignToken(secret string, claims Claims) (string, error) {
+	header, _ := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signed := encodeSegment(header) + "." + encodeSegment(payload)
+	return signed + "." + encodeSegment(sign(sha256.New, secret, signed)), nil
+}
+
+// verify checks the signature of token against every secret and returns its
+// claims if they hold at now.
+func (k Keys) verify(token string, now time.Time) (Claims, error) {
+	var claims Claims
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims, fmt.Errorf("malformed token: %w", ErrUnauthorized)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return claims, err
+	}
+	newHash, ok := algorithms[header.Alg]
+	if !ok {
+		return claims, fmt.Errorf("unsupported token algorithm %q: %w", header.Alg, ErrUnauthorized)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return claims, fmt.Errorf("malformed token signature: %w", ErrUnauthorized)
+	}
+	valid := false
+	for _, secret := range k.JWTSecrets {
+		if hmac.Equal(signature, sign(newHash, secret, parts[0]+"."+parts[1])) {
+			valid = true
+		}
+	}
+	if !valid {
+		return claims, fmt.Errorf("invalid token signature: %w", ErrUnauthorized)
+	}
+
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return claims, err
+	}
+	switch {
+	case claims.Subject == "":
+		return claims, fmt.Errorf("token has no subject: %w", ErrUnauthorized)
+	case claims.ExpiresAt != 0 && now.Unix() >= claims.ExpiresAt:
+		return claims, fmt.Errorf("token expired: %w", ErrUnauthorized)
+	case claims.NotBefore != 0 && now.Unix() < claims.NotBefore:
+		return claims, fmt.Errorf("token not valid yet: %w", ErrUnauthorized)
+	}
+	return claims, nil
+}
+
+func sign(newHash func() hash.Hash, secret, signed string) []byte {
+	mac := hmac.New(newHash, []byte(secret))
+	mac.Write([]byte(signed))
+	return mac.Sum(nil)
+}
+
+func encodeSegment(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err == nil {
+		err = json.Unmarshal(b, v)
+	}
+	if err != nil {
+		return fmt.Errorf("malformed token: %w", ErrUnauthorized)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package auth_test
+
+import (
+	"americanas/auth"
+	"americanas/test"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func authenticateToken(t *testing.T, token string) (string, error) {
+	f := setup(t, `{"jwtSecrets": ["s3cr3t"]}`)
+	defer f.close()
+	a, _ := auth.New(f.path)
+
+	return a.Authenticate(request(map[string]string{"Authorization": "bearer " + token}))
+}
+
+func TestTokenClaims(t *testing.T) {
+	testCase := "TestTokenClaims"
+
+	now := time.Now()
+	for _, c := range []struct {
+		claims auth.Claims
+		valid  bool
+	}{
+		{auth.Claims{Subject: "alice"}, true},
+		{auth.Claims{Subject: "alice", ExpiresAt: now.Add(time.Minute).Unix(), NotBefore: now.Add(-time.Minute).Unix()}, true},
+		{auth.Claims{Subject: "alice", ExpiresAt: now.Add(-time.Minute).Unix()}, false},
+		{auth.Claims{Subject: "alice", NotBefore: now.Add(time.Minute).Unix()}, false},
+		{auth.Claims{}, false},
+	} {
+		token, _ := auth.SignToken("s3cr3t", c.claims)
+		owner, err := authenticateToken(t, token)
+		if c.valid {
+			test.AssertNoError(t, testCase, err)
+			test.AssertEqual(t, testCase, owner, c.claims.Subject)
+		} else {
+			test.AssertEqual(t, testCase, errors.Is(err, auth.ErrUnauthorized), true)
+		}
+	}
+}
+
+func TestTokenTampering(t *testing.T) {
+	testCase := "TestTokenTampering"
+
+	token, _ := auth.SignToken("s3cr3t", auth.Claims{Subject: "alice"})
+	parts := strings.Split(token, ".")
+	segment := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+
+	for _, tampered := range []string{
+		parts[0] + "." + segment(`{"sub":"root"}`) + "." + parts[2],
+		segment(`{"alg":"none"}`) + "." + parts[1] + ".",
+		segment(`{"alg":"HS512"}`) + "." + parts[1] + "." + parts[2],
+		parts[0] + "." + parts[1],
+		"not a token",
+	} {
+		_, err := authenticateToken(t, tampered)
+		test.AssertEqual(t, testCase, errors.Is(err, auth.ErrUnauthorized), true)
+	}
+}
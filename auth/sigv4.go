@@ -0,0 +1,180 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3 clients sign requests with AWS Signature Version 4 in the Authorization
+// header. The access key ID of an API key names it, and the key itself is the
+// secret. The payload is covered by the x-amz-content-sha256 header the
+// client signs; comparing it with the content is left to the caller.
+
+const (
+	sigV4Algorithm = "AWS4-HMAC-SHA256"
+	sigV4Time      = "20060102T150405Z"
+	// maxClockSkew is how far the time a request was signed at may be from
+	// now, as S3 allows.
+	maxClockSkew = 15 * time.Minute
+)
+
+// SignV4 signs r with AWS Signature Version 4 for the access key ID and
+// secret, as an S3 client in region would at t. The host and every header of
+// r are signed, x-amz-content-sha256 included, which is set to
+// UNSIGNED-PAYLOAD unless given.
+func SignV4(r *http.Request, accessKeyID, secret, region string, t time.Time) {
+	t = t.UTC()
+	r.Header.Set("X-Amz-Date", t.Format(sigV4Time))
+	if r.Header.Get("X-Amz-Content-Sha256") == "" {
+		r.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+	}
+
+	signed := []string{"host"}
+	for name := range r.Header {
+		if name = strings.ToLower(name); name != "authorization" {
+			signed = append(signed, name)
+		}
+	}
+	sort.Strings(signed)
+
+	scope := strings.Join([]string{t.Format("20060102"), region, "s3", "aws4_request"}, "/")
+	signature := sigV4Signature(r, signed, scope, t.Format(sigV4Time), secret)
+	r.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigV4Algorithm, accessKeyID, scope, strings.Join(signed, ";"), signature))
+}
+
+// isSigV4 reports whether header is an AWS Signature Version 4 Authorization.
+func isSigV4(header string) bool {
+	return strings.HasPrefix(header, sigV4Algorithm+" ")
+}
+
+// verifySigV4 checks the Signature Version 4 of r against the API key its
+// credential names and returns the owner of the key.
+func (k Keys) verifySigV4(r *http.Request, now time.Time) (string, error) {
+	fields := make(map[string]string)
+	for _, field := range strings.Split(strings.TrimPrefix(r.Header.Get("Authorization"), sigV4Algorithm+" "), ",") {
+		if i := strings.Index(field, "="); i >= 0 {
+			fields[strings.TrimSpace(field[:i])] = strings.TrimSpace(field[i+1:])
+		}
+	}
+	credential := strings.SplitN(fields["Credential"], "/", 2)
+	signed := strings.Split(fields["SignedHeaders"], ";")
+	if len(credential) != 2 || fields["Signature"] == "" || !contains(signed, "host") {
+		return "", fmt.Errorf("malformed AWS signature: %w", ErrUnauthorized)
+	}
+	accessKeyID, scope := credential[0], credential[1]
+
+	t, err := time.Parse(sigV4Time, r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return "", fmt.Errorf("missing or malformed X-Amz-Date header: %w", ErrUnauthorized)
+	}
+	if now.Sub(t) > maxClockSkew || t.Sub(now) > maxClockSkew {
+		return "", fmt.Errorf("request signed at %s, too far from now: %w", t.Format(sigV4Time), ErrUnauthorized)
+	}
+	parts := strings.Split(scope, "/")
+	if len(parts) != 4 || parts[0] != t.Format("20060102") || parts[3] != "aws4_request" {
+		return "", fmt.Errorf("malformed AWS credential scope %q: %w", scope, ErrUnauthorized)
+	}
+	if r.Header.Get("X-Amz-Content-Sha256") == "" {
+		return "", fmt.Errorf("missing X-Amz-Content-Sha256 header: %w", ErrUnauthorized)
+	}
+
+	for _, apiKey := range k.APIKeys {
+		if apiKey.AccessKeyID == "" || apiKey.AccessKeyID != accessKeyID {
+			continue
+		}
+		want := sigV4Signature(r, signed, scope, t.Format(sigV4Time), apiKey.Key)
+		if !hmac.Equal([]byte(want), []byte(fields["Signature"])) {
+			return "", fmt.Errorf("invalid AWS signature: %w", ErrUnauthorized)
+		}
+		return apiKey.Owner, nil
+	}
+	return "", fmt.Errorf("unknown access key ID %q: %w", accessKeyID, ErrUnauthorized)
+}
+
+// sigV4Signature is the hex signature of r over the signed headers, for the
+// credential scope "date/region/service/aws4_request" at amzDate.
+func sigV4Signature(r *http.Request, signed []string, scope, amzDate, secret string) string {
+	canonical := strings.Join([]string{
+		r.Method,
+		uriEncode(r.URL.Path, false),
+		canonicalQuery(r),
+		canonicalHeaders(r, signed),
+		strings.Join(signed, ";"),
+		r.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+	hashed := sha256.Sum256([]byte(canonical))
+	stringToSign := strings.Join([]string{sigV4Algorithm, amzDate, scope, hex.EncodeToString(hashed[:])}, "\n")
+
+	key := []byte("AWS4" + secret)
+	for _, part := range strings.Split(scope, "/") {
+		key = sign(sha256.New, string(key), part)
+	}
+	return hex.EncodeToString(sign(sha256.New, string(key), stringToSign))
+}
+
+func canonicalQuery(r *http.Request) string {
+	var pairs []string
+	for name, values := range r.URL.Query() {
+		for _, value := range values {
+			pairs = append(pairs, uriEncode(name, true)+"="+uriEncode(value, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// canonicalHeaders lists the signed headers as "name:value\n", with their
+// values trimmed and their inner spaces collapsed.
+func canonicalHeaders(r *http.Request, signed []string) string {
+	var b strings.Builder
+	for _, name := range signed {
+		var values []string
+		switch name {
+		case "host":
+			values = []string{r.Host}
+		case "content-length":
+			values = []string{strconv.FormatInt(r.ContentLength, 10)}
+		default:
+			values = append([]string(nil), r.Header[http.CanonicalHeaderKey(name)]...)
+		}
+		for i, v := range values {
+			values[i] = strings.Join(strings.Fields(v), " ")
+		}
+		b.WriteString(name + ":" + strings.Join(values, ",") + "\n")
+	}
+	return b.String()
+}
+
+// uriEncode escapes every byte of s but the unreserved characters, and the
+// slashes unless encodeSlash.
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
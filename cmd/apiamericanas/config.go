@@ -0,0 +1,225 @@
+package main
+
+import (
+	"americanas/storagedata"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds everything that depends on where the service is deployed.
+// Defaults are overridden by the JSON file named by -config or
+// APIAMERICANAS_CONFIG, then by APIAMERICANAS_* environment variables and
+// finally by command line flags.
+type Config struct {
+	Root            string   `json:"root"`
+	MetadataBackend string   `json:"metadataBackend"`
+	MetadataPath    string   `json:"metadataPath"`
+	Addr            string   `json:"addr"`
+	S3Addr          string   `json:"s3Addr"`
+	MaxUploadSize   int64    `json:"maxUploadSize"`
+	SpoolDir        string   `json:"spoolDir"`
+	ReadTimeout     Duration `json:"readTimeout"`
+	WriteTimeout    Duration `json:"writeTimeout"`
+	IdleTimeout     Duration `json:"idleTimeout"`
+	TrashRetention  Duration `json:"trashRetention"`
+	AuthKeysFile    string   `json:"authKeysFile"`
+	Admins          []string `json:"admins"`
+	PresignSecret   string   `json:"presignSecret"`
+}
+
+// Duration is a time.Duration written as "30s" in the config file.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func defaultConfig() Config {
+	return Config{
+		Root:            "storagedata",
+		MetadataBackend: "json",
+		Addr:            ":8081",
+		IdleTimeout:     Duration(2 * time.Minute),
+		TrashRetention:  Duration(30 * 24 * time.Hour),
+	}
+}
+
+// settings are the options that can be given as a flag or an environment
+// variable.
+var settings = []struct {
+	flag  string
+	env   string
+	usage string
+	set   func(c *Config, value string) error
+}{
+	{"root", "APIAMERICANAS_ROOT", "directory files are stored under", func(c *Config, v string) error {
+		c.Root = v
+		return nil
+	}},
+	{"metadata-backend", "APIAMERICANAS_METADATA_BACKEND", "metadata index backend: json or bolt", func(c *Config, v string) error {
+		c.MetadataBackend = v
+		return nil
+	}},
+	{"metadata", "APIAMERICANAS_METADATA", "metadata index file, metadata.json or metadata.db inside the root by default", func(c *Config, v string) error {
+		c.MetadataPath = v
+		return nil
+	}},
+	{"addr", "APIAMERICANAS_ADDR", "listen address of the API", func(c *Config, v string) error {
+		c.Addr = v
+		return nil
+	}},
+	{"s3-addr", "APIAMERICANAS_S3_ADDR", "listen address of the S3 compatible API, disabled when empty", func(c *Config, v string) error {
+		c.S3Addr = v
+		return nil
+	}},
+	{"max-upload-size", "APIAMERICANAS_MAX_UPLOAD_SIZE", "largest accepted upload in bytes, 0 for no limit", func(c *Config, v string) error {
+		n, err := strconv.ParseInt(v, 10, 64)
+		c.MaxUploadSize = n
+		return err
+	}},
+	{"spool-dir", "APIAMERICANAS_SPOOL_DIR", "directory for uploads that have to be buffered, the system temporary directory by default", func(c *Config, v string) error {
+		c.SpoolDir = v
+		return nil
+	}},
+	{"read-timeout", "APIAMERICANAS_READ_TIMEOUT", "maximum duration for reading a request, 0 for none", func(c *Config, v string) error {
+		return setDuration(&c.ReadTimeout, v)
+	}},
+	{"write-timeout", "APIAMERICANAS_WRITE_TIMEOUT", "maximum duration for writing a response, 0 for none", func(c *Config, v string) error {
+		return setDuration(&c.WriteTimeout, v)
+	}},
+	{"idle-timeout", "APIAMERICANAS_IDLE_TIMEOUT", "how long idle keep-alive connections are kept open", func(c *Config, v string) error {
+		return setDuration(&c.IdleTimeout, v)
+	}},
+	{"trash-retention", "APIAMERICANAS_TRASH_RETENTION", "how long deleted files stay in the trash, 0 to delete permanently", func(c *Config, v string) error {
+		return setDuration(&c.TrashRetention, v)
+	}},
+	{"auth-keys", "APIAMERICANAS_AUTH_KEYS", "JSON file with the API keys and JWT secrets requests are checked against, no authentication when empty", func(c *Config, v string) error {
+		c.AuthKeysFile = v
+		return nil
+	}},
+	{"admins", "APIAMERICANAS_ADMINS", "comma separated owners who administer the root besides what its ACL grants", func(c *Config, v string) error {
+		c.Admins = strings.Split(v, ",")
+		return nil
+	}},
+	{"presign-secret", "APIAMERICANAS_PRESIGN_SECRET", "key pre-signed URLs are signed with, a random one that changes on restart when empty", func(c *Config, v string) error {
+		c.PresignSecret = v
+		return nil
+	}},
+}
+
+func setDuration(d *Duration, value string) error {
+	v, err := time.ParseDuration(value)
+	*d = Duration(v)
+	return err
+}
+
+// loadConfig builds the Config from the command line arguments and the
+// environment.
+func loadConfig(args []string, getenv func(string) string) (Config, error) {
+	cfg := defaultConfig()
+
+	fs := flag.NewFlagSet("apiamericanas", flag.ContinueOnError)
+	configPath := fs.String("config", "", "JSON config file")
+	values := make(map[string]*string, len(settings))
+	for _, s := range settings {
+		values[s.flag] = fs.String(s.flag, "", fmt.Sprintf("%s (env %s)", s.usage, s.env))
+	}
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	path := *configPath
+	if path == "" {
+		path = getenv("APIAMERICANAS_CONFIG")
+	}
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return cfg, err
+		}
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return cfg, fmt.Errorf("config file %s: %v", path, err)
+		}
+	}
+
+	for _, s := range settings {
+		if v := getenv(s.env); v != "" {
+			if err := s.set(&cfg, v); err != nil {
+				return cfg, fmt.Errorf("%s: %v", s.env, err)
+			}
+		}
+	}
+
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.flag == f.Name && err == nil {
+				if setErr := s.set(&cfg, *values[s.flag]); setErr != nil {
+					err = fmt.Errorf("-%s: %v", s.flag, setErr)
+				}
+			}
+		}
+	})
+	if err != nil {
+		return cfg, err
+	}
+
+	return cfg, cfg.validate()
+}
+
+func (c *Config) validate() error {
+	if c.Root == "" {
+		return errors.New("root must not be empty")
+	}
+	if c.MetadataBackend != "json" && c.MetadataBackend != "bolt" {
+		return fmt.Errorf("unknown metadata backend %q", c.MetadataBackend)
+	}
+	if c.MaxUploadSize < 0 {
+		return errors.New("max upload size must not be negative")
+	}
+	if c.TrashRetention < 0 {
+		return errors.New("trash retention must not be negative")
+	}
+	for _, admin := range c.Admins {
+		if admin == "" {
+			return errors.New("admins must not be empty")
+		}
+	}
+	return nil
+}
+
+// metadataStore opens the configured metadata index.
+func (c *Config) metadataStore() (storagedata.MetadataStore, error) {
+	path := c.MetadataPath
+	if c.MetadataBackend == "bolt" {
+		if path == "" {
+			path = filepath.Join(c.Root, "metadata.db")
+		}
+		return storagedata.NewBoltStore(path)
+	}
+
+	if path == "" {
+		path = filepath.Join(c.Root, "metadata.json")
+	}
+	return storagedata.NewJSONStore(path), nil
+}
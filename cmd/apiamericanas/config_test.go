@@ -0,0 +1,97 @@
+package main
+
+import (
+	"americanas/test"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigDefaults(t *testing.T) {
+	testCase := "TestLoadConfigDefaults"
+
+	cfg, err := loadConfig(nil, env(nil))
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, cfg, defaultConfig())
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	testCase := "TestLoadConfigPrecedence"
+
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.json")
+	ioutil.WriteFile(path, []byte(`{
+	"root": "/srv/files",
+	"addr": ":9090",
+	"maxUploadSize": 1024,
+	"readTimeout": "30s",
+	"presignSecret": "s3cr3t"
+}`), 0644)
+
+	cfg, err := loadConfig(
+		[]string{"-config", path, "-max-upload-size", "4096", "-trash-retention", "0", "-admins", "root,carol"},
+		env(map[string]string{"APIAMERICANAS_ADDR": ":7070", "APIAMERICANAS_MAX_UPLOAD_SIZE": "2048", "APIAMERICANAS_AUTH_KEYS": "/etc/americanas/keys.json"}),
+	)
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, cfg.Root, "/srv/files")
+	test.AssertEqual(t, testCase, cfg.Addr, ":7070")
+	test.AssertEqual(t, testCase, cfg.MaxUploadSize, int64(4096))
+	test.AssertEqual(t, testCase, cfg.ReadTimeout, Duration(30*time.Second))
+	test.AssertEqual(t, testCase, cfg.MetadataBackend, "json")
+	test.AssertEqual(t, testCase, cfg.TrashRetention, Duration(0))
+	test.AssertEqual(t, testCase, cfg.AuthKeysFile, "/etc/americanas/keys.json")
+	test.AssertEqual(t, testCase, cfg.PresignSecret, "s3cr3t")
+	test.AssertEqual(t, testCase, cfg.Admins, []string{"root", "carol"})
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	testCase := "TestLoadConfigErrors"
+
+	_, err := loadConfig([]string{"-metadata-backend", "sqlite"}, env(nil))
+	test.AssertError(t, testCase, err)
+
+	_, err = loadConfig(nil, env(map[string]string{"APIAMERICANAS_IDLE_TIMEOUT": "soon"}))
+	test.AssertError(t, testCase, err)
+
+	// An empty admin would be whoever sends no credentials.
+	_, err = loadConfig([]string{"-admins", "root,"}, env(nil))
+	test.AssertError(t, testCase, err)
+
+	_, err = loadConfig([]string{"-trash-retention", "-1h"}, env(nil))
+	test.AssertError(t, testCase, err)
+
+	_, err = loadConfig([]string{"-config", "does-not-exist.json"}, env(nil))
+	test.AssertError(t, testCase, err)
+}
+
+func TestConfigMetadataPath(t *testing.T) {
+	testCase := "TestConfigMetadataPath"
+
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg, err := loadConfig([]string{"-root", dir, "-metadata-backend", "bolt"}, env(nil))
+	test.AssertNoError(t, testCase, err)
+
+	store, err := cfg.metadataStore()
+	test.AssertNoError(t, testCase, err)
+	defer store.Close()
+
+	_, err = os.Stat(filepath.Join(dir, "metadata.db"))
+	test.AssertNoError(t, testCase, err)
+}
+
+func env(values map[string]string) func(string) string {
+	return func(key string) string {
+		return values[key]
+	}
+}
@@ -2,18 +2,88 @@ package main
 
 import (
 	"americanas/api"
+	"americanas/auth"
+	"americanas/s3"
 	"americanas/storagedata"
+	"flag"
 	"fmt"
 	"net/http"
+	"os"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 )
 
 func main() {
+	cfg, err := loadConfig(os.Args[1:], os.Getenv)
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+
+	err = os.MkdirAll(cfg.Root, os.ModePerm)
+	if err != nil {
+		panic(err)
+	}
+	store, err := cfg.metadataStore()
+	if err != nil {
+		panic(err)
+	}
+
+	apiOpts := []api.Option{api.WithMaxUploadSize(cfg.MaxUploadSize), api.WithSpoolDir(cfg.SpoolDir)}
+	s3Opts := []s3.Option{s3.WithMaxUploadSize(cfg.MaxUploadSize)}
+	admins := cfg.Admins
+	if cfg.AuthKeysFile != "" {
+		authenticator, err := auth.New(cfg.AuthKeysFile)
+		if err != nil {
+			panic(err)
+		}
+		apiOpts = append(apiOpts, api.WithAuthenticator(authenticator))
+		s3Opts = append(s3Opts, s3.WithAuthenticator(authenticator))
+		if len(admins) == 0 {
+			fmt.Println("No -admins given, only a root ACL can make someone an admin")
+		}
+	} else {
+		// Every caller is anonymous, and may do anything.
+		admins = []string{""}
+		fmt.Println("No -auth-keys file given, the API is open to anyone who can reach it")
+	}
+
+	storage := storagedata.New(
+		storagedata.WithRoot(cfg.Root),
+		storagedata.WithMetadataStore(store),
+		storagedata.WithTrashRetention(time.Duration(cfg.TrashRetention)),
+		storagedata.WithAdmins(admins...),
+	)
+	defer storage.Close()
+
+	if cfg.S3Addr != "" {
+		go func() {
+			fmt.Printf("s3 Server running on http://localhost%s\n", cfg.S3Addr)
+			panic(newServer(cfg, cfg.S3Addr, s3.New(storage, s3Opts...)).ListenAndServe())
+		}()
+	}
+	if cfg.PresignSecret != "" {
+		apiOpts = append(apiOpts, api.WithPresignKey([]byte(cfg.PresignSecret)))
+	} else {
+		fmt.Println("No -presign-secret given, pre-signed URLs stop working on restart")
+	}
 
-	storage := storagedata.New()
 	router := httprouter.New()
-	api.New(storage).RegisterRouters(router)
-	fmt.Println("api Server running on http://localhost:8081")
-	panic(http.ListenAndServe(":8081", router))
+	api.New(storage, apiOpts...).RegisterRouters(router)
+	fmt.Printf("api Server running on http://localhost%s\n", cfg.Addr)
+	panic(newServer(cfg, cfg.Addr, router).ListenAndServe())
+}
+
+func newServer(cfg Config, addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:         addr,
+		Handler:      handler,
+		ReadTimeout:  time.Duration(cfg.ReadTimeout),
+		WriteTimeout: time.Duration(cfg.WriteTimeout),
+		IdleTimeout:  time.Duration(cfg.IdleTimeout),
+	}
 }
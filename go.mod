@@ -6,4 +6,5 @@ require (
 	github.com/NeowayLabs/logger v0.0.0-20170104125500-04464868b8c5 // indirect
 	github.com/julienschmidt/httprouter v1.3.0
 	github.com/vincent-petithory/dataurl v0.0.0-20191104211930-d1553a71de50
+	go.etcd.io/bbolt v1.3.6
 )
@@ -0,0 +1,630 @@
+// Package s3 serves a subset of the Amazon S3 REST API on top of the file
+// storage: PutObject, GetObject, HeadObject, DeleteObject, CopyObject,
+// ListObjectsV2 and ListBuckets, with path-style addressing only. A bucket is
+// a top-level directory and the object key is the rest of the file path, so
+// s3://planets/inner/earth.png is the file stored at planets/inner/earth.png.
+package s3
+
+import (
+	"americanas/auth"
+	"americanas/storagedata"
+	"bufio"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Storage interface {
+	StorageFile(req storagedata.UploadRequest) (int, storagedata.FileMetadata, error)
+	DeleteByID(id string) (int, error)
+	GetMetadataJSON() (map[string]storagedata.FileMetadata, error)
+	FindByPath(path string) (int, storagedata.FileMetadata, error)
+	OpenByPath(path string) (int, *os.File, storagedata.FileMetadata, error)
+	ACLs() (int, storagedata.ACLs, error)
+	Authorize(owner string, perm storagedata.Permission, paths ...string) (int, error)
+}
+
+type Server struct {
+	storage       Storage
+	authenticator Authenticator
+	maxUploadSize int64
+}
+
+type Option func(*Server)
+
+// Authenticator returns the owner a request acts as, or an error matching
+// auth.ErrUnauthorized. *auth.Authenticator is one.
+type Authenticator interface {
+	Authenticate(r *http.Request) (string, error)
+}
+
+// WithAuthenticator makes every request require credentials, usually an
+// AWS signature from an S3 client, and stores objects with the caller as
+// their owner. Without it the server is open to anyone.
+func WithAuthenticator(a Authenticator) Option {
+	return func(s *Server) {
+		s.authenticator = a
+	}
+}
+
+// WithMaxUploadSize caps the size of an object uploaded with PutObject; 0
+// means no limit.
+func WithMaxUploadSize(n int64) Option {
+	return func(s *Server) {
+		s.maxUploadSize = n
+	}
+}
+
+const (
+	xmlns          = "http://s3.amazonaws.com/doc/2006-03-01/"
+	defaultMaxKeys = 1000
+)
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.authenticator != nil {
+		owner, err := s.authenticator.Authenticate(r)
+		if err != nil {
+			fmt.Printf("[ServeHTTP] %s %s rejected. error %v\n", r.Method, r.URL.Path, err)
+			s.sendError(w, r, http.StatusForbidden, "AccessDenied", err.Error())
+			return
+		}
+		r = r.WithContext(auth.WithOwner(r.Context(), owner))
+	}
+
+	bucket, key := splitPath(r.URL.Path)
+
+	switch {
+	case bucket == "" && r.Method == http.MethodGet:
+		s.listBuckets(w, r)
+	case bucket == "":
+		s.sendError(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed against this resource.")
+	case key == "" && r.Method == http.MethodGet:
+		s.listObjects(w, r, bucket)
+	case key == "" && (r.Method == http.MethodHead || r.Method == http.MethodPut):
+		// Buckets are plain directories, so every bucket already exists.
+		w.WriteHeader(http.StatusOK)
+	case key == "":
+		s.sendError(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed against this resource.")
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		s.getObject(w, r, bucket, key)
+	case r.Method == http.MethodPut && r.Header.Get("x-amz-copy-source") != "":
+		s.copyObject(w, r, bucket, key)
+	case r.Method == http.MethodPut:
+		s.putObject(w, r, bucket, key)
+	case r.Method == http.MethodDelete:
+		s.deleteObject(w, r, bucket, key)
+	default:
+		s.sendError(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed against this resource.")
+	}
+}
+
+func (s *Server) putObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	if !s.authorize(w, r, storagedata.PermWrite, bucket+"/"+key) {
+		return
+	}
+
+	var body io.Reader = r.Body
+	if isAWSChunked(r) {
+		body = newChunkedReader(r.Body)
+	}
+	if s.maxUploadSize > 0 {
+		body = &limitedBody{body: http.MaxBytesReader(w, ioutil.NopCloser(body), s.maxUploadSize), limit: s.maxUploadSize}
+	}
+
+	digest, err := storagedata.DigestFromHeaders(r.Header)
+	if err != nil {
+		s.sendError(w, r, http.StatusBadRequest, "InvalidDigest", err.Error())
+		return
+	}
+	// An AWS signature only covers the content through its hash, which has
+	// to match.
+	if sum, err := hex.DecodeString(r.Header.Get("x-amz-content-sha256")); err == nil && len(sum) == sha256.Size && digest.SHA256 == nil {
+		digest.SHA256 = sum
+	}
+
+	entry, err := s.save(r, bucket, key, body, r.Header.Get("Content-Type"), digest)
+	if err != nil {
+		fmt.Printf("[putObject] Error in save. error %v", err.Error())
+		s.sendStorageError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(entry))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) getObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	if !s.authorize(w, r, storagedata.PermRead, bucket+"/"+key) {
+		return
+	}
+
+	statusCode, file, entry, err := s.storage.OpenByPath(bucket + "/" + key)
+	if err != nil {
+		fmt.Printf("[getObject] Error in OpenByPath with statusCode: %v - error %v", statusCode, err.Error())
+		s.sendStorageError(w, r, err)
+		return
+	}
+	defer file.Close()
+
+	if entry.ContentType != "" {
+		w.Header().Set("Content-Type", entry.ContentType)
+	}
+	if tag := etag(entry); tag != "" {
+		w.Header().Set("ETag", tag)
+	}
+	http.ServeContent(w, r, key, entry.ModTime, file)
+}
+
+func (s *Server) deleteObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	if !s.authorize(w, r, storagedata.PermDelete, bucket+"/"+key) {
+		return
+	}
+
+	statusCode, entry, err := s.storage.FindByPath(bucket + "/" + key)
+	if errors.Is(err, storagedata.ErrNotFound) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err == nil {
+		statusCode, err = s.storage.DeleteByID(entry.ID)
+	}
+	if err != nil {
+		fmt.Printf("[deleteObject] Error in delete with statusCode: %v - error %v", statusCode, err.Error())
+		s.sendStorageError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type copyObjectResult struct {
+	XMLName      xml.Name `xml:"CopyObjectResult"`
+	Xmlns        string   `xml:"xmlns,attr"`
+	LastModified string   `xml:"LastModified"`
+	ETag         string   `xml:"ETag"`
+}
+
+func (s *Server) copyObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	source, err := url.PathUnescape(r.Header.Get("x-amz-copy-source"))
+	if err != nil {
+		s.sendError(w, r, http.StatusBadRequest, "InvalidArgument", "Invalid copy source.")
+		return
+	}
+	source = strings.SplitN(source, "?", 2)[0]
+	srcBucket, srcKey := splitPath(source)
+	if srcBucket == "" || srcKey == "" {
+		s.sendError(w, r, http.StatusBadRequest, "InvalidArgument", "Invalid copy source.")
+		return
+	}
+
+	if !s.authorize(w, r, storagedata.PermRead, srcBucket+"/"+srcKey) || !s.authorize(w, r, storagedata.PermWrite, bucket+"/"+key) {
+		return
+	}
+
+	_, file, srcEntry, err := s.storage.OpenByPath(srcBucket + "/" + srcKey)
+	if err != nil {
+		s.sendStorageError(w, r, err)
+		return
+	}
+	defer file.Close()
+
+	contentType := srcEntry.ContentType
+	if r.Header.Get("x-amz-metadata-directive") == "REPLACE" {
+		contentType = r.Header.Get("Content-Type")
+	}
+
+	entry, err := s.save(r, bucket, key, file, contentType, storagedata.Digest{})
+	if err != nil {
+		fmt.Printf("[copyObject] Error in save. error %v", err.Error())
+		s.sendStorageError(w, r, err)
+		return
+	}
+
+	s.sendXML(w, http.StatusOK, copyObjectResult{
+		Xmlns:        xmlns,
+		LastModified: formatTime(entry.ModTime),
+		ETag:         etag(entry),
+	})
+}
+
+type listBucketResult struct {
+	XMLName               xml.Name       `xml:"ListBucketResult"`
+	Xmlns                 string         `xml:"xmlns,attr"`
+	Name                  string         `xml:"Name"`
+	Prefix                string         `xml:"Prefix"`
+	Delimiter             string         `xml:"Delimiter,omitempty"`
+	StartAfter            string         `xml:"StartAfter,omitempty"`
+	ContinuationToken     string         `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+	MaxKeys               int            `xml:"MaxKeys"`
+	KeyCount              int            `xml:"KeyCount"`
+	IsTruncated           bool           `xml:"IsTruncated"`
+	Contents              []object       `xml:"Contents"`
+	CommonPrefixes        []commonPrefix `xml:"CommonPrefixes"`
+}
+
+type object struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type commonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+func (s *Server) listObjects(w http.ResponseWriter, r *http.Request, bucket string) {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	delimiter := query.Get("delimiter")
+
+	maxKeys := defaultMaxKeys
+	if v := query.Get("max-keys"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			s.sendError(w, r, http.StatusBadRequest, "InvalidArgument", "Invalid max-keys.")
+			return
+		}
+		if n < maxKeys {
+			maxKeys = n
+		}
+	}
+
+	after := query.Get("start-after")
+	token := query.Get("continuation-token")
+	if token != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(token)
+		if err != nil {
+			s.sendError(w, r, http.StatusBadRequest, "InvalidArgument", "Invalid continuation token.")
+			return
+		}
+		after = string(decoded)
+	}
+
+	objects, err := s.objects(r, bucket)
+	if err != nil {
+		fmt.Printf("[listObjects] Error in GetMetadataJSON. error %v", err.Error())
+		s.sendStorageError(w, r, err)
+		return
+	}
+
+	keys := make([]string, 0, len(objects))
+	for key := range objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	result := listBucketResult{
+		Xmlns:             xmlns,
+		Name:              bucket,
+		Prefix:            prefix,
+		Delimiter:         delimiter,
+		StartAfter:        query.Get("start-after"),
+		ContinuationToken: token,
+		MaxKeys:           maxKeys,
+	}
+
+	seen := make(map[string]bool)
+	last := ""
+	for _, key := range keys {
+		if !strings.HasPrefix(key, prefix) || key <= after {
+			continue
+		}
+		// A continuation token naming a common prefix skips everything in it.
+		if delimiter != "" && strings.HasSuffix(after, delimiter) && strings.HasPrefix(key, after) {
+			continue
+		}
+
+		common := ""
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				common = key[:len(prefix)+i+len(delimiter)]
+			}
+		}
+		if common != "" && seen[common] {
+			continue
+		}
+
+		if result.KeyCount == maxKeys {
+			result.IsTruncated = true
+			result.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(last))
+			break
+		}
+
+		if common != "" {
+			seen[common] = true
+			result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: common})
+			last = common
+		} else {
+			entry := objects[key]
+			result.Contents = append(result.Contents, object{
+				Key:          key,
+				LastModified: formatTime(entry.ModTime),
+				ETag:         etag(entry),
+				Size:         entry.Size,
+				StorageClass: "STANDARD",
+			})
+			last = key
+		}
+		result.KeyCount++
+	}
+
+	s.sendXML(w, http.StatusOK, result)
+}
+
+type listAllMyBucketsResult struct {
+	XMLName xml.Name `xml:"ListAllMyBucketsResult"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Owner   struct {
+		ID          string `xml:"ID"`
+		DisplayName string `xml:"DisplayName"`
+	} `xml:"Owner"`
+	Buckets []bucketInfo `xml:"Buckets>Bucket"`
+}
+
+type bucketInfo struct {
+	Name         string `xml:"Name"`
+	CreationDate string `xml:"CreationDate"`
+}
+
+func (s *Server) listBuckets(w http.ResponseWriter, r *http.Request) {
+	all, err := s.readable(r)
+	if err != nil {
+		fmt.Printf("[listBuckets] Error in readable. error %v", err.Error())
+		s.sendStorageError(w, r, err)
+		return
+	}
+
+	created := make(map[string]time.Time)
+	for _, entry := range all {
+		bucket, key := splitPath(entry.Path)
+		if bucket == "" || key == "" {
+			continue
+		}
+		t := entry.ModTime
+		if c, ok := created[bucket]; !ok || t.Before(c) {
+			created[bucket] = t
+		}
+	}
+
+	result := listAllMyBucketsResult{Xmlns: xmlns}
+	result.Owner.ID = "americanas"
+	result.Owner.DisplayName = "americanas"
+	for name, t := range created {
+		result.Buckets = append(result.Buckets, bucketInfo{Name: name, CreationDate: formatTime(t)})
+	}
+	sort.Slice(result.Buckets, func(i, j int) bool {
+		return result.Buckets[i].Name < result.Buckets[j].Name
+	})
+
+	s.sendXML(w, http.StatusOK, result)
+}
+
+// save stores content at bucket/key for the caller of r, replacing the object
+// already there.
+func (s *Server) save(r *http.Request, bucket, key string, content io.Reader, contentType string, digest storagedata.Digest) (storagedata.FileMetadata, error) {
+	dir := bucket
+	name := key
+	if i := strings.LastIndex(key, "/"); i >= 0 {
+		dir = bucket + "/" + key[:i]
+		name = key[i+1:]
+	}
+	if name == "" {
+		return storagedata.FileMetadata{}, fmt.Errorf("object key must not end with /: %w", storagedata.ErrInvalidPath)
+	}
+
+	req := storagedata.UploadRequest{
+		Path:        dir,
+		Name:        name,
+		ContentType: contentType,
+		Content:     content,
+		Digest:      digest,
+		Owner:       auth.Owner(r.Context()),
+		// The last writer of a key wins, even among concurrent ones.
+		Conflict: storagedata.ConflictOverwrite,
+	}
+
+	_, entry, err := s.storage.StorageFile(req)
+	return entry, err
+}
+
+// objects returns the entries of a bucket the caller of r may read, keyed by
+// object key.
+func (s *Server) objects(r *http.Request, bucket string) (map[string]storagedata.FileMetadata, error) {
+	all, err := s.readable(r)
+	if err != nil {
+		return nil, err
+	}
+
+	objects := make(map[string]storagedata.FileMetadata)
+	for _, entry := range all {
+		if b, key := splitPath(entry.Path); b == bucket && key != "" {
+			objects[key] = entry
+		}
+	}
+	return objects, nil
+}
+
+// readable returns every entry the caller of r may read.
+func (s *Server) readable(r *http.Request) (map[string]storagedata.FileMetadata, error) {
+	all, err := s.storage.GetMetadataJSON()
+	if err != nil {
+		return nil, err
+	}
+	_, acls, err := s.storage.ACLs()
+	if err != nil {
+		return nil, err
+	}
+	return acls.Readable(auth.Owner(r.Context()), all), nil
+}
+
+// authorize answers AccessDenied unless the caller of r holds perm on every
+// one of paths, with the ACLs the API enforces.
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request, perm storagedata.Permission, paths ...string) bool {
+	statusCode, err := s.storage.Authorize(auth.Owner(r.Context()), perm, paths...)
+	if err != nil {
+		fmt.Printf("[authorize] Error in Authorize with statusCode: %v - error %v", statusCode, err.Error())
+		s.sendStorageError(w, r, err)
+		return false
+	}
+	return true
+}
+
+type errorResponse struct {
+	XMLName  xml.Name `xml:"Error"`
+	Code     string   `xml:"Code"`
+	Message  string   `xml:"Message"`
+	Resource string   `xml:"Resource"`
+}
+
+func (s *Server) sendError(w http.ResponseWriter, r *http.Request, statusCode int, code, message string) {
+	s.sendXML(w, statusCode, errorResponse{
+		Code:     code,
+		Message:  message,
+		Resource: r.URL.Path,
+	})
+}
+
+// limitedBody reports the error of an http.MaxBytesReader that read limit
+// bytes as storagedata.ErrTooLarge. The limit applies to the decoded object,
+// so chunk signatures don't count towards it.
+type limitedBody struct {
+	body  io.Reader
+	limit int64
+	read  int64
+}
+
+func (l *limitedBody) Read(p []byte) (int, error) {
+	n, err := l.body.Read(p)
+	l.read += int64(n)
+	if err != nil && err != io.EOF && l.read >= l.limit {
+		err = fmt.Errorf("object larger than %d bytes: %w", l.limit, storagedata.ErrTooLarge)
+	}
+	return n, err
+}
+
+// sendStorageError answers with the S3 error matching a storagedata error.
+func (s *Server) sendStorageError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, storagedata.ErrNotFound):
+		s.sendError(w, r, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+	case errors.Is(err, storagedata.ErrForbidden):
+		s.sendError(w, r, http.StatusForbidden, "AccessDenied", err.Error())
+	case errors.Is(err, storagedata.ErrConflict):
+		s.sendError(w, r, http.StatusConflict, "OperationAborted", err.Error())
+	case errors.Is(err, storagedata.ErrTooLarge):
+		s.sendError(w, r, http.StatusBadRequest, "EntityTooLarge", err.Error())
+	case errors.Is(err, storagedata.ErrQuotaExceeded):
+		s.sendError(w, r, http.StatusInsufficientStorage, "QuotaExceeded", err.Error())
+	case errors.Is(err, storagedata.ErrChecksumMismatch):
+		s.sendError(w, r, http.StatusBadRequest, "BadDigest", err.Error())
+	case errors.Is(err, storagedata.ErrInvalidPath), errors.Is(err, storagedata.ErrInvalidRequest):
+		s.sendError(w, r, http.StatusBadRequest, "InvalidRequest", err.Error())
+	case errors.Is(err, storagedata.ErrStorageUnavailable):
+		s.sendError(w, r, http.StatusServiceUnavailable, "ServiceUnavailable", err.Error())
+	default:
+		s.sendError(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+	}
+}
+
+func (s *Server) sendXML(w http.ResponseWriter, statusCode int, value interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(statusCode)
+	io.WriteString(w, xml.Header)
+	_ = xml.NewEncoder(w).Encode(value)
+}
+
+func splitPath(path string) (string, string) {
+	parts := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+func etag(entry storagedata.FileMetadata) string {
+	if entry.SHA256 == "" {
+		return ""
+	}
+	return `"` + entry.SHA256 + `"`
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+// isAWSChunked reports whether the body uses the aws-chunked encoding that
+// SigV4 streaming uploads wrap the payload in.
+func isAWSChunked(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("x-amz-content-sha256"), "STREAMING-") ||
+		strings.Contains(r.Header.Get("Content-Encoding"), "aws-chunked")
+}
+
+// chunkedReader strips the aws-chunked framing:
+// <hex size>;chunk-signature=<sig>\r\n<data>\r\n ... 0;...\r\n[trailers]\r\n
+type chunkedReader struct {
+	r         *bufio.Reader
+	remaining int64
+	done      bool
+}
+
+func newChunkedReader(r io.Reader) *chunkedReader {
+	return &chunkedReader{r: bufio.NewReader(r)}
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	if c.done {
+		return 0, io.EOF
+	}
+
+	if c.remaining == 0 {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return 0, err
+		}
+		size := strings.TrimSpace(strings.SplitN(line, ";", 2)[0])
+		n, err := strconv.ParseInt(size, 16, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid aws-chunked chunk size %q", size)
+		}
+		if n == 0 {
+			c.done = true
+			return 0, io.EOF
+		}
+		c.remaining = n
+	}
+
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.r.Read(p)
+	c.remaining -= int64(n)
+	if c.remaining == 0 && err == nil {
+		_, err = c.r.Discard(2)
+	}
+	return n, err
+}
+
+func New(storage Storage, opts ...Option) *Server {
+	s := &Server{
+		storage: storage,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
@@ -0,0 +1,373 @@
+package s3_test
+
+import (
+	"americanas/auth"
+	"americanas/s3"
+	"americanas/storagedata"
+	"americanas/test"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fixture struct {
+	server *httptest.Server
+	sd     *storagedata.StorageData
+	dir    string
+}
+
+func setup(t *testing.T, opts ...s3.Option) *fixture {
+	dir, err := ioutil.TempDir("", "s3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sd := storagedata.New(storagedata.WithRoot(dir))
+	return &fixture{
+		server: httptest.NewServer(s3.New(sd, opts...)),
+		sd:     sd,
+		dir:    dir,
+	}
+}
+
+func (f *fixture) close() {
+	f.server.Close()
+	f.sd.Close()
+	os.RemoveAll(f.dir)
+}
+
+func (f *fixture) do(method, path string, body io.Reader, headers map[string]string) (*http.Response, string) {
+	req, _ := http.NewRequest(method, f.server.URL+path, body)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err.Error()
+	}
+	defer resp.Body.Close()
+
+	b, _ := ioutil.ReadAll(resp.Body)
+	return resp, string(b)
+}
+
+// fakeAuthenticator takes the API key "k3y" for alice.
+type fakeAuthenticator struct{}
+
+func (fakeAuthenticator) Authenticate(r *http.Request) (string, error) {
+	if r.Header.Get("X-API-Key") != "k3y" {
+		return "", fmt.Errorf("unknown API key: %w", auth.ErrUnauthorized)
+	}
+	return "alice", nil
+}
+
+type listResult struct {
+	KeyCount              int
+	IsTruncated           bool
+	NextContinuationToken string
+	Contents              []struct {
+		Key  string
+		Size int64
+	}
+	CommonPrefixes []struct {
+		Prefix string
+	}
+}
+
+func TestPutGetHeadDeleteObject(t *testing.T) {
+	testCase := "TestPutGetHeadDeleteObject"
+	f := setup(t)
+	defer f.close()
+
+	content := "s3 object content for put and get"
+	resp, _ := f.do("PUT", "/planets/inner/earth.txt", strings.NewReader(content), map[string]string{"Content-Type": "text/plain"})
+	test.AssertEqual(t, testCase, resp.StatusCode, http.StatusOK)
+	etag := resp.Header.Get("ETag")
+	test.AssertEqual(t, testCase, len(etag), 66)
+
+	resp, body := f.do("GET", "/planets/inner/earth.txt", nil, nil)
+	test.AssertEqual(t, testCase, resp.StatusCode, http.StatusOK)
+	test.AssertEqual(t, testCase, body, content)
+	test.AssertEqual(t, testCase, resp.Header.Get("Content-Type"), "text/plain")
+	test.AssertEqual(t, testCase, resp.Header.Get("ETag"), etag)
+
+	resp, body = f.do("GET", "/planets/inner/earth.txt", nil, map[string]string{"Range": "bytes=3-8"})
+	test.AssertEqual(t, testCase, resp.StatusCode, http.StatusPartialContent)
+	test.AssertEqual(t, testCase, body, content[3:9])
+
+	resp, body = f.do("HEAD", "/planets/inner/earth.txt", nil, nil)
+	test.AssertEqual(t, testCase, resp.StatusCode, http.StatusOK)
+	test.AssertEqual(t, testCase, resp.ContentLength, int64(len(content)))
+	test.AssertEqual(t, testCase, body, "")
+
+	replaced := "s3 object content after overwrite"
+	resp, _ = f.do("PUT", "/planets/inner/earth.txt", strings.NewReader(replaced), nil)
+	test.AssertEqual(t, testCase, resp.StatusCode, http.StatusOK)
+	_, body = f.do("GET", "/planets/inner/earth.txt", nil, nil)
+	test.AssertEqual(t, testCase, body, replaced)
+	all, _ := f.sd.GetMetadataJSON()
+	test.AssertEqual(t, testCase, len(all), 1)
+
+	resp, _ = f.do("DELETE", "/planets/inner/earth.txt", nil, nil)
+	test.AssertEqual(t, testCase, resp.StatusCode, http.StatusNoContent)
+
+	resp, body = f.do("GET", "/planets/inner/earth.txt", nil, nil)
+	test.AssertEqual(t, testCase, resp.StatusCode, http.StatusNotFound)
+	test.AssertEqual(t, testCase, strings.Contains(body, "<Code>NoSuchKey</Code>"), true)
+}
+
+func TestConcurrentPutObject(t *testing.T) {
+	testCase := "TestConcurrentPutObject"
+	f := setup(t)
+	defer f.close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			f.do("PUT", "/planets/earth.txt", strings.NewReader(fmt.Sprintf("writer %d", i)), nil)
+		}(i)
+	}
+	wg.Wait()
+
+	// Every writer replaced the key instead of storing a renamed copy.
+	all, _ := f.sd.GetMetadataJSON()
+	test.AssertEqual(t, testCase, len(all), 1)
+	for _, entry := range all {
+		test.AssertEqual(t, testCase, entry.Path, "planets/earth.txt")
+	}
+}
+
+func TestListObjectsV2(t *testing.T) {
+	testCase := "TestListObjectsV2"
+	f := setup(t)
+	defer f.close()
+
+	for _, key := range []string{"inner/earth.txt", "inner/mars.txt", "outer/jupiter.txt", "sun.txt"} {
+		resp, _ := f.do("PUT", "/planets/"+key, strings.NewReader("s3 listing "+key), nil)
+		test.AssertEqual(t, testCase, resp.StatusCode, http.StatusOK)
+	}
+	f.do("PUT", "/moons/titan.txt", strings.NewReader("s3 listing titan"), nil)
+
+	var result listResult
+	resp, body := f.do("GET", "/planets?list-type=2&delimiter=/", nil, nil)
+	test.AssertEqual(t, testCase, resp.StatusCode, http.StatusOK)
+	xml.Unmarshal([]byte(body), &result)
+	test.AssertEqual(t, testCase, result.KeyCount, 3)
+	test.AssertEqual(t, testCase, len(result.Contents), 1)
+	test.AssertEqual(t, testCase, result.Contents[0].Key, "sun.txt")
+	test.AssertEqual(t, testCase, len(result.CommonPrefixes), 2)
+	test.AssertEqual(t, testCase, result.CommonPrefixes[0].Prefix, "inner/")
+	test.AssertEqual(t, testCase, result.CommonPrefixes[1].Prefix, "outer/")
+
+	result = listResult{}
+	_, body = f.do("GET", "/planets?list-type=2&prefix=inner/", nil, nil)
+	xml.Unmarshal([]byte(body), &result)
+	test.AssertEqual(t, testCase, len(result.Contents), 2)
+	test.AssertEqual(t, testCase, result.Contents[0].Key, "inner/earth.txt")
+	test.AssertEqual(t, testCase, result.Contents[0].Size, int64(len("s3 listing inner/earth.txt")))
+
+	var keys []string
+	token := ""
+	for {
+		result = listResult{}
+		_, body = f.do("GET", "/planets?list-type=2&max-keys=3&continuation-token="+token, nil, nil)
+		xml.Unmarshal([]byte(body), &result)
+		for _, c := range result.Contents {
+			keys = append(keys, c.Key)
+		}
+		if !result.IsTruncated {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+	test.AssertEqual(t, testCase, keys, []string{"inner/earth.txt", "inner/mars.txt", "outer/jupiter.txt", "sun.txt"})
+}
+
+func TestCopyObject(t *testing.T) {
+	testCase := "TestCopyObject"
+	f := setup(t)
+	defer f.close()
+
+	content := "s3 object content to be copied"
+	f.do("PUT", "/planets/earth.txt", strings.NewReader(content), map[string]string{"Content-Type": "text/plain"})
+
+	resp, body := f.do("PUT", "/backup/planets/earth.txt", nil, map[string]string{"x-amz-copy-source": "/planets/earth.txt"})
+	test.AssertEqual(t, testCase, resp.StatusCode, http.StatusOK)
+	test.AssertEqual(t, testCase, strings.Contains(body, "<CopyObjectResult"), true)
+
+	resp, body = f.do("GET", "/backup/planets/earth.txt", nil, nil)
+	test.AssertEqual(t, testCase, resp.StatusCode, http.StatusOK)
+	test.AssertEqual(t, testCase, body, content)
+	test.AssertEqual(t, testCase, resp.Header.Get("Content-Type"), "text/plain")
+
+	resp, _ = f.do("GET", "/planets/earth.txt", nil, nil)
+	test.AssertEqual(t, testCase, resp.StatusCode, http.StatusOK)
+}
+
+func TestPutObjectAWSChunked(t *testing.T) {
+	testCase := "TestPutObjectAWSChunked"
+	f := setup(t)
+	defer f.close()
+
+	body := "a;chunk-signature=00\r\ns3 chunked\r\n7;chunk-signature=00\r\n upload\r\n0;chunk-signature=00\r\n\r\n"
+	resp, _ := f.do("PUT", "/planets/chunked.txt", strings.NewReader(body), map[string]string{
+		"x-amz-content-sha256": "STREAMING-AWS4-HMAC-SHA256-PAYLOAD",
+	})
+	test.AssertEqual(t, testCase, resp.StatusCode, http.StatusOK)
+
+	_, got := f.do("GET", "/planets/chunked.txt", nil, nil)
+	test.AssertEqual(t, testCase, got, "s3 chunked upload")
+}
+
+func TestPutObjectTooLarge(t *testing.T) {
+	testCase := "TestPutObjectTooLarge"
+	f := setup(t, s3.WithMaxUploadSize(10))
+	defer f.close()
+
+	resp, body := f.do("PUT", "/planets/small.txt", strings.NewReader("0123456789"), nil)
+	test.AssertEqual(t, testCase, resp.StatusCode, http.StatusOK)
+
+	resp, body = f.do("PUT", "/planets/large.txt", strings.NewReader("0123456789a"), nil)
+	test.AssertEqual(t, testCase, resp.StatusCode, http.StatusBadRequest)
+	test.AssertEqual(t, testCase, strings.Contains(body, "<Code>EntityTooLarge</Code>"), true)
+
+	chunked := "6;chunk-signature=00\r\n012345\r\n5;chunk-signature=00\r\n6789a\r\n0;chunk-signature=00\r\n\r\n"
+	resp, body = f.do("PUT", "/planets/chunked.txt", strings.NewReader(chunked), map[string]string{
+		"x-amz-content-sha256": "STREAMING-AWS4-HMAC-SHA256-PAYLOAD",
+	})
+	test.AssertEqual(t, testCase, resp.StatusCode, http.StatusBadRequest)
+	test.AssertEqual(t, testCase, strings.Contains(body, "<Code>EntityTooLarge</Code>"), true)
+
+	status, _, _ := f.sd.FindByPath("planets/large.txt")
+	test.AssertEqual(t, testCase, status, http.StatusNotFound)
+}
+
+func TestPutObjectContentMD5(t *testing.T) {
+	testCase := "TestPutObjectContentMD5"
+
+	f := setup(t)
+	defer f.close()
+
+	// The MD5 of "red", sent with other content.
+	md5 := map[string]string{"Content-MD5": "valkOsZgFyKijyOHFCdNpA=="}
+	resp, body := f.do("PUT", "/solarsystem/mars.txt", strings.NewReader("red"), md5)
+	test.AssertEqual(t, testCase, resp.StatusCode, http.StatusOK)
+
+	resp, body = f.do("PUT", "/solarsystem/venus.txt", strings.NewReader("yellow"), md5)
+	test.AssertEqual(t, testCase, resp.StatusCode, http.StatusBadRequest)
+	test.AssertEqual(t, testCase, strings.Contains(body, "<Code>BadDigest</Code>"), true)
+
+	resp, body = f.do("PUT", "/solarsystem/venus.txt", strings.NewReader("yellow"), map[string]string{"Content-MD5": "yellow"})
+	test.AssertEqual(t, testCase, resp.StatusCode, http.StatusBadRequest)
+	test.AssertEqual(t, testCase, strings.Contains(body, "<Code>InvalidDigest</Code>"), true)
+}
+
+func TestAuthentication(t *testing.T) {
+	testCase := "TestAuthentication"
+	f := setup(t, s3.WithAuthenticator(fakeAuthenticator{}))
+	defer f.close()
+
+	resp, body := f.do("PUT", "/planets/earth.txt", strings.NewReader("earth"), nil)
+	test.AssertEqual(t, testCase, resp.StatusCode, http.StatusForbidden)
+	test.AssertEqual(t, testCase, strings.Contains(body, "<Code>AccessDenied</Code>"), true)
+	resp, _ = f.do("GET", "/", nil, map[string]string{"X-API-Key": "wrong"})
+	test.AssertEqual(t, testCase, resp.StatusCode, http.StatusForbidden)
+
+	resp, _ = f.do("PUT", "/planets/earth.txt", strings.NewReader("earth"), map[string]string{"X-API-Key": "k3y"})
+	test.AssertEqual(t, testCase, resp.StatusCode, http.StatusOK)
+	_, entry, err := f.sd.FindByPath("planets/earth.txt")
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, entry.Owner, "alice")
+}
+
+func TestAWSSignature(t *testing.T) {
+	testCase := "TestAWSSignature"
+
+	keys, _ := ioutil.TempFile("", "keys")
+	keys.WriteString(`{"apiKeys": [{"key": "s3cr3t", "owner": "alice", "accessKeyId": "AKALICE"}]}`)
+	keys.Close()
+	defer os.Remove(keys.Name())
+	authenticator, err := auth.New(keys.Name())
+	test.AssertNoError(t, testCase, err)
+	f := setup(t, s3.WithAuthenticator(authenticator))
+	defer f.close()
+
+	put := func(content, payloadHash, secret string) (*http.Response, string) {
+		req, _ := http.NewRequest("PUT", f.server.URL+"/planets/earth.txt", strings.NewReader(content))
+		req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+		auth.SignV4(req, "AKALICE", secret, "us-east-1", time.Now())
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, err.Error()
+		}
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(resp.Body)
+		return resp, string(b)
+	}
+	sum := sha256.Sum256([]byte("blue"))
+
+	resp, _ := put("blue", hex.EncodeToString(sum[:]), "s3cr3t")
+	test.AssertEqual(t, testCase, resp.StatusCode, http.StatusOK)
+	_, entry, _ := f.sd.FindByPath("planets/earth.txt")
+	test.AssertEqual(t, testCase, entry.Owner, "alice")
+
+	resp, body := put("blue", hex.EncodeToString(sum[:]), "guess")
+	test.AssertEqual(t, testCase, resp.StatusCode, http.StatusForbidden)
+	test.AssertEqual(t, testCase, strings.Contains(body, "<Code>AccessDenied</Code>"), true)
+
+	// The signed hash stands for the content.
+	resp, body = put("green", hex.EncodeToString(sum[:]), "s3cr3t")
+	test.AssertEqual(t, testCase, resp.StatusCode, http.StatusBadRequest)
+	test.AssertEqual(t, testCase, strings.Contains(body, "<Code>BadDigest</Code>"), true)
+}
+
+func TestACLs(t *testing.T) {
+	testCase := "TestACLs"
+	f := setup(t, s3.WithAuthenticator(fakeAuthenticator{}))
+	defer f.close()
+	f.sd.SetACL(storagedata.ACL{Path: "", Grants: map[string][]storagedata.Permission{
+		"admin":              {storagedata.PermAdmin},
+		storagedata.Everyone: {storagedata.PermRead},
+	}})
+	f.sd.SetACL(storagedata.ACL{Path: "planets", Grants: map[string][]storagedata.Permission{
+		"alice": {storagedata.PermRead, storagedata.PermWrite},
+	}})
+	f.sd.SetACL(storagedata.ACL{Path: "secret", Grants: map[string][]storagedata.Permission{
+		"bob": {storagedata.PermRead},
+	}})
+	f.sd.StorageFile(storagedata.UploadRequest{Path: "secret", Name: "plan.txt", Content: strings.NewReader("plan")})
+	alice := map[string]string{"X-API-Key": "k3y"}
+
+	resp, _ := f.do("PUT", "/planets/earth.txt", strings.NewReader("earth"), alice)
+	test.AssertEqual(t, testCase, resp.StatusCode, http.StatusOK)
+	resp, body := f.do("PUT", "/moons/titan.txt", strings.NewReader("titan"), alice)
+	test.AssertEqual(t, testCase, resp.StatusCode, http.StatusForbidden)
+	test.AssertEqual(t, testCase, strings.Contains(body, "<Code>AccessDenied</Code>"), true)
+	resp, _ = f.do("DELETE", "/planets/earth.txt", nil, alice)
+	test.AssertEqual(t, testCase, resp.StatusCode, http.StatusForbidden)
+	resp, _ = f.do("GET", "/secret/plan.txt", nil, alice)
+	test.AssertEqual(t, testCase, resp.StatusCode, http.StatusForbidden)
+	resp, _ = f.do("PUT", "/planets/plan.txt", nil, map[string]string{"X-API-Key": "k3y", "x-amz-copy-source": "/secret/plan.txt"})
+	test.AssertEqual(t, testCase, resp.StatusCode, http.StatusForbidden)
+
+	// Listings leave out what alice can't read.
+	_, body = f.do("GET", "/", nil, alice)
+	test.AssertEqual(t, testCase, strings.Contains(body, "<Name>planets</Name>"), true)
+	test.AssertEqual(t, testCase, strings.Contains(body, "secret"), false)
+	_, body = f.do("GET", "/secret?list-type=2", nil, alice)
+	var result listResult
+	xml.Unmarshal([]byte(body), &result)
+	test.AssertEqual(t, testCase, result.KeyCount, 0)
+}
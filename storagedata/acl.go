@@ -0,0 +1,362 @@
+package storagedata
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+// ACLs restrict what callers may do below a directory. They are kept in
+// acls.json under the root, keyed by directory, and move along with it in
+// MoveDir. A path no ACL covers is open to everyone for everything but admin,
+// which only the owners given to WithAdmins hold until a root ACL grants it
+// to someone. Every front end checks its callers with Authorize and
+// AuthorizeTree.
+
+// Permission is what an ACL grants on its directory and everything below it.
+type Permission string
+
+const (
+	PermRead   Permission = "read"
+	PermWrite  Permission = "write"
+	PermDelete Permission = "delete"
+	// PermAdmin implies every other permission, there and below, and allows
+	// editing the ACLs of the directory and its subdirectories.
+	PermAdmin Permission = "admin"
+)
+
+// Everyone is the grantee matching every caller, anonymous ones included.
+const Everyone = "*"
+
+// ACL grants permissions on Path to owners, keyed by owner or Everyone.
+type ACL struct {
+	Path   string                  `json:"path"`
+	Grants map[string][]Permission `json:"grants"`
+}
+
+// ACLs holds every ACL by path.
+type ACLs map[string]ACL
+
+// Allows reports whether owner holds perm on p. The ACL closest to p, on p or
+// one of its parents, decides, except that admin on any parent always holds.
+// Without any, every permission but admin is granted.
+func (acls ACLs) Allows(owner, p string, perm Permission) bool {
+	decided := false
+	for dir := p; ; dir = parentDir(dir) {
+		if acl, ok := acls[dir]; ok {
+			if acl.grants(owner, PermAdmin) || (!decided && acl.grants(owner, perm)) {
+				return true
+			}
+			decided = true
+		}
+		if dir == "" {
+			return !decided && perm != PermAdmin
+		}
+	}
+}
+
+// Reaches reports whether owner may read dir or something below it, which is
+// what it takes for dir to be shown in a listing.
+func (acls ACLs) Reaches(owner, dir string) bool {
+	if acls.Allows(owner, dir, PermRead) {
+		return true
+	}
+	for p := range acls {
+		if _, ok := under(p, dir); ok && acls.Allows(owner, p, PermRead) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsBelow reports whether owner holds perm on dir and on everything below
+// it, as it takes to move or delete the whole directory.
+func (acls ACLs) AllowsBelow(owner, dir string, perm Permission) bool {
+	if !acls.Allows(owner, dir, perm) {
+		return false
+	}
+	for p := range acls {
+		if _, ok := under(p, dir); ok && !acls.Allows(owner, p, perm) {
+			return false
+		}
+	}
+	return true
+}
+
+// Check fails with ErrForbidden unless owner holds perm on every one of
+// paths.
+func (acls ACLs) Check(owner string, perm Permission, paths ...string) error {
+	for _, p := range paths {
+		p, err := CleanPath(p)
+		if err != nil {
+			return err
+		}
+		if !acls.Allows(owner, p, perm) {
+			return Forbidden(owner, perm, p)
+		}
+	}
+	return nil
+}
+
+// CheckBelow is Check on dir and every directory below it.
+func (acls ACLs) CheckBelow(owner string, perm Permission, dir string) error {
+	dir, err := CleanPath(dir)
+	if err != nil {
+		return err
+	}
+	if !acls.AllowsBelow(owner, dir, perm) {
+		return Forbidden(owner, perm, dir)
+	}
+	return nil
+}
+
+// Readable keeps the files owner may read.
+func (acls ACLs) Readable(owner string, files map[string]FileMetadata) map[string]FileMetadata {
+	if len(acls) == 0 {
+		return files
+	}
+
+	kept := make(map[string]FileMetadata, len(files))
+	for id, file := range files {
+		if acls.Allows(owner, file.Path, PermRead) {
+			kept[id] = file
+		}
+	}
+	return kept
+}
+
+// Forbidden is the error of owner lacking perm on p.
+func Forbidden(owner string, perm Permission, p string) error {
+	if owner == "" {
+		owner = "anonymous"
+	}
+	if p == "" {
+		p = "/"
+	}
+	return fmt.Errorf("%s has no %s permission on %s: %w", owner, perm, p, ErrForbidden)
+}
+
+func (acl ACL) grants(owner string, perm Permission) bool {
+	for _, grantee := range []string{owner, Everyone} {
+		for _, granted := range acl.Grants[grantee] {
+			if granted == perm || granted == PermAdmin {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// WithAdmins makes owners admins of the root, on top of what the root ACL
+// grants. Without them, no one may set the first ACL or a quota.
+func WithAdmins(owners ...string) Option {
+	return func(s *StorageData) {
+		s.admins = owners
+	}
+}
+
+// ACLs returns every ACL, the admins given to WithAdmins included in the root
+// one.
+func (s *StorageData) ACLs() (int, ACLs, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	acls, err := s.readACLs()
+	if err != nil {
+		return http.StatusServiceUnavailable, nil, err
+	}
+	if len(s.admins) == 0 {
+		return http.StatusOK, acls, nil
+	}
+
+	// Without a root ACL everyone keeps what an uncovered path allows.
+	root, ok := acls[""]
+	if !ok {
+		root = ACL{Path: "", Grants: map[string][]Permission{
+			Everyone: {PermRead, PermWrite, PermDelete},
+		}}
+	}
+	for _, owner := range s.admins {
+		root.Grants[owner] = append(root.Grants[owner], PermAdmin)
+	}
+	acls[""] = root
+	return http.StatusOK, acls, nil
+}
+
+// Authorize fails with ErrForbidden unless owner holds perm on every one of
+// paths.
+func (s *StorageData) Authorize(owner string, perm Permission, paths ...string) (int, error) {
+	status, acls, err := s.ACLs()
+	if err != nil {
+		return status, err
+	}
+	if err := acls.Check(owner, perm, paths...); err != nil {
+		return statusOf(err), err
+	}
+	return http.StatusOK, nil
+}
+
+// AuthorizeTree is Authorize on dir and every directory below it, as it takes
+// to move or delete the whole directory.
+func (s *StorageData) AuthorizeTree(owner string, perm Permission, dir string) (int, error) {
+	status, acls, err := s.ACLs()
+	if err != nil {
+		return status, err
+	}
+	if err := acls.CheckBelow(owner, perm, dir); err != nil {
+		return statusOf(err), err
+	}
+	return http.StatusOK, nil
+}
+
+// SetACL replaces the ACL of acl.Path. An ACL without grants is removed, and
+// the path is open again as far as its parents allow. The root ACL must grant
+// admin to someone, or no one could ever change it again.
+func (s *StorageData) SetACL(acl ACL) (int, ACL, error) {
+	acl, err := checkACL(acl)
+	if err != nil {
+		return http.StatusBadRequest, ACL{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	acls, err := s.readACLs()
+	if err != nil {
+		return http.StatusServiceUnavailable, ACL{}, err
+	}
+	if len(acl.Grants) == 0 {
+		delete(acls, acl.Path)
+	} else {
+		acls[acl.Path] = acl
+	}
+	if err := s.saveACLs(acls); err != nil {
+		return http.StatusServiceUnavailable, ACL{}, err
+	}
+	return http.StatusOK, acl, nil
+}
+
+func checkACL(acl ACL) (ACL, error) {
+	var err error
+	acl.Path, err = CleanPath(acl.Path)
+	if err != nil {
+		return acl, err
+	}
+
+	grants := make(map[string][]Permission)
+	admin := false
+	for grantee, perms := range acl.Grants {
+		if grantee == "" {
+			return acl, fmt.Errorf("empty grantee: %w", ErrInvalidRequest)
+		}
+		for _, perm := range perms {
+			switch perm {
+			case PermRead, PermWrite, PermDelete, PermAdmin:
+			default:
+				return acl, fmt.Errorf("unknown permission %q: %w", perm, ErrInvalidRequest)
+			}
+			admin = admin || perm == PermAdmin
+		}
+		if len(perms) > 0 {
+			grants[grantee] = perms
+		}
+	}
+	if acl.Path == "" && len(grants) > 0 && !admin {
+		return acl, fmt.Errorf("the root ACL must grant admin to someone: %w", ErrInvalidRequest)
+	}
+
+	acl.Grants = grants
+	return acl, nil
+}
+
+// moveACLs gives the ACLs of dir and below to toDir. Called with s.mu held.
+func (s *StorageData) moveACLs(dir, toDir string) error {
+	acls, err := s.readACLs()
+	if err != nil || len(acls) == 0 {
+		return err
+	}
+
+	moved := make(ACLs, len(acls))
+	for p, acl := range acls {
+		if p == dir {
+			acl.Path = toDir
+		} else if rest, ok := under(p, dir); ok {
+			acl.Path = toDir + "/" + rest
+		}
+		moved[acl.Path] = acl
+	}
+	return s.saveACLs(moved)
+}
+
+// dropACLs removes the ACLs of dir and below. Called with s.mu held.
+func (s *StorageData) dropACLs(dir string) error {
+	acls, err := s.readACLs()
+	if err != nil || len(acls) == 0 {
+		return err
+	}
+
+	for p := range acls {
+		if _, ok := under(p, dir); ok || p == dir {
+			delete(acls, p)
+		}
+	}
+	return s.saveACLs(acls)
+}
+
+func (s *StorageData) aclsPath() string {
+	return filepath.Join(s.root, "acls.json")
+}
+
+// readACLs loads the ACLs. Called with s.mu held.
+func (s *StorageData) readACLs() (ACLs, error) {
+	acls := make(ACLs)
+
+	b, err := ioutil.ReadFile(s.aclsPath())
+	if os.IsNotExist(err) {
+		return acls, nil
+	}
+	if err != nil {
+		return nil, unavailable(err)
+	}
+
+	var list []ACL
+	if err := json.Unmarshal(b, &list); err != nil {
+		return nil, unavailable(err)
+	}
+	for _, acl := range list {
+		acls[acl.Path] = acl
+	}
+	return acls, nil
+}
+
+// saveACLs replaces the ACLs. Called with s.mu held.
+func (s *StorageData) saveACLs(acls ACLs) error {
+	list := make([]ACL, 0, len(acls))
+	for _, acl := range acls {
+		list = append(list, acl)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Path < list[j].Path
+	})
+
+	b, err := json.MarshalIndent(list, "", "	")
+	if err != nil {
+		return unavailable(err)
+	}
+	return unavailable(writeFileAtomic(s.aclsPath(), b))
+}
+
+// parentDir is the directory holding p; the parent of a top level path is
+// the root "".
+func parentDir(p string) string {
+	dir := path.Dir(p)
+	if dir == "." {
+		return ""
+	}
+	return dir
+}
@@ -0,0 +1,196 @@
+package storagedata_test
+
+import (
+	"americanas/storagedata"
+	"americanas/test"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestACLsAllows(t *testing.T) {
+	testCase := "TestACLsAllows"
+
+	acls := storagedata.ACLs{
+		"": {Path: "", Grants: map[string][]storagedata.Permission{
+			"root":               {storagedata.PermAdmin},
+			storagedata.Everyone: {storagedata.PermRead},
+		}},
+		"finance": {Path: "finance", Grants: map[string][]storagedata.Permission{
+			"alice": {storagedata.PermRead, storagedata.PermWrite},
+			"carol": {storagedata.PermAdmin},
+		}},
+	}
+
+	test.AssertEqual(t, testCase, acls.Allows("bob", "space/mars.png", storagedata.PermRead), true)
+	test.AssertEqual(t, testCase, acls.Allows("bob", "space/mars.png", storagedata.PermWrite), false)
+
+	// The closest ACL decides: finance isn't readable by everyone anymore.
+	test.AssertEqual(t, testCase, acls.Allows("bob", "finance/2020/report.pdf", storagedata.PermRead), false)
+	test.AssertEqual(t, testCase, acls.Allows("alice", "finance/2020/report.pdf", storagedata.PermWrite), true)
+	test.AssertEqual(t, testCase, acls.Allows("alice", "finance/2020/report.pdf", storagedata.PermDelete), false)
+	test.AssertEqual(t, testCase, acls.Allows("carol", "finance/2020", storagedata.PermDelete), true)
+	test.AssertEqual(t, testCase, acls.Allows("carol", "space", storagedata.PermWrite), false)
+
+	// Admin on a parent holds below whatever the closer ACL says.
+	test.AssertEqual(t, testCase, acls.Allows("root", "finance/2020", storagedata.PermDelete), true)
+
+	// A path no ACL covers is open, but to no admin.
+	test.AssertEqual(t, testCase, storagedata.ACLs{}.Allows("", "space", storagedata.PermDelete), true)
+	test.AssertEqual(t, testCase, storagedata.ACLs{}.Allows("alice", "space", storagedata.PermAdmin), false)
+}
+
+func TestACLsReaches(t *testing.T) {
+	testCase := "TestACLsReaches"
+
+	acls := storagedata.ACLs{
+		"": {Path: "", Grants: map[string][]storagedata.Permission{"root": {storagedata.PermAdmin}}},
+		"space/planets": {Path: "space/planets", Grants: map[string][]storagedata.Permission{
+			"bob": {storagedata.PermRead},
+		}},
+	}
+
+	test.AssertEqual(t, testCase, acls.Reaches("bob", ""), true)
+	test.AssertEqual(t, testCase, acls.Reaches("bob", "space"), true)
+	test.AssertEqual(t, testCase, acls.Reaches("bob", "finance"), false)
+	test.AssertEqual(t, testCase, acls.Reaches("alice", "space"), false)
+
+	test.AssertEqual(t, testCase, acls.AllowsBelow("root", "space", storagedata.PermDelete), true)
+	test.AssertEqual(t, testCase, acls.AllowsBelow("bob", "space/planets", storagedata.PermRead), true)
+	test.AssertEqual(t, testCase, acls.AllowsBelow("bob", "space", storagedata.PermRead), false)
+}
+
+func TestAuthorize(t *testing.T) {
+	testCase := "TestAuthorize"
+
+	f := setup(t)
+	defer f.close()
+	f.sd.SetACL(storagedata.ACL{Path: "", Grants: map[string][]storagedata.Permission{"root": {storagedata.PermAdmin}}})
+	f.sd.SetACL(storagedata.ACL{Path: "space", Grants: map[string][]storagedata.Permission{"alice": {storagedata.PermDelete}}})
+	f.sd.SetACL(storagedata.ACL{Path: "space/planets", Grants: map[string][]storagedata.Permission{"bob": {storagedata.PermRead}}})
+
+	status, err := f.sd.Authorize("alice", storagedata.PermDelete, "space/mars.png", "space/moons")
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+
+	status, err = f.sd.Authorize("alice", storagedata.PermDelete, "space", "space/planets/earth.png")
+	test.AssertEqual(t, testCase, status, http.StatusForbidden)
+	test.AssertEqual(t, testCase, err.Error(), "alice has no delete permission on space/planets/earth.png: forbidden")
+
+	_, err = f.sd.Authorize("", storagedata.PermRead, "")
+	test.AssertEqual(t, testCase, err.Error(), "anonymous has no read permission on /: forbidden")
+	status, _ = f.sd.Authorize("alice", storagedata.PermRead, "../etc")
+	test.AssertEqual(t, testCase, status, http.StatusBadRequest)
+
+	// A whole tree takes the permission on every directory below it.
+	status, _ = f.sd.AuthorizeTree("alice", storagedata.PermDelete, "space")
+	test.AssertEqual(t, testCase, status, http.StatusForbidden)
+	status, _ = f.sd.AuthorizeTree("root", storagedata.PermDelete, "space")
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+}
+
+func TestAdmins(t *testing.T) {
+	testCase := "TestAdmins"
+
+	f := setup(t)
+	defer f.close()
+
+	// No one may set the first root ACL unless made an admin.
+	status, err := f.sd.Authorize("alice", storagedata.PermAdmin, "")
+	test.AssertEqual(t, testCase, status, http.StatusForbidden)
+	test.AssertEqual(t, testCase, errors.Is(err, storagedata.ErrForbidden), true)
+	status, _ = f.sd.Authorize("alice", storagedata.PermWrite, "space")
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+
+	f = setup(t, storagedata.WithAdmins("root"))
+	defer f.close()
+	status, _ = f.sd.Authorize("root", storagedata.PermAdmin, "")
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+	status, _ = f.sd.Authorize("alice", storagedata.PermAdmin, "space")
+	test.AssertEqual(t, testCase, status, http.StatusForbidden)
+	status, _ = f.sd.Authorize("alice", storagedata.PermWrite, "space")
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+
+	// The admins hold on top of a root ACL, which isn't stored with them.
+	f.sd.SetACL(storagedata.ACL{Path: "", Grants: map[string][]storagedata.Permission{"carol": {storagedata.PermAdmin}}})
+	status, _ = f.sd.Authorize("root", storagedata.PermAdmin, "")
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+	status, _ = f.sd.Authorize("alice", storagedata.PermWrite, "space")
+	test.AssertEqual(t, testCase, status, http.StatusForbidden)
+	b, _ := ioutil.ReadFile(filepath.Join(f.dir, "acls.json"))
+	test.AssertEqual(t, testCase, strings.Contains(string(b), `"root"`), false)
+}
+
+func TestSetACL(t *testing.T) {
+	testCase := "TestSetACL"
+
+	f := setup(t)
+	defer f.close()
+
+	status, acl, err := f.sd.SetACL(storagedata.ACL{
+		Path:   "finance/",
+		Grants: map[string][]storagedata.Permission{"alice": {storagedata.PermRead}, "bob": {}},
+	})
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+	test.AssertEqual(t, testCase, acl.Path, "finance")
+	test.AssertEqual(t, testCase, acl.Grants, map[string][]storagedata.Permission{"alice": {storagedata.PermRead}})
+
+	_, acls, _ := f.sd.ACLs()
+	test.AssertEqual(t, testCase, acls, storagedata.ACLs{"finance": acl})
+
+	status, _, err = f.sd.SetACL(storagedata.ACL{Path: "finance", Grants: map[string][]storagedata.Permission{"alice": {"own"}}})
+	test.AssertEqual(t, testCase, status, http.StatusBadRequest)
+	test.AssertEqual(t, testCase, errors.Is(err, storagedata.ErrInvalidRequest), true)
+
+	status, _, err = f.sd.SetACL(storagedata.ACL{Path: "", Grants: map[string][]storagedata.Permission{"alice": {storagedata.PermRead}}})
+	test.AssertEqual(t, testCase, status, http.StatusBadRequest)
+	test.AssertEqual(t, testCase, errors.Is(err, storagedata.ErrInvalidRequest), true)
+
+	// An ACL without grants is removed.
+	_, _, err = f.sd.SetACL(storagedata.ACL{Path: "finance"})
+	test.AssertNoError(t, testCase, err)
+	_, acls, _ = f.sd.ACLs()
+	test.AssertEqual(t, testCase, len(acls), 0)
+}
+
+func TestMoveDirMovesACLs(t *testing.T) {
+	testCase := "TestMoveDirMovesACLs"
+
+	f := listFixture(t)
+	defer f.close()
+
+	grants := map[string][]storagedata.Permission{"alice": {storagedata.PermRead}}
+	f.sd.SetACL(storagedata.ACL{Path: "space/planets/moons", Grants: grants})
+	f.sd.SetACL(storagedata.ACL{Path: "space/planetsX", Grants: grants})
+
+	_, err := f.sd.MoveDir("space/planets", "solar")
+	test.AssertNoError(t, testCase, err)
+
+	_, acls, _ := f.sd.ACLs()
+	test.AssertEqual(t, testCase, acls, storagedata.ACLs{
+		"solar/moons":    {Path: "solar/moons", Grants: grants},
+		"space/planetsX": {Path: "space/planetsX", Grants: grants},
+	})
+}
+
+func TestListVisible(t *testing.T) {
+	testCase := "TestListVisible"
+
+	f := listFixture(t)
+	defer f.close()
+
+	_, result, err := f.sd.List(storagedata.ListOptions{
+		Dir:       "space",
+		Recursive: true,
+		Limit:     2,
+		Visible: func(entry storagedata.FileMetadata) bool {
+			return entry.Name != "earth.jpg"
+		},
+	})
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, names(result.Files), []string{"mars.png", "notes.txt"})
+}
@@ -0,0 +1,138 @@
+package storagedata
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// File contents live in blobs/<first two hex digits>/<sha256>, so every
+// metadata entry with the same content shares one copy on disk. A blob is
+// kept while at least one entry references its hash.
+
+func (s *StorageData) blobDir() string {
+	return filepath.Join(s.root, "blobs")
+}
+
+func (s *StorageData) blobPath(hash string) string {
+	return filepath.Join(s.blobDir(), hash[:2], hash)
+}
+
+// saveBlob stores the content of r by its SHA-256 and returns the hash and
+// size, unless it doesn't match the sums in want. The blob lock for the hash
+// is still held on return, so the caller can record its reference before a
+// concurrent delete decides the blob is unused.
+func (s *StorageData) saveBlob(r io.Reader, want Digest) (string, int64, func(), error) {
+	err := os.MkdirAll(s.blobDir(), os.ModePerm)
+	if err != nil {
+		return "", 0, nil, unavailable(err)
+	}
+
+	tmp, err := ioutil.TempFile(s.blobDir(), ".upload-")
+	if err != nil {
+		return "", 0, nil, unavailable(err)
+	}
+	defer os.Remove(tmp.Name())
+
+	hasher := newDigester(want)
+	src := &sourceReader{r: r}
+	size, err := io.Copy(io.MultiWriter(tmp, hasher), src)
+	if err != nil {
+		tmp.Close()
+		// A broken upload is the client's problem, a failed write is ours.
+		if src.err != nil {
+			return "", 0, nil, WrapError(ErrInvalidRequest, fmt.Errorf("error in copy: %w", err))
+		}
+		return "", 0, nil, unavailable(fmt.Errorf("error in copy: %w", err))
+	}
+	if err := tmp.Close(); err != nil {
+		return "", 0, nil, unavailable(err)
+	}
+	hash, err := hasher.check()
+	if err != nil {
+		return "", 0, nil, err
+	}
+
+	unlock := s.blobs.lock(hash)
+
+	dst := s.blobPath(hash)
+	if _, err := os.Stat(dst); err == nil {
+		return hash, size, unlock, nil
+	}
+
+	err = os.MkdirAll(filepath.Dir(dst), os.ModePerm)
+	if err == nil {
+		err = os.Rename(tmp.Name(), dst)
+	}
+	if err != nil {
+		unlock()
+		return "", 0, nil, unavailable(err)
+	}
+
+	return hash, size, unlock, nil
+}
+
+// sourceReader remembers the error of the reader it wraps, so a failed copy
+// can be blamed on the right side.
+type sourceReader struct {
+	r   io.Reader
+	err error
+}
+
+func (s *sourceReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if err != nil && err != io.EOF {
+		s.err = err
+	}
+	return n, err
+}
+
+// releaseBlob removes the blob once no metadata entry, trashed or not,
+// references it anymore. Called with the blob lock for hash held.
+func (s *StorageData) releaseBlob(hash string) error {
+	used, err := s.referenced(hash)
+	if err != nil || used {
+		return err
+	}
+
+	err = os.Remove(s.blobPath(hash))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// referenced reports whether an entry, trashed or not, references hash.
+func (s *StorageData) referenced(hash string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	refs, err := s.store.Refs(hash)
+	if err != nil || refs > 0 {
+		return refs > 0, unavailable(err)
+	}
+	refs, err = s.trashedRefs(hash)
+	return refs > 0, err
+}
+
+// contentPath is where the bytes of an entry live on disk. Entries written
+// before blobs existed keep their content at their own path.
+func (s *StorageData) contentPath(entry FileMetadata) string {
+	if len(entry.SHA256) > 2 {
+		return s.blobPath(entry.SHA256)
+	}
+
+	return filepath.Join(s.root, entry.Path)
+}
+
+// contentID derives a file ID from the content hash and the path the entry
+// was created at. n disambiguates the rare case where that ID is already
+// taken, e.g. by an entry that was moved away from the same path.
+func contentID(hash, path string, n int) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s:%s:%d", hash, path, n)))
+	return hex.EncodeToString(sum[:16])
+}
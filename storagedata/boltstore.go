@@ -0,0 +1,246 @@
+package storagedata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	metadataBucket = []byte("metadata")
+	// pathsBucket indexes the IDs by path, with keys made of the path, a
+	// NUL byte and the ID. Paths can't contain control characters.
+	pathsBucket = []byte("paths")
+	// refsBucket counts the references to each blob, as a big endian
+	// uint64 keyed by hash.
+	refsBucket = []byte("refs")
+)
+
+// BoltStore keeps the index in a single-file B+tree, so every operation only
+// touches the entries it needs instead of rewriting the whole index.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+func (b *BoltStore) Get(id string) (FileMetadata, bool, error) {
+	var entry FileMetadata
+	found := false
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(metadataBucket).Get([]byte(id))
+		if value == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(value, &entry)
+	})
+	if err != nil {
+		return FileMetadata{}, false, err
+	}
+
+	return entry, found, nil
+}
+
+func (b *BoltStore) Put(id string, entry FileMetadata) error {
+	entry.ID = id
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return putEntry(tx, entry)
+	})
+}
+
+func (b *BoltStore) PutAll(entries []FileMetadata) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		for _, entry := range entries {
+			if err := putEntry(tx, entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *BoltStore) Delete(id string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if err := unindexEntry(tx, id); err != nil {
+			return err
+		}
+		return tx.Bucket(metadataBucket).Delete([]byte(id))
+	})
+}
+
+func (b *BoltStore) ByPath(path string) ([]FileMetadata, error) {
+	var entries []FileMetadata
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		prefix := pathKey(path, "")
+		metadata := tx.Bucket(metadataBucket)
+		c := tx.Bucket(pathsBucket).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			var entry FileMetadata
+			if err := json.Unmarshal(metadata.Get(k[len(prefix):]), &entry); err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func (b *BoltStore) Refs(hash string) (int, error) {
+	var refs uint64
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		if value := tx.Bucket(refsBucket).Get([]byte(hash)); value != nil {
+			refs = binary.BigEndian.Uint64(value)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return int(refs), nil
+}
+
+func (b *BoltStore) All() (map[string]FileMetadata, error) {
+	all := make(map[string]FileMetadata)
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(metadataBucket).ForEach(func(k, v []byte) error {
+			var entry FileMetadata
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return err
+			}
+			all[string(k)] = entry
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return all, nil
+}
+
+func (b *BoltStore) Close() error {
+	return b.db.Close()
+}
+
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		metadata, err := tx.CreateBucketIfNotExists(metadataBucket)
+		if err != nil {
+			return err
+		}
+		if tx.Bucket(pathsBucket) != nil && tx.Bucket(refsBucket) != nil {
+			return nil
+		}
+
+		// Databases written before the indexes existed are indexed once.
+		for _, name := range [][]byte{pathsBucket, refsBucket} {
+			if tx.Bucket(name) != nil {
+				if err := tx.DeleteBucket(name); err != nil {
+					return err
+				}
+			}
+			if _, err := tx.CreateBucket(name); err != nil {
+				return err
+			}
+		}
+		return metadata.ForEach(func(k, v []byte) error {
+			var entry FileMetadata
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return err
+			}
+			return indexEntry(tx, entry, 1)
+		})
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltStore{
+		db: db,
+	}, nil
+}
+
+// putEntry stores entry and moves it in the indexes.
+func putEntry(tx *bolt.Tx, entry FileMetadata) error {
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	if err := unindexEntry(tx, entry.ID); err != nil {
+		return err
+	}
+	if err := tx.Bucket(metadataBucket).Put([]byte(entry.ID), value); err != nil {
+		return err
+	}
+	return indexEntry(tx, entry, 1)
+}
+
+// unindexEntry removes the entry of id, if any, from the indexes.
+func unindexEntry(tx *bolt.Tx, id string) error {
+	value := tx.Bucket(metadataBucket).Get([]byte(id))
+	if value == nil {
+		return nil
+	}
+
+	var entry FileMetadata
+	if err := json.Unmarshal(value, &entry); err != nil {
+		return err
+	}
+	return indexEntry(tx, entry, -1)
+}
+
+// indexEntry adds entry to the path index and its references to the counts
+// with delta 1, and takes them out with delta -1.
+func indexEntry(tx *bolt.Tx, entry FileMetadata, delta int) error {
+	paths := tx.Bucket(pathsBucket)
+	if delta > 0 {
+		if err := paths.Put(pathKey(entry.Path, entry.ID), []byte{}); err != nil {
+			return err
+		}
+	} else if err := paths.Delete(pathKey(entry.Path, entry.ID)); err != nil {
+		return err
+	}
+
+	refs := tx.Bucket(refsBucket)
+	for _, hash := range entry.hashes() {
+		count := int64(delta)
+		if value := refs.Get([]byte(hash)); value != nil {
+			count += int64(binary.BigEndian.Uint64(value))
+		}
+
+		var err error
+		if count <= 0 {
+			err = refs.Delete([]byte(hash))
+		} else {
+			value := make([]byte, 8)
+			binary.BigEndian.PutUint64(value, uint64(count))
+			err = refs.Put([]byte(hash), value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func pathKey(path, id string) []byte {
+	return []byte(path + "\x00" + id)
+}
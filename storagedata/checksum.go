@@ -0,0 +1,96 @@
+package storagedata
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"strings"
+)
+
+// Digest holds the checksums a client sent along with a file, as raw sums.
+// Content that doesn't match them is rejected with ErrChecksumMismatch.
+type Digest struct {
+	MD5    []byte
+	SHA256 []byte
+}
+
+// DigestFromHeaders reads a Content-MD5 header and an RFC 3230 Digest header
+// such as "sha-256=X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=". Algorithms
+// other than MD5 and SHA-256 are ignored.
+func DigestFromHeaders(h http.Header) (Digest, error) {
+	var d Digest
+	var err error
+	if value := h.Get("Content-MD5"); value != "" {
+		d.MD5, err = decodeSum("Content-MD5", value, md5.Size)
+		if err != nil {
+			return Digest{}, err
+		}
+	}
+
+	for _, field := range strings.Split(h.Get("Digest"), ",") {
+		i := strings.Index(field, "=")
+		if i < 0 {
+			continue
+		}
+		alg, value := strings.ToLower(strings.TrimSpace(field[:i])), strings.TrimSpace(field[i+1:])
+		switch alg {
+		case "md5":
+			d.MD5, err = decodeSum("Digest md5", value, md5.Size)
+		case "sha-256":
+			d.SHA256, err = decodeSum("Digest sha-256", value, sha256.Size)
+		}
+		if err != nil {
+			return Digest{}, err
+		}
+	}
+	return d, nil
+}
+
+func decodeSum(name, value string, size int) ([]byte, error) {
+	sum, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(sum) != size {
+		return nil, fmt.Errorf("malformed %s %q: %w", name, value, ErrInvalidRequest)
+	}
+	return sum, nil
+}
+
+// digester computes the sums a Digest asks for while content is copied.
+type digester struct {
+	want   Digest
+	md5    hash.Hash
+	sha256 hash.Hash
+}
+
+func newDigester(want Digest) *digester {
+	d := &digester{want: want, sha256: sha256.New()}
+	if want.MD5 != nil {
+		d.md5 = md5.New()
+	}
+	return d
+}
+
+func (d *digester) Write(p []byte) (int, error) {
+	d.sha256.Write(p)
+	if d.md5 != nil {
+		d.md5.Write(p)
+	}
+	return len(p), nil
+}
+
+// check returns the hex SHA-256 of what was written, or an error matching
+// ErrChecksumMismatch when a wanted sum differs.
+func (d *digester) check() (string, error) {
+	sum := d.sha256.Sum(nil)
+	if d.want.SHA256 != nil && !bytes.Equal(sum, d.want.SHA256) {
+		return "", fmt.Errorf("content doesn't match the SHA-256 digest sent: %w", ErrChecksumMismatch)
+	}
+	if d.md5 != nil && !bytes.Equal(d.md5.Sum(nil), d.want.MD5) {
+		return "", fmt.Errorf("content doesn't match the MD5 digest sent: %w", ErrChecksumMismatch)
+	}
+	return hex.EncodeToString(sum), nil
+}
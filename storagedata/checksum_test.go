@@ -0,0 +1,75 @@
+package storagedata_test
+
+import (
+	"americanas/storagedata"
+	"americanas/test"
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestDigestFromHeaders(t *testing.T) {
+	testCase := "TestDigestFromHeaders"
+
+	md5Sum := md5.Sum([]byte("red"))
+	shaSum := sha256.Sum256([]byte("red"))
+	h := http.Header{}
+	h.Set("Content-MD5", base64.StdEncoding.EncodeToString(md5Sum[:]))
+	h.Set("Digest", "unixsum=30637, SHA-256="+base64.StdEncoding.EncodeToString(shaSum[:]))
+
+	digest, err := storagedata.DigestFromHeaders(h)
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, digest.MD5, md5Sum[:])
+	test.AssertEqual(t, testCase, digest.SHA256, shaSum[:])
+
+	digest, err = storagedata.DigestFromHeaders(http.Header{})
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, digest, storagedata.Digest{})
+
+	h = http.Header{}
+	h.Set("Content-MD5", "not base64")
+	_, err = storagedata.DigestFromHeaders(h)
+	test.AssertEqual(t, testCase, errors.Is(err, storagedata.ErrInvalidRequest), true)
+
+	h = http.Header{}
+	h.Set("Digest", "sha-256="+base64.StdEncoding.EncodeToString(md5Sum[:]))
+	_, err = storagedata.DigestFromHeaders(h)
+	test.AssertEqual(t, testCase, errors.Is(err, storagedata.ErrInvalidRequest), true)
+}
+
+func TestStorageFileChecksOfferedDigest(t *testing.T) {
+	testCase := "TestStorageFileChecksOfferedDigest"
+
+	f := setup(t)
+	defer f.close()
+
+	md5Sum := md5.Sum([]byte("red"))
+	shaSum := sha256.Sum256([]byte("red"))
+	req := uploadRequest("space", "mars.png", *bytes.NewBufferString("red"))
+	req.Digest = storagedata.Digest{MD5: md5Sum[:], SHA256: shaSum[:]}
+	status, mars, err := f.sd.StorageFile(req)
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+	test.AssertNoError(t, testCase, err)
+
+	req = uploadRequest("space", "venus.png", *bytes.NewBufferString("yellow"))
+	req.Digest = storagedata.Digest{MD5: md5Sum[:]}
+	status, _, err = f.sd.StorageFile(req)
+	test.AssertEqual(t, testCase, status, http.StatusBadRequest)
+	test.AssertEqual(t, testCase, errors.Is(err, storagedata.ErrChecksumMismatch), true)
+
+	req = uploadRequest("space", "venus.png", *bytes.NewBufferString("yellow"))
+	req.Digest = storagedata.Digest{SHA256: shaSum[:]}
+	status, _, err = f.sd.OverwriteFile(mars.ID, req)
+	test.AssertEqual(t, testCase, status, http.StatusBadRequest)
+	test.AssertEqual(t, testCase, errors.Is(err, storagedata.ErrChecksumMismatch), true)
+
+	// Nothing of the rejected content is kept.
+	test.AssertEqual(t, testCase, blobExists(f, sha256Hex(*bytes.NewBufferString("yellow"))), false)
+	all, _ := f.sd.GetMetadataJSON()
+	test.AssertEqual(t, testCase, len(all), 1)
+	test.AssertEqual(t, testCase, all[mars.ID].Version, 0)
+}
@@ -0,0 +1,152 @@
+package storagedata
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ConflictPolicy decides what happens when a file is stored, moved or copied
+// to a path another file already uses.
+type ConflictPolicy string
+
+const (
+	// ConflictFail rejects the operation with ErrConflict.
+	ConflictFail ConflictPolicy = "fail"
+	// ConflictRename stores the file as "name (1).ext", "name (2).ext" and
+	// so on, whichever is free first.
+	ConflictRename ConflictPolicy = "rename"
+	// ConflictOverwrite deletes the files already at the path.
+	ConflictOverwrite ConflictPolicy = "overwrite"
+	// ConflictKeepBoth stores the file at the path anyway. Both files stay
+	// reachable by ID; a lookup by path finds the newest.
+	ConflictKeepBoth ConflictPolicy = "keep-both"
+)
+
+func (p ConflictPolicy) check() error {
+	switch p {
+	case "", ConflictFail, ConflictRename, ConflictOverwrite, ConflictKeepBoth:
+		return nil
+	}
+	return fmt.Errorf("unknown conflict policy %q: %w", string(p), ErrInvalidRequest)
+}
+
+// or is p, or def when no policy was asked for.
+func (p ConflictPolicy) or(def ConflictPolicy) ConflictPolicy {
+	if p == "" {
+		return def
+	}
+	return p
+}
+
+// resolvePath returns the path a file named name in dir ends up at under
+// policy, and the entries it replaces. id is the file being moved, which
+// never conflicts with itself. Called with s.mu held.
+func (s *StorageData) resolvePath(dir, name, id string, policy ConflictPolicy) (string, []FileMetadata, error) {
+	fullPath := path.Join(dir, name)
+	taken, err := s.takenBy(fullPath, id)
+	if err != nil || len(taken) == 0 || policy == ConflictKeepBoth {
+		return fullPath, nil, err
+	}
+
+	switch policy {
+	case ConflictOverwrite:
+		return fullPath, taken, nil
+	case ConflictRename:
+		for n := 1; len(taken) > 0; n++ {
+			fullPath = path.Join(dir, numbered(name, n))
+			if taken, err = s.takenBy(fullPath, id); err != nil {
+				return "", nil, err
+			}
+		}
+		return fullPath, nil, nil
+	}
+	return "", nil, fmt.Errorf("file %s already exists: %w", fullPath, ErrConflict)
+}
+
+// takenBy returns the entries at p other than id. Called with s.mu held.
+func (s *StorageData) takenBy(p, id string) ([]FileMetadata, error) {
+	entries, err := s.store.ByPath(p)
+	if err != nil {
+		return nil, unavailable(err)
+	}
+
+	taken := entries[:0]
+	for _, entry := range entries {
+		if entry.ID != id {
+			taken = append(taken, entry)
+		}
+	}
+	return taken, nil
+}
+
+// numbered returns name with " (n)" inserted before its extension.
+func numbered(name string, n int) string {
+	ext := path.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	if stem == "" {
+		stem, ext = name, ""
+	}
+	return fmt.Sprintf("%s (%d)%s", stem, n, ext)
+}
+
+// addEntry records a new entry for content already stored as a blob at
+// dir/name, resolving conflicts with policy. An empty id gets a new one. The
+// replaced entries are returned so the caller can release their blobs once
+// it holds no locks. Called with s.mu and the blob lock of entry.SHA256 held.
+func (s *StorageData) addEntry(id, dir, name string, policy ConflictPolicy, entry FileMetadata) (FileMetadata, []FileMetadata, error) {
+	fullPath, replaced, err := s.resolvePath(dir, name, id, policy)
+	if err != nil {
+		return FileMetadata{}, nil, err
+	}
+
+	if id == "" {
+		id, err = s.freeID(entry.SHA256, fullPath)
+		if err != nil {
+			return FileMetadata{}, nil, err
+		}
+	}
+
+	entry.ID = id
+	entry.Name = path.Base(fullPath)
+	entry.Path = fullPath
+	entry.ModTime = time.Now()
+	if err := s.store.Put(id, entry); err != nil {
+		return FileMetadata{}, nil, unavailable(err)
+	}
+
+	if err := s.dropEntries(replaced); err != nil {
+		return FileMetadata{}, nil, err
+	}
+	return entry, replaced, nil
+}
+
+// dropEntries removes replaced entries from the index, along with the
+// content of those stored before blobs existed. Called with s.mu held.
+func (s *StorageData) dropEntries(entries []FileMetadata) error {
+	for _, entry := range entries {
+		if err := s.store.Delete(entry.ID); err != nil {
+			return unavailable(err)
+		}
+		if entry.SHA256 == "" {
+			err := os.Remove(filepath.Join(s.root, entry.Path))
+			if err != nil && !os.IsNotExist(err) {
+				return unavailable(err)
+			}
+		}
+	}
+	return nil
+}
+
+// releaseEntries removes the blobs of dropped entries, versions included,
+// that nothing else references. Called without s.mu or any blob lock held.
+func (s *StorageData) releaseEntries(entries []FileMetadata) {
+	for _, entry := range entries {
+		for _, hash := range entry.hashes() {
+			s.releaseHash(hash)
+		}
+	}
+}
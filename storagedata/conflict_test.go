@@ -0,0 +1,194 @@
+package storagedata_test
+
+import (
+	"americanas/storagedata"
+	"americanas/test"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func upload(f *fixture, dir, name, content string, policy storagedata.ConflictPolicy) (int, storagedata.FileMetadata, error) {
+	req := uploadRequest(dir, name, *bytes.NewBufferString(content))
+	req.Conflict = policy
+	return f.sd.StorageFile(req)
+}
+
+func TestConflictRename(t *testing.T) {
+	testCase := "TestConflictRename"
+
+	f := setup(t)
+	defer f.close()
+
+	var paths []string
+	for _, content := range []string{"first", "second", "third"} {
+		_, entry, err := upload(f, "space", "mars.png", content, "")
+		test.AssertNoError(t, testCase, err)
+		paths = append(paths, entry.Path)
+	}
+	test.AssertEqual(t, testCase, paths, []string{"space/mars.png", "space/mars (1).png", "space/mars (2).png"})
+
+	_, dotfile, _ := upload(f, "space", ".hidden", "first", storagedata.ConflictRename)
+	_, dotfile, _ = upload(f, "space", ".hidden", "second", storagedata.ConflictRename)
+	test.AssertEqual(t, testCase, dotfile.Path, "space/.hidden (1)")
+	test.AssertEqual(t, testCase, dotfile.Name, ".hidden (1)")
+
+	_, venus, _ := upload(f, "space/inner", "venus.png", "venus", "")
+	_, moved, err := f.sd.RenameFile(venus.ID, "space", "mars.png", storagedata.ConflictRename)
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, moved.Path, "space/mars (3).png")
+
+	_, _, mars, _ := f.sd.OpenByPath("space/mars.png")
+	_, copied, err := f.sd.CopyFile(mars.ID, "", "", "", "")
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, copied.Path, "space/mars (4).png")
+}
+
+func TestConflictFail(t *testing.T) {
+	testCase := "TestConflictFail"
+
+	f := setup(t)
+	defer f.close()
+
+	_, mars, _ := upload(f, "space", "mars.png", "mars", "")
+	status, _, err := upload(f, "space", "mars.png", "other", storagedata.ConflictFail)
+	test.AssertEqual(t, testCase, status, http.StatusConflict)
+	test.AssertEqual(t, testCase, errors.Is(err, storagedata.ErrConflict), true)
+
+	_, venus, _ := upload(f, "space/inner", "mars.png", "venus", "")
+	status, _, err = f.sd.RenameFile(venus.ID, "space", "", "")
+	test.AssertEqual(t, testCase, status, http.StatusConflict)
+	test.AssertEqual(t, testCase, errors.Is(err, storagedata.ErrConflict), true)
+	status, err = f.sd.MoveFile(venus.ID, "space")
+	test.AssertEqual(t, testCase, status, http.StatusConflict)
+
+	status, _, err = f.sd.CopyFile(mars.ID, "", "", "", storagedata.ConflictFail)
+	test.AssertEqual(t, testCase, status, http.StatusConflict)
+	test.AssertEqual(t, testCase, errors.Is(err, storagedata.ErrConflict), true)
+
+	_, all, _ := f.sd.AllFiles()
+	test.AssertEqual(t, testCase, len(all), 2)
+}
+
+func TestConflictOverwrite(t *testing.T) {
+	testCase := "TestConflictOverwrite"
+
+	f := setup(t)
+	defer f.close()
+
+	_, old, _ := upload(f, "space", "mars.png", "old mars", "")
+	status, mars, err := upload(f, "space", "mars.png", "new mars", storagedata.ConflictOverwrite)
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+	test.AssertEqual(t, testCase, mars.Path, "space/mars.png")
+
+	status, _, _ = f.sd.ByID(old.ID)
+	test.AssertEqual(t, testCase, status, http.StatusNotFound)
+	_, err = os.Stat(filepath.Join(f.dir, "blobs", old.SHA256[:2], old.SHA256))
+	test.AssertEqual(t, testCase, os.IsNotExist(err), true)
+
+	_, venus, _ := upload(f, "space/inner", "venus.png", "venus", "")
+	_, moved, err := f.sd.RenameFile(venus.ID, "space", "mars.png", storagedata.ConflictOverwrite)
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, moved.Path, "space/mars.png")
+	status, _, _ = f.sd.ByID(mars.ID)
+	test.AssertEqual(t, testCase, status, http.StatusNotFound)
+
+	_, pluto, _ := upload(f, "space/dwarfs", "pluto.png", "pluto", "")
+	_, copied, err := f.sd.CopyFile(pluto.ID, "space", "mars.png", "", storagedata.ConflictOverwrite)
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, copied.Path, "space/mars.png")
+
+	_, file, found, _ := f.sd.OpenByPath("space/mars.png")
+	content, _ := ioutil.ReadAll(file)
+	file.Close()
+	test.AssertEqual(t, testCase, found.ID, copied.ID)
+	test.AssertEqual(t, testCase, string(content), "pluto")
+
+	_, all, _ := f.sd.AllFiles()
+	test.AssertEqual(t, testCase, len(all), 2)
+}
+
+func TestConflictKeepBoth(t *testing.T) {
+	testCase := "TestConflictKeepBoth"
+
+	f := setup(t)
+	defer f.close()
+
+	_, first, _ := upload(f, "space", "mars.png", "first", "")
+	_, second, err := upload(f, "space", "mars.png", "second", storagedata.ConflictKeepBoth)
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, second.Path, "space/mars.png")
+	test.AssertEqual(t, testCase, second.ID != first.ID, true)
+
+	_, found, _ := f.sd.FindByPath("space/mars.png")
+	test.AssertEqual(t, testCase, found.ID, second.ID)
+
+	_, venus, _ := upload(f, "space/inner", "mars.png", "venus", "")
+	_, moved, err := f.sd.RenameFile(venus.ID, "space", "", storagedata.ConflictKeepBoth)
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, moved.Path, "space/mars.png")
+
+	_, copied, err := f.sd.CopyFile(first.ID, "", "", "", storagedata.ConflictKeepBoth)
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, copied.Path, "space/mars.png")
+
+	_, files, _ := f.sd.UnderDir("space")
+	test.AssertEqual(t, testCase, len(files), 4)
+}
+
+func TestConflictKeepBothLegacy(t *testing.T) {
+	testCase := "TestConflictKeepBothLegacy"
+
+	dir, err := ioutil.TempDir("", "storagedata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := storagedata.NewJSONStore(filepath.Join(dir, "metadata.json"))
+	for id, content := range map[string]string{"space/mars.png": "mars", "space/inner/mars.png": "venus"} {
+		os.MkdirAll(filepath.Join(dir, filepath.Dir(id)), os.ModePerm)
+		ioutil.WriteFile(filepath.Join(dir, id), []byte(content), 0644)
+		store.Put(content, storagedata.FileMetadata{Name: "mars.png", Path: id, Size: int64(len(content))})
+	}
+	sd := storagedata.New(storagedata.WithRoot(dir), storagedata.WithMetadataStore(store))
+	defer sd.Close()
+
+	// Both legacy files keep their own content at the shared path.
+	_, moved, err := sd.RenameFile("venus", "space", "", storagedata.ConflictKeepBoth)
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, moved.Path, "space/mars.png")
+
+	for id, expected := range map[string]string{"mars": "mars", "venus": "venus"} {
+		_, file, _, err := sd.OpenByID(id)
+		test.AssertNoError(t, testCase, err)
+		content, _ := ioutil.ReadAll(file)
+		file.Close()
+		test.AssertEqual(t, testCase, string(content), expected)
+	}
+}
+
+func TestConflictUnknownPolicy(t *testing.T) {
+	testCase := "TestConflictUnknownPolicy"
+
+	f := setup(t)
+	defer f.close()
+
+	status, mars, err := upload(f, "space", "mars.png", "mars", "replace")
+	test.AssertEqual(t, testCase, status, http.StatusBadRequest)
+	test.AssertEqual(t, testCase, errors.Is(err, storagedata.ErrInvalidRequest), true)
+
+	_, mars, _ = upload(f, "space", "mars.png", "mars", "")
+	status, _, err = f.sd.RenameFile(mars.ID, "other", "", "replace")
+	test.AssertEqual(t, testCase, status, http.StatusBadRequest)
+	status, _, err = f.sd.CopyFile(mars.ID, "other", "", "", "replace")
+	test.AssertEqual(t, testCase, status, http.StatusBadRequest)
+	status, _, err = f.sd.CreateUpload(4, map[string]string{"path": "space", "filename": "mars.png", "conflict": "replace"})
+	test.AssertEqual(t, testCase, status, http.StatusBadRequest)
+	test.AssertEqual(t, testCase, errors.Is(err, storagedata.ErrInvalidRequest), true)
+}
@@ -0,0 +1,89 @@
+package storagedata
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+)
+
+// CopyFile stores a copy of the file id as toDir/name under a new ID. An
+// empty toDir or name keeps the one of the original. The copy shares the
+// original's blob but, like an upload, belongs to and counts against owner;
+// when its path is taken, policy decides what happens and it is renamed by
+// default.
+func (s *StorageData) CopyFile(id, toDir, name, owner string, policy ConflictPolicy) (int, FileMetadata, error) {
+	toDir, name, err := cleanTarget(toDir, name)
+	if err == nil {
+		err = policy.check()
+	}
+	if err != nil {
+		return http.StatusBadRequest, FileMetadata{}, err
+	}
+
+	source, err := s.getEntry(id)
+	if err != nil {
+		return statusOf(err), FileMetadata{}, err
+	}
+
+	if toDir == "" && path.Dir(source.Path) != "." {
+		toDir = path.Dir(source.Path)
+	}
+	if name == "" {
+		name = source.Name
+	}
+	if err := s.precheckQuotas(owner, toDir, source.Size, ""); err != nil {
+		return statusOf(err), FileMetadata{}, err
+	}
+
+	hash, unlockBlob, err := s.copyBlob(source)
+	if err != nil {
+		return statusOf(err), FileMetadata{}, err
+	}
+
+	s.mu.Lock()
+	// With the blob lock held, the original still existing means its blob
+	// hasn't been released.
+	_, err = s.lookup(id)
+	if err == nil {
+		err = s.checkQuotas(owner, toDir, source.Size, "")
+	}
+	var entry FileMetadata
+	var replaced []FileMetadata
+	if err == nil {
+		entry, replaced, err = s.addEntry("", toDir, name, policy.or(ConflictRename), FileMetadata{
+			ContentType: source.ContentType,
+			Size:        source.Size,
+			SHA256:      hash,
+			Owner:       owner,
+		})
+	}
+	s.mu.Unlock()
+	unlockBlob()
+	if err != nil {
+		return statusOf(err), FileMetadata{}, err
+	}
+
+	s.releaseEntries(replaced)
+	return http.StatusCreated, entry, nil
+}
+
+// copyBlob returns the blob the copy of source refers to, with its lock
+// held. Content stored before blobs existed is turned into a blob first.
+func (s *StorageData) copyBlob(source FileMetadata) (string, func(), error) {
+	if source.SHA256 != "" {
+		return source.SHA256, s.blobs.lock(source.SHA256), nil
+	}
+
+	file, err := os.Open(s.contentPath(source))
+	if os.IsNotExist(err) {
+		return "", nil, fmt.Errorf("content of %s: %w", source.ID, ErrNotFound)
+	}
+	if err != nil {
+		return "", nil, unavailable(err)
+	}
+	defer file.Close()
+
+	hash, _, unlock, err := s.saveBlob(file, Digest{})
+	return hash, unlock, err
+}
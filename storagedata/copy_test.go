@@ -0,0 +1,85 @@
+package storagedata_test
+
+import (
+	"americanas/storagedata"
+	"americanas/test"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCopyFile(t *testing.T) {
+	testCase := "TestCopyFile"
+
+	f := setup(t)
+	defer f.close()
+
+	e := createFile("earth.png")
+	_, earth, _ := f.sd.StorageFile(uploadRequest("space/planets", "earth.png", e))
+
+	status, copied, err := f.sd.CopyFile(earth.ID, "space/backup", "terra.png", "", "")
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, status, http.StatusCreated)
+	test.AssertEqual(t, testCase, copied.ID != earth.ID, true)
+	test.AssertEqual(t, testCase, copied.Path, "space/backup/terra.png")
+	test.AssertEqual(t, testCase, copied.SHA256, earth.SHA256)
+	test.AssertEqual(t, testCase, copied.Size, earth.Size)
+	test.AssertEqual(t, testCase, copied.ContentType, earth.ContentType)
+
+	_, again, err := f.sd.CopyFile(earth.ID, "", "", "", "")
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, filepath.Dir(again.Path), "space/planets")
+	test.AssertEqual(t, testCase, again.Path != earth.Path, true)
+
+	// The blob is shared, so it outlives the original.
+	f.sd.DeleteByID(earth.ID)
+	_, file, _, err := f.sd.OpenByID(copied.ID)
+	test.AssertNoError(t, testCase, err)
+	content, _ := ioutil.ReadAll(file)
+	file.Close()
+	test.AssertEqual(t, testCase, content, e.Bytes())
+
+	status, _, err = f.sd.CopyFile(earth.ID, "space/backup", "", "", "")
+	test.AssertEqual(t, testCase, status, http.StatusNotFound)
+	test.AssertEqual(t, testCase, errors.Is(err, storagedata.ErrNotFound), true)
+
+	status, _, err = f.sd.CopyFile(copied.ID, "../outside", "", "", "")
+	test.AssertEqual(t, testCase, status, http.StatusBadRequest)
+	test.AssertEqual(t, testCase, errors.Is(err, storagedata.ErrInvalidPath), true)
+}
+
+func TestCopyLegacyFile(t *testing.T) {
+	testCase := "TestCopyLegacyFile"
+
+	dir, err := ioutil.TempDir("", "storagedata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	e := createFile("earth.png")
+	os.MkdirAll(filepath.Join(dir, "space/planets"), os.ModePerm)
+	ioutil.WriteFile(filepath.Join(dir, "space/planets/earth.png"), e.Bytes(), 0644)
+
+	store := storagedata.NewJSONStore(filepath.Join(dir, "metadata.json"))
+	store.Put("legacy", storagedata.FileMetadata{
+		Name:        "earth.png",
+		Path:        "space/planets/earth.png",
+		ContentType: "png",
+		Size:        int64(e.Len()),
+		ModTime:     time.Now(),
+	})
+	sd := storagedata.New(storagedata.WithRoot(dir), storagedata.WithMetadataStore(store))
+	defer sd.Close()
+
+	_, copied, err := sd.CopyFile("legacy", "space/backup", "", "", "")
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, copied.SHA256, sha256Hex(e))
+
+	_, err = os.Stat(filepath.Join(dir, "blobs", copied.SHA256[:2], copied.SHA256))
+	test.AssertNoError(t, testCase, err)
+}
@@ -0,0 +1,448 @@
+package storagedata
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Directories are the path prefixes of the stored files. Folders created
+// empty with CreateDir are remembered in directories.json under the root
+// until a move or delete drops them. Their ACLs are in acl.go.
+
+// DirListing is the immediate content of a directory.
+type DirListing struct {
+	Path        string         `json:"path"`
+	Directories []string       `json:"directories"`
+	Files       []FileMetadata `json:"files"`
+}
+
+// ListDir returns the subdirectories and files directly in dir.
+func (s *StorageData) ListDir(dir string) (int, DirListing, error) {
+	dir, err := CleanPath(dir)
+	if err != nil {
+		return http.StatusBadRequest, DirListing{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	all, dirs, err := s.tree()
+	if err != nil {
+		return http.StatusServiceUnavailable, DirListing{}, err
+	}
+	if !dirExists(dir, all, dirs) {
+		return http.StatusNotFound, DirListing{}, fmt.Errorf("directory %s: %w", dir, ErrNotFound)
+	}
+
+	listing := DirListing{Path: dir, Directories: []string{}, Files: []FileMetadata{}}
+	subdirs := make(map[string]bool)
+	for _, entry := range all {
+		rest, ok := under(entry.Path, dir)
+		if !ok {
+			continue
+		}
+		if i := strings.Index(rest, "/"); i >= 0 {
+			subdirs[rest[:i]] = true
+		} else {
+			listing.Files = append(listing.Files, entry)
+		}
+	}
+	for d := range dirs {
+		if rest, ok := under(d, dir); ok {
+			subdirs[strings.SplitN(rest, "/", 2)[0]] = true
+		}
+	}
+
+	for d := range subdirs {
+		listing.Directories = append(listing.Directories, d)
+	}
+	sort.Strings(listing.Directories)
+	sort.Slice(listing.Files, func(i, j int) bool {
+		return listing.Files[i].Name < listing.Files[j].Name
+	})
+	return http.StatusOK, listing, nil
+}
+
+// CreateDir creates the empty folder dir, along with its parents.
+func (s *StorageData) CreateDir(dir string) (int, error) {
+	dir, err := CleanPath(dir)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	if dir == "" {
+		return http.StatusBadRequest, fmt.Errorf("missing directory: %w", ErrInvalidPath)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all, dirs, err := s.tree()
+	if err != nil {
+		return http.StatusServiceUnavailable, err
+	}
+	if dirExists(dir, all, dirs) {
+		return http.StatusConflict, fmt.Errorf("directory %s already exists: %w", dir, ErrConflict)
+	}
+	if file, ok := fileOnPath(dir, all); ok {
+		return http.StatusConflict, fmt.Errorf("file %s is in the way: %w", file, ErrConflict)
+	}
+
+	dirs[dir] = true
+	if err := s.saveDirs(dirs); err != nil {
+		return http.StatusServiceUnavailable, err
+	}
+	return http.StatusCreated, nil
+}
+
+// MoveDir renames the folder dir to toDir. The entries of every file below
+// it are updated together, so readers see either the old or the new tree.
+func (s *StorageData) MoveDir(dir, toDir string) (int, error) {
+	dir, err := CleanPath(dir)
+	if err == nil {
+		toDir, err = CleanPath(toDir)
+	}
+	if err == nil && (dir == "" || toDir == "") {
+		err = fmt.Errorf("the root can't be moved or replaced: %w", ErrInvalidPath)
+	}
+	if err == nil && (toDir == dir || strings.HasPrefix(toDir, dir+"/")) {
+		err = fmt.Errorf("can't move %s into itself: %w", dir, ErrInvalidPath)
+	}
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	if err := s.migrateTree(dir); err != nil {
+		return statusOf(err), err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all, dirs, err := s.tree()
+	if err != nil {
+		return http.StatusServiceUnavailable, err
+	}
+	if !dirExists(dir, all, dirs) {
+		return http.StatusNotFound, fmt.Errorf("directory %s: %w", dir, ErrNotFound)
+	}
+	if dirExists(toDir, all, dirs) {
+		return http.StatusConflict, fmt.Errorf("directory %s already exists: %w", toDir, ErrConflict)
+	}
+	if file, ok := fileOnPath(toDir, all); ok {
+		return http.StatusConflict, fmt.Errorf("file %s is in the way: %w", file, ErrConflict)
+	}
+
+	var moved []FileMetadata
+	for _, entry := range all {
+		rest, ok := under(entry.Path, dir)
+		if !ok {
+			continue
+		}
+		// Legacy content left after migrateTree is missing, so there is
+		// nothing on disk to keep with its entry.
+		if entry.SHA256 == "" && s.legacyPresent(entry) {
+			return http.StatusConflict, fmt.Errorf("file %s was stored meanwhile, try again: %w", entry.Path, ErrConflict)
+		}
+		entry.Path = toDir + "/" + rest
+		moved = append(moved, entry)
+	}
+
+	if err := s.checkMoveQuotas(moved); err != nil {
+		return statusOf(err), err
+	}
+
+	// The folders, ACLs, shares and quotas move first and are put back if
+	// anything fails, so the tree never moves without them.
+	saved, err := s.snapshotFiles(s.dirsPath(), s.aclsPath(), s.sharesPath(), s.quotasPath())
+	if err != nil {
+		return http.StatusServiceUnavailable, err
+	}
+	if err := s.moveDirFiles(dir, toDir, dirs); err != nil {
+		s.restoreFiles(saved)
+		return http.StatusServiceUnavailable, err
+	}
+	if err := s.store.PutAll(moved); err != nil {
+		s.restoreFiles(saved)
+		return http.StatusServiceUnavailable, unavailable(err)
+	}
+	return http.StatusOK, nil
+}
+
+// moveDirFiles moves dir to toDir in the created folders and in the ACLs,
+// shares and quotas. Called with s.mu held.
+func (s *StorageData) moveDirFiles(dir, toDir string, dirs map[string]bool) error {
+	for d := range dirs {
+		if d == dir {
+			delete(dirs, d)
+			dirs[toDir] = true
+		} else if rest, ok := under(d, dir); ok {
+			delete(dirs, d)
+			dirs[toDir+"/"+rest] = true
+		}
+	}
+	if err := s.saveDirs(dirs); err != nil {
+		return err
+	}
+	if err := s.moveACLs(dir, toDir); err != nil {
+		return err
+	}
+	if err := s.moveShares(dir, toDir); err != nil {
+		return err
+	}
+	return s.moveQuotas(dir, toDir)
+}
+
+// snapshotFiles reads the files at paths so restoreFiles can put them back.
+// Missing files are kept as nil. Called with s.mu held.
+func (s *StorageData) snapshotFiles(paths ...string) (map[string][]byte, error) {
+	saved := make(map[string][]byte, len(paths))
+	for _, p := range paths {
+		b, err := ioutil.ReadFile(p)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, unavailable(err)
+		}
+		saved[p] = b
+	}
+	return saved, nil
+}
+
+// restoreFiles puts back the files of a snapshot, removing those that were
+// missing. Called with s.mu held.
+func (s *StorageData) restoreFiles(saved map[string][]byte) {
+	for p, b := range saved {
+		var err error
+		if b == nil {
+			err = os.Remove(p)
+		} else {
+			err = writeFileAtomic(p, b)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			fmt.Printf("[restoreFiles] Error restoring %s. Error: %s", p, err)
+		}
+	}
+}
+
+// DeleteDir deletes dir with every file below it and returns the deleted
+// entries. With dryRun nothing is deleted; the entries that would be are
+// returned instead.
+func (s *StorageData) DeleteDir(dir string, dryRun bool) (int, []FileMetadata, error) {
+	dir, err := CleanPath(dir)
+	if err != nil {
+		return http.StatusBadRequest, nil, err
+	}
+	if dir == "" {
+		return http.StatusBadRequest, nil, fmt.Errorf("the root can't be deleted: %w", ErrInvalidPath)
+	}
+
+	s.mu.RLock()
+	all, dirs, err := s.tree()
+	s.mu.RUnlock()
+	if err != nil {
+		return http.StatusServiceUnavailable, nil, err
+	}
+	if !dirExists(dir, all, dirs) {
+		return http.StatusNotFound, nil, fmt.Errorf("directory %s: %w", dir, ErrNotFound)
+	}
+
+	files := make([]FileMetadata, 0)
+	for _, entry := range all {
+		if _, ok := under(entry.Path, dir); ok {
+			files = append(files, entry)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+	if dryRun {
+		return http.StatusOK, files, nil
+	}
+
+	for _, entry := range files {
+		unlock := s.ids.lock(entry.ID)
+		status, err := s.deleteByID(entry.ID)
+		unlock()
+		if err != nil && status != http.StatusNotFound {
+			return status, nil, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The ACLs, shares and quotas go with the folders, so a folder created
+	// at the same path later doesn't inherit them.
+	saved, err := s.snapshotFiles(s.dirsPath(), s.aclsPath(), s.sharesPath(), s.quotasPath())
+	if err != nil {
+		return http.StatusServiceUnavailable, nil, err
+	}
+	if err := s.deleteDirFiles(dir); err != nil {
+		s.restoreFiles(saved)
+		return http.StatusServiceUnavailable, nil, err
+	}
+	return http.StatusOK, files, nil
+}
+
+// deleteDirFiles removes dir and below from the created folders and the
+// ACLs, shares and quotas. Called with s.mu held.
+func (s *StorageData) deleteDirFiles(dir string) error {
+	dirs, err := s.readDirs()
+	if err != nil {
+		return err
+	}
+	for d := range dirs {
+		if _, ok := under(d, dir); ok || d == dir {
+			delete(dirs, d)
+		}
+	}
+	if err := s.saveDirs(dirs); err != nil {
+		return err
+	}
+	if err := s.dropACLs(dir); err != nil {
+		return err
+	}
+	if err := s.dropShares(dir); err != nil {
+		return err
+	}
+	return s.dropQuotas(dir)
+}
+
+// tree returns every entry and the created folders. Called with s.mu held.
+func (s *StorageData) tree() (map[string]FileMetadata, map[string]bool, error) {
+	all, err := s.store.All()
+	if err != nil {
+		return nil, nil, unavailable(err)
+	}
+	dirs, err := s.readDirs()
+	if err != nil {
+		return nil, nil, err
+	}
+	return all, dirs, nil
+}
+
+func (s *StorageData) dirsPath() string {
+	return filepath.Join(s.root, "directories.json")
+}
+
+// readDirs loads the created folders. Called with s.mu held.
+func (s *StorageData) readDirs() (map[string]bool, error) {
+	dirs := make(map[string]bool)
+
+	b, err := ioutil.ReadFile(s.dirsPath())
+	if os.IsNotExist(err) {
+		return dirs, nil
+	}
+	if err != nil {
+		return nil, unavailable(err)
+	}
+
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return nil, unavailable(err)
+	}
+	for _, d := range list {
+		dirs[d] = true
+	}
+	return dirs, nil
+}
+
+// saveDirs replaces the created folders. Called with s.mu held.
+func (s *StorageData) saveDirs(dirs map[string]bool) error {
+	list := make([]string, 0, len(dirs))
+	for d := range dirs {
+		list = append(list, d)
+	}
+	sort.Strings(list)
+
+	b, err := json.MarshalIndent(list, "", "	")
+	if err != nil {
+		return unavailable(err)
+	}
+	return unavailable(writeFileAtomic(s.dirsPath(), b))
+}
+
+// migrateTree moves the content of every entry below dir stored before blobs
+// existed into the blob store, so the tree can be moved in the index alone.
+// Entries whose legacy content is missing are left as they are. Called
+// without s.mu held.
+func (s *StorageData) migrateTree(dir string) error {
+	s.mu.RLock()
+	all, err := s.store.All()
+	s.mu.RUnlock()
+	if err != nil {
+		return unavailable(err)
+	}
+
+	for id, entry := range all {
+		if _, ok := under(entry.Path, dir); !ok || entry.SHA256 != "" {
+			continue
+		}
+
+		unlock := s.ids.lock(id)
+		entry, err := s.getEntry(id)
+		if err == nil {
+			_, err = s.migrateLegacy(entry)
+		}
+		unlock()
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// legacyPresent reports whether the content of an entry stored before blobs
+// existed is still on disk.
+func (s *StorageData) legacyPresent(entry FileMetadata) bool {
+	_, err := os.Stat(s.contentPath(entry))
+	return !os.IsNotExist(err)
+}
+
+// under reports whether p is below dir and returns the rest of it. Every
+// path is below the root "".
+func under(p, dir string) (string, bool) {
+	if dir == "" {
+		return p, true
+	}
+	if strings.HasPrefix(p, dir+"/") {
+		return p[len(dir)+1:], true
+	}
+	return "", false
+}
+
+func dirExists(dir string, all map[string]FileMetadata, dirs map[string]bool) bool {
+	if dir == "" || dirs[dir] {
+		return true
+	}
+	for d := range dirs {
+		if _, ok := under(d, dir); ok {
+			return true
+		}
+	}
+	for _, entry := range all {
+		if _, ok := under(entry.Path, dir); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// fileOnPath returns the path of a file stored at dir or at one of its
+// parents, which would keep dir from being a directory.
+func fileOnPath(dir string, all map[string]FileMetadata) (string, bool) {
+	for _, entry := range all {
+		if entry.Path == dir {
+			return entry.Path, true
+		}
+		if _, ok := under(dir, entry.Path); ok {
+			return entry.Path, true
+		}
+	}
+	return "", false
+}
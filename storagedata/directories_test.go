@@ -0,0 +1,222 @@
+package storagedata_test
+
+import (
+	"americanas/storagedata"
+	"americanas/test"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUnderDirMatchesWholeSegments(t *testing.T) {
+	testCase := "TestUnderDirMatchesWholeSegments"
+
+	f := listFixture(t)
+	defer f.close()
+
+	_, files, err := f.sd.UnderDir("space/planets")
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, len(files), 4)
+	for _, file := range files {
+		test.AssertEqual(t, testCase, file.Name != "vulcan.png", true)
+	}
+}
+
+func TestListDir(t *testing.T) {
+	testCase := "TestListDir"
+
+	f := listFixture(t)
+	defer f.close()
+
+	status, listing, err := f.sd.ListDir("space")
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+	test.AssertEqual(t, testCase, listing.Directories, []string{"planets", "planetsX"})
+	test.AssertEqual(t, testCase, names(listing.Files), []string{"sun.png"})
+
+	_, listing, _ = f.sd.ListDir("")
+	test.AssertEqual(t, testCase, listing.Directories, []string{"space"})
+	test.AssertEqual(t, testCase, len(listing.Files), 0)
+
+	status, _, err = f.sd.ListDir("space/plan")
+	test.AssertEqual(t, testCase, status, http.StatusNotFound)
+	test.AssertEqual(t, testCase, errors.Is(err, storagedata.ErrNotFound), true)
+}
+
+func TestCreateDir(t *testing.T) {
+	testCase := "TestCreateDir"
+
+	f := listFixture(t)
+	defer f.close()
+
+	status, err := f.sd.CreateDir("space/comets/halley")
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, status, http.StatusCreated)
+
+	_, listing, _ := f.sd.ListDir("space")
+	test.AssertEqual(t, testCase, listing.Directories, []string{"comets", "planets", "planetsX"})
+	status, listing, _ = f.sd.ListDir("space/comets/halley")
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+	test.AssertEqual(t, testCase, len(listing.Directories)+len(listing.Files), 0)
+
+	for _, dir := range []string{"space/comets", "space/planets", "space/sun.png", "space/sun.png/spots"} {
+		status, err = f.sd.CreateDir(dir)
+		test.AssertEqual(t, testCase, status, http.StatusConflict)
+		test.AssertEqual(t, testCase, errors.Is(err, storagedata.ErrConflict), true)
+	}
+
+	// Created folders survive a restart.
+	sd := storagedata.New(storagedata.WithRoot(f.dir))
+	defer sd.Close()
+	status, _, _ = sd.ListDir("space/comets/halley")
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+}
+
+func TestMoveDir(t *testing.T) {
+	testCase := "TestMoveDir"
+
+	f := listFixture(t)
+	defer f.close()
+	f.sd.CreateDir("space/planets/rings")
+
+	_, mars, _ := f.sd.FindByPath("space/planets/mars.png")
+	status, err := f.sd.MoveDir("space/planets", "solarsystem/planets")
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+
+	_, moved, _ := f.sd.ByID(mars.ID)
+	test.AssertEqual(t, testCase, moved.Path, "solarsystem/planets/mars.png")
+	_, files, _ := f.sd.UnderDir("solarsystem/planets")
+	test.AssertEqual(t, testCase, len(files), 4)
+	_, listing, _ := f.sd.ListDir("solarsystem/planets")
+	test.AssertEqual(t, testCase, listing.Directories, []string{"moons", "rings"})
+	_, listing, _ = f.sd.ListDir("space")
+	test.AssertEqual(t, testCase, listing.Directories, []string{"planetsX"})
+
+	_, file, content, err := f.sd.OpenByID(mars.ID)
+	test.AssertNoError(t, testCase, err)
+	file.Close()
+	test.AssertEqual(t, testCase, content.Path, "solarsystem/planets/mars.png")
+
+	cases := []struct {
+		dir, toDir string
+		status     int
+		err        error
+	}{
+		{"space/planets", "elsewhere", http.StatusNotFound, storagedata.ErrNotFound},
+		{"solarsystem", "space", http.StatusConflict, storagedata.ErrConflict},
+		{"solarsystem", "space/sun.png", http.StatusConflict, storagedata.ErrConflict},
+		{"solarsystem", "solarsystem/inner", http.StatusBadRequest, storagedata.ErrInvalidPath},
+		{"solarsystem", "", http.StatusBadRequest, storagedata.ErrInvalidPath},
+	}
+	for _, c := range cases {
+		status, err = f.sd.MoveDir(c.dir, c.toDir)
+		test.AssertEqual(t, testCase, status, c.status)
+		test.AssertEqual(t, testCase, errors.Is(err, c.err), true)
+	}
+}
+
+// failingStore fails every PutAll.
+type failingStore struct {
+	storagedata.MetadataStore
+}
+
+func (failingStore) PutAll(entries []storagedata.FileMetadata) error {
+	return errors.New("disk full")
+}
+
+func TestMoveDirFailureKeepsDirFiles(t *testing.T) {
+	testCase := "TestMoveDirFailureKeepsDirFiles"
+
+	dir, err := ioutil.TempDir("", "storagedata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := failingStore{storagedata.NewJSONStore(filepath.Join(dir, "metadata.json"))}
+	sd := storagedata.New(storagedata.WithRoot(dir), storagedata.WithMetadataStore(store))
+	defer sd.Close()
+
+	sd.StorageFile(uploadRequest("space/planets", "mars.png", *bytes.NewBufferString("mars")))
+	sd.CreateDir("space/planets/rings")
+	sd.SetACL(storagedata.ACL{Path: "space/planets", Grants: map[string][]storagedata.Permission{storagedata.Everyone: {storagedata.PermAdmin}}})
+	sd.SetQuota(storagedata.Quota{Path: "space/planets", MaxFiles: 5})
+
+	status, err := sd.MoveDir("space/planets", "solar")
+	test.AssertEqual(t, testCase, status, http.StatusServiceUnavailable)
+	test.AssertEqual(t, testCase, errors.Is(err, storagedata.ErrStorageUnavailable), true)
+
+	_, listing, _ := sd.ListDir("space/planets")
+	test.AssertEqual(t, testCase, listing.Directories, []string{"rings"})
+	_, acls, _ := sd.ACLs()
+	_, ok := acls["space/planets"]
+	test.AssertEqual(t, testCase, ok, true)
+	_, quotas, _ := sd.Quotas()
+	test.AssertEqual(t, testCase, quotas[0].Path, "space/planets")
+}
+
+func TestDeleteDir(t *testing.T) {
+	testCase := "TestDeleteDir"
+
+	f := listFixture(t)
+	defer f.close()
+	f.sd.CreateDir("space/planets/rings")
+
+	status, files, err := f.sd.DeleteDir("space/planets", true)
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+	test.AssertEqual(t, testCase, names(files), []string{"earth.jpg", "mars.png", "phobos.png", "notes.txt"})
+	_, all, _ := f.sd.AllFiles()
+	test.AssertEqual(t, testCase, len(all), 6)
+
+	_, files, err = f.sd.DeleteDir("space/planets", false)
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, len(files), 4)
+	_, all, _ = f.sd.AllFiles()
+	test.AssertEqual(t, testCase, len(all), 2)
+
+	status, _, _ = f.sd.ListDir("space/planets")
+	test.AssertEqual(t, testCase, status, http.StatusNotFound)
+
+	status, _, err = f.sd.DeleteDir("", false)
+	test.AssertEqual(t, testCase, status, http.StatusBadRequest)
+	test.AssertEqual(t, testCase, errors.Is(err, storagedata.ErrInvalidPath), true)
+}
+
+func TestDeleteDirDropsDirFiles(t *testing.T) {
+	testCase := "TestDeleteDirDropsDirFiles"
+
+	f := listFixture(t)
+	defer f.close()
+	grants := map[string][]storagedata.Permission{storagedata.Everyone: {storagedata.PermRead}}
+	for _, dir := range []string{"space/planets", "space/planets/moons", "space/planetsX"} {
+		f.sd.SetACL(storagedata.ACL{Path: dir, Grants: grants})
+		f.sd.SetQuota(storagedata.Quota{Path: dir, MaxFiles: 5})
+		f.sd.CreateShare(storagedata.ShareRequest{Dir: dir})
+	}
+
+	_, _, err := f.sd.DeleteDir("space/planets", false)
+	test.AssertNoError(t, testCase, err)
+
+	_, acls, _ := f.sd.ACLs()
+	test.AssertEqual(t, testCase, len(acls), 1)
+	_, ok := acls["space/planetsX"]
+	test.AssertEqual(t, testCase, ok, true)
+	_, quotas, _ := f.sd.Quotas()
+	test.AssertEqual(t, testCase, len(quotas), 1)
+	test.AssertEqual(t, testCase, quotas[0].Path, "space/planetsX")
+	_, shares, _ := f.sd.Shares()
+	test.AssertEqual(t, testCase, len(shares), 1)
+	test.AssertEqual(t, testCase, shares[0].Dir, "space/planetsX")
+
+	// A folder created again starts without them.
+	f.sd.CreateDir("space/planets")
+	_, acls, _ = f.sd.ACLs()
+	_, ok = acls["space/planets"]
+	test.AssertEqual(t, testCase, ok, false)
+}
@@ -0,0 +1,56 @@
+package storagedata
+
+import "errors"
+
+// Every error returned by StorageData matches one of these with errors.Is, so
+// callers can tell what went wrong without parsing messages.
+var (
+	ErrNotFound           = errors.New("not found")
+	ErrConflict           = errors.New("conflict")
+	ErrInvalidPath        = errors.New("invalid path")
+	ErrInvalidRequest     = errors.New("invalid request")
+	ErrTooLarge           = errors.New("too large")
+	ErrChecksumMismatch   = errors.New("checksum mismatch")
+	ErrForbidden          = errors.New("forbidden")
+	ErrQuotaExceeded      = errors.New("quota exceeded")
+	ErrStorageUnavailable = errors.New("storage unavailable")
+)
+
+var errorKinds = []error{ErrNotFound, ErrConflict, ErrInvalidPath, ErrInvalidRequest, ErrTooLarge, ErrChecksumMismatch, ErrForbidden, ErrQuotaExceeded, ErrStorageUnavailable}
+
+// Error classifies an underlying error, e.g. a failing disk, as one of the
+// sentinel errors while keeping it available to errors.Unwrap.
+type Error struct {
+	Kind error
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Kind.Error() + ": " + e.Err.Error()
+}
+
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// WrapError classifies err as kind unless it already matches one of the
+// sentinel errors.
+func WrapError(kind, err error) error {
+	if err == nil {
+		return nil
+	}
+	for _, k := range errorKinds {
+		if errors.Is(err, k) {
+			return err
+		}
+	}
+	return &Error{Kind: kind, Err: err}
+}
+
+func unavailable(err error) error {
+	return WrapError(ErrStorageUnavailable, err)
+}
@@ -0,0 +1,137 @@
+package storagedata
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+)
+
+const (
+	journalPut    = "put"
+	journalDelete = "delete"
+	journalPutAll = "putAll"
+)
+
+type journalRecord struct {
+	Op    string        `json:"op"`
+	ID    string        `json:"id"`
+	Entry *FileMetadata `json:"entry,omitempty"`
+
+	// Entries of a putAll record, committed together as one line.
+	Entries []FileMetadata `json:"entries,omitempty"`
+}
+
+func (r journalRecord) applyTo(entries map[string]FileMetadata) {
+	switch r.Op {
+	case journalPut:
+		if r.Entry != nil {
+			entries[r.ID] = *r.Entry
+		}
+	case journalDelete:
+		delete(entries, r.ID)
+	case journalPutAll:
+		for _, entry := range r.Entries {
+			entries[entry.ID] = entry
+		}
+	}
+}
+
+// ids returns the IDs of the entries the record changes.
+func (r journalRecord) ids() []string {
+	if r.Op != journalPutAll {
+		return []string{r.ID}
+	}
+	ids := make([]string, 0, len(r.Entries))
+	for _, entry := range r.Entries {
+		ids = append(ids, entry.ID)
+	}
+	return ids
+}
+
+// journal is an append-only log of metadata changes, one JSON record per
+// line. A record is committed once its line, newline included, has been
+// synced to disk.
+type journal struct {
+	file    *os.File
+	records int
+}
+
+// openJournal opens (or creates) the journal at path and returns the records
+// committed in it. A torn last line left by a crash is dropped.
+func openJournal(path string) (*journal, []journalRecord, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var records []journalRecord
+	var committed int64
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+
+		var record journalRecord
+		if err := json.Unmarshal(bytes.TrimSpace(line), &record); err != nil {
+			break
+		}
+		records = append(records, record)
+		committed += int64(len(line))
+	}
+
+	if err := file.Truncate(committed); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	if _, err := file.Seek(committed, io.SeekStart); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	return &journal{file: file, records: len(records)}, records, nil
+}
+
+func (j *journal) append(record journalRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if _, err := j.file.Write(line); err != nil {
+		return err
+	}
+	if err := j.file.Sync(); err != nil {
+		return err
+	}
+
+	j.records++
+	return nil
+}
+
+func (j *journal) truncate() error {
+	if err := j.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := j.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := j.file.Sync(); err != nil {
+		return err
+	}
+
+	j.records = 0
+	return nil
+}
+
+func (j *journal) close() error {
+	return j.file.Close()
+}
@@ -0,0 +1,242 @@
+package storagedata
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultListLimit = 100
+	MaxListLimit     = 1000
+)
+
+// Sort orders accepted by ListOptions.Sort.
+const (
+	SortByName    = "name"
+	SortBySize    = "size"
+	SortByModTime = "modificationTime"
+)
+
+// ListOptions selects and orders the entries returned by List. Zero values
+// mean no filter: the whole tree, sorted by name, DefaultListLimit at a time.
+type ListOptions struct {
+	// Dir restricts the listing to the files directly in Dir, or anywhere
+	// below it when Recursive is set.
+	Dir       string
+	Recursive bool
+
+	Sort string
+	Desc bool
+
+	// Cursor is the NextCursor of the previous page.
+	Cursor string
+	Limit  int
+
+	// ContentType matches the stored type exactly, or every type of a family
+	// when it ends in "/*" as in "image/*".
+	ContentType    string
+	MinSize        int64
+	MaxSize        int64
+	ModifiedAfter  time.Time
+	ModifiedBefore time.Time
+
+	// Visible, when set, hides the entries it returns false for, such as
+	// those the caller may not read, before the page is cut.
+	Visible func(FileMetadata) bool
+}
+
+// ListResult is one page of a listing. NextCursor is empty on the last page.
+type ListResult struct {
+	Files      []FileMetadata `json:"files"`
+	NextCursor string         `json:"nextCursor,omitempty"`
+}
+
+// listCursor is the position of the last entry of a page. It holds the sort
+// key itself rather than an offset, so pages stay consistent while files are
+// added or removed.
+type listCursor struct {
+	Sort    string    `json:"s"`
+	Desc    bool      `json:"d,omitempty"`
+	Name    string    `json:"n,omitempty"`
+	Size    int64     `json:"z,omitempty"`
+	ModTime time.Time `json:"t"`
+	Path    string    `json:"p"`
+	ID      string    `json:"i"`
+}
+
+// List returns the page of entries selected by opts.
+func (s *StorageData) List(opts ListOptions) (int, ListResult, error) {
+	opts, err := checkListOptions(opts)
+	if err != nil {
+		return http.StatusBadRequest, ListResult{}, err
+	}
+
+	var after *FileMetadata
+	if opts.Cursor != "" {
+		after, err = decodeCursor(opts)
+		if err != nil {
+			return http.StatusBadRequest, ListResult{}, err
+		}
+	}
+
+	all, err := s.GetMetadataJSON()
+	if err != nil {
+		return http.StatusServiceUnavailable, ListResult{}, err
+	}
+
+	less := entryLess(opts.Sort, opts.Desc)
+	files := make([]FileMetadata, 0)
+	for _, entry := range all {
+		if opts.matches(entry) && (after == nil || less(*after, entry)) {
+			files = append(files, entry)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return less(files[i], files[j])
+	})
+
+	result := ListResult{Files: files}
+	if len(files) > opts.Limit {
+		result.Files = files[:opts.Limit]
+		result.NextCursor = encodeCursor(opts, result.Files[opts.Limit-1])
+	}
+	return http.StatusOK, result, nil
+}
+
+func checkListOptions(opts ListOptions) (ListOptions, error) {
+	var err error
+	opts.Dir, err = CleanPath(opts.Dir)
+	if err != nil {
+		return opts, err
+	}
+
+	switch opts.Sort {
+	case "":
+		opts.Sort = SortByName
+	case SortByName, SortBySize, SortByModTime:
+	default:
+		return opts, fmt.Errorf("unknown sort %q: %w", opts.Sort, ErrInvalidRequest)
+	}
+
+	switch {
+	case opts.Limit < 0:
+		return opts, fmt.Errorf("negative limit %d: %w", opts.Limit, ErrInvalidRequest)
+	case opts.Limit == 0:
+		opts.Limit = DefaultListLimit
+	case opts.Limit > MaxListLimit:
+		opts.Limit = MaxListLimit
+	}
+
+	if opts.MinSize < 0 || opts.MaxSize < 0 || (opts.MaxSize > 0 && opts.MaxSize < opts.MinSize) {
+		return opts, fmt.Errorf("invalid size range %d-%d: %w", opts.MinSize, opts.MaxSize, ErrInvalidRequest)
+	}
+	return opts, nil
+}
+
+func (opts ListOptions) matches(entry FileMetadata) bool {
+	if !opts.inDir(entry.Path) {
+		return false
+	}
+
+	if opts.ContentType != "" {
+		if family := strings.TrimSuffix(opts.ContentType, "*"); family != opts.ContentType {
+			if !strings.HasPrefix(strings.ToLower(entry.ContentType), strings.ToLower(family)) {
+				return false
+			}
+		} else if !strings.EqualFold(entry.ContentType, opts.ContentType) {
+			return false
+		}
+	}
+
+	if entry.Size < opts.MinSize || (opts.MaxSize > 0 && entry.Size > opts.MaxSize) {
+		return false
+	}
+	if !opts.ModifiedAfter.IsZero() && !entry.ModTime.After(opts.ModifiedAfter) {
+		return false
+	}
+	if !opts.ModifiedBefore.IsZero() && !entry.ModTime.Before(opts.ModifiedBefore) {
+		return false
+	}
+	return opts.Visible == nil || opts.Visible(entry)
+}
+
+func (opts ListOptions) inDir(p string) bool {
+	if opts.Recursive {
+		return opts.Dir == "" || strings.HasPrefix(p, opts.Dir+"/")
+	}
+
+	dir := path.Dir(p)
+	if dir == "." {
+		dir = ""
+	}
+	return dir == opts.Dir
+}
+
+// entryLess orders entries by the sort key, then by path and ID so the order
+// is total and a cursor always points between two entries.
+func entryLess(by string, desc bool) func(a, b FileMetadata) bool {
+	return func(a, b FileMetadata) bool {
+		if desc {
+			a, b = b, a
+		}
+
+		switch by {
+		case SortBySize:
+			if a.Size != b.Size {
+				return a.Size < b.Size
+			}
+		case SortByModTime:
+			if !a.ModTime.Equal(b.ModTime) {
+				return a.ModTime.Before(b.ModTime)
+			}
+		default:
+			if a.Name != b.Name {
+				return a.Name < b.Name
+			}
+		}
+
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		return a.ID < b.ID
+	}
+}
+
+func encodeCursor(opts ListOptions, last FileMetadata) string {
+	c := listCursor{Sort: opts.Sort, Desc: opts.Desc, Path: last.Path, ID: last.ID}
+	switch opts.Sort {
+	case SortBySize:
+		c.Size = last.Size
+	case SortByModTime:
+		c.ModTime = last.ModTime
+	default:
+		c.Name = last.Name
+	}
+
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor returns the entry a page must start after. A cursor only
+// makes sense for the order it was issued for.
+func decodeCursor(opts ListOptions) (*FileMetadata, error) {
+	var c listCursor
+	b, err := base64.RawURLEncoding.DecodeString(opts.Cursor)
+	if err == nil {
+		err = json.Unmarshal(b, &c)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("malformed cursor: %w", ErrInvalidRequest)
+	}
+	if c.Sort != opts.Sort || c.Desc != opts.Desc {
+		return nil, fmt.Errorf("cursor was issued for another sort order: %w", ErrInvalidRequest)
+	}
+
+	return &FileMetadata{ID: c.ID, Name: c.Name, Path: c.Path, Size: c.Size, ModTime: c.ModTime}, nil
+}
@@ -0,0 +1,110 @@
+package storagedata_test
+
+import (
+	"americanas/storagedata"
+	"americanas/test"
+	"bytes"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func listFixture(t *testing.T) *fixture {
+	f := setup(t)
+	files := []struct {
+		dir, name, contentType string
+		size                   int
+	}{
+		{"space", "sun.png", "image/png", 30},
+		{"space/planets", "mars.png", "image/png", 10},
+		{"space/planets", "earth.jpg", "image/jpeg", 20},
+		{"space/planets", "notes.txt", "text/plain", 5},
+		{"space/planets/moons", "phobos.png", "image/png", 40},
+		{"space/planetsX", "vulcan.png", "image/png", 50},
+	}
+	for _, file := range files {
+		req := uploadRequest(file.dir, file.name, *bytes.NewBufferString(strings.Repeat("x", file.size)))
+		req.ContentType = file.contentType
+		if _, _, err := f.sd.StorageFile(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return f
+}
+
+func names(files []storagedata.FileMetadata) []string {
+	var names []string
+	for _, file := range files {
+		names = append(names, file.Name)
+	}
+	return names
+}
+
+func TestList(t *testing.T) {
+	testCase := "TestList"
+
+	f := listFixture(t)
+	defer f.close()
+
+	status, result, err := f.sd.List(storagedata.ListOptions{Dir: "space/planets"})
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+	test.AssertEqual(t, testCase, names(result.Files), []string{"earth.jpg", "mars.png", "notes.txt"})
+	test.AssertEqual(t, testCase, result.NextCursor, "")
+
+	_, result, _ = f.sd.List(storagedata.ListOptions{Dir: "space/planets", Recursive: true, Sort: storagedata.SortBySize, Desc: true})
+	test.AssertEqual(t, testCase, names(result.Files), []string{"phobos.png", "earth.jpg", "mars.png", "notes.txt"})
+
+	_, result, _ = f.sd.List(storagedata.ListOptions{Recursive: true, ContentType: "image/*", MinSize: 15, MaxSize: 40, Sort: storagedata.SortBySize})
+	test.AssertEqual(t, testCase, names(result.Files), []string{"earth.jpg", "sun.png", "phobos.png"})
+
+	_, result, _ = f.sd.List(storagedata.ListOptions{Recursive: true, ContentType: "text/plain"})
+	test.AssertEqual(t, testCase, names(result.Files), []string{"notes.txt"})
+
+	_, result, _ = f.sd.List(storagedata.ListOptions{Recursive: true, ModifiedBefore: time.Now().Add(-time.Hour)})
+	test.AssertEqual(t, testCase, len(result.Files), 0)
+
+	_, result, _ = f.sd.List(storagedata.ListOptions{Recursive: true, ModifiedAfter: time.Now().Add(-time.Hour)})
+	test.AssertEqual(t, testCase, len(result.Files), 6)
+}
+
+func TestListPagination(t *testing.T) {
+	testCase := "TestListPagination"
+
+	f := listFixture(t)
+	defer f.close()
+
+	opts := storagedata.ListOptions{Recursive: true, Sort: storagedata.SortBySize, Limit: 4}
+	_, page, err := f.sd.List(opts)
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, names(page.Files), []string{"notes.txt", "mars.png", "earth.jpg", "sun.png"})
+
+	// Files removed or added before the cursor don't shift the next page.
+	_, sun, _ := f.sd.FindByPath("space/sun.png")
+	f.sd.DeleteByID(sun.ID)
+	f.sd.StorageFile(uploadRequest("space", "dust.png", *bytes.NewBufferString("x")))
+
+	opts.Cursor = page.NextCursor
+	_, page, err = f.sd.List(opts)
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, names(page.Files), []string{"phobos.png", "vulcan.png"})
+	test.AssertEqual(t, testCase, page.NextCursor, "")
+
+	opts.Desc = true
+	status, _, err := f.sd.List(opts)
+	test.AssertEqual(t, testCase, status, http.StatusBadRequest)
+	test.AssertEqual(t, testCase, errors.Is(err, storagedata.ErrInvalidRequest), true)
+
+	for _, opts := range []storagedata.ListOptions{
+		{Cursor: "not a cursor"},
+		{Sort: "color"},
+		{Limit: -1},
+		{MinSize: 10, MaxSize: 5},
+	} {
+		status, _, err = f.sd.List(opts)
+		test.AssertEqual(t, testCase, status, http.StatusBadRequest)
+		test.AssertEqual(t, testCase, errors.Is(err, storagedata.ErrInvalidRequest), true)
+	}
+}
@@ -0,0 +1,42 @@
+package storagedata
+
+import "sync"
+
+// idLocker hands out one mutex per file ID, created on demand and dropped
+// once nobody holds or waits for it.
+type idLocker struct {
+	mu    sync.Mutex
+	locks map[string]*idLock
+}
+
+type idLock struct {
+	sync.Mutex
+	refs int
+}
+
+func (l *idLocker) lock(id string) func() {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*idLock)
+	}
+	lk, ok := l.locks[id]
+	if !ok {
+		lk = &idLock{}
+		l.locks[id] = lk
+	}
+	lk.refs++
+	l.mu.Unlock()
+
+	lk.Lock()
+
+	return func() {
+		lk.Unlock()
+
+		l.mu.Lock()
+		lk.refs--
+		if lk.refs == 0 {
+			delete(l.locks, id)
+		}
+		l.mu.Unlock()
+	}
+}
@@ -0,0 +1,81 @@
+package storagedata
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// FileMetadata is one entry of the metadata index.
+type FileMetadata struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Path        string    `json:"path"`
+	ContentType string    `json:"type"`
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"modificationTime"`
+	SHA256      string    `json:"sha256,omitempty"`
+	Owner       string    `json:"owner,omitempty"`
+
+	// Version numbers the contents the file has had, starting at 1, and
+	// Versions keeps the previous ones, oldest first.
+	Version  int           `json:"version,omitempty"`
+	Versions []FileVersion `json:"versions,omitempty"`
+}
+
+// UploadRequest describes a file to store: Content is streamed to disk and
+// saved as Name inside the Path directory. When another file is already
+// there, Conflict decides what happens; by default the new one is renamed.
+// Sums set in Digest are checked against the content. Owner is recorded as
+// the owner of a new file.
+type UploadRequest struct {
+	Path        string
+	Name        string
+	ContentType string
+	Content     io.Reader
+	Conflict    ConflictPolicy
+	Digest      Digest
+	Owner       string
+	// Size is the length of Content when known in advance, so quotas can
+	// refuse it before anything is written.
+	Size int64
+}
+
+// legacyModTimeLayout is how modificationTime was written before it became a
+// time.Time; entries in that format are still read.
+const legacyModTimeLayout = "01/02/2006 15:04:05"
+
+func (m *FileMetadata) UnmarshalJSON(data []byte) error {
+	type plain FileMetadata
+	aux := struct {
+		*plain
+		ModTime string `json:"modificationTime"`
+	}{
+		plain: (*plain)(m),
+	}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	modTime, err := parseModTime(aux.ModTime)
+	if err != nil {
+		return err
+	}
+	m.ModTime = modTime
+	return nil
+}
+
+func parseModTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation(legacyModTimeLayout, value, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid modificationTime %q", value)
+}
@@ -0,0 +1,317 @@
+package storagedata
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// MetadataStore keeps the metadata index, one entry per file ID.
+type MetadataStore interface {
+	Get(id string) (FileMetadata, bool, error)
+	Put(id string, entry FileMetadata) error
+	// PutAll stores every entry, keyed by its ID, or none of them.
+	PutAll(entries []FileMetadata) error
+	Delete(id string) error
+	// ByPath returns the entries stored at path without going through
+	// every entry.
+	ByPath(path string) ([]FileMetadata, error)
+	// Refs counts the references the entries hold to the blob hash, their
+	// versions included.
+	Refs(hash string) (int, error)
+	All() (map[string]FileMetadata, error)
+	Close() error
+}
+
+// snapshotEvery is how many journal records the JSONStore accumulates
+// before folding them into a new metadata.json snapshot.
+const snapshotEvery = 100
+
+// JSONStore keeps the whole index in a single metadata.json file. Every
+// change is first committed to an append-only journal next to it and the
+// snapshot is only rewritten, atomically, every snapshotEvery changes.
+// A JSONStore must be the only writer of its files.
+type JSONStore struct {
+	path    string
+	mu      sync.Mutex
+	loaded  bool
+	entries map[string]FileMetadata
+	// paths indexes the IDs of entries by path, refs counts the
+	// references to each blob.
+	paths   map[string]map[string]bool
+	refs    map[string]int
+	journal *journal
+}
+
+func (j *JSONStore) Get(id string) (FileMetadata, bool, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := j.load(); err != nil {
+		return FileMetadata{}, false, err
+	}
+
+	entry, ok := j.entries[id]
+	return entry, ok, nil
+}
+
+func (j *JSONStore) Put(id string, entry FileMetadata) error {
+	entry.ID = id
+	return j.apply(journalRecord{Op: journalPut, ID: id, Entry: &entry})
+}
+
+func (j *JSONStore) PutAll(entries []FileMetadata) error {
+	return j.apply(journalRecord{Op: journalPutAll, Entries: entries})
+}
+
+func (j *JSONStore) Delete(id string) error {
+	return j.apply(journalRecord{Op: journalDelete, ID: id})
+}
+
+func (j *JSONStore) ByPath(path string) ([]FileMetadata, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := j.load(); err != nil {
+		return nil, err
+	}
+
+	entries := make([]FileMetadata, 0, len(j.paths[path]))
+	for id := range j.paths[path] {
+		entries = append(entries, j.entries[id])
+	}
+	return entries, nil
+}
+
+func (j *JSONStore) Refs(hash string) (int, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := j.load(); err != nil {
+		return 0, err
+	}
+
+	return j.refs[hash], nil
+}
+
+func (j *JSONStore) All() (map[string]FileMetadata, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := j.load(); err != nil {
+		return nil, err
+	}
+
+	all := make(map[string]FileMetadata, len(j.entries))
+	for k, v := range j.entries {
+		all[k] = v
+	}
+	return all, nil
+}
+
+func (j *JSONStore) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if !j.loaded {
+		return nil
+	}
+
+	err := j.snapshot()
+	if closeErr := j.journal.close(); err == nil {
+		err = closeErr
+	}
+	j.loaded = false
+	return err
+}
+
+func (j *JSONStore) apply(record journalRecord) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := j.load(); err != nil {
+		return err
+	}
+
+	if err := j.journal.append(record); err != nil {
+		return err
+	}
+	ids := record.ids()
+	for _, id := range ids {
+		j.unindex(id)
+	}
+	record.applyTo(j.entries)
+	for _, id := range ids {
+		j.index(id)
+	}
+
+	if j.journal.records >= snapshotEvery {
+		return j.snapshot()
+	}
+	return nil
+}
+
+// load reads the last snapshot and replays every journal record committed
+// after it. Called with j.mu held.
+func (j *JSONStore) load() error {
+	if j.loaded {
+		return nil
+	}
+
+	entries, err := readSnapshot(j.path)
+	if err != nil {
+		return err
+	}
+
+	jr, records, err := openJournal(j.path + ".journal")
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		record.applyTo(entries)
+	}
+
+	j.entries = entries
+	j.paths = make(map[string]map[string]bool)
+	j.refs = make(map[string]int)
+	for id := range entries {
+		j.index(id)
+	}
+	j.journal = jr
+	j.loaded = true
+
+	if jr.records > 0 {
+		return j.snapshot()
+	}
+	return nil
+}
+
+// index adds the entry of id, if any, to the path index and counts its
+// references. Called with j.mu held.
+func (j *JSONStore) index(id string) {
+	entry, ok := j.entries[id]
+	if !ok {
+		return
+	}
+	if j.paths[entry.Path] == nil {
+		j.paths[entry.Path] = make(map[string]bool)
+	}
+	j.paths[entry.Path][id] = true
+	for _, hash := range entry.hashes() {
+		j.refs[hash]++
+	}
+}
+
+// unindex removes the entry of id, if any, from the path index and drops its
+// references. Called with j.mu held.
+func (j *JSONStore) unindex(id string) {
+	entry, ok := j.entries[id]
+	if !ok {
+		return
+	}
+	delete(j.paths[entry.Path], id)
+	if len(j.paths[entry.Path]) == 0 {
+		delete(j.paths, entry.Path)
+	}
+	for _, hash := range entry.hashes() {
+		if j.refs[hash]--; j.refs[hash] <= 0 {
+			delete(j.refs, hash)
+		}
+	}
+}
+
+// snapshot atomically replaces metadata.json with the in-memory index and
+// then empties the journal. Called with j.mu held.
+func (j *JSONStore) snapshot() error {
+	mapMetadataIdent, err := json.MarshalIndent(j.entries, "", "	")
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(j.path, mapMetadataIdent); err != nil {
+		return err
+	}
+
+	return j.journal.truncate()
+}
+
+func readSnapshot(path string) (map[string]FileMetadata, error) {
+	fileMetadata, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return make(map[string]FileMetadata), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	mapFileMetadata := make(map[string]FileMetadata)
+	if len(bytes.TrimSpace(fileMetadata)) == 0 {
+		return mapFileMetadata, nil
+	}
+
+	err = json.Unmarshal(fileMetadata, &mapFileMetadata)
+	if err != nil {
+		return nil, err
+	}
+
+	// Entries written before FileMetadata carried its ID only have it as key.
+	for id, entry := range mapFileMetadata {
+		entry.ID = id
+		mapFileMetadata[id] = entry
+	}
+	return mapFileMetadata, nil
+}
+
+// writeFileAtomic writes data to a temporary file in the same directory,
+// syncs it and renames it over path, so readers see either the old or the
+// new content and never a partial write.
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	return syncDir(dir)
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+func NewJSONStore(path string) *JSONStore {
+	return &JSONStore{
+		path: path,
+	}
+}
@@ -0,0 +1,413 @@
+package storagedata
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Quotas cap the bytes and the number of files an owner, or a directory with
+// everything below it, may hold. They are kept in quotas.json under the root,
+// move along with their directory in MoveDir, and are checked before a file
+// is stored, overwritten, copied, moved or restored. Usage is computed from
+// the metadata of every file with its older versions, the trash included:
+// all of it takes room on disk until pruned or purged.
+
+// Quota limits either the files of Owner or those below Path. A zero limit
+// doesn't restrict.
+type Quota struct {
+	Owner    string `json:"owner,omitempty"`
+	Path     string `json:"path,omitempty"`
+	MaxBytes int64  `json:"maxBytes,omitempty"`
+	MaxFiles int    `json:"maxFiles,omitempty"`
+}
+
+// Usage is what a set of files takes.
+type Usage struct {
+	Bytes int64 `json:"bytes"`
+	Files int   `json:"files"`
+}
+
+// QuotaUsage is a quota with what is used of it. What remains is left out for
+// the limits the quota doesn't set.
+type QuotaUsage struct {
+	Quota
+	Used           Usage  `json:"used"`
+	RemainingBytes *int64 `json:"remainingBytes,omitempty"`
+	RemainingFiles *int   `json:"remainingFiles,omitempty"`
+}
+
+// QuotaError reports a write a quota has no room for. It matches ErrTooLarge
+// when the file alone is larger than the quota, and ErrQuotaExceeded
+// otherwise.
+type QuotaError struct {
+	Quota QuotaUsage
+	Size  int64
+}
+
+func (e *QuotaError) Error() string {
+	var remaining []string
+	if e.Quota.RemainingBytes != nil {
+		remaining = append(remaining, fmt.Sprintf("%d of %d bytes", *e.Quota.RemainingBytes, e.Quota.MaxBytes))
+	}
+	if e.Quota.RemainingFiles != nil {
+		remaining = append(remaining, fmt.Sprintf("%d of %d files", *e.Quota.RemainingFiles, e.Quota.MaxFiles))
+	}
+	return fmt.Sprintf("%s: no room for %d bytes in the quota of %s, %s left",
+		e.kind(), e.Size, e.Quota.subject(), strings.Join(remaining, " and "))
+}
+
+func (e *QuotaError) Is(target error) bool {
+	return target == e.kind()
+}
+
+func (e *QuotaError) kind() error {
+	if e.Quota.MaxBytes > 0 && e.Size > e.Quota.MaxBytes {
+		return ErrTooLarge
+	}
+	return ErrQuotaExceeded
+}
+
+// Quotas returns every quota with its usage, owners first.
+func (s *StorageData) Quotas() (int, []QuotaUsage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	quotas, err := s.readQuotas()
+	if err != nil {
+		return http.StatusServiceUnavailable, nil, err
+	}
+	held, err := s.heldFiles()
+	if err != nil {
+		return http.StatusServiceUnavailable, nil, err
+	}
+
+	list := make([]QuotaUsage, 0, len(quotas))
+	for _, q := range quotas {
+		list = append(list, q.usage(held))
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].key() < list[j].key()
+	})
+	return http.StatusOK, list, nil
+}
+
+// SetQuota replaces the quota of q.Owner or q.Path. A quota without limits is
+// removed.
+func (s *StorageData) SetQuota(q Quota) (int, Quota, error) {
+	q, err := checkQuota(q)
+	if err != nil {
+		return http.StatusBadRequest, Quota{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	quotas, err := s.readQuotas()
+	if err != nil {
+		return http.StatusServiceUnavailable, Quota{}, err
+	}
+	if q.MaxBytes == 0 && q.MaxFiles == 0 {
+		delete(quotas, q.key())
+	} else {
+		quotas[q.key()] = q
+	}
+	if err := s.saveQuotas(quotas); err != nil {
+		return http.StatusServiceUnavailable, Quota{}, err
+	}
+	return http.StatusOK, q, nil
+}
+
+// Usage returns what the files of owner take, or every file for an empty
+// owner.
+func (s *StorageData) Usage(owner string) (int, Usage, error) {
+	s.mu.RLock()
+	held, err := s.heldFiles()
+	s.mu.RUnlock()
+	if err != nil {
+		return http.StatusServiceUnavailable, Usage{}, err
+	}
+
+	var used Usage
+	for _, entry := range held {
+		if owner == "" || entry.Owner == owner {
+			used.add(entry)
+		}
+	}
+	return http.StatusOK, used, nil
+}
+
+func checkQuota(q Quota) (Quota, error) {
+	var err error
+	q.Path, err = CleanPath(q.Path)
+	if err != nil {
+		return q, err
+	}
+
+	switch {
+	case (q.Owner == "") == (q.Path == ""):
+		err = fmt.Errorf("expected either an owner or a path: %w", ErrInvalidRequest)
+	case q.MaxBytes < 0 || q.MaxFiles < 0:
+		err = fmt.Errorf("negative quota limit: %w", ErrInvalidRequest)
+	}
+	return q, err
+}
+
+// precheckQuotas is checkQuotas for callers not holding s.mu, before the
+// content is written. A negative size is unknown.
+func (s *StorageData) precheckQuotas(owner, dir string, size int64, replacing string) error {
+	if size < 0 {
+		size = 0
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.checkQuotas(owner, dir, size, replacing)
+}
+
+// checkQuotas fails with a *QuotaError unless the quotas of owner and dir
+// have room for size more bytes, and for one more file unless the content
+// of the entry replacing is replaced and kept as a version. Called with s.mu
+// held.
+func (s *StorageData) checkQuotas(owner, dir string, size int64, replacing string) error {
+	quotas, err := s.readQuotas()
+	if err != nil || len(quotas) == 0 {
+		return err
+	}
+
+	var applied []Quota
+	for _, q := range quotas {
+		if q.appliesTo(owner, dir) {
+			applied = append(applied, q)
+		}
+	}
+	if len(applied) == 0 {
+		return nil
+	}
+
+	added := Usage{Bytes: size, Files: 1}
+	if replacing != "" {
+		added.Files = 0
+	}
+	held, err := s.heldFiles()
+	if err != nil {
+		return err
+	}
+	for _, q := range applied {
+		usage := q.usage(held)
+		if !usage.fits(added) {
+			return &QuotaError{Quota: usage, Size: size}
+		}
+	}
+	return nil
+}
+
+// checkMoveQuotas fails with a *QuotaError unless the quotas counting the
+// entries of moved at their new path, but not at the one they are moved
+// from, have room for them. Owners don't change, so only the quotas of
+// directories can. Called with s.mu held.
+func (s *StorageData) checkMoveQuotas(moved []FileMetadata) error {
+	quotas, err := s.readQuotas()
+	if err != nil || len(quotas) == 0 {
+		return err
+	}
+
+	all, err := s.store.All()
+	if err != nil {
+		return unavailable(err)
+	}
+	held, err := s.heldFiles()
+	if err != nil {
+		return err
+	}
+	for _, q := range quotas {
+		var added Usage
+		for _, entry := range moved {
+			from := all[entry.ID]
+			if q.appliesTo(entry.Owner, parentDir(entry.Path)) && !q.appliesTo(from.Owner, parentDir(from.Path)) {
+				added.add(entry)
+			}
+		}
+		if added.Files == 0 {
+			continue
+		}
+
+		usage := q.usage(held)
+		if !usage.fits(added) {
+			return &QuotaError{Quota: usage, Size: added.Bytes}
+		}
+	}
+	return nil
+}
+
+// fits reports whether added is within the limits left by what is used.
+func (u QuotaUsage) fits(added Usage) bool {
+	if u.MaxBytes > 0 && u.Used.Bytes+added.Bytes > u.MaxBytes {
+		return false
+	}
+	return u.MaxFiles == 0 || u.Used.Files+added.Files <= u.MaxFiles
+}
+
+// appliesTo reports whether a file of owner stored in dir counts against q.
+func (q Quota) appliesTo(owner, dir string) bool {
+	if q.Owner != "" {
+		return q.Owner == owner
+	}
+	_, ok := under(dir, q.Path)
+	return dir == q.Path || ok
+}
+
+// usage sums the entries of held that count against q.
+func (q Quota) usage(held []FileMetadata) QuotaUsage {
+	usage := QuotaUsage{Quota: q}
+	for _, entry := range held {
+		if q.appliesTo(entry.Owner, parentDir(entry.Path)) {
+			usage.Used.add(entry)
+		}
+	}
+
+	if q.MaxBytes > 0 {
+		remaining := q.MaxBytes - usage.Used.Bytes
+		if remaining < 0 {
+			remaining = 0
+		}
+		usage.RemainingBytes = &remaining
+	}
+	if q.MaxFiles > 0 {
+		remaining := q.MaxFiles - usage.Used.Files
+		if remaining < 0 {
+			remaining = 0
+		}
+		usage.RemainingFiles = &remaining
+	}
+	return usage
+}
+
+func (q Quota) key() string {
+	if q.Owner != "" {
+		return "owner:" + q.Owner
+	}
+	return "path:" + q.Path
+}
+
+func (q Quota) subject() string {
+	if q.Owner != "" {
+		return "owner " + q.Owner
+	}
+	return "directory " + q.Path
+}
+
+// add counts entry with its older versions.
+func (u *Usage) add(entry FileMetadata) {
+	u.Bytes += entry.Size
+	for _, v := range entry.Versions {
+		u.Bytes += v.Size
+	}
+	u.Files++
+}
+
+// heldFiles returns the current entries and the trashed ones, which take
+// room until they are purged. Called with s.mu held.
+func (s *StorageData) heldFiles() ([]FileMetadata, error) {
+	all, err := s.store.All()
+	if err != nil {
+		return nil, unavailable(err)
+	}
+	trash, err := s.readTrash()
+	if err != nil {
+		return nil, err
+	}
+
+	held := make([]FileMetadata, 0, len(all)+len(trash))
+	for _, entry := range all {
+		held = append(held, entry)
+	}
+	for _, item := range trash {
+		held = append(held, item.File)
+	}
+	return held, nil
+}
+
+// moveQuotas gives the quotas of dir and below to toDir. Called with s.mu
+// held.
+func (s *StorageData) moveQuotas(dir, toDir string) error {
+	quotas, err := s.readQuotas()
+	if err != nil || len(quotas) == 0 {
+		return err
+	}
+
+	moved := make(map[string]Quota, len(quotas))
+	for _, q := range quotas {
+		if q.Path == dir && q.Owner == "" {
+			q.Path = toDir
+		} else if rest, ok := under(q.Path, dir); ok && q.Owner == "" {
+			q.Path = toDir + "/" + rest
+		}
+		moved[q.key()] = q
+	}
+	return s.saveQuotas(moved)
+}
+
+// dropQuotas removes the quotas of dir and below. Called with s.mu held.
+func (s *StorageData) dropQuotas(dir string) error {
+	quotas, err := s.readQuotas()
+	if err != nil || len(quotas) == 0 {
+		return err
+	}
+
+	for key, q := range quotas {
+		if _, ok := under(q.Path, dir); (ok || q.Path == dir) && q.Owner == "" {
+			delete(quotas, key)
+		}
+	}
+	return s.saveQuotas(quotas)
+}
+
+func (s *StorageData) quotasPath() string {
+	return filepath.Join(s.root, "quotas.json")
+}
+
+// readQuotas loads the quotas by key. Called with s.mu held.
+func (s *StorageData) readQuotas() (map[string]Quota, error) {
+	quotas := make(map[string]Quota)
+
+	b, err := ioutil.ReadFile(s.quotasPath())
+	if os.IsNotExist(err) {
+		return quotas, nil
+	}
+	if err != nil {
+		return nil, unavailable(err)
+	}
+
+	var list []Quota
+	if err := json.Unmarshal(b, &list); err != nil {
+		return nil, unavailable(err)
+	}
+	for _, q := range list {
+		quotas[q.key()] = q
+	}
+	return quotas, nil
+}
+
+// saveQuotas replaces the quotas. Called with s.mu held.
+func (s *StorageData) saveQuotas(quotas map[string]Quota) error {
+	list := make([]Quota, 0, len(quotas))
+	for _, q := range quotas {
+		list = append(list, q)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].key() < list[j].key()
+	})
+
+	b, err := json.MarshalIndent(list, "", "	")
+	if err != nil {
+		return unavailable(err)
+	}
+	return unavailable(writeFileAtomic(s.quotasPath(), b))
+}
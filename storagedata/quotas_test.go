@@ -0,0 +1,258 @@
+package storagedata_test
+
+import (
+	"americanas/storagedata"
+	"americanas/test"
+	"bytes"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSetQuota(t *testing.T) {
+	testCase := "TestSetQuota"
+
+	f := setup(t)
+	defer f.close()
+
+	status, q, err := f.sd.SetQuota(storagedata.Quota{Path: "space/", MaxBytes: 100})
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+	test.AssertEqual(t, testCase, q.Path, "space")
+	f.sd.SetQuota(storagedata.Quota{Owner: "alice", MaxFiles: 2})
+
+	_, quotas, _ := f.sd.Quotas()
+	test.AssertEqual(t, testCase, len(quotas), 2)
+	test.AssertEqual(t, testCase, quotas[0].Owner, "alice")
+	test.AssertEqual(t, testCase, *quotas[0].RemainingFiles, 2)
+	test.AssertEqual(t, testCase, quotas[0].RemainingBytes == nil, true)
+	test.AssertEqual(t, testCase, *quotas[1].RemainingBytes, int64(100))
+
+	status, _, err = f.sd.SetQuota(storagedata.Quota{Owner: "alice", Path: "space", MaxFiles: 1})
+	test.AssertEqual(t, testCase, status, http.StatusBadRequest)
+	test.AssertEqual(t, testCase, errors.Is(err, storagedata.ErrInvalidRequest), true)
+	status, _, _ = f.sd.SetQuota(storagedata.Quota{Owner: "alice", MaxFiles: -1})
+	test.AssertEqual(t, testCase, status, http.StatusBadRequest)
+
+	// A quota without limits is removed.
+	f.sd.SetQuota(storagedata.Quota{Owner: "alice"})
+	_, quotas, _ = f.sd.Quotas()
+	test.AssertEqual(t, testCase, len(quotas), 1)
+}
+
+func TestDirQuota(t *testing.T) {
+	testCase := "TestDirQuota"
+
+	f := listFixture(t)
+	defer f.close()
+	f.sd.SetQuota(storagedata.Quota{Path: "space/planetsX", MaxBytes: 60})
+
+	status, _, err := f.sd.StorageFile(uploadRequest("space/planetsX", "pluto.png", *bytes.NewBufferString(strings.Repeat("x", 20))))
+	test.AssertEqual(t, testCase, status, http.StatusInsufficientStorage)
+	test.AssertEqual(t, testCase, errors.Is(err, storagedata.ErrQuotaExceeded), true)
+	var quotaErr *storagedata.QuotaError
+	test.AssertEqual(t, testCase, errors.As(err, &quotaErr), true)
+	test.AssertEqual(t, testCase, *quotaErr.Quota.RemainingBytes, int64(10))
+
+	status, _, err = f.sd.StorageFile(uploadRequest("space/planetsX/far", "eris.png", *bytes.NewBufferString(strings.Repeat("x", 70))))
+	test.AssertEqual(t, testCase, status, http.StatusRequestEntityTooLarge)
+	test.AssertEqual(t, testCase, errors.Is(err, storagedata.ErrTooLarge), true)
+
+	// A known size is refused before the content is read.
+	req := uploadRequest("space/planetsX", "pluto.png", *bytes.NewBufferString("x"))
+	req.Size = 1000
+	status, _, _ = f.sd.StorageFile(req)
+	test.AssertEqual(t, testCase, status, http.StatusRequestEntityTooLarge)
+
+	status, _, err = f.sd.StorageFile(uploadRequest("space/planetsX", "pluto.png", *bytes.NewBufferString("xxxxx")))
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+	status, _, err = f.sd.StorageFile(uploadRequest("space/planets", "pluto.png", *bytes.NewBufferString(strings.Repeat("x", 70))))
+	test.AssertNoError(t, testCase, err)
+
+	// Refused content isn't left behind.
+	_, report, _ := f.sd.Scrub()
+	test.AssertEqual(t, testCase, len(report.Orphaned), 0)
+}
+
+func TestOwnerQuota(t *testing.T) {
+	testCase := "TestOwnerQuota"
+
+	f := setup(t)
+	defer f.close()
+	f.sd.SetQuota(storagedata.Quota{Owner: "alice", MaxFiles: 1, MaxBytes: 10})
+
+	req := uploadRequest("space", "mars.png", *bytes.NewBufferString("xxxxx"))
+	req.Owner = "alice"
+	_, file, err := f.sd.StorageFile(req)
+	test.AssertNoError(t, testCase, err)
+
+	req = uploadRequest("space", "earth.png", *bytes.NewBufferString("xxxxx"))
+	req.Owner = "alice"
+	status, _, err := f.sd.StorageFile(req)
+	test.AssertEqual(t, testCase, status, http.StatusInsufficientStorage)
+	test.AssertEqual(t, testCase, strings.Contains(err.Error(), "0 of 1 files"), true)
+
+	status, _, err = f.sd.CopyFile(file.ID, "backup", "", "alice", "")
+	test.AssertEqual(t, testCase, status, http.StatusInsufficientStorage)
+
+	// A copy counts against whoever makes it, not the owner of the original.
+	_, copied, err := f.sd.CopyFile(file.ID, "backup", "", "bob", "")
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, copied.Owner, "bob")
+
+	// Overwriting adds no file, but the replaced content is kept as a
+	// version and still counts.
+	status, _, err = f.sd.OverwriteFile(file.ID, uploadRequest("space", "mars.png", *bytes.NewBufferString("xxxxx")))
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+
+	// Others aren't limited.
+	_, _, err = f.sd.StorageFile(uploadRequest("space", "earth.png", *bytes.NewBufferString("xxxxx")))
+	test.AssertNoError(t, testCase, err)
+
+	_, used, _ := f.sd.Usage("alice")
+	test.AssertEqual(t, testCase, used, storagedata.Usage{Bytes: 10, Files: 1})
+	_, used, _ = f.sd.Usage("bob")
+	test.AssertEqual(t, testCase, used, storagedata.Usage{Bytes: 5, Files: 1})
+	_, used, _ = f.sd.Usage("")
+	test.AssertEqual(t, testCase, used, storagedata.Usage{Bytes: 20, Files: 3})
+}
+
+func TestMoveDirMovesQuotas(t *testing.T) {
+	testCase := "TestMoveDirMovesQuotas"
+
+	f := listFixture(t)
+	defer f.close()
+	f.sd.SetQuota(storagedata.Quota{Path: "space/planets/moons", MaxFiles: 5})
+	f.sd.SetQuota(storagedata.Quota{Path: "space/planetsX", MaxFiles: 5})
+
+	_, err := f.sd.MoveDir("space/planets", "solar")
+	test.AssertNoError(t, testCase, err)
+
+	_, quotas, _ := f.sd.Quotas()
+	test.AssertEqual(t, testCase, len(quotas), 2)
+	test.AssertEqual(t, testCase, quotas[0].Path, "solar/moons")
+	test.AssertEqual(t, testCase, quotas[0].Used, storagedata.Usage{Bytes: 40, Files: 1})
+	test.AssertEqual(t, testCase, quotas[1].Path, "space/planetsX")
+}
+
+func TestMoveIntoFullDir(t *testing.T) {
+	testCase := "TestMoveIntoFullDir"
+
+	f := listFixture(t)
+	defer f.close()
+	f.sd.SetQuota(storagedata.Quota{Path: "space/planetsX", MaxBytes: 60})
+	_, earth, _ := f.sd.FindByPath("space/planets/earth.jpg")
+	_, mars, _ := f.sd.FindByPath("space/planets/mars.png")
+
+	status, err := f.sd.MoveFile(earth.ID, "space/planetsX")
+	test.AssertEqual(t, testCase, status, http.StatusInsufficientStorage)
+	test.AssertEqual(t, testCase, errors.Is(err, storagedata.ErrQuotaExceeded), true)
+
+	_, err = f.sd.MoveFile(mars.ID, "space/planetsX")
+	test.AssertNoError(t, testCase, err)
+	// Moving within the quota adds nothing to it.
+	_, _, err = f.sd.RenameFile(mars.ID, "", "red.png", "")
+	test.AssertNoError(t, testCase, err)
+
+	status, err = f.sd.MoveDir("space/planets/moons", "space/planetsX/moons")
+	test.AssertEqual(t, testCase, status, http.StatusInsufficientStorage)
+	var quotaErr *storagedata.QuotaError
+	test.AssertEqual(t, testCase, errors.As(err, &quotaErr), true)
+	test.AssertEqual(t, testCase, quotaErr.Size, int64(40))
+	_, _, err = f.sd.FindByPath("space/planets/moons/phobos.png")
+	test.AssertNoError(t, testCase, err)
+
+	_, err = f.sd.MoveDir("space/planets/moons", "solar")
+	test.AssertNoError(t, testCase, err)
+}
+
+func TestRestoreIntoFullDir(t *testing.T) {
+	testCase := "TestRestoreIntoFullDir"
+
+	f := listFixture(t)
+	defer f.close()
+	f.sd.SetQuota(storagedata.Quota{Path: "space/planetsX", MaxFiles: 1})
+	f.sd.SetQuota(storagedata.Quota{Path: "space/planets", MaxBytes: 80})
+
+	_, vulcan, _ := f.sd.FindByPath("space/planetsX/vulcan.png")
+	f.sd.DeleteByID(vulcan.ID)
+
+	// The trashed file keeps its place until it is purged, and takes it
+	// back when restored.
+	status, _, err := f.sd.StorageFile(uploadRequest("space/planetsX", "pluto.png", *bytes.NewBufferString("x")))
+	test.AssertEqual(t, testCase, status, http.StatusInsufficientStorage)
+	test.AssertEqual(t, testCase, errors.Is(err, storagedata.ErrQuotaExceeded), true)
+	_, _, err = f.sd.RestoreFile(vulcan.ID, "")
+	test.AssertNoError(t, testCase, err)
+
+	// space/planets holds 75 bytes: notes.txt keeps its 5 as a version and
+	// gets 1 more, and big.txt takes 3, leaving no room for the 5 bytes of
+	// notes.txt again.
+	_, notes, _ := f.sd.FindByPath("space/planets/notes.txt")
+	_, _, err = f.sd.OverwriteFile(notes.ID, uploadRequest("space/planets", "notes.txt", *bytes.NewBufferString("x")))
+	test.AssertNoError(t, testCase, err)
+	_, _, err = f.sd.StorageFile(uploadRequest("space/planets", "big.txt", *bytes.NewBufferString("xxx")))
+	test.AssertNoError(t, testCase, err)
+
+	status, _, err = f.sd.RestoreVersion(notes.ID, 1)
+	test.AssertEqual(t, testCase, status, http.StatusInsufficientStorage)
+	test.AssertEqual(t, testCase, errors.Is(err, storagedata.ErrQuotaExceeded), true)
+	_, current, _ := f.sd.ByID(notes.ID)
+	test.AssertEqual(t, testCase, current.Size, int64(1))
+}
+
+func TestQuotaCountsVersionsAndTrash(t *testing.T) {
+	testCase := "TestQuotaCountsVersionsAndTrash"
+
+	f := setup(t)
+	defer f.close()
+	f.sd.SetQuota(storagedata.Quota{Path: "space", MaxBytes: 20})
+
+	_, file, err := f.sd.StorageFile(uploadRequest("space", "mars.png", *bytes.NewBufferString(strings.Repeat("x", 8))))
+	test.AssertNoError(t, testCase, err)
+	_, _, err = f.sd.OverwriteFile(file.ID, uploadRequest("space", "mars.png", *bytes.NewBufferString(strings.Repeat("y", 8))))
+	test.AssertNoError(t, testCase, err)
+
+	// Both versions are kept, so a third doesn't fit.
+	status, _, err := f.sd.OverwriteFile(file.ID, uploadRequest("space", "mars.png", *bytes.NewBufferString(strings.Repeat("z", 8))))
+	test.AssertEqual(t, testCase, status, http.StatusInsufficientStorage)
+	test.AssertEqual(t, testCase, errors.Is(err, storagedata.ErrQuotaExceeded), true)
+
+	// Nor does deleting and uploading it again, until the trash is purged.
+	f.sd.DeleteByID(file.ID)
+	status, _, err = f.sd.StorageFile(uploadRequest("space", "mars.png", *bytes.NewBufferString(strings.Repeat("z", 8))))
+	test.AssertEqual(t, testCase, status, http.StatusInsufficientStorage)
+	test.AssertEqual(t, testCase, errors.Is(err, storagedata.ErrQuotaExceeded), true)
+	_, quotas, _ := f.sd.Quotas()
+	test.AssertEqual(t, testCase, quotas[0].Used, storagedata.Usage{Bytes: 16, Files: 1})
+
+	f.sd.PurgeTrash(file.ID)
+	_, _, err = f.sd.StorageFile(uploadRequest("space", "mars.png", *bytes.NewBufferString(strings.Repeat("z", 8))))
+	test.AssertNoError(t, testCase, err)
+}
+
+func TestCreateUploadChecksQuotas(t *testing.T) {
+	testCase := "TestCreateUploadChecksQuotas"
+
+	f := setup(t)
+	defer f.close()
+	f.sd.SetQuota(storagedata.Quota{Owner: "alice", MaxBytes: 100})
+
+	status, _, err := f.sd.CreateUpload(101, map[string]string{"path": "space", "filename": "pluto.png", "owner": "alice"})
+	test.AssertEqual(t, testCase, status, http.StatusRequestEntityTooLarge)
+	test.AssertEqual(t, testCase, errors.Is(err, storagedata.ErrTooLarge), true)
+
+	status, _, err = f.sd.CreateUpload(100, map[string]string{"path": "space", "filename": "pluto.png", "owner": "alice"})
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, status, http.StatusCreated)
+
+	req := uploadRequest("space", "mars.png", *bytes.NewBufferString(strings.Repeat("x", 60)))
+	req.Owner = "alice"
+	f.sd.StorageFile(req)
+	status, _, err = f.sd.CreateUpload(50, map[string]string{"path": "space", "filename": "pluto.png", "owner": "alice"})
+	test.AssertEqual(t, testCase, status, http.StatusInsufficientStorage)
+	test.AssertEqual(t, testCase, errors.Is(err, storagedata.ErrQuotaExceeded), true)
+}
@@ -0,0 +1,102 @@
+package storagedata
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Every path that comes from a client goes through CleanPath before it is
+// looked up in the index or joined onto the storage root.
+
+const (
+	maxSegmentLength = 255
+	maxPathLength    = 4096
+)
+
+// reservedNames can't be used as a file or directory name on Windows, with or
+// without an extension.
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// CleanPath normalizes a relative, slash separated path: backslashes become
+// slashes and empty and "." segments are dropped. Absolute paths, ".."
+// segments, control characters, reserved names, overlong segments and paths
+// into the files StorageData keeps under the root are rejected with
+// ErrInvalidPath. The empty path is the storage root.
+func CleanPath(p string) (string, error) {
+	if len(p) > maxPathLength {
+		return "", fmt.Errorf("path longer than %d bytes: %w", maxPathLength, ErrInvalidPath)
+	}
+
+	p = strings.Replace(p, `\`, "/", -1)
+	if strings.HasPrefix(p, "/") {
+		return "", fmt.Errorf("absolute path %q: %w", p, ErrInvalidPath)
+	}
+
+	var segments []string
+	for _, segment := range strings.Split(p, "/") {
+		if segment == "" || segment == "." {
+			continue
+		}
+		if err := checkSegment(segment); err != nil {
+			return "", err
+		}
+		segments = append(segments, segment)
+	}
+	if len(segments) > 0 && internalName(segments[0]) {
+		return "", fmt.Errorf("reserved name %q: %w", segments[0], ErrInvalidPath)
+	}
+
+	return strings.Join(segments, "/"), nil
+}
+
+// CleanName returns the last segment of a client supplied file name, so a
+// name like "C:\fakepath\mars.png" becomes "mars.png". Names of the files
+// StorageData keeps under the root are rejected, as the name may end up there.
+func CleanName(name string) (string, error) {
+	name = path.Base(strings.Replace(name, `\`, "/", -1))
+	if name == "." || name == "/" {
+		return "", fmt.Errorf("missing file name: %w", ErrInvalidPath)
+	}
+	if err := checkSegment(name); err != nil {
+		return "", err
+	}
+	if internalName(name) {
+		return "", fmt.Errorf("reserved name %q: %w", name, ErrInvalidPath)
+	}
+	return name, nil
+}
+
+// internalName reports whether segment, at the root, would reach one of the
+// internalFiles or the files a metadata store keeps next to metadata.json.
+// Case is ignored for case-insensitive file systems.
+func internalName(segment string) bool {
+	segment = strings.ToLower(segment)
+	return internalFiles[segment] || strings.HasPrefix(segment, "metadata.json")
+}
+
+func checkSegment(segment string) error {
+	if segment == ".." {
+		return fmt.Errorf("path must not contain \"..\": %w", ErrInvalidPath)
+	}
+	if len(segment) > maxSegmentLength {
+		return fmt.Errorf("path segment longer than %d bytes: %w", maxSegmentLength, ErrInvalidPath)
+	}
+	for _, r := range segment {
+		if r < 0x20 || r == 0x7f {
+			return fmt.Errorf("path must not contain control characters: %w", ErrInvalidPath)
+		}
+	}
+
+	base := strings.ToUpper(strings.SplitN(segment, ".", 2)[0])
+	if reservedNames[strings.TrimRight(base, " ")] {
+		return fmt.Errorf("reserved name %q: %w", segment, ErrInvalidPath)
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package storagedata_test
+
+import (
+	"americanas/storagedata"
+	"americanas/test"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCleanPath(t *testing.T) {
+	testCase := "TestCleanPath"
+
+	valid := map[string]string{
+		"":                      "",
+		".":                     "",
+		"ht/monthly":            "ht/monthly",
+		"ht//monthly/":          "ht/monthly",
+		"./ht/./monthly":        "ht/monthly",
+		`ht\monthly`:            "ht/monthly",
+		"space/planets/..earth": "space/planets/..earth",
+		"console/console.d":     "console/console.d",
+		"space/blobs/uploads":   "space/blobs/uploads",
+	}
+	for input, expected := range valid {
+		actual, err := storagedata.CleanPath(input)
+		test.AssertNoError(t, testCase, err)
+		test.AssertEqual(t, testCase, actual, expected)
+	}
+
+	invalid := []string{
+		"/etc",
+		"../../etc",
+		"ht/../../etc",
+		`..\..\etc`,
+		"ht/\x00/monthly",
+		"ht/\nmonthly",
+		"ht/CON",
+		"ht/nul.txt",
+		"ht/" + strings.Repeat("a", 256),
+		strings.Repeat("a/", 2049),
+		"blobs/ab/abcdef",
+		"./uploads/1234",
+		"Metadata.json",
+		"metadata.json.journal",
+		"acls.json",
+	}
+	for _, input := range invalid {
+		_, err := storagedata.CleanPath(input)
+		test.AssertEqual(t, testCase, errors.Is(err, storagedata.ErrInvalidPath), true)
+	}
+}
+
+func TestCleanName(t *testing.T) {
+	testCase := "TestCleanName"
+
+	valid := map[string]string{
+		"mars.png":                 "mars.png",
+		`C:\fakepath\mars.png`:     "mars.png",
+		"../../etc/passwd":         "passwd",
+		"space/planets/earth.png/": "earth.png",
+	}
+	for input, expected := range valid {
+		actual, err := storagedata.CleanName(input)
+		test.AssertNoError(t, testCase, err)
+		test.AssertEqual(t, testCase, actual, expected)
+	}
+
+	for _, input := range []string{"", "..", "/", "aux", "mars\x00.png", "trash.json", "ht/shares.json"} {
+		_, err := storagedata.CleanName(input)
+		test.AssertEqual(t, testCase, errors.Is(err, storagedata.ErrInvalidPath), true)
+	}
+}
+
+func TestPathTraversalIsRejected(t *testing.T) {
+	testCase := "TestPathTraversalIsRejected"
+
+	f := setup(t)
+	defer f.close()
+
+	e := createFile("earth.png")
+	status, _, err := f.sd.StorageFile(uploadRequest("../../etc", "earth.png", e))
+	test.AssertEqual(t, testCase, status, http.StatusBadRequest)
+	test.AssertEqual(t, testCase, errors.Is(err, storagedata.ErrInvalidPath), true)
+
+	_, earth, err := f.sd.StorageFile(uploadRequest("space/planets", "../../earth.png", e))
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, earth.Path, "space/planets/earth.png")
+
+	status, err = f.sd.MoveFile(earth.ID, "space/../../outside")
+	test.AssertEqual(t, testCase, status, http.StatusBadRequest)
+	test.AssertEqual(t, testCase, errors.Is(err, storagedata.ErrInvalidPath), true)
+
+	status, _, err = f.sd.UnderDir("/etc")
+	test.AssertEqual(t, testCase, status, http.StatusBadRequest)
+	test.AssertEqual(t, testCase, errors.Is(err, storagedata.ErrInvalidPath), true)
+
+	status, _, err = f.sd.CreateUpload(10, map[string]string{"path": "../uploads", "filename": "pluto.png"})
+	test.AssertEqual(t, testCase, status, http.StatusBadRequest)
+	test.AssertEqual(t, testCase, errors.Is(err, storagedata.ErrInvalidPath), true)
+}
+
+func TestCleanPathInvariants(t *testing.T) {
+	inputs := []string{
+		"", "ht/monthly", "../etc", "/abs", `a\..\b`, "a/./b//c", "x/\x00", "LPT1.log",
+		"..", "./..", "a/../..", "a/b/../../..", "//", "/../x", `..\x`, "a/..%2f..", "%2e%2e/x",
+		" ", "a /b ", "ht/monthly/", "....//x", "a/.../b", "\u00e9t\u00e9/caf\u00e9", "a\tb", "C:/x", `C:\x`,
+	}
+
+	root := filepath.FromSlash("/storage/root")
+	for _, input := range inputs {
+		cleaned, err := storagedata.CleanPath(input)
+		if err != nil {
+			if !errors.Is(err, storagedata.ErrInvalidPath) {
+				t.Fatalf("CleanPath(%q) returned an unclassified error: %v", input, err)
+			}
+			continue
+		}
+
+		for _, segment := range strings.Split(cleaned, "/") {
+			if segment == ".." || segment == "." || (segment == "" && cleaned != "") {
+				t.Fatalf("CleanPath(%q) = %q keeps segment %q", input, cleaned, segment)
+			}
+		}
+		if strings.ContainsAny(cleaned, "\x00\\") || strings.HasPrefix(cleaned, "/") {
+			t.Fatalf("CleanPath(%q) = %q", input, cleaned)
+		}
+
+		joined := filepath.Join(root, cleaned)
+		if joined != root && !strings.HasPrefix(joined, root+string(filepath.Separator)) {
+			t.Fatalf("CleanPath(%q) = %q escapes the root as %q", input, cleaned, joined)
+		}
+
+		again, err := storagedata.CleanPath(cleaned)
+		if err != nil || again != cleaned {
+			t.Fatalf("CleanPath is not idempotent for %q: %q, %v", cleaned, again, err)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package storagedata
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ScrubReport is what Scrub found. Every content an entry references, the
+// versions and the trash included, is read back and hashed; Orphaned lists
+// the files under the root that no entry references, relative to it.
+type ScrubReport struct {
+	Checked  int            `json:"checked"`
+	Missing  []ScrubProblem `json:"missing"`
+	Modified []ScrubProblem `json:"modified"`
+	Orphaned []string       `json:"orphaned"`
+}
+
+// ScrubProblem is a content that is missing or doesn't hash to SHA256
+// anymore. Actual is the hash it has now.
+type ScrubProblem struct {
+	ID      string `json:"id"`
+	Path    string `json:"path"`
+	Version int    `json:"version"`
+	SHA256  string `json:"sha256,omitempty"`
+	Actual  string `json:"actualSha256,omitempty"`
+	Trashed bool   `json:"trashed,omitempty"`
+}
+
+// internalFiles are kept directly under the root by StorageData and the
+// default metadata stores.
+var internalFiles = map[string]bool{
+	"blobs":                 true,
+	"uploads":               true,
+	"metadata.json":         true,
+	"metadata.json.journal": true,
+	"metadata.db":           true,
+	"directories.json":      true,
+	"trash.json":            true,
+	"acls.json":             true,
+	"shares.json":           true,
+	"quotas.json":           true,
+}
+
+// Scrub checks the stored contents against the hashes recorded for them and
+// looks for files nothing references. It only reports; nothing is changed.
+func (s *StorageData) Scrub() (int, ScrubReport, error) {
+	s.mu.RLock()
+	all, err := s.store.All()
+	err = unavailable(err)
+	var trash map[string]TrashedFile
+	if err == nil {
+		trash, err = s.readTrash()
+	}
+	s.mu.RUnlock()
+	if err != nil {
+		return http.StatusServiceUnavailable, ScrubReport{}, err
+	}
+
+	report := ScrubReport{Missing: []ScrubProblem{}, Modified: []ScrubProblem{}, Orphaned: []string{}}
+	refs := make(map[string][]ScrubProblem)
+	legacy := make(map[string]bool)
+	add := func(entry FileMetadata, trashed bool) {
+		for _, v := range append([]FileVersion{entry.asVersion()}, entry.Versions...) {
+			ref := ScrubProblem{ID: entry.ID, Path: entry.Path, Version: v.Version, SHA256: v.SHA256, Trashed: trashed}
+			if v.SHA256 != "" {
+				refs[v.SHA256] = append(refs[v.SHA256], ref)
+				continue
+			}
+
+			// Entries stored before blobs existed have no hash to check.
+			legacy[entry.Path] = true
+			report.Checked++
+			if _, err := os.Stat(filepath.Join(s.root, entry.Path)); os.IsNotExist(err) {
+				report.Missing = append(report.Missing, ref)
+			}
+		}
+	}
+	for _, entry := range all {
+		add(entry, false)
+	}
+	for _, item := range trash {
+		add(item.File, true)
+	}
+
+	for hash, hashRefs := range refs {
+		report.Checked++
+		actual, err := hashFile(s.blobPath(hash))
+		switch {
+		case os.IsNotExist(err):
+			report.Missing = append(report.Missing, hashRefs...)
+		case err != nil:
+			return http.StatusServiceUnavailable, ScrubReport{}, unavailable(err)
+		case actual != hash:
+			for _, ref := range hashRefs {
+				ref.Actual = actual
+				report.Modified = append(report.Modified, ref)
+			}
+		}
+	}
+
+	report.Orphaned, err = s.orphans(refs, legacy)
+	if err != nil {
+		return http.StatusServiceUnavailable, ScrubReport{}, unavailable(err)
+	}
+
+	sortProblems(report.Missing)
+	sortProblems(report.Modified)
+	return http.StatusOK, report, nil
+}
+
+// orphans returns the files under the root that are neither a blob in refs,
+// the content of a legacy entry nor kept by StorageData itself.
+func (s *StorageData) orphans(refs map[string][]ScrubProblem, legacy map[string]bool) ([]string, error) {
+	orphaned := []string{}
+	err := filepath.Walk(s.root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(s.root, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if !strings.Contains(rel, "/") && (internalFiles[rel] || strings.HasPrefix(rel, ".")) {
+			if info.IsDir() && rel != "blobs" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		if blob, ok := under(rel, "blobs"); ok {
+			if s.orphanBlob(blob, refs) {
+				orphaned = append(orphaned, rel)
+			}
+		} else if !legacy[rel] {
+			orphaned = append(orphaned, rel)
+		}
+		return nil
+	})
+	sort.Strings(orphaned)
+	return orphaned, err
+}
+
+// orphanBlob reports whether the file at blob, relative to the blob store,
+// is a blob nothing references. Blobs missing from refs are checked again
+// under their lock, since an upload may have stored one since refs was taken.
+func (s *StorageData) orphanBlob(blob string, refs map[string][]ScrubProblem) bool {
+	hash := filepath.Base(blob)
+	if strings.HasPrefix(hash, ".") {
+		// An upload still being written.
+		return false
+	}
+	if len(hash) <= 2 || blob != hash[:2]+"/"+hash {
+		return true
+	}
+	if len(refs[hash]) > 0 {
+		return false
+	}
+
+	unlock := s.blobs.lock(hash)
+	defer unlock()
+	used, err := s.referenced(hash)
+	return err == nil && !used
+}
+
+func hashFile(p string) (string, error) {
+	file, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+func sortProblems(problems []ScrubProblem) {
+	sort.Slice(problems, func(i, j int) bool {
+		a, b := problems[i], problems[j]
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		if a.ID != b.ID {
+			return a.ID < b.ID
+		}
+		return a.Version < b.Version
+	})
+}
@@ -0,0 +1,95 @@
+package storagedata_test
+
+import (
+	"americanas/storagedata"
+	"americanas/test"
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestScrub(t *testing.T) {
+	testCase := "TestScrub"
+
+	f := setup(t)
+	defer f.close()
+
+	_, mars, _ := upload(f, "space", "mars.png", "red", "")
+	_, venus, _ := upload(f, "space", "venus.png", "yellow", "")
+	_, earth, _ := upload(f, "space", "earth.png", "blue", "")
+	earth = overwrite(f, earth, "green")
+	_, pluto, _ := upload(f, "space", "pluto.png", "grey", "")
+	f.sd.DeleteByID(pluto.ID)
+
+	status, report, err := f.sd.Scrub()
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, report.Checked, 5)
+	test.AssertEqual(t, testCase, report.Missing, []storagedata.ScrubProblem{})
+	test.AssertEqual(t, testCase, report.Modified, []storagedata.ScrubProblem{})
+	test.AssertEqual(t, testCase, report.Orphaned, []string{})
+
+	// Damage the store behind its back.
+	blob := func(hash string) string { return filepath.Join(f.dir, "blobs", hash[:2], hash) }
+	ioutil.WriteFile(blob(mars.SHA256), []byte("rusty"), 0644)
+	os.Remove(blob(earth.Versions[0].SHA256))
+	os.Remove(blob(pluto.SHA256))
+	os.MkdirAll(filepath.Join(f.dir, "space"), os.ModePerm)
+	os.MkdirAll(filepath.Join(f.dir, "blobs", "ab"), os.ModePerm)
+	ioutil.WriteFile(filepath.Join(f.dir, "space", "stray.png"), []byte("?"), 0644)
+	ioutil.WriteFile(filepath.Join(f.dir, "blobs", "ab", "abcd"), []byte("?"), 0644)
+
+	_, report, err = f.sd.Scrub()
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, report.Missing, []storagedata.ScrubProblem{
+		{ID: earth.ID, Path: "space/earth.png", Version: 1, SHA256: earth.Versions[0].SHA256},
+		{ID: pluto.ID, Path: "space/pluto.png", Version: 1, SHA256: pluto.SHA256, Trashed: true},
+	})
+	rusty := sha256Hex(*bytes.NewBufferString("rusty"))
+	test.AssertEqual(t, testCase, report.Modified, []storagedata.ScrubProblem{
+		{ID: mars.ID, Path: "space/mars.png", Version: 1, SHA256: mars.SHA256, Actual: rusty},
+	})
+	test.AssertEqual(t, testCase, report.Orphaned, []string{"blobs/ab/abcd", "space/stray.png"})
+
+	// A blob nothing references anymore is an orphan too.
+	f.sd.DeleteByID(venus.ID)
+	f.sd.PurgeTrash(venus.ID)
+	os.MkdirAll(filepath.Dir(blob(venus.SHA256)), os.ModePerm)
+	ioutil.WriteFile(blob(venus.SHA256), []byte("yellow"), 0644)
+	_, report, _ = f.sd.Scrub()
+	test.AssertEqual(t, testCase, report.Orphaned, []string{
+		"blobs/ab/abcd",
+		"blobs/" + venus.SHA256[:2] + "/" + venus.SHA256,
+		"space/stray.png",
+	})
+}
+
+func TestScrubLegacyEntries(t *testing.T) {
+	testCase := "TestScrubLegacyEntries"
+
+	dir, err := ioutil.TempDir("", "storagedata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fixture{dir: dir}
+	os.MkdirAll(filepath.Join(dir, "space"), os.ModePerm)
+	ioutil.WriteFile(filepath.Join(dir, "space/mars.png"), []byte("legacy"), 0644)
+
+	store := storagedata.NewJSONStore(filepath.Join(dir, "metadata.json"))
+	store.Put("mars", storagedata.FileMetadata{ID: "mars", Name: "mars.png", Path: "space/mars.png", ModTime: time.Now()})
+	store.Put("venus", storagedata.FileMetadata{ID: "venus", Name: "venus.png", Path: "space/venus.png", ModTime: time.Now()})
+	f.sd = storagedata.New(storagedata.WithRoot(dir), storagedata.WithMetadataStore(store))
+	defer f.close()
+
+	_, report, err := f.sd.Scrub()
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, report.Checked, 2)
+	test.AssertEqual(t, testCase, report.Missing, []storagedata.ScrubProblem{
+		{ID: "venus", Path: "space/venus.png", Version: 1},
+	})
+	test.AssertEqual(t, testCase, report.Orphaned, []string{})
+}
@@ -0,0 +1,354 @@
+package storagedata
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Shares are public links to a file, followed by ID, or to a directory,
+// followed by path through MoveDir. They are kept in shares.json under the
+// root with a PBKDF2 hash of their password, if any.
+
+// Share is a share link. Downloads counts the times it was opened.
+type Share struct {
+	Token        string     `json:"token"`
+	FileID       string     `json:"fileId,omitempty"`
+	Dir          string     `json:"dir,omitempty"`
+	Owner        string     `json:"owner,omitempty"`
+	Protected    bool       `json:"passwordProtected"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	ExpiresAt    *time.Time `json:"expiresAt,omitempty"`
+	MaxDownloads int        `json:"maxDownloads,omitempty"`
+	Downloads    int        `json:"downloads"`
+}
+
+// ShareRequest describes the share to create: either FileID or Dir. Zero
+// ExpiresAt and MaxDownloads don't limit it.
+type ShareRequest struct {
+	FileID       string
+	Dir          string
+	Owner        string
+	Password     string
+	ExpiresAt    time.Time
+	MaxDownloads int
+}
+
+// storedShare is a Share as kept in shares.json.
+type storedShare struct {
+	Share
+	PasswordHash string `json:"passwordHash,omitempty"`
+}
+
+const (
+	shareTokenBytes  = 9
+	pbkdf2Iterations = 100000
+)
+
+// CreateShare creates a share link with a new random token.
+func (s *StorageData) CreateShare(req ShareRequest) (int, Share, error) {
+	var err error
+	switch {
+	case (req.FileID == "") == (req.Dir == ""):
+		err = fmt.Errorf("expected either a file or a directory: %w", ErrInvalidRequest)
+	case req.MaxDownloads < 0:
+		err = fmt.Errorf("negative max downloads: %w", ErrInvalidRequest)
+	case !req.ExpiresAt.IsZero() && !req.ExpiresAt.After(time.Now()):
+		err = fmt.Errorf("expiry in the past: %w", ErrInvalidRequest)
+	case req.Dir != "":
+		req.Dir, err = CleanPath(req.Dir)
+		if err == nil && req.Dir == "" {
+			err = fmt.Errorf("the root can't be shared: %w", ErrInvalidPath)
+		}
+	}
+	if err != nil {
+		return http.StatusBadRequest, Share{}, err
+	}
+
+	token, err := randomToken(shareTokenBytes)
+	if err != nil {
+		return http.StatusServiceUnavailable, Share{}, unavailable(err)
+	}
+	share := storedShare{Share: Share{
+		Token:        token,
+		FileID:       req.FileID,
+		Dir:          req.Dir,
+		Owner:        req.Owner,
+		CreatedAt:    time.Now(),
+		MaxDownloads: req.MaxDownloads,
+	}}
+	if !req.ExpiresAt.IsZero() {
+		share.ExpiresAt = &req.ExpiresAt
+	}
+	if req.Password != "" {
+		share.PasswordHash, err = hashPassword(req.Password)
+		if err != nil {
+			return http.StatusServiceUnavailable, Share{}, unavailable(err)
+		}
+		share.Protected = true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if req.FileID != "" {
+		_, err = s.lookup(req.FileID)
+	} else {
+		var all map[string]FileMetadata
+		var dirs map[string]bool
+		all, dirs, err = s.tree()
+		if err == nil && !dirExists(req.Dir, all, dirs) {
+			err = fmt.Errorf("directory %s: %w", req.Dir, ErrNotFound)
+		}
+	}
+	if err != nil {
+		return statusOf(err), Share{}, err
+	}
+
+	shares, err := s.readShares()
+	if err != nil {
+		return http.StatusServiceUnavailable, Share{}, err
+	}
+	shares[token] = share
+	if err := s.saveShares(shares); err != nil {
+		return http.StatusServiceUnavailable, Share{}, err
+	}
+	return http.StatusCreated, share.Share, nil
+}
+
+// Shares returns every share link, most recently created first.
+func (s *StorageData) Shares() (int, []Share, error) {
+	s.mu.RLock()
+	shares, err := s.readShares()
+	s.mu.RUnlock()
+	if err != nil {
+		return http.StatusServiceUnavailable, nil, err
+	}
+
+	list := make([]Share, 0, len(shares))
+	for _, share := range shares {
+		list = append(list, share.Share)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].CreatedAt.Equal(list[j].CreatedAt) {
+			return list[i].CreatedAt.After(list[j].CreatedAt)
+		}
+		return list[i].Token < list[j].Token
+	})
+	return http.StatusOK, list, nil
+}
+
+// RevokeShare deletes the share link token.
+func (s *StorageData) RevokeShare(token string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	shares, err := s.readShares()
+	if err != nil {
+		return http.StatusServiceUnavailable, err
+	}
+	if _, ok := shares[token]; !ok {
+		return http.StatusNotFound, fmt.Errorf("share %s: %w", token, ErrNotFound)
+	}
+	delete(shares, token)
+	if err := s.saveShares(shares); err != nil {
+		return http.StatusServiceUnavailable, err
+	}
+	return http.StatusOK, nil
+}
+
+// OpenShare counts a download of the share link token and returns it. An
+// expired or used up link is reported as missing; a wrong password fails
+// with ErrForbidden and isn't counted.
+func (s *StorageData) OpenShare(token, password string) (int, Share, error) {
+	s.mu.RLock()
+	_, share, err := s.liveShare(token)
+	s.mu.RUnlock()
+	if err != nil {
+		return statusOf(err), Share{}, err
+	}
+
+	// The password is slow to check on purpose, so no lock is held meanwhile.
+	if share.PasswordHash != "" && !checkPassword(share.PasswordHash, password) {
+		return http.StatusForbidden, Share{}, fmt.Errorf("wrong password for share %s: %w", token, ErrForbidden)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The link may have been revoked or used up while the password was
+	// checked.
+	shares, share, err := s.liveShare(token)
+	if err != nil {
+		return statusOf(err), Share{}, err
+	}
+	share.Downloads++
+	shares[token] = share
+	if err := s.saveShares(shares); err != nil {
+		return http.StatusServiceUnavailable, Share{}, err
+	}
+	return http.StatusOK, share.Share, nil
+}
+
+// liveShare returns the share links and the one of token, unless it is
+// missing, expired or used up. Called with s.mu held.
+func (s *StorageData) liveShare(token string) (map[string]storedShare, storedShare, error) {
+	shares, err := s.readShares()
+	if err != nil {
+		return nil, storedShare{}, err
+	}
+	share, ok := shares[token]
+	if ok && share.ExpiresAt != nil && !time.Now().Before(*share.ExpiresAt) {
+		ok = false
+	}
+	if ok && share.MaxDownloads > 0 && share.Downloads >= share.MaxDownloads {
+		ok = false
+	}
+	if !ok {
+		return nil, storedShare{}, fmt.Errorf("share %s: %w", token, ErrNotFound)
+	}
+	return shares, share, nil
+}
+
+// moveShares points the shares of dir and below at toDir. Called with s.mu
+// held.
+func (s *StorageData) moveShares(dir, toDir string) error {
+	shares, err := s.readShares()
+	if err != nil || len(shares) == 0 {
+		return err
+	}
+
+	for token, share := range shares {
+		if share.Dir == dir {
+			share.Dir = toDir
+		} else if rest, ok := under(share.Dir, dir); ok && share.Dir != "" {
+			share.Dir = toDir + "/" + rest
+		}
+		shares[token] = share
+	}
+	return s.saveShares(shares)
+}
+
+// dropShares revokes the shares of dir and below. Called with s.mu held.
+func (s *StorageData) dropShares(dir string) error {
+	shares, err := s.readShares()
+	if err != nil || len(shares) == 0 {
+		return err
+	}
+
+	for token, share := range shares {
+		if _, ok := under(share.Dir, dir); ok || share.Dir == dir {
+			delete(shares, token)
+		}
+	}
+	return s.saveShares(shares)
+}
+
+func (s *StorageData) sharesPath() string {
+	return filepath.Join(s.root, "shares.json")
+}
+
+// readShares loads the share links by token. Called with s.mu held.
+func (s *StorageData) readShares() (map[string]storedShare, error) {
+	shares := make(map[string]storedShare)
+
+	b, err := ioutil.ReadFile(s.sharesPath())
+	if os.IsNotExist(err) {
+		return shares, nil
+	}
+	if err != nil {
+		return nil, unavailable(err)
+	}
+
+	if err := json.Unmarshal(b, &shares); err != nil {
+		return nil, unavailable(err)
+	}
+	return shares, nil
+}
+
+// saveShares replaces the share links. Called with s.mu held.
+func (s *StorageData) saveShares(shares map[string]storedShare) error {
+	b, err := json.MarshalIndent(shares, "", "	")
+	if err != nil {
+		return unavailable(err)
+	}
+	return unavailable(writeFileAtomic(s.sharesPath(), b))
+}
+
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashPassword returns "pbkdf2-sha256$<iterations>$<salt>$<hash>".
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	hash := pbkdf2([]byte(password), salt, pbkdf2Iterations)
+	return strings.Join([]string{
+		"pbkdf2-sha256",
+		strconv.Itoa(pbkdf2Iterations),
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(hash),
+	}, "$"), nil
+}
+
+func checkPassword(encoded, password string) bool {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations < 1 {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	hash, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+
+	return hmac.Equal(hash, pbkdf2([]byte(password), salt, iterations))
+}
+
+// pbkdf2 is PBKDF2 with HMAC-SHA256 (RFC 8018), for a single block of key.
+func pbkdf2(password, salt []byte, iterations int) []byte {
+	mac := hmac.New(sha256.New, password)
+	var block [4]byte
+	binary.BigEndian.PutUint32(block[:], 1)
+	mac.Write(bytes.Join([][]byte{salt, block[:]}, nil))
+	u := mac.Sum(nil)
+
+	key := append([]byte(nil), u...)
+	for i := 1; i < iterations; i++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(u[:0])
+		for j := range key {
+			key[j] ^= u[j]
+		}
+	}
+	return key
+}
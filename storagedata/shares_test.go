@@ -0,0 +1,127 @@
+package storagedata_test
+
+import (
+	"americanas/storagedata"
+	"americanas/test"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestShares(t *testing.T) {
+	testCase := "TestShares"
+
+	f := listFixture(t)
+	defer f.close()
+	_, files, _ := f.sd.UnderDir("space/planets/moons")
+	var id string
+	for id = range files {
+		break
+	}
+
+	status, file, err := f.sd.CreateShare(storagedata.ShareRequest{FileID: id, Owner: "alice", Password: "pw", MaxDownloads: 2})
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, status, http.StatusCreated)
+	test.AssertEqual(t, testCase, file.Protected, true)
+	test.AssertEqual(t, testCase, len(file.Token), 12)
+
+	_, dir, err := f.sd.CreateShare(storagedata.ShareRequest{Dir: "space/planets/", ExpiresAt: time.Now().Add(time.Hour)})
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, dir.Dir, "space/planets")
+
+	_, shares, _ := f.sd.Shares()
+	test.AssertEqual(t, testCase, len(shares), 2)
+	test.AssertEqual(t, testCase, shares[0].Token, dir.Token)
+
+	status, _, err = f.sd.CreateShare(storagedata.ShareRequest{Dir: "space/comets"})
+	test.AssertEqual(t, testCase, status, http.StatusNotFound)
+	test.AssertEqual(t, testCase, errors.Is(err, storagedata.ErrNotFound), true)
+	status, _, _ = f.sd.CreateShare(storagedata.ShareRequest{Dir: "space", FileID: id})
+	test.AssertEqual(t, testCase, status, http.StatusBadRequest)
+	status, _, _ = f.sd.CreateShare(storagedata.ShareRequest{FileID: id, ExpiresAt: time.Now().Add(-time.Hour)})
+	test.AssertEqual(t, testCase, status, http.StatusBadRequest)
+
+	status, err = f.sd.RevokeShare(dir.Token)
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+	status, _, _ = f.sd.OpenShare(dir.Token, "")
+	test.AssertEqual(t, testCase, status, http.StatusNotFound)
+}
+
+func TestOpenShare(t *testing.T) {
+	testCase := "TestOpenShare"
+
+	f := listFixture(t)
+	defer f.close()
+
+	_, share, _ := f.sd.CreateShare(storagedata.ShareRequest{Dir: "space", Password: "pw", MaxDownloads: 2})
+
+	status, _, err := f.sd.OpenShare(share.Token, "wrong")
+	test.AssertEqual(t, testCase, status, http.StatusForbidden)
+	test.AssertEqual(t, testCase, errors.Is(err, storagedata.ErrForbidden), true)
+
+	for i := 1; i <= 2; i++ {
+		status, opened, err := f.sd.OpenShare(share.Token, "pw")
+		test.AssertNoError(t, testCase, err)
+		test.AssertEqual(t, testCase, status, http.StatusOK)
+		test.AssertEqual(t, testCase, opened.Downloads, i)
+	}
+	status, _, err = f.sd.OpenShare(share.Token, "pw")
+	test.AssertEqual(t, testCase, status, http.StatusNotFound)
+	test.AssertEqual(t, testCase, errors.Is(err, storagedata.ErrNotFound), true)
+}
+
+func TestOpenShareConcurrently(t *testing.T) {
+	testCase := "TestOpenShareConcurrently"
+
+	f := listFixture(t)
+	defer f.close()
+
+	_, share, _ := f.sd.CreateShare(storagedata.ShareRequest{Dir: "space", Password: "pw", MaxDownloads: 1})
+
+	// Passwords are checked in parallel, but the link still opens once.
+	var wg sync.WaitGroup
+	statuses := make(chan int, 4)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			status, _, _ := f.sd.OpenShare(share.Token, "pw")
+			statuses <- status
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+
+	opened := 0
+	for status := range statuses {
+		if status == http.StatusOK {
+			opened++
+		} else {
+			test.AssertEqual(t, testCase, status, http.StatusNotFound)
+		}
+	}
+	test.AssertEqual(t, testCase, opened, 1)
+}
+
+func TestMoveDirMovesShares(t *testing.T) {
+	testCase := "TestMoveDirMovesShares"
+
+	f := listFixture(t)
+	defer f.close()
+
+	f.sd.CreateShare(storagedata.ShareRequest{Dir: "space/planets/moons"})
+	f.sd.CreateShare(storagedata.ShareRequest{Dir: "space/planetsX"})
+
+	_, err := f.sd.MoveDir("space/planets", "solar")
+	test.AssertNoError(t, testCase, err)
+
+	_, shares, _ := f.sd.Shares()
+	dirs := map[string]bool{}
+	for _, share := range shares {
+		dirs[share.Dir] = true
+	}
+	test.AssertEqual(t, testCase, dirs, map[string]bool{"solar/moons": true, "space/planetsX": true})
+}
@@ -1,398 +1,554 @@
 package storagedata
 
 import (
-	"americanas/helper"
-	"bytes"
-	"crypto/md5"
-	"encoding/hex"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
-	"io/ioutil"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
-	"runtime"
 	"strings"
+	"sync"
+	"time"
 )
 
+// StorageData is safe for concurrent use. Metadata reads share mu while
+// changes to the index are serialized on it, and every operation on an
+// existing file also holds that file's ID lock, so moves, overwrites and
+// deletes of the same ID never interleave.
 type StorageData struct {
+	root    string
+	store   MetadataStore
+	mu      sync.RWMutex
+	ids     idLocker
+	blobs   idLocker
+	uploads idLocker
+
+	// trashRefs counts the references trashed entries hold to each blob,
+	// nil until the trash is first read. Guarded by refsMu.
+	refsMu    sync.Mutex
+	trashRefs map[string]int
+
+	admins         []string
+	uploadExpiry   time.Duration
+	trashRetention time.Duration
+	done           chan struct{}
+	closeOnce      sync.Once
 }
 
-func (s *StorageData) StorageFile(body map[string]interface{}) (int, []byte, map[string]interface{}, error) {
+type Option func(*StorageData)
 
-	file, fullPath, typeFile, err := s.saveFileInDisk(body)
-	if err != nil {
-		return http.StatusBadRequest, nil, nil, err
+// WithRoot sets the directory files are stored under; the working directory
+// is used otherwise. Unless WithMetadataStore is given as well, the index is
+// kept in metadata.json inside it.
+func WithRoot(dir string) Option {
+	return func(s *StorageData) {
+		s.root = dir
 	}
-	defer file.Close()
+}
 
-	metadata, err := file.Stat()
-	if err != nil {
-		return http.StatusBadRequest, nil, nil, err
+// WithMetadataStore replaces the default metadata.json index.
+func WithMetadataStore(store MetadataStore) Option {
+	return func(s *StorageData) {
+		s.store = store
 	}
+}
 
-	hash := md5.Sum([]byte(metadata.ModTime().String() + metadata.Name()))
-	fileID := hex.EncodeToString(hash[:])
-	modTime := metadata.ModTime()
-	hh := modTime.Format("01/02/2006 15:04:05")
-	metadataJSON := map[string]interface{}{
-		fileID: map[string]interface{}{
-			"name":             metadata.Name(),
-			"path":             fullPath,
-			"type":             typeFile,
-			"size":             metadata.Size(),
-			"modificationTime": hh,
-		},
-	}
+func (s *StorageData) StorageFile(req UploadRequest) (int, FileMetadata, error) {
 
-	metadataFile, err := s.openMetadataJSON(os.O_CREATE | os.O_WRONLY)
+	req, err := validateRequest(req)
 	if err != nil {
-		return http.StatusBadRequest, nil, nil, err
+		fmt.Printf("[StorageFile] Wrong body format. Error: %s", err)
+		return http.StatusBadRequest, FileMetadata{}, err
 	}
-	defer metadataFile.Close()
-
-	fileMap := make(map[string]interface{})
 
-	mtpath := getMetaDataDir()
-	byteValue, _ := ioutil.ReadFile(mtpath)
-	err = json.Unmarshal(byteValue, &fileMap)
-	if err != nil {
-		return http.StatusBadRequest, nil, nil, err
+	if err := s.precheckQuotas(req.Owner, req.Path, req.Size, ""); err != nil {
+		return statusOf(err), FileMetadata{}, err
 	}
-	fileMap = helper.MergeMaps(fileMap, metadataJSON)
-	fileOut, _ := json.MarshalIndent(fileMap, "", "	")
-	_, err = io.Copy(metadataFile, bytes.NewReader(fileOut))
+
+	entry, err := s.storeContent("", req, FileMetadata{Owner: req.Owner})
 	if err != nil {
-		return http.StatusBadRequest, nil, nil, err
+		return statusOf(err), FileMetadata{}, err
 	}
 
-	return http.StatusOK, []byte(fileID), metadataJSON, nil
+	return http.StatusOK, entry, nil
 }
 
-func (s *StorageData) AllFiles() (int, []byte, error) {
+func (s *StorageData) AllFiles() (int, map[string]FileMetadata, error) {
 
 	mapFileMetadata, err := s.GetMetadataJSON()
 	if err != nil {
-		return http.StatusBadRequest, nil, err
+		return http.StatusServiceUnavailable, nil, err
 	}
 
-	ret, err := json.Marshal(mapFileMetadata)
+	return http.StatusOK, mapFileMetadata, nil
+}
+
+func (s *StorageData) UnderDir(dir string) (int, map[string]FileMetadata, error) {
+
+	dir, err := CleanPath(dir)
 	if err != nil {
 		return http.StatusBadRequest, nil, err
 	}
 
-	return http.StatusOK, ret, nil
-}
-
-func (s *StorageData) UnderDir(dir string) (int, []byte, error) {
-
 	mapFileMetadata, err := s.GetMetadataJSON()
 
 	if err != nil {
-		return http.StatusBadRequest, nil, err
+		return http.StatusServiceUnavailable, nil, err
 	}
 
-	mapreturn := make(map[string]interface{})
+	mapreturn := make(map[string]FileMetadata)
 	for k, v := range mapFileMetadata {
-		item, ok := v.(map[string]interface{})
-		if !ok {
-			return http.StatusBadRequest, nil, err
-		}
-		d, ok := item["path"].(string)
-		if !ok {
-			return http.StatusBadRequest, nil, err
+		if _, ok := under(v.Path, dir); ok {
+			mapreturn[k] = v
 		}
+	}
 
-		if strings.HasPrefix(d, dir) {
-			m := make(map[string]interface{})
-			m[k] = v
-			mapreturn = helper.MergeMaps(mapreturn, m)
-		}
+	return http.StatusOK, mapreturn, nil
+}
 
-	}
+func (s *StorageData) ByID(id string) (int, FileMetadata, error) {
 
-	ret, err := json.Marshal(mapreturn)
+	entry, err := s.getEntry(id)
 	if err != nil {
-		return http.StatusBadRequest, nil, err
+		return statusOf(err), FileMetadata{}, err
 	}
 
-	return http.StatusOK, ret, nil
+	return http.StatusOK, entry, nil
 }
 
-func (s *StorageData) ByID(id string) (int, []byte, error) {
+func (s *StorageData) MoveFile(id, toDir string) (int, error) {
+	if toDir == "" {
+		return http.StatusBadRequest, fmt.Errorf("missing directory: %w", ErrInvalidPath)
+	}
 
-	mapFileMetadata, err := s.GetMetadataJSON()
+	status, _, err := s.RenameFile(id, toDir, "", ConflictFail)
+	return status, err
+}
+
+// RenameFile moves the file to toDir and renames it to name. An empty toDir
+// or name keeps the current one. When the new path is taken, policy decides
+// what happens; by default the move fails.
+func (s *StorageData) RenameFile(id, toDir, name string, policy ConflictPolicy) (int, FileMetadata, error) {
+	unlock := s.ids.lock(id)
+	defer unlock()
+
+	toDir, name, err := cleanTarget(toDir, name)
+	if err == nil {
+		err = policy.check()
+	}
 	if err != nil {
-		return http.StatusBadRequest, nil, err
+		return http.StatusBadRequest, FileMetadata{}, err
 	}
 
-	mapWithId, ok := mapFileMetadata[id].(map[string]interface{})
-	if !ok {
-		return http.StatusBadRequest, nil, err
+	// Content stored before blobs existed is moved into the blob store
+	// first, so only the index changes and nothing is renamed on disk.
+	entry, err := s.getEntry(id)
+	if err == nil {
+		_, err = s.migrateLegacy(entry)
+	}
+	if err != nil {
+		return statusOf(err), FileMetadata{}, err
 	}
 
-	ret, err := json.Marshal(mapWithId)
+	status, entry, replaced, err := s.renameEntry(id, toDir, name, policy.or(ConflictFail))
 	if err != nil {
-		return http.StatusBadRequest, nil, err
+		return status, FileMetadata{}, err
 	}
 
-	return http.StatusOK, ret, nil
+	s.releaseEntries(replaced)
+	return http.StatusOK, entry, nil
 }
 
-func (s *StorageData) MoveFile(id, toDir string) (int, error) {
+func (s *StorageData) renameEntry(id, toDir, name string, policy ConflictPolicy) (int, FileMetadata, []FileMetadata, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	mapFileMetadata, err := s.GetMetadataJSON()
+	// The entry is read under mu so a directory moved meanwhile isn't undone.
+	entry, err := s.lookup(id)
 	if err != nil {
-		return http.StatusBadRequest, err
+		return statusOf(err), FileMetadata{}, nil, err
 	}
-
-	mapWithId, ok := mapFileMetadata[id].(map[string]interface{})
-	if !ok {
-		return http.StatusBadRequest, err
+	if toDir == "" {
+		toDir = path.Dir(entry.Path)
 	}
-
-	fromPath, ok := mapWithId["path"].(string)
-	if !ok {
-		return http.StatusBadRequest, err
+	if name == "" {
+		name = entry.Name
 	}
 
-	nameFile, ok := mapWithId["name"].(string)
-	if !ok {
-		return http.StatusBadRequest, err
-	}
-
-	toDirComplete := filepath.Join(getStorageDir(), toDir)
-
-	toDirAndFile := toDirComplete + "/" + nameFile
-
-	os.MkdirAll(toDirComplete, os.ModePerm)
-	err = os.Rename(filepath.Join(getStorageDir(), fromPath), toDirAndFile)
+	newPath, replaced, err := s.resolvePath(toDir, name, id, policy)
 	if err != nil {
-		return http.StatusBadRequest, err
+		return statusOf(err), FileMetadata{}, nil, err
+	}
+	entry.Path = newPath
+	entry.Name = path.Base(newPath)
+	if err := s.checkMoveQuotas([]FileMetadata{entry}); err != nil {
+		return statusOf(err), FileMetadata{}, nil, err
+	}
+	if err := s.dropEntries(replaced); err != nil {
+		return http.StatusServiceUnavailable, FileMetadata{}, nil, err
 	}
 
-	mapWithId["path"] = toDir + "/" + nameFile
-	mapFileMetadata[id] = mapWithId
-	err = s.WriteMetadataInDisk(mapFileMetadata)
+	err = s.store.Put(id, entry)
 	if err != nil {
-		return http.StatusBadRequest, err
+		return http.StatusServiceUnavailable, FileMetadata{}, nil, unavailable(err)
 	}
 
-	return http.StatusOK, nil
+	return http.StatusOK, entry, replaced, nil
 }
 
 func (s *StorageData) DeleteByID(id string) (int, error) {
+	unlock := s.ids.lock(id)
+	defer unlock()
 
-	mapFileMetadata, err := s.GetMetadataJSON()
+	return s.deleteByID(id)
+}
+
+func (s *StorageData) deleteByID(id string) (int, error) {
+
+	entry, err := s.getEntry(id)
 	if err != nil {
-		return http.StatusBadRequest, err
+		return statusOf(err), err
 	}
 
-	mapWithId, ok := mapFileMetadata[id].(map[string]interface{})
-	if !ok {
-		return http.StatusBadRequest, err
+	if s.trashRetention > 0 {
+		err = s.trashEntry(entry)
+		if err == nil {
+			return http.StatusOK, nil
+		}
+		// Legacy content that is already missing has nothing to keep, so
+		// its entry is deleted for good.
+		if entry.SHA256 != "" || !errors.Is(err, ErrNotFound) {
+			return statusOf(err), err
+		}
 	}
 
-	path := ""
-	if path, ok = mapWithId["path"].(string); !ok {
-		return http.StatusBadRequest, err
-	}
-	path = filepath.Join(getStorageDir(), path)
+	if entry.SHA256 == "" {
+		err = os.Remove(filepath.Join(s.root, entry.Path))
+		if err != nil && !os.IsNotExist(err) {
+			return http.StatusServiceUnavailable, unavailable(err)
+		}
 
-	err = os.Remove(path)
-	if err != nil {
-		return http.StatusBadRequest, err
+		err = s.deleteEntry(id)
+		if err != nil {
+			return http.StatusServiceUnavailable, err
+		}
+
+		return http.StatusOK, nil
 	}
 
-	delete(mapFileMetadata, id)
-	err = s.WriteMetadataInDisk(mapFileMetadata)
+	unlockBlob := s.blobs.lock(entry.SHA256)
+	err = s.deleteEntry(id)
+	if err == nil {
+		err = s.releaseBlob(entry.SHA256)
+	}
+	unlockBlob()
 	if err != nil {
-		return http.StatusBadRequest, err
+		return http.StatusServiceUnavailable, unavailable(err)
 	}
 
+	for _, v := range entry.Versions {
+		s.releaseHash(v.SHA256)
+	}
 	return http.StatusOK, nil
 }
 
-func (s *StorageData) OverwriteFile(id string, body map[string]interface{}) (int, []byte, error) {
+func (s *StorageData) OverwriteFile(id string, req UploadRequest) (int, FileMetadata, error) {
+	unlock := s.ids.lock(id)
+	defer unlock()
 
-	_, err := s.DeleteByID(id)
+	req, err := validateRequest(req)
 	if err != nil {
-		return http.StatusBadRequest, nil, err
+		fmt.Printf("[OverwriteFile] Wrong body format. Error: %s", err)
+		return http.StatusBadRequest, FileMetadata{}, err
 	}
 
-	file, fullPath, typeFile, err := s.saveFileInDisk(body)
-	if err != nil {
-		return http.StatusBadRequest, nil, err
+	previous, err := s.getEntry(id)
+	if err == nil {
+		previous, err = s.migrateLegacy(previous)
 	}
-	defer file.Close()
-
-	mapFileMetadata, err := s.GetMetadataJSON()
 	if err != nil {
-		return http.StatusBadRequest, nil, err
+		return statusOf(err), FileMetadata{}, err
 	}
 
-	metadata, err := file.Stat()
+	// The replaced content stays reachable as a version, and the file keeps
+	// its owner.
+	owner := previous.Owner
+	if owner == "" {
+		owner = req.Owner
+	}
+	if err := s.precheckQuotas(owner, req.Path, req.Size, id); err != nil {
+		return statusOf(err), FileMetadata{}, err
+	}
+	entry, err := s.storeContent(id, req, FileMetadata{
+		Owner:    owner,
+		Version:  previous.currentVersion() + 1,
+		Versions: append(previous.Versions, previous.asVersion()),
+	})
 	if err != nil {
-		return http.StatusBadRequest, nil, err
+		return statusOf(err), FileMetadata{}, err
 	}
 
-	dataToOverWrite := map[string]interface{}{
-		"name":             metadata.Name(),
-		"path":             fullPath,
-		"type":             typeFile,
-		"size":             metadata.Size(),
-		"modificationTime": metadata.ModTime(),
-	}
+	return http.StatusOK, entry, nil
 
-	mapFileMetadata[id] = dataToOverWrite
+}
 
-	err = s.WriteMetadataInDisk(mapFileMetadata)
+// FindByPath returns the entry of the file stored at path.
+func (s *StorageData) FindByPath(path string) (int, FileMetadata, error) {
+	path, err := CleanPath(strings.TrimPrefix(path, "/"))
 	if err != nil {
-		return http.StatusBadRequest, nil, err
+		return http.StatusBadRequest, FileMetadata{}, err
 	}
 
-	ret, err := json.Marshal(dataToOverWrite)
+	s.mu.RLock()
+	entries, err := s.store.ByPath(path)
+	s.mu.RUnlock()
 	if err != nil {
-		return http.StatusBadRequest, nil, err
+		return http.StatusServiceUnavailable, FileMetadata{}, unavailable(err)
 	}
 
-	return http.StatusOK, ret, nil
+	// Files kept with ConflictKeepBoth share a path; the newest wins.
+	var found *FileMetadata
+	for _, entry := range entries {
+		if found == nil || newer(entry, *found) {
+			entry := entry
+			found = &entry
+		}
+	}
+	if found == nil {
+		return http.StatusNotFound, FileMetadata{}, fmt.Errorf("file %s: %w", path, ErrNotFound)
+	}
 
+	return http.StatusOK, *found, nil
 }
 
-func (s *StorageData) openMetadataJSON(flags int) (*os.File, error) {
-	mtpath := getMetaDataDir()
+// OpenByPath opens the content of the file stored at path.
+func (s *StorageData) OpenByPath(path string) (int, *os.File, FileMetadata, error) {
 
-	metadataFile, err := os.OpenFile(mtpath, flags, os.ModePerm)
+	statusCode, entry, err := s.FindByPath(path)
 	if err != nil {
-		return nil, err
+		return statusCode, nil, FileMetadata{}, err
 	}
 
-	return metadataFile, nil
-}
-
-func (s *StorageData) saveFileInDisk(body map[string]interface{}) (*os.File, string, string, error) {
-
-	err := validateBody(body)
+	file, err := s.openContent(entry)
 	if err != nil {
-		fmt.Printf("[saveFileInDisk] Wrong body format. Error: %s", err)
-		return nil, "", "", err
+		return statusOf(err), nil, FileMetadata{}, err
 	}
 
-	f := body["file"].(bytes.Buffer)
-	path := body["path"].(string)
-	name := body["name"].(string)
-	typeFile := body["type"].(string)
-
-	dir := filepath.Join(getStorageDir(), path)
-	_ = os.MkdirAll(dir, os.ModePerm)
-	fullPath := filepath.Join(dir, name)
-	_, fileExists := os.Stat(fullPath)
+	return http.StatusOK, file, entry, nil
+}
 
-	count := 1
-	for fileExists == nil {
-		name = fmt.Sprintf("%s(%v).%s", name, count, typeFile)
-		fullPath = fmt.Sprintf("%s/%s", path, name)
-		count++
-		_, fileExists = os.Stat(fullPath)
-	}
+// OpenByID opens the content of the file with the given ID.
+func (s *StorageData) OpenByID(id string) (int, *os.File, FileMetadata, error) {
 
-	file, err := os.OpenFile(fullPath, os.O_WRONLY|os.O_CREATE, os.ModePerm)
+	entry, err := s.getEntry(id)
 	if err != nil {
-		return nil, "", "", fmt.Errorf("error in open file: %s / %v", fullPath, err)
+		return statusOf(err), nil, FileMetadata{}, err
 	}
-	// Copy the file to the destination path
-	_, err = io.Copy(file, bytes.NewReader(f.Bytes()))
+
+	file, err := s.openContent(entry)
 	if err != nil {
-		return nil, "", "", fmt.Errorf("error in copy: %v", err)
+		return statusOf(err), nil, FileMetadata{}, err
 	}
 
-	return file, filepath.Join(path, name), typeFile, err
+	return http.StatusOK, file, entry, nil
 }
 
-func (s *StorageData) WriteMetadataInDisk(newMetadata map[string]interface{}) error {
-
-	fileMetadata, err := s.openMetadataJSON(os.O_CREATE | os.O_WRONLY | os.O_TRUNC)
+// openContent opens the bytes of entry. A file deleted since its entry was
+// read is reported as not found.
+func (s *StorageData) openContent(entry FileMetadata) (*os.File, error) {
+	file, err := os.Open(s.contentPath(entry))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("file %s: %w", entry.ID, ErrNotFound)
+	}
 	if err != nil {
-		return err
+		return nil, unavailable(err)
 	}
-	defer fileMetadata.Close()
+	return file, nil
+}
 
-	mapMetadataIdent, err := json.MarshalIndent(newMetadata, "", "	")
+// storeContent saves the content of req and records it as the entry id, or
+// a new one when id is empty. The owner and version fields are taken from
+// template.
+func (s *StorageData) storeContent(id string, req UploadRequest, template FileMetadata) (FileMetadata, error) {
+	hash, size, unlockBlob, err := s.saveBlob(req.Content, req.Digest)
+	if err != nil {
+		return FileMetadata{}, err
+	}
+
+	s.mu.Lock()
+	err = s.checkQuotas(template.Owner, req.Path, size, id)
+	var entry FileMetadata
+	var replaced []FileMetadata
+	if err == nil {
+		entry, replaced, err = s.addEntry(id, req.Path, req.Name, req.Conflict.or(ConflictRename), FileMetadata{
+			ContentType: req.ContentType,
+			Size:        size,
+			SHA256:      hash,
+			Owner:       template.Owner,
+			Version:     template.Version,
+			Versions:    template.Versions,
+		})
+	}
+	s.mu.Unlock()
+	if err != nil {
+		// Nothing refers to content refused by a quota or a conflict.
+		if releaseErr := s.releaseBlob(hash); releaseErr != nil {
+			fmt.Printf("[storeContent] Error releasing blob %s. Error: %s", hash, releaseErr)
+		}
+	}
+	unlockBlob()
 	if err != nil {
-		return err
+		return FileMetadata{}, err
 	}
 
-	_, err = io.Copy(fileMetadata, bytes.NewReader(mapMetadataIdent))
-	if err != nil {
-		return err
+	s.releaseEntries(replaced)
+	return entry, nil
+}
+
+// freeID returns the content addressed ID for a new entry, skipping those
+// of trashed files. Called with s.mu held.
+func (s *StorageData) freeID(hash, path string) (string, error) {
+	for n := 0; ; n++ {
+		id := contentID(hash, path, n)
+		_, exists, err := s.store.Get(id)
+		if err != nil {
+			return "", unavailable(err)
+		}
+		if !exists {
+			exists, err = s.trashed(id)
+		}
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return id, nil
+		}
 	}
+}
+
+func (s *StorageData) GetMetadataJSON() (map[string]FileMetadata, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	return nil
+	all, err := s.store.All()
+	return all, unavailable(err)
 }
 
-func (s *StorageData) GetMetadataJSON() (map[string]interface{}, error) {
-	mtpath := getMetaDataDir()
+// getEntry returns the entry of id or an error matching ErrNotFound.
+func (s *StorageData) getEntry(id string) (FileMetadata, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	fileMetadata, err := ioutil.ReadFile(mtpath)
-	if err != nil {
-		return nil, err
-	}
+	return s.lookup(id)
+}
 
-	mapFileMetadata := make(map[string]interface{})
-	err = json.Unmarshal(fileMetadata, &mapFileMetadata)
+// lookup is getEntry for callers already holding s.mu.
+func (s *StorageData) lookup(id string) (FileMetadata, error) {
+	entry, ok, err := s.store.Get(id)
 	if err != nil {
-		return nil, err
+		return FileMetadata{}, unavailable(err)
 	}
-	return mapFileMetadata, nil
+	if !ok {
+		return FileMetadata{}, fmt.Errorf("file %s: %w", id, ErrNotFound)
+	}
+	return entry, nil
 }
 
-func validateBody(body map[string]interface{}) error {
-	var fieldsMissing []string
+func (s *StorageData) deleteEntry(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	value, ok := body["path"].(string)
-	if !ok || value == "" {
-		fieldsMissing = append(fieldsMissing, "path")
-	}
+	return unavailable(s.store.Delete(id))
+}
 
-	_, aa := body["file"].(bytes.Buffer)
-	if !aa {
-		fieldsMissing = append(fieldsMissing, "file")
+func newer(a, b FileMetadata) bool {
+	if !a.ModTime.Equal(b.ModTime) {
+		return a.ModTime.After(b.ModTime)
 	}
+	return a.ID > b.ID
+}
 
-	if len(fieldsMissing) > 0 {
-		return errors.New("missing fields: " + strings.Join(fieldsMissing, ", "))
+// cleanTarget cleans the directory and name a file is moved or copied to.
+// Unlike an uploaded file name, name must be a single segment.
+func cleanTarget(toDir, name string) (string, string, error) {
+	toDir, err := CleanPath(toDir)
+	if err != nil || name == "" {
+		return toDir, name, err
 	}
 
-	return nil
+	name, err = CleanPath(name)
+	if err == nil && (name == "" || strings.Contains(name, "/")) {
+		err = fmt.Errorf("invalid file name %q: %w", name, ErrInvalidPath)
+	}
+	return toDir, name, err
 }
 
-func New() *StorageData {
+// validateRequest returns req with its path and name cleaned.
+func validateRequest(req UploadRequest) (UploadRequest, error) {
+	if req.Path == "" {
+		return req, fmt.Errorf("missing field path: %w", ErrInvalidPath)
+	}
 
-	sd := StorageData{}
+	if req.Content == nil {
+		return req, fmt.Errorf("missing field file: %w", ErrInvalidRequest)
+	}
+	if err := req.Conflict.check(); err != nil {
+		return req, err
+	}
 
-	return &sd
+	var err error
+	req.Path, err = CleanPath(req.Path)
+	if err != nil {
+		return req, err
+	}
+	req.Name, err = CleanName(req.Name)
+	return req, err
 }
 
-func getStorageDir() string {
-	_, b, _, _ := runtime.Caller(0)
-	basepath := filepath.Dir(b)
-	if strings.Contains(basepath, "storagedata") {
-		return basepath
+// statusOf is the HTTP status returned alongside err.
+func statusOf(err error) int {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, ErrInvalidPath), errors.Is(err, ErrInvalidRequest), errors.Is(err, ErrChecksumMismatch):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, ErrQuotaExceeded):
+		return http.StatusInsufficientStorage
+	default:
+		return http.StatusServiceUnavailable
 	}
-	path := filepath.Join(basepath, "storagedata")
+}
 
-	return path
+func (s *StorageData) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+	return s.store.Close()
 }
 
-func getMetaDataDir() string {
-	_, b, _, _ := runtime.Caller(0)
-	basepath := filepath.Dir(b)
-	path := filepath.Join(basepath, "storagedata/metadata.json")
-	if strings.Contains(basepath, "storagedata") {
-		path = filepath.Join(basepath, "metadata.json")
+func New(opts ...Option) *StorageData {
+
+	sd := StorageData{
+		root:           ".",
+		uploadExpiry:   defaultUploadExpiry,
+		trashRetention: defaultTrashRetention,
+		done:           make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(&sd)
+	}
+	if sd.store == nil {
+		sd.store = NewJSONStore(filepath.Join(sd.root, "metadata.json"))
 	}
-	return path
+
+	go sd.janitor(time.Hour)
+
+	return &sd
 }
@@ -4,57 +4,67 @@ import (
 	"americanas/storagedata"
 	"americanas/test"
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 )
 
 type fixture struct {
-	sd *storagedata.StorageData
+	sd  *storagedata.StorageData
+	dir string
 }
 
-func setup() *fixture {
+func setup(t *testing.T, opts ...storagedata.Option) *fixture {
+	dir, err := ioutil.TempDir("", "storagedata")
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	sd := storagedata.New()
+	sd := storagedata.New(append([]storagedata.Option{storagedata.WithRoot(dir)}, opts...)...)
 	return &fixture{
-		sd: sd,
+		sd:  sd,
+		dir: dir,
 	}
 }
 
+func (f *fixture) close() {
+	f.sd.Close()
+	os.RemoveAll(f.dir)
+}
+
 func TestStorageFile(t *testing.T) {
 	testCase := "TestStorageFile"
 
-	f := setup()
+	f := setup(t)
+	defer f.close()
 
 	e := createFile("earth.png")
 
-	req := map[string]interface{}{
-		"path": "ht/monthly",
-		"file": e,
-		"name": "earth.png",
-		"type": "png",
-	}
-
-	status, fileID, metadata, err := f.sd.StorageFile(req)
+	req := uploadRequest("ht/monthly", "earth.png", e)
 
-	md := metadata[string(fileID)].(map[string]interface{})
-	modificationTime := md["modificationTime"]
+	status, metadata, err := f.sd.StorageFile(req)
 
-	metadataExpected := map[string]interface{}{
-		string(fileID): map[string]interface{}{
-			"modificationTime": modificationTime,
-			"name":             "earth.png",
-			"path":             "ht/monthly/earth.png",
-			"size":             int64(312866),
-			"type":             "png",
-		},
+	metadataExpected := storagedata.FileMetadata{
+		ID:          metadata.ID,
+		ModTime:     metadata.ModTime,
+		Name:        "earth.png",
+		Path:        "ht/monthly/earth.png",
+		Size:        int64(312866),
+		ContentType: "png",
+		SHA256:      sha256Hex(e),
 	}
 
-	f.sd.DeleteByID(string(fileID))
+	f.sd.DeleteByID(metadata.ID)
 	test.AssertEqual(t, testCase, metadata, metadataExpected)
 	test.AssertEqual(t, testCase, status, http.StatusOK)
 	test.AssertNil(t, testCase, err)
@@ -63,37 +73,30 @@ func TestStorageFile(t *testing.T) {
 
 func TestAllFiles(t *testing.T) {
 	testCase := "TestAllFiles"
-	f := setup()
+	f := setup(t)
+	defer f.close()
 
 	e := createFile("earth.png")
 
-	req := map[string]interface{}{
-		"path": "ht/monthly",
-		"file": e,
-		"name": "earth.png",
-		"type": "png",
-	}
+	req := uploadRequest("ht/monthly", "earth.png", e)
 
-	_, fileIDEarth, metadata, _ := f.sd.StorageFile(req)
+	_, metadata, _ := f.sd.StorageFile(req)
 
-	status, body, err := f.sd.AllFiles()
-	var actual map[string]interface{}
-	if err := json.Unmarshal(body, &actual); err != nil {
-		fmt.Println(err)
-	}
+	status, actual, err := f.sd.AllFiles()
 
-	md := metadata[string(fileIDEarth)].(map[string]interface{})
-	metadataExpected := map[string]interface{}{
-		string(fileIDEarth): map[string]interface{}{
-			"modificationTime": md["modificationTime"],
-			"name":             "earth.png",
-			"path":             "ht/monthly/earth.png",
-			"size":             float64(312866),
-			"type":             "png",
+	metadataExpected := map[string]storagedata.FileMetadata{
+		metadata.ID: {
+			ID:          metadata.ID,
+			ModTime:     metadata.ModTime,
+			Name:        "earth.png",
+			Path:        "ht/monthly/earth.png",
+			Size:        int64(312866),
+			ContentType: "png",
+			SHA256:      sha256Hex(e),
 		},
 	}
 
-	f.sd.DeleteByID(string(fileIDEarth))
+	f.sd.DeleteByID(metadata.ID)
 	test.AssertEqual(t, testCase, status, http.StatusOK)
 	test.AssertEqual(t, testCase, actual, metadataExpected)
 	test.AssertNil(t, testCase, err)
@@ -103,46 +106,34 @@ func TestAllFiles(t *testing.T) {
 func TestUnderDir(t *testing.T) {
 	testCase := "TestUnderDir"
 
-	f := setup()
+	f := setup(t)
+	defer f.close()
 
 	e := createFile("earth.png")
-	req := map[string]interface{}{
-		"path": "space/planets",
-		"file": e,
-		"name": "earth.png",
-		"type": "png",
-	}
-	_, fileIDEarth, metadataEarth, _ := f.sd.StorageFile(req)
+	req := uploadRequest("space/planets", "earth.png", e)
+	_, metadataEarth, _ := f.sd.StorageFile(req)
 
 	p := createFile("perseverance.png")
-	req = map[string]interface{}{
-		"path": "space/robots",
-		"file": p,
-		"name": "perseverance.png",
-		"type": "png",
-	}
-	_, fileIDPerseverance, _, _ := f.sd.StorageFile(req)
+	req = uploadRequest("space/robots", "perseverance.png", p)
+	_, metadataPerseverance, _ := f.sd.StorageFile(req)
 
 	dir := "space/planets"
-	status, body, err := f.sd.UnderDir(dir)
-	var actual map[string]interface{}
-	if err := json.Unmarshal(body, &actual); err != nil {
-		fmt.Println(err)
-	}
-
-	md := metadataEarth[string(fileIDEarth)].(map[string]interface{})
-	metadataExpected := map[string]interface{}{
-		string(fileIDEarth): map[string]interface{}{
-			"modificationTime": md["modificationTime"],
-			"name":             "earth.png",
-			"path":             "space/planets/earth.png",
-			"size":             float64(312866),
-			"type":             "png",
+	status, actual, err := f.sd.UnderDir(dir)
+
+	metadataExpected := map[string]storagedata.FileMetadata{
+		metadataEarth.ID: {
+			ID:          metadataEarth.ID,
+			ModTime:     metadataEarth.ModTime,
+			Name:        "earth.png",
+			Path:        "space/planets/earth.png",
+			Size:        int64(312866),
+			ContentType: "png",
+			SHA256:      sha256Hex(e),
 		},
 	}
 
-	f.sd.DeleteByID(string(fileIDEarth))
-	f.sd.DeleteByID(string(fileIDPerseverance))
+	f.sd.DeleteByID(metadataEarth.ID)
+	f.sd.DeleteByID(metadataPerseverance.ID)
 	test.AssertEqual(t, testCase, status, http.StatusOK)
 	test.AssertEqual(t, testCase, actual, metadataExpected)
 	test.AssertNil(t, testCase, err)
@@ -152,33 +143,26 @@ func TestUnderDir(t *testing.T) {
 func TestByID(t *testing.T) {
 	testCase := "TestByID"
 
-	f := setup()
+	f := setup(t)
+	defer f.close()
 
 	e := createFile("earth.png")
-	req := map[string]interface{}{
-		"path": "space/planets",
-		"file": e,
-		"name": "earth.png",
-		"type": "png",
+	req := uploadRequest("space/planets", "earth.png", e)
+	_, metadataEarth, _ := f.sd.StorageFile(req)
+
+	status, actual, err := f.sd.ByID(metadataEarth.ID)
+
+	metadataExpected := storagedata.FileMetadata{
+		ID:          metadataEarth.ID,
+		ModTime:     metadataEarth.ModTime,
+		Name:        "earth.png",
+		Path:        "space/planets/earth.png",
+		Size:        int64(312866),
+		ContentType: "png",
+		SHA256:      sha256Hex(e),
 	}
-	_, fileIDEarth, metadataEarth, _ := f.sd.StorageFile(req)
 
-	status, body, err := f.sd.ByID(string(fileIDEarth))
-	var actual map[string]interface{}
-	if err := json.Unmarshal(body, &actual); err != nil {
-		fmt.Println(err)
-	}
-
-	md := metadataEarth[string(fileIDEarth)].(map[string]interface{})
-	metadataExpected := map[string]interface{}{
-		"modificationTime": md["modificationTime"],
-		"name":             "earth.png",
-		"path":             "space/planets/earth.png",
-		"size":             float64(312866),
-		"type":             "png",
-	}
-
-	f.sd.DeleteByID(string(fileIDEarth))
+	f.sd.DeleteByID(metadataEarth.ID)
 	test.AssertEqual(t, testCase, status, http.StatusOK)
 	test.AssertEqual(t, testCase, actual, metadataExpected)
 	test.AssertNil(t, testCase, err)
@@ -188,18 +172,14 @@ func TestByID(t *testing.T) {
 func TestMoveFile(t *testing.T) {
 	testCase := "TestMoveFile"
 
-	f := setup()
+	f := setup(t)
+	defer f.close()
 
 	e := createFile("earth.png")
-	req := map[string]interface{}{
-		"path": "space/planets",
-		"file": e,
-		"name": "earth.png",
-		"type": "png",
-	}
-	_, fileIDEarth, _, _ := f.sd.StorageFile(req)
+	req := uploadRequest("space/planets", "earth.png", e)
+	_, metadataEarth, _ := f.sd.StorageFile(req)
 
-	id := string(fileIDEarth)
+	id := metadataEarth.ID
 	toDir := "newproject/go"
 
 	status, err := f.sd.MoveFile(id, toDir)
@@ -208,32 +188,114 @@ func TestMoveFile(t *testing.T) {
 	if errM != nil {
 		fmt.Println(err)
 	}
-	actualMetadata := m[id].(map[string]interface{})
-
-	actualPath := actualMetadata["path"].(string)
+	actualPath := m[id].Path
 
-	f.sd.DeleteByID(string(fileIDEarth))
+	f.sd.DeleteByID(id)
 	test.AssertEqual(t, testCase, status, http.StatusOK)
 	test.AssertEqual(t, testCase, actualPath, `newproject/go/earth.png`)
 	test.AssertNil(t, testCase, err)
 
 }
 
+func TestRenameFile(t *testing.T) {
+	testCase := "TestRenameFile"
+
+	f := setup(t)
+	defer f.close()
+
+	e := createFile("earth.png")
+	_, earth, _ := f.sd.StorageFile(uploadRequest("space/planets", "earth.png", e))
+	_, mars, _ := f.sd.StorageFile(uploadRequest("space/planets", "mars.png", e))
+
+	status, renamed, err := f.sd.RenameFile(earth.ID, "", "terra.png", "")
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+	test.AssertEqual(t, testCase, renamed.Path, "space/planets/terra.png")
+	test.AssertEqual(t, testCase, renamed.Name, "terra.png")
+
+	status, renamed, err = f.sd.RenameFile(earth.ID, "home", "earth.png", "")
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, renamed.Path, "home/earth.png")
+
+	_, stored, _ := f.sd.ByID(earth.ID)
+	test.AssertEqual(t, testCase, stored, renamed)
+
+	status, _, err = f.sd.RenameFile(mars.ID, "home", "earth.png", "")
+	test.AssertEqual(t, testCase, status, http.StatusConflict)
+	test.AssertEqual(t, testCase, errors.Is(err, storagedata.ErrConflict), true)
+
+	status, _, err = f.sd.RenameFile(mars.ID, "", "moons/phobos.png", "")
+	test.AssertEqual(t, testCase, status, http.StatusBadRequest)
+	test.AssertEqual(t, testCase, errors.Is(err, storagedata.ErrInvalidPath), true)
+
+	status, _, err = f.sd.RenameFile("unknown", "", "pluto.png", "")
+	test.AssertEqual(t, testCase, status, http.StatusNotFound)
+	test.AssertEqual(t, testCase, errors.Is(err, storagedata.ErrNotFound), true)
+}
+
+func TestMoveLegacyFiles(t *testing.T) {
+	testCase := "TestMoveLegacyFiles"
+
+	dir, err := ioutil.TempDir("", "storagedata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	e := createFile("earth.png")
+	m := createFile("mars.png")
+	store := storagedata.NewJSONStore(filepath.Join(dir, "metadata.json"))
+	for id, content := range map[string]bytes.Buffer{"space/planets/earth.png": e, "space/moons/mars.png": m} {
+		os.MkdirAll(filepath.Join(dir, filepath.Dir(id)), os.ModePerm)
+		ioutil.WriteFile(filepath.Join(dir, id), content.Bytes(), 0644)
+		store.Put(filepath.Base(id), storagedata.FileMetadata{
+			Name:    filepath.Base(id),
+			Path:    id,
+			Size:    int64(content.Len()),
+			ModTime: time.Now(),
+		})
+	}
+	sd := storagedata.New(storagedata.WithRoot(dir), storagedata.WithMetadataStore(store))
+	defer sd.Close()
+
+	// Legacy content is moved into the blob store, never renamed on disk.
+	_, moved, err := sd.RenameFile("earth.png", "space/backup", "", "")
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, moved.SHA256, sha256Hex(e))
+	_, err = os.Stat(filepath.Join(dir, "space/planets/earth.png"))
+	test.AssertEqual(t, testCase, os.IsNotExist(err), true)
+	_, err = os.Stat(filepath.Join(dir, "space/backup/earth.png"))
+	test.AssertEqual(t, testCase, os.IsNotExist(err), true)
+
+	_, err = sd.MoveDir("space/moons", "solar")
+	test.AssertNoError(t, testCase, err)
+	_, file, entry, err := sd.OpenByID("mars.png")
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, entry.Path, "solar/mars.png")
+	test.AssertEqual(t, testCase, entry.SHA256, sha256Hex(m))
+	content, _ := ioutil.ReadAll(file)
+	file.Close()
+	test.AssertEqual(t, testCase, content, m.Bytes())
+	_, err = os.Stat(filepath.Join(dir, "solar/mars.png"))
+	test.AssertEqual(t, testCase, os.IsNotExist(err), true)
+
+	// Nor can a file be moved into the blob store.
+	status, _, err := sd.RenameFile("mars.png", "blobs/"+moved.SHA256[:2], moved.SHA256, "")
+	test.AssertEqual(t, testCase, status, http.StatusBadRequest)
+	test.AssertEqual(t, testCase, errors.Is(err, storagedata.ErrInvalidPath), true)
+}
+
 func TestDeleteFile(t *testing.T) {
 	testCase := "TestDeleteFile"
 
-	f := setup()
+	f := setup(t)
+	defer f.close()
 
 	e := createFile("earth.png")
-	req := map[string]interface{}{
-		"path": "space/planets",
-		"file": e,
-		"name": "earth",
-		"type": "png",
-	}
-	_, fileIDEarth, _, _ := f.sd.StorageFile(req)
+	req := uploadRequest("space/planets", "earth", e)
+	_, metadataEarth, _ := f.sd.StorageFile(req)
 
-	status, err := f.sd.DeleteByID(string(fileIDEarth))
+	status, err := f.sd.DeleteByID(metadataEarth.ID)
 
 	test.AssertEqual(t, testCase, status, http.StatusOK)
 	test.AssertNil(t, testCase, err)
@@ -243,18 +305,14 @@ func TestDeleteFile(t *testing.T) {
 func TestOverwriteFile(t *testing.T) {
 	testCase := "TestOverwriteFile"
 
-	f := setup()
+	f := setup(t)
+	defer f.close()
 
 	e := createFile("earth.png")
 
-	req := map[string]interface{}{
-		"path": "ht/monthly",
-		"file": e,
-		"name": "earth.png",
-		"type": "png",
-	}
+	req := uploadRequest("ht/monthly", "earth.png", e)
 
-	_, fileID, meta, err := f.sd.StorageFile(req)
+	_, meta, err := f.sd.StorageFile(req)
 	fmt.Println(meta)
 	if err != nil {
 		fmt.Println(err)
@@ -262,34 +320,408 @@ func TestOverwriteFile(t *testing.T) {
 
 	m := createFile("mars.png")
 
-	newMarsFile := map[string]interface{}{
-		"path": "ht/monthly",
-		"file": m,
-		"name": "mars.png",
-		"type": "png",
+	newMarsFile := uploadRequest("ht/monthly", "mars.png", m)
+
+	expected := storagedata.FileMetadata{
+		Name:        "mars.png",
+		Path:        "ht/monthly/mars.png",
+		ContentType: "png",
+		Size:        int64(338135),
+	}
+
+	status, actual, err := f.sd.OverwriteFile(meta.ID, newMarsFile)
+
+	f.sd.DeleteByID(meta.ID)
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+	test.AssertEqual(t, testCase, actual.ID, meta.ID)
+	test.AssertEqual(t, testCase, actual.Name, expected.Name)
+	test.AssertEqual(t, testCase, actual.Path, expected.Path)
+	test.AssertEqual(t, testCase, actual.ContentType, expected.ContentType)
+	test.AssertEqual(t, testCase, actual.Size, expected.Size)
+	test.AssertNil(t, testCase, err)
+
+}
+
+func TestBoltMetadataStore(t *testing.T) {
+	testCase := "TestBoltMetadataStore"
+
+	dir, err := ioutil.TempDir("", "metadata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := storagedata.NewBoltStore(filepath.Join(dir, "metadata.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sd := storagedata.New(storagedata.WithRoot(dir), storagedata.WithMetadataStore(store))
+	defer sd.Close()
+
+	e := createFile("earth.png")
+	req := uploadRequest("space/planets", "earth.png", e)
+	_, metadataEarth, err := sd.StorageFile(req)
+	test.AssertNil(t, testCase, err)
+
+	status, actual, err := sd.ByID(metadataEarth.ID)
+
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+	test.AssertEqual(t, testCase, actual.Path, "space/planets/earth.png")
+	test.AssertEqual(t, testCase, actual.Size, int64(312866))
+	test.AssertNil(t, testCase, err)
+
+	status, err = sd.DeleteByID(metadataEarth.ID)
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+	test.AssertNil(t, testCase, err)
+
+	all, err := sd.GetMetadataJSON()
+	test.AssertEqual(t, testCase, len(all), 0)
+	test.AssertNil(t, testCase, err)
+
+	test.AssertNoError(t, testCase, store.PutAll([]storagedata.FileMetadata{
+		{ID: "mars", Path: "space/planets/mars.png"},
+		{ID: "venus", Path: "space/planets/venus.png"},
+	}))
+	all, err = sd.GetMetadataJSON()
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, all["venus"].Path, "space/planets/venus.png")
+	test.AssertEqual(t, testCase, len(all), 2)
+}
+
+func TestMetadataStoreIndexes(t *testing.T) {
+	testCase := "TestMetadataStoreIndexes"
+
+	dir, err := ioutil.TempDir("", "metadata")
+	if err != nil {
+		t.Fatal(err)
 	}
+	defer os.RemoveAll(dir)
+
+	stores := map[string]func() (storagedata.MetadataStore, error){
+		"json": func() (storagedata.MetadataStore, error) {
+			return storagedata.NewJSONStore(filepath.Join(dir, "metadata.json")), nil
+		},
+		"bolt": func() (storagedata.MetadataStore, error) {
+			return storagedata.NewBoltStore(filepath.Join(dir, "metadata.db"))
+		},
+	}
+
+	for name, open := range stores {
+		store, err := open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		store.Put("earth", storagedata.FileMetadata{Path: "space/planets/earth.png"})
+		store.Put("earth2", storagedata.FileMetadata{Path: "space/planets/earth.png", SHA256: "aa"})
+		store.Put("mars", storagedata.FileMetadata{Path: "space/planets/mars.png", SHA256: "aa"})
+		store.PutAll([]storagedata.FileMetadata{{ID: "mars", Path: "solar/mars.png", SHA256: "bb",
+			Versions: []storagedata.FileVersion{{Version: 1, SHA256: "aa"}}}})
+		store.Delete("earth2")
+
+		refs, err := store.Refs("aa")
+		test.AssertNoError(t, name+testCase, err)
+		test.AssertEqual(t, name+testCase, refs, 1)
+		refs, _ = store.Refs("bb")
+		test.AssertEqual(t, name+testCase, refs, 1)
+
+		entries, err := store.ByPath("space/planets/earth.png")
+		test.AssertNoError(t, name+testCase, err)
+		test.AssertEqual(t, name+testCase, entries, []storagedata.FileMetadata{{ID: "earth", Path: "space/planets/earth.png"}})
+		entries, _ = store.ByPath("space/planets/mars.png")
+		test.AssertEqual(t, name+testCase, len(entries), 0)
+
+		// The index survives reopening the store.
+		test.AssertNoError(t, name+testCase, store.Close())
+		store, err = open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries, _ = store.ByPath("solar/mars.png")
+		test.AssertEqual(t, name+testCase, len(entries), 1)
+		test.AssertEqual(t, name+testCase, entries[0].ID, "mars")
+		entries, _ = store.ByPath("solar")
+		test.AssertEqual(t, name+testCase, len(entries), 0)
+		store.Delete("mars")
+		refs, _ = store.Refs("aa")
+		test.AssertEqual(t, name+testCase, refs, 0)
+		store.Close()
+	}
+}
+
+func TestJSONStoreReplaysJournal(t *testing.T) {
+	testCase := "TestJSONStoreReplaysJournal"
+
+	dir, err := ioutil.TempDir("", "metadata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "metadata.json")
+
+	store := storagedata.NewJSONStore(path)
+	test.AssertNoError(t, testCase, store.Put("earth", storagedata.FileMetadata{Path: "space/planets/earth.png"}))
+	test.AssertNoError(t, testCase, store.Put("mars", storagedata.FileMetadata{Path: "space/planets/mars.png"}))
+	test.AssertNoError(t, testCase, store.Delete("earth"))
+	test.AssertNoError(t, testCase, store.PutAll([]storagedata.FileMetadata{
+		{ID: "mars", Path: "solarsystem/planets/mars.png"},
+		{ID: "venus", Path: "solarsystem/planets/venus.png"},
+	}))
+
+	// Simulate a crash in the middle of appending the next record.
+	journal, err := os.OpenFile(path+".journal", os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	journal.WriteString(`{"op":"put","id":"pluto","entry":{"pa`)
+	journal.Close()
+
+	recovered := storagedata.NewJSONStore(path)
+	all, err := recovered.All()
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, all, map[string]storagedata.FileMetadata{
+		"mars":  {ID: "mars", Path: "solarsystem/planets/mars.png"},
+		"venus": {ID: "venus", Path: "solarsystem/planets/venus.png"},
+	})
+
+	snapshot, _ := ioutil.ReadFile(path)
+	var onDisk map[string]storagedata.FileMetadata
+	json.Unmarshal(snapshot, &onDisk)
+	test.AssertEqual(t, testCase, onDisk, all)
+
+	info, _ := os.Stat(path + ".journal")
+	test.AssertEqual(t, testCase, info.Size(), int64(0))
+	test.AssertNoError(t, testCase, recovered.Close())
+}
 
-	expected := map[string]interface{}{
+func TestJSONStoreLoadsLegacyFormat(t *testing.T) {
+	testCase := "TestJSONStoreLoadsLegacyFormat"
+
+	dir, err := ioutil.TempDir("", "metadata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "metadata.json")
+
+	legacy := `{
+	"0cb90ac871279cc942de976882b71a00": {
+		"modificationTime": "09/11/2021 23:07:39",
+		"name": "golang.png",
+		"path": "ht/monthly/golang.png",
+		"size": 24357,
+		"type": "png"
+	},
+	"aab053840116dacaf13a062d909e5761": {
+		"modificationTime": "2021-09-11T23:07:39.696063108-03:00",
 		"name": "mars.png",
 		"path": "ht/monthly/mars.png",
-		"type": "png",
-		"size": float64(338135),
+		"size": 338135,
+		"type": "image/png"
 	}
+}`
+	ioutil.WriteFile(path, []byte(legacy), 0644)
 
-	status, body, err := f.sd.OverwriteFile(string(fileID), newMarsFile)
-	var actual map[string]interface{}
-	if err := json.Unmarshal(body, &actual); err != nil {
-		fmt.Println(err)
+	all, err := storagedata.NewJSONStore(path).All()
+	test.AssertNoError(t, testCase, err)
+
+	golang := all["0cb90ac871279cc942de976882b71a00"]
+	test.AssertEqual(t, testCase, golang.ID, "0cb90ac871279cc942de976882b71a00")
+	test.AssertEqual(t, testCase, golang.Path, "ht/monthly/golang.png")
+	test.AssertEqual(t, testCase, golang.Size, int64(24357))
+	test.AssertEqual(t, testCase, golang.ModTime.Equal(time.Date(2021, 9, 11, 23, 7, 39, 0, time.Local)), true)
+
+	mars := all["aab053840116dacaf13a062d909e5761"]
+	test.AssertEqual(t, testCase, mars.ContentType, "image/png")
+	test.AssertEqual(t, testCase, mars.ModTime.Equal(time.Date(2021, 9, 12, 2, 7, 39, 696063108, time.UTC)), true)
+}
+
+func TestConcurrentOperations(t *testing.T) {
+	testCase := "TestConcurrentOperations"
+
+	f := setup(t)
+	defer f.close()
+	e := createFile("earth.png")
+
+	const uploads = 16
+	ids := make([]string, uploads)
+	errs := make([]error, uploads)
+
+	var wg sync.WaitGroup
+	for i := 0; i < uploads; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req := uploadRequest("stress/uploads", "earth.png", e)
+			_, metadata, err := f.sd.StorageFile(req)
+			ids[i] = metadata.ID
+			errs[i] = err
+		}(i)
+	}
+	wg.Wait()
+
+	m, err := f.sd.GetMetadataJSON()
+	test.AssertNoError(t, testCase, err)
+	paths := make(map[string]bool)
+	for i, id := range ids {
+		test.AssertNoError(t, testCase, errs[i])
+		entry, ok := m[id]
+		if !ok {
+			t.Fatalf("%s: upload %d with id %s lost from metadata", testCase, i, id)
+		}
+		paths[entry.Path] = true
+	}
+	test.AssertEqual(t, testCase, len(paths), uploads)
+
+	for _, id := range ids {
+		for _, dir := range []string{"stress/a", "stress/b", "stress/c"} {
+			wg.Add(2)
+			go func(id, dir string) {
+				defer wg.Done()
+				f.sd.MoveFile(id, dir)
+			}(id, dir)
+			go func(id string) {
+				defer wg.Done()
+				f.sd.ByID(id)
+			}(id)
+		}
+	}
+	wg.Wait()
+
+	m, err = f.sd.GetMetadataJSON()
+	test.AssertNoError(t, testCase, err)
+	for _, id := range ids {
+		status, file, _, err := f.sd.OpenByPath(m[id].Path)
+		test.AssertEqual(t, testCase, status, http.StatusOK)
+		test.AssertNoError(t, testCase, err)
+		if file != nil {
+			file.Close()
+		}
 	}
 
-	f.sd.DeleteByID(string(fileID))
+	deleted := make(chan string, 2*uploads)
+	for _, id := range ids {
+		for i := 0; i < 2; i++ {
+			wg.Add(1)
+			go func(id string) {
+				defer wg.Done()
+				if status, _ := f.sd.DeleteByID(id); status == http.StatusOK {
+					deleted <- id
+				}
+			}(id)
+		}
+	}
+	wg.Wait()
+	close(deleted)
+
+	test.AssertEqual(t, testCase, len(deleted), uploads)
+	m, err = f.sd.GetMetadataJSON()
+	test.AssertNoError(t, testCase, err)
+	for _, id := range ids {
+		_, ok := m[id]
+		test.AssertEqual(t, testCase, ok, false)
+	}
+}
+
+func TestDeduplication(t *testing.T) {
+	testCase := "TestDeduplication"
+
+	f := setup(t, storagedata.WithTrashRetention(0))
+	defer f.close()
+
+	e := createFile("earth.png")
+	hash := sha256Hex(e)
+	blob := filepath.Join(f.dir, "blobs", hash[:2], hash)
+
+	var ids []string
+	for _, dir := range []string{"space/planets", "space/backup"} {
+		req := uploadRequest(dir, "earth.png", e)
+		_, metadata, err := f.sd.StorageFile(req)
+		test.AssertNoError(t, testCase, err)
+		test.AssertEqual(t, testCase, metadata.SHA256, hash)
+		ids = append(ids, metadata.ID)
+	}
+	test.AssertEqual(t, testCase, ids[0] != ids[1], true)
+
+	_, err := os.Stat(blob)
+	test.AssertNoError(t, testCase, err)
+
+	status, err := f.sd.DeleteByID(ids[0])
 	test.AssertEqual(t, testCase, status, http.StatusOK)
-	test.AssertEqual(t, testCase, actual["name"], expected["name"])
-	test.AssertEqual(t, testCase, actual["path"], expected["path"])
-	test.AssertEqual(t, testCase, actual["type"], expected["type"])
-	test.AssertEqual(t, testCase, actual["size"], expected["size"])
-	test.AssertNil(t, testCase, err)
+	test.AssertNoError(t, testCase, err)
+	_, err = os.Stat(blob)
+	test.AssertNoError(t, testCase, err)
+
+	status, err = f.sd.DeleteByID(ids[1])
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+	test.AssertNoError(t, testCase, err)
+	_, err = os.Stat(blob)
+	test.AssertEqual(t, testCase, os.IsNotExist(err), true)
+}
+
+func TestOpenByID(t *testing.T) {
+	testCase := "TestOpenByID"
+
+	f := setup(t)
+	defer f.close()
+
+	e := createFile("earth.png")
+	_, earth, _ := f.sd.StorageFile(uploadRequest("space/planets", "earth.png", e))
+
+	status, file, entry, err := f.sd.OpenByID(earth.ID)
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, entry, earth)
+	content, _ := ioutil.ReadAll(file)
+	file.Close()
+	test.AssertEqual(t, testCase, content, e.Bytes())
+
+	status, _, _, err = f.sd.OpenByID("unknown")
+	test.AssertEqual(t, testCase, status, http.StatusNotFound)
+	test.AssertEqual(t, testCase, errors.Is(err, storagedata.ErrNotFound), true)
+}
+
+func TestErrors(t *testing.T) {
+	testCase := "TestErrors"
 
+	f := setup(t)
+	defer f.close()
+
+	status, _, err := f.sd.ByID("unknown")
+	test.AssertEqual(t, testCase, status, http.StatusNotFound)
+	test.AssertEqual(t, testCase, errors.Is(err, storagedata.ErrNotFound), true)
+
+	status, err = f.sd.DeleteByID("unknown")
+	test.AssertEqual(t, testCase, status, http.StatusNotFound)
+	test.AssertEqual(t, testCase, errors.Is(err, storagedata.ErrNotFound), true)
+
+	e := createFile("earth.png")
+	status, _, err = f.sd.StorageFile(storagedata.UploadRequest{Name: "earth.png", Content: bytes.NewReader(e.Bytes())})
+	test.AssertEqual(t, testCase, status, http.StatusBadRequest)
+	test.AssertEqual(t, testCase, errors.Is(err, storagedata.ErrInvalidPath), true)
+
+	_, earth, _ := f.sd.StorageFile(uploadRequest("errors/a", "earth.png", e))
+	_, other, _ := f.sd.StorageFile(uploadRequest("errors/b", "earth.png", e))
+
+	status, err = f.sd.MoveFile(earth.ID, "errors/b")
+	test.AssertEqual(t, testCase, status, http.StatusConflict)
+	test.AssertEqual(t, testCase, errors.Is(err, storagedata.ErrConflict), true)
+
+	f.sd.DeleteByID(earth.ID)
+	f.sd.DeleteByID(other.ID)
+}
+
+func uploadRequest(dir, name string, b bytes.Buffer) storagedata.UploadRequest {
+	return storagedata.UploadRequest{
+		Path:        dir,
+		Name:        name,
+		ContentType: "png",
+		Content:     bytes.NewReader(b.Bytes()),
+	}
+}
+
+func sha256Hex(b bytes.Buffer) string {
+	sum := sha256.Sum256(b.Bytes())
+	return hex.EncodeToString(sum[:])
 }
 
 func createFile(fileName string) bytes.Buffer {
@@ -306,3 +738,26 @@ func createFile(fileName string) bytes.Buffer {
 
 	return b
 }
+
+func TestOwnerIsRecorded(t *testing.T) {
+	testCase := "TestOwnerIsRecorded"
+
+	f := setup(t)
+	defer f.close()
+
+	req := uploadRequest("space", "mars.png", *bytes.NewBufferString("red"))
+	req.Owner = "alice"
+	_, mars, err := f.sd.StorageFile(req)
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, mars.Owner, "alice")
+
+	// Overwriting a file doesn't change who owns it, a copy belongs to
+	// whoever made it.
+	req = uploadRequest("space", "mars.png", *bytes.NewBufferString("dusty"))
+	req.Owner = "bob"
+	_, mars, _ = f.sd.OverwriteFile(mars.ID, req)
+	test.AssertEqual(t, testCase, mars.Owner, "alice")
+
+	_, copied, _ := f.sd.CopyFile(mars.ID, "backup", "", "bob", "")
+	test.AssertEqual(t, testCase, copied.Owner, "bob")
+}
@@ -0,0 +1,289 @@
+package storagedata
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Deleted files are moved to the trash, kept in trash.json under the root,
+// and purged for good once they are older than the retention. Their blobs
+// stay referenced until then, and their IDs aren't given to new files so a
+// restore puts them back under the same ID.
+
+// TrashedFile is a deleted file. File is its entry as it was when deleted,
+// so File.Path is the path it is restored to.
+type TrashedFile struct {
+	File      FileMetadata `json:"file"`
+	DeletedAt time.Time    `json:"deletedAt"`
+}
+
+const defaultTrashRetention = 30 * 24 * time.Hour
+
+// WithTrashRetention sets how long deleted files are kept in the trash. A
+// zero retention turns the trash off: deletes are permanent.
+func WithTrashRetention(d time.Duration) Option {
+	return func(s *StorageData) {
+		s.trashRetention = d
+	}
+}
+
+// Trash returns the deleted files, most recently deleted first.
+func (s *StorageData) Trash() (int, []TrashedFile, error) {
+	s.mu.RLock()
+	trash, err := s.readTrash()
+	s.mu.RUnlock()
+	if err != nil {
+		return http.StatusServiceUnavailable, nil, err
+	}
+
+	files := make([]TrashedFile, 0, len(trash))
+	for _, item := range trash {
+		files = append(files, item)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if !files[i].DeletedAt.Equal(files[j].DeletedAt) {
+			return files[i].DeletedAt.After(files[j].DeletedAt)
+		}
+		return files[i].File.ID < files[j].File.ID
+	})
+	return http.StatusOK, files, nil
+}
+
+// RestoreFile puts a deleted file back at its original path. When that path
+// is taken, policy decides what happens; by default the file is renamed.
+func (s *StorageData) RestoreFile(id string, policy ConflictPolicy) (int, FileMetadata, error) {
+	unlock := s.ids.lock(id)
+	defer unlock()
+
+	if err := policy.check(); err != nil {
+		return http.StatusBadRequest, FileMetadata{}, err
+	}
+
+	entry, replaced, err := s.restoreEntry(id, policy.or(ConflictRename))
+	if err != nil {
+		return statusOf(err), FileMetadata{}, err
+	}
+
+	s.releaseEntries(replaced)
+	return http.StatusOK, entry, nil
+}
+
+func (s *StorageData) restoreEntry(id string, policy ConflictPolicy) (FileMetadata, []FileMetadata, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	trash, err := s.readTrash()
+	if err != nil {
+		return FileMetadata{}, nil, err
+	}
+	item, ok := trash[id]
+	if !ok {
+		return FileMetadata{}, nil, fmt.Errorf("trashed file %s: %w", id, ErrNotFound)
+	}
+
+	entry := item.File
+	newPath, replaced, err := s.resolvePath(path.Dir(entry.Path), entry.Name, id, policy)
+	if err != nil {
+		return FileMetadata{}, nil, err
+	}
+	if err := s.dropEntries(replaced); err != nil {
+		return FileMetadata{}, nil, err
+	}
+
+	// The entry goes back before it leaves the trash, so its blobs are
+	// referenced all along.
+	entry.Path = newPath
+	entry.Name = path.Base(newPath)
+	if err := s.store.Put(id, entry); err != nil {
+		return FileMetadata{}, nil, unavailable(err)
+	}
+	delete(trash, id)
+	if err := s.saveTrash(trash); err != nil {
+		s.store.Delete(id)
+		return FileMetadata{}, nil, err
+	}
+	return entry, replaced, nil
+}
+
+// PurgeTrash permanently deletes a file from the trash.
+func (s *StorageData) PurgeTrash(id string) (int, error) {
+	unlock := s.ids.lock(id)
+	defer unlock()
+
+	s.mu.Lock()
+	trash, err := s.readTrash()
+	if err != nil {
+		s.mu.Unlock()
+		return http.StatusServiceUnavailable, err
+	}
+	item, ok := trash[id]
+	if !ok {
+		s.mu.Unlock()
+		return http.StatusNotFound, fmt.Errorf("trashed file %s: %w", id, ErrNotFound)
+	}
+	delete(trash, id)
+	err = s.saveTrash(trash)
+	s.mu.Unlock()
+	if err != nil {
+		return http.StatusServiceUnavailable, err
+	}
+
+	s.releaseEntries([]FileMetadata{item.File})
+	return http.StatusOK, nil
+}
+
+// PurgeExpiredTrash permanently deletes every file that has been in the
+// trash longer than the retention and returns how many were purged.
+func (s *StorageData) PurgeExpiredTrash() (int, error) {
+	s.mu.Lock()
+	trash, err := s.readTrash()
+	if err != nil {
+		s.mu.Unlock()
+		return 0, err
+	}
+	var purged []FileMetadata
+	deadline := time.Now().Add(-s.trashRetention)
+	for id, item := range trash {
+		if item.DeletedAt.Before(deadline) {
+			purged = append(purged, item.File)
+			delete(trash, id)
+		}
+	}
+	if len(purged) > 0 {
+		err = s.saveTrash(trash)
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	s.releaseEntries(purged)
+	return len(purged), nil
+}
+
+// trashEntry moves entry to the trash. Content stored before blobs
+// existed is moved into the blob store first, so nothing is left at a path
+// another file may take. Called with the ID lock held.
+func (s *StorageData) trashEntry(entry FileMetadata) error {
+	entry, err := s.migrateLegacy(entry)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	trash, err := s.readTrash()
+	if err != nil {
+		return err
+	}
+	entry, err = s.lookup(entry.ID)
+	if err != nil {
+		return err
+	}
+
+	trash[entry.ID] = TrashedFile{File: entry, DeletedAt: time.Now()}
+	if err := s.saveTrash(trash); err != nil {
+		return err
+	}
+	if err := s.store.Delete(entry.ID); err != nil {
+		delete(trash, entry.ID)
+		s.saveTrash(trash)
+		return unavailable(err)
+	}
+	return nil
+}
+
+func (s *StorageData) trashPath() string {
+	return filepath.Join(s.root, "trash.json")
+}
+
+// readTrash loads the trashed files by ID. Called with s.mu held.
+func (s *StorageData) readTrash() (map[string]TrashedFile, error) {
+	trash := make(map[string]TrashedFile)
+
+	b, err := ioutil.ReadFile(s.trashPath())
+	if os.IsNotExist(err) {
+		return trash, nil
+	}
+	if err != nil {
+		return nil, unavailable(err)
+	}
+
+	if err := json.Unmarshal(b, &trash); err != nil {
+		return nil, unavailable(err)
+	}
+	return trash, nil
+}
+
+// saveTrash replaces the trashed files. Called with s.mu held.
+func (s *StorageData) saveTrash(trash map[string]TrashedFile) error {
+	b, err := json.MarshalIndent(trash, "", "	")
+	if err != nil {
+		return unavailable(err)
+	}
+	if err := writeFileAtomic(s.trashPath(), b); err != nil {
+		// The counts are read again along with what made it to disk.
+		s.countTrashRefs(nil)
+		return unavailable(err)
+	}
+
+	s.countTrashRefs(trash)
+	return nil
+}
+
+// trashedRefs counts the references trashed entries hold to hash. Called
+// with s.mu held.
+func (s *StorageData) trashedRefs(hash string) (int, error) {
+	s.refsMu.Lock()
+	defer s.refsMu.Unlock()
+
+	if s.trashRefs == nil {
+		trash, err := s.readTrash()
+		if err != nil {
+			return 0, err
+		}
+		s.trashRefs = trashRefs(trash)
+	}
+	return s.trashRefs[hash], nil
+}
+
+// countTrashRefs replaces the counts of trashedRefs with those of trash, or
+// has them read again for a nil trash.
+func (s *StorageData) countTrashRefs(trash map[string]TrashedFile) {
+	s.refsMu.Lock()
+	defer s.refsMu.Unlock()
+
+	s.trashRefs = nil
+	if trash != nil {
+		s.trashRefs = trashRefs(trash)
+	}
+}
+
+func trashRefs(trash map[string]TrashedFile) map[string]int {
+	refs := make(map[string]int)
+	for _, item := range trash {
+		for _, hash := range item.File.hashes() {
+			refs[hash]++
+		}
+	}
+	return refs
+}
+
+// trashed reports whether id belongs to a file in the trash. Called with
+// s.mu held.
+func (s *StorageData) trashed(id string) (bool, error) {
+	trash, err := s.readTrash()
+	if err != nil {
+		return false, err
+	}
+	_, ok := trash[id]
+	return ok, nil
+}
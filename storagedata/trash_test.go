@@ -0,0 +1,113 @@
+package storagedata_test
+
+import (
+	"americanas/storagedata"
+	"americanas/test"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestDeleteMovesToTrash(t *testing.T) {
+	testCase := "TestDeleteMovesToTrash"
+
+	f := setup(t)
+	defer f.close()
+
+	_, mars, _ := upload(f, "space", "mars.png", "red", "")
+	before := time.Now()
+	status, err := f.sd.DeleteByID(mars.ID)
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+	test.AssertNoError(t, testCase, err)
+
+	status, _, err = f.sd.ByID(mars.ID)
+	test.AssertEqual(t, testCase, status, http.StatusNotFound)
+	test.AssertEqual(t, testCase, blobExists(f, mars.SHA256), true)
+
+	status, trash, err := f.sd.Trash()
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, len(trash), 1)
+	test.AssertEqual(t, testCase, trash[0].File.ID, mars.ID)
+	test.AssertEqual(t, testCase, trash[0].File.Path, "space/mars.png")
+	test.AssertEqual(t, testCase, trash[0].DeletedAt.Before(before), false)
+}
+
+func TestRestoreFile(t *testing.T) {
+	testCase := "TestRestoreFile"
+
+	f := setup(t)
+	defer f.close()
+
+	_, mars, _ := upload(f, "space", "mars.png", "red", "")
+	f.sd.DeleteByID(mars.ID)
+
+	// The same content uploaded again doesn't take the ID of the trashed file.
+	_, again, _ := upload(f, "space", "mars.png", "red", "")
+	test.AssertEqual(t, testCase, again.ID != mars.ID, true)
+
+	status, restored, err := f.sd.RestoreFile(mars.ID, "")
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, restored.ID, mars.ID)
+	test.AssertEqual(t, testCase, restored.Path, "space/mars (1).png")
+
+	_, _, entry, _ := f.sd.OpenByID(mars.ID)
+	test.AssertEqual(t, testCase, entry.SHA256, mars.SHA256)
+	_, trash, _ := f.sd.Trash()
+	test.AssertEqual(t, testCase, len(trash), 0)
+
+	status, _, err = f.sd.RestoreFile(mars.ID, "")
+	test.AssertEqual(t, testCase, status, http.StatusNotFound)
+	test.AssertEqual(t, testCase, errors.Is(err, storagedata.ErrNotFound), true)
+
+	f.sd.DeleteByID(mars.ID)
+	status, _, err = f.sd.RestoreFile(mars.ID, storagedata.ConflictFail)
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+	test.AssertNoError(t, testCase, err)
+}
+
+func TestPurgeTrash(t *testing.T) {
+	testCase := "TestPurgeTrash"
+
+	f := setup(t, storagedata.WithTrashRetention(time.Millisecond))
+	defer f.close()
+
+	_, mars, _ := upload(f, "space", "mars.png", "red", "")
+	_, venus, _ := upload(f, "space", "venus.png", "yellow", "")
+	f.sd.DeleteByID(mars.ID)
+	f.sd.DeleteByID(venus.ID)
+
+	status, err := f.sd.PurgeTrash(venus.ID)
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, blobExists(f, venus.SHA256), false)
+
+	status, err = f.sd.PurgeTrash(venus.ID)
+	test.AssertEqual(t, testCase, status, http.StatusNotFound)
+
+	time.Sleep(5 * time.Millisecond)
+	purged, err := f.sd.PurgeExpiredTrash()
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, purged, 1)
+	test.AssertEqual(t, testCase, blobExists(f, mars.SHA256), false)
+
+	_, trash, _ := f.sd.Trash()
+	test.AssertEqual(t, testCase, len(trash), 0)
+}
+
+func TestPurgeExpiredTrashKeepsRecent(t *testing.T) {
+	testCase := "TestPurgeExpiredTrashKeepsRecent"
+
+	f := setup(t)
+	defer f.close()
+
+	_, mars, _ := upload(f, "space", "mars.png", "red", "")
+	f.sd.DeleteByID(mars.ID)
+
+	purged, err := f.sd.PurgeExpiredTrash()
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, purged, 0)
+	test.AssertEqual(t, testCase, blobExists(f, mars.SHA256), true)
+}
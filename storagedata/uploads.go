@@ -0,0 +1,362 @@
+package storagedata
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Upload is a resumable upload session. Its bytes are appended to
+// uploads/<id>.part and, once Offset reaches Length, stored like any other
+// file; FileID then holds the ID of the stored file.
+type Upload struct {
+	ID        string            `json:"id"`
+	Length    int64             `json:"length"`
+	Offset    int64             `json:"offset"`
+	Metadata  map[string]string `json:"metadata"`
+	CreatedAt time.Time         `json:"createdAt"`
+	ExpiresAt time.Time         `json:"expiresAt"`
+	FileID    string            `json:"fileId,omitempty"`
+}
+
+const defaultUploadExpiry = 24 * time.Hour
+
+// WithUploadExpiry sets how long an upload session lives after its last
+// chunk before it is garbage collected.
+func WithUploadExpiry(d time.Duration) Option {
+	return func(s *StorageData) {
+		s.uploadExpiry = d
+	}
+}
+
+// CreateUpload starts a resumable upload of length bytes. metadata must hold
+// the "path" and "filename" of the file; "filetype", "conflict" and the
+// "owner" recorded on the file are optional.
+func (s *StorageData) CreateUpload(length int64, metadata map[string]string) (int, Upload, error) {
+	if length < 0 {
+		return http.StatusBadRequest, Upload{}, fmt.Errorf("invalid upload length: %w", ErrInvalidRequest)
+	}
+
+	var fieldsMissing []string
+	for _, field := range []string{"path", "filename"} {
+		if metadata[field] == "" {
+			fieldsMissing = append(fieldsMissing, field)
+		}
+	}
+	if len(fieldsMissing) > 0 {
+		return http.StatusBadRequest, Upload{}, fmt.Errorf("missing metadata %s: %w", strings.Join(fieldsMissing, ", "), ErrInvalidRequest)
+	}
+	dir, err := CleanPath(metadata["path"])
+	if err != nil {
+		return http.StatusBadRequest, Upload{}, err
+	}
+	if _, err := CleanName(metadata["filename"]); err != nil {
+		return http.StatusBadRequest, Upload{}, err
+	}
+	if err := ConflictPolicy(metadata["conflict"]).check(); err != nil {
+		return http.StatusBadRequest, Upload{}, err
+	}
+	// The length is known up front, so an upload that can't fit is refused
+	// before any of it is sent. It is checked again once complete.
+	if err := s.precheckQuotas(metadata["owner"], dir, length, ""); err != nil {
+		return statusOf(err), Upload{}, err
+	}
+
+	id, err := newUploadID()
+	if err != nil {
+		return http.StatusServiceUnavailable, Upload{}, unavailable(err)
+	}
+
+	err = os.MkdirAll(s.uploadDir(), os.ModePerm)
+	if err != nil {
+		return http.StatusServiceUnavailable, Upload{}, unavailable(err)
+	}
+
+	part, err := os.OpenFile(s.uploadPartPath(id), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return http.StatusServiceUnavailable, Upload{}, unavailable(err)
+	}
+	part.Close()
+
+	now := time.Now()
+	upload := Upload{
+		ID:        id,
+		Length:    length,
+		Metadata:  metadata,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.uploadExpiry),
+	}
+
+	unlock := s.uploads.lock(id)
+	defer unlock()
+
+	err = s.saveUpload(upload)
+	if err != nil {
+		return http.StatusServiceUnavailable, Upload{}, unavailable(err)
+	}
+
+	if length == 0 {
+		return s.finishUpload(upload)
+	}
+
+	return http.StatusCreated, upload, nil
+}
+
+func (s *StorageData) GetUpload(id string) (int, Upload, error) {
+	unlock := s.uploads.lock(id)
+	defer unlock()
+
+	return s.loadUpload(id)
+}
+
+// WriteUpload appends the content of r to the upload, which must currently
+// be at offset. Whatever was received is kept even if r fails midway, so the
+// client can resume from the offset reported by GetUpload.
+func (s *StorageData) WriteUpload(id string, offset int64, r io.Reader) (int, Upload, error) {
+	unlock := s.uploads.lock(id)
+	defer unlock()
+
+	status, upload, err := s.loadUpload(id)
+	if err != nil {
+		return status, upload, err
+	}
+	if upload.FileID != "" {
+		return http.StatusConflict, upload, fmt.Errorf("upload already completed: %w", ErrConflict)
+	}
+	if offset != upload.Offset {
+		return http.StatusConflict, upload, fmt.Errorf("upload is at offset %d, not %d: %w", upload.Offset, offset, ErrConflict)
+	}
+
+	part, err := os.OpenFile(s.uploadPartPath(id), os.O_WRONLY, 0644)
+	if err != nil {
+		return http.StatusServiceUnavailable, upload, unavailable(err)
+	}
+	defer part.Close()
+
+	// Drop anything past the committed offset left by an interrupted write.
+	if err := part.Truncate(upload.Offset); err != nil {
+		return http.StatusServiceUnavailable, upload, unavailable(err)
+	}
+	if _, err := part.Seek(upload.Offset, io.SeekStart); err != nil {
+		return http.StatusServiceUnavailable, upload, unavailable(err)
+	}
+
+	src := &sourceReader{r: io.LimitReader(r, upload.Length-upload.Offset)}
+	n, copyErr := io.Copy(part, src)
+	if copyErr != nil {
+		if src.err != nil {
+			copyErr = WrapError(ErrInvalidRequest, copyErr)
+		} else {
+			copyErr = unavailable(copyErr)
+		}
+	}
+	if err := part.Sync(); err != nil && copyErr == nil {
+		copyErr = unavailable(err)
+	}
+
+	upload.Offset += n
+	upload.ExpiresAt = time.Now().Add(s.uploadExpiry)
+	if copyErr == nil && upload.Offset == upload.Length {
+		return s.finishUpload(upload)
+	}
+
+	err = s.saveUpload(upload)
+	if err != nil {
+		return http.StatusServiceUnavailable, upload, unavailable(err)
+	}
+	if copyErr != nil {
+		return statusOf(copyErr), upload, copyErr
+	}
+
+	return http.StatusOK, upload, nil
+}
+
+func (s *StorageData) DeleteUpload(id string) (int, error) {
+	unlock := s.uploads.lock(id)
+	defer unlock()
+
+	status, _, err := s.loadUpload(id)
+	if err != nil {
+		return status, err
+	}
+
+	err = s.removeUpload(id)
+	if err != nil {
+		return http.StatusServiceUnavailable, unavailable(err)
+	}
+
+	return http.StatusOK, nil
+}
+
+// PurgeExpiredUploads removes every upload session past its expiry and
+// returns how many were removed.
+func (s *StorageData) PurgeExpiredUploads() (int, error) {
+	infos, err := filepath.Glob(filepath.Join(s.uploadDir(), "*.json"))
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	now := time.Now()
+	for _, info := range infos {
+		id := strings.TrimSuffix(filepath.Base(info), ".json")
+
+		unlock := s.uploads.lock(id)
+		upload, err := s.readUpload(id)
+		if err != nil || !now.After(upload.ExpiresAt) {
+			unlock()
+			continue
+		}
+		err = s.removeUpload(id)
+		unlock()
+
+		if err != nil && !os.IsNotExist(err) {
+			return purged, err
+		}
+		purged++
+	}
+
+	return purged, nil
+}
+
+// finishUpload stores the received bytes as a file and keeps the session,
+// now pointing at the new file ID, until it expires. Called with the upload
+// lock held.
+func (s *StorageData) finishUpload(upload Upload) (int, Upload, error) {
+	part, err := os.Open(s.uploadPartPath(upload.ID))
+	if err != nil {
+		return http.StatusServiceUnavailable, upload, unavailable(err)
+	}
+	defer part.Close()
+
+	req := UploadRequest{
+		Path:        upload.Metadata["path"],
+		Name:        upload.Metadata["filename"],
+		ContentType: upload.Metadata["filetype"],
+		Content:     part,
+		Conflict:    ConflictPolicy(upload.Metadata["conflict"]),
+		Owner:       upload.Metadata["owner"],
+		Size:        upload.Length,
+	}
+	status, entry, err := s.StorageFile(req)
+	if err != nil {
+		return status, upload, err
+	}
+
+	upload.FileID = entry.ID
+	err = s.saveUpload(upload)
+	if err != nil {
+		return http.StatusServiceUnavailable, upload, unavailable(err)
+	}
+	os.Remove(s.uploadPartPath(upload.ID))
+
+	return http.StatusCreated, upload, nil
+}
+
+// loadUpload is GetUpload without the lock; expired sessions are reported as
+// missing even before the purge gets to them.
+func (s *StorageData) loadUpload(id string) (int, Upload, error) {
+	upload, err := s.readUpload(id)
+	if os.IsNotExist(err) || (err == nil && time.Now().After(upload.ExpiresAt)) {
+		return http.StatusNotFound, Upload{}, fmt.Errorf("upload %s: %w", id, ErrNotFound)
+	}
+	if err != nil {
+		return http.StatusServiceUnavailable, Upload{}, unavailable(err)
+	}
+
+	return http.StatusOK, upload, nil
+}
+
+// janitor purges expired uploads and trashed files past the retention until
+// Close is called.
+func (s *StorageData) janitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if _, err := s.PurgeExpiredUploads(); err != nil {
+				fmt.Printf("[janitor] Error purging uploads: %v\n", err)
+			}
+			if s.trashRetention > 0 {
+				if _, err := s.PurgeExpiredTrash(); err != nil {
+					fmt.Printf("[janitor] Error purging trash: %v\n", err)
+				}
+			}
+		}
+	}
+}
+
+func (s *StorageData) readUpload(id string) (Upload, error) {
+	var upload Upload
+	if !validUploadID(id) {
+		return upload, os.ErrNotExist
+	}
+
+	data, err := ioutil.ReadFile(s.uploadInfoPath(id))
+	if err != nil {
+		return upload, err
+	}
+
+	err = json.Unmarshal(data, &upload)
+	return upload, err
+}
+
+func (s *StorageData) saveUpload(upload Upload) error {
+	data, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(s.uploadInfoPath(upload.ID), data)
+}
+
+func (s *StorageData) removeUpload(id string) error {
+	err := os.Remove(s.uploadPartPath(id))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return os.Remove(s.uploadInfoPath(id))
+}
+
+func newUploadID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// validUploadID keeps client supplied IDs from naming files outside the
+// uploads directory.
+func validUploadID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+func (s *StorageData) uploadDir() string {
+	return filepath.Join(s.root, "uploads")
+}
+
+func (s *StorageData) uploadInfoPath(id string) string {
+	return filepath.Join(s.uploadDir(), id+".json")
+}
+
+func (s *StorageData) uploadPartPath(id string) string {
+	return filepath.Join(s.uploadDir(), id+".part")
+}
@@ -0,0 +1,72 @@
+package storagedata_test
+
+import (
+	"americanas/storagedata"
+	"americanas/test"
+	"bytes"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestResumableUpload(t *testing.T) {
+	testCase := "TestResumableUpload"
+
+	f := setup(t)
+	defer f.close()
+	m := createFile("mars.png")
+	content := m.Bytes()
+	half := int64(len(content) / 2)
+
+	status, upload, err := f.sd.CreateUpload(int64(len(content)), map[string]string{
+		"path":     "space/planets",
+		"filename": "mars.png",
+		"filetype": "png",
+	})
+	test.AssertEqual(t, testCase, status, http.StatusCreated)
+	test.AssertNoError(t, testCase, err)
+
+	status, upload, err = f.sd.WriteUpload(upload.ID, 0, bytes.NewReader(content[:half]))
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, upload.Offset, half)
+
+	status, _, err = f.sd.WriteUpload(upload.ID, 0, bytes.NewReader(content))
+	test.AssertEqual(t, testCase, status, http.StatusConflict)
+	test.AssertError(t, testCase, err)
+
+	status, upload, err = f.sd.GetUpload(upload.ID)
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+	test.AssertEqual(t, testCase, upload.Offset, half)
+
+	status, upload, err = f.sd.WriteUpload(upload.ID, half, bytes.NewReader(content[half:]))
+	test.AssertEqual(t, testCase, status, http.StatusCreated)
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, upload.Offset, int64(len(content)))
+
+	status, actual, err := f.sd.ByID(upload.FileID)
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+	test.AssertEqual(t, testCase, actual.Path, "space/planets/mars.png")
+	test.AssertEqual(t, testCase, actual.Size, int64(len(content)))
+
+	f.sd.DeleteByID(upload.FileID)
+	f.sd.DeleteUpload(upload.ID)
+}
+
+func TestPurgeExpiredUploads(t *testing.T) {
+	testCase := "TestPurgeExpiredUploads"
+
+	f := setup(t, storagedata.WithUploadExpiry(time.Millisecond))
+	defer f.close()
+
+	_, upload, err := f.sd.CreateUpload(10, map[string]string{"path": "space", "filename": "pluto.png"})
+	test.AssertNoError(t, testCase, err)
+	time.Sleep(5 * time.Millisecond)
+
+	status, _, _ := f.sd.GetUpload(upload.ID)
+	test.AssertEqual(t, testCase, status, http.StatusNotFound)
+
+	purged, err := f.sd.PurgeExpiredUploads()
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, purged, 1)
+}
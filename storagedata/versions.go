@@ -0,0 +1,257 @@
+package storagedata
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+)
+
+// OverwriteFile keeps the content it replaces as a version of the entry.
+// Versions share the blob store with current contents, so a blob is only
+// removed once no entry references it as either.
+
+// FileVersion is one content a file has had.
+type FileVersion struct {
+	Version     int       `json:"version"`
+	Name        string    `json:"name"`
+	ContentType string    `json:"type"`
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"modificationTime"`
+	SHA256      string    `json:"sha256"`
+
+	// Current is only set in the result of Versions.
+	Current bool `json:"current,omitempty"`
+}
+
+// currentVersion is the number of the content entry holds now. Entries
+// written before versions were kept are at version 1.
+func (entry FileMetadata) currentVersion() int {
+	if entry.Version == 0 {
+		return 1
+	}
+	return entry.Version
+}
+
+func (entry FileMetadata) asVersion() FileVersion {
+	return FileVersion{
+		Version:     entry.currentVersion(),
+		Name:        entry.Name,
+		ContentType: entry.ContentType,
+		Size:        entry.Size,
+		ModTime:     entry.ModTime,
+		SHA256:      entry.SHA256,
+	}
+}
+
+// hashes returns every blob entry references.
+func (entry FileMetadata) hashes() []string {
+	var hashes []string
+	if entry.SHA256 != "" {
+		hashes = append(hashes, entry.SHA256)
+	}
+	for _, v := range entry.Versions {
+		hashes = append(hashes, v.SHA256)
+	}
+	return hashes
+}
+
+// Versions lists every content of the file id, newest first.
+func (s *StorageData) Versions(id string) (int, []FileVersion, error) {
+	entry, err := s.getEntry(id)
+	if err != nil {
+		return statusOf(err), nil, err
+	}
+
+	current := entry.asVersion()
+	current.Current = true
+	versions := []FileVersion{current}
+	for i := len(entry.Versions) - 1; i >= 0; i-- {
+		versions = append(versions, entry.Versions[i])
+	}
+	return http.StatusOK, versions, nil
+}
+
+// OpenVersion opens the content the file id had at version. The returned
+// entry describes that version.
+func (s *StorageData) OpenVersion(id string, version int) (int, *os.File, FileMetadata, error) {
+	entry, err := s.getEntry(id)
+	if err != nil {
+		return statusOf(err), nil, FileMetadata{}, err
+	}
+
+	if version != entry.currentVersion() {
+		v, err := findVersion(entry, version)
+		if err != nil {
+			return statusOf(err), nil, FileMetadata{}, err
+		}
+		entry.Name = v.Name
+		entry.ContentType = v.ContentType
+		entry.Size = v.Size
+		entry.ModTime = v.ModTime
+		entry.SHA256 = v.SHA256
+		entry.Version = v.Version
+	}
+	entry.Versions = nil
+
+	file, err := s.openContent(entry)
+	if err != nil {
+		return statusOf(err), nil, FileMetadata{}, err
+	}
+	return http.StatusOK, file, entry, nil
+}
+
+// RestoreVersion makes the content of version current again. The content it
+// replaces is kept as a version, like with OverwriteFile.
+func (s *StorageData) RestoreVersion(id string, version int) (int, FileMetadata, error) {
+	unlock := s.ids.lock(id)
+	defer unlock()
+
+	entry, err := s.getEntry(id)
+	if err != nil {
+		return statusOf(err), FileMetadata{}, err
+	}
+	if version == entry.currentVersion() {
+		return http.StatusOK, entry, nil
+	}
+	restored, err := findVersion(entry, version)
+	if err != nil {
+		return statusOf(err), FileMetadata{}, err
+	}
+
+	entry, err = s.migrateLegacy(entry)
+	if err != nil {
+		return statusOf(err), FileMetadata{}, err
+	}
+
+	unlockBlob := s.blobs.lock(restored.SHA256)
+	defer unlockBlob()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The entry is read again under mu so a directory moved meanwhile
+	// isn't undone.
+	entry, err = s.lookup(id)
+	if err != nil {
+		return statusOf(err), FileMetadata{}, err
+	}
+	if err := s.checkQuotas(entry.Owner, parentDir(entry.Path), restored.Size, id); err != nil {
+		return statusOf(err), FileMetadata{}, err
+	}
+
+	entry.Versions = append(entry.Versions, entry.asVersion())
+	entry.Version = entry.currentVersion() + 1
+	entry.ContentType = restored.ContentType
+	entry.Size = restored.Size
+	entry.SHA256 = restored.SHA256
+	entry.ModTime = time.Now()
+	if err := s.store.Put(id, entry); err != nil {
+		return http.StatusServiceUnavailable, FileMetadata{}, unavailable(err)
+	}
+	return http.StatusOK, entry, nil
+}
+
+// PruneVersions deletes the previous versions of id beyond the keep newest
+// ones, or older than olderThan. A zero keep or olderThan doesn't limit. The
+// deleted versions are returned.
+func (s *StorageData) PruneVersions(id string, keep int, olderThan time.Duration) (int, []FileVersion, error) {
+	if keep < 0 || olderThan < 0 || (keep == 0 && olderThan == 0) {
+		return http.StatusBadRequest, nil, fmt.Errorf("expected a count or an age to keep: %w", ErrInvalidRequest)
+	}
+
+	unlock := s.ids.lock(id)
+	defer unlock()
+
+	s.mu.Lock()
+	entry, err := s.lookup(id)
+	if err != nil {
+		s.mu.Unlock()
+		return statusOf(err), nil, err
+	}
+
+	var kept []FileVersion
+	pruned := make([]FileVersion, 0)
+	cutoff := time.Now().Add(-olderThan)
+	for i, v := range entry.Versions {
+		newer := len(entry.Versions) - i
+		if (keep > 0 && newer > keep) || (olderThan > 0 && v.ModTime.Before(cutoff)) {
+			pruned = append(pruned, v)
+		} else {
+			kept = append(kept, v)
+		}
+	}
+	entry.Versions = kept
+	err = unavailable(s.store.Put(id, entry))
+	s.mu.Unlock()
+	if err != nil {
+		return http.StatusServiceUnavailable, nil, err
+	}
+
+	for _, v := range pruned {
+		s.releaseHash(v.SHA256)
+	}
+	sort.Slice(pruned, func(i, j int) bool {
+		return pruned[i].Version > pruned[j].Version
+	})
+	return http.StatusOK, pruned, nil
+}
+
+func findVersion(entry FileMetadata, version int) (FileVersion, error) {
+	for _, v := range entry.Versions {
+		if v.Version == version {
+			return v, nil
+		}
+	}
+	return FileVersion{}, fmt.Errorf("version %d of %s: %w", version, entry.ID, ErrNotFound)
+}
+
+// migrateLegacy moves the content of an entry stored before blobs existed
+// into the blob store, so it can be kept as a version. Other entries are
+// returned as they are. Called with the ID lock held.
+func (s *StorageData) migrateLegacy(entry FileMetadata) (FileMetadata, error) {
+	if entry.SHA256 != "" {
+		return entry, nil
+	}
+
+	legacyPath := s.contentPath(entry)
+	file, err := os.Open(legacyPath)
+	if os.IsNotExist(err) {
+		return FileMetadata{}, fmt.Errorf("content of %s: %w", entry.ID, ErrNotFound)
+	}
+	if err != nil {
+		return FileMetadata{}, unavailable(err)
+	}
+	hash, _, unlockBlob, err := s.saveBlob(file, Digest{})
+	file.Close()
+	if err != nil {
+		return FileMetadata{}, err
+	}
+	defer unlockBlob()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, err = s.lookup(entry.ID)
+	if err != nil {
+		return FileMetadata{}, err
+	}
+	entry.SHA256 = hash
+	if err := s.store.Put(entry.ID, entry); err != nil {
+		return FileMetadata{}, unavailable(err)
+	}
+
+	os.Remove(legacyPath)
+	return entry, nil
+}
+
+// releaseHash removes the blob of hash if nothing references it anymore.
+// Called without s.mu or any blob lock held.
+func (s *StorageData) releaseHash(hash string) {
+	unlock := s.blobs.lock(hash)
+	defer unlock()
+
+	if err := s.releaseBlob(hash); err != nil {
+		fmt.Printf("[releaseHash] Error releasing blob %s. Error: %s", hash, err)
+	}
+}
@@ -0,0 +1,177 @@
+package storagedata_test
+
+import (
+	"americanas/storagedata"
+	"americanas/test"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func overwrite(f *fixture, entry storagedata.FileMetadata, content string) storagedata.FileMetadata {
+	_, entry, _ = f.sd.OverwriteFile(entry.ID, uploadRequest(filepath.Dir(entry.Path), entry.Name, *bytes.NewBufferString(content)))
+	return entry
+}
+
+func readVersion(t *testing.T, f *fixture, id string, version int) string {
+	_, file, _, err := f.sd.OpenVersion(id, version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	content, _ := ioutil.ReadAll(file)
+	return string(content)
+}
+
+func blobExists(f *fixture, hash string) bool {
+	_, err := os.Stat(filepath.Join(f.dir, "blobs", hash[:2], hash))
+	return err == nil
+}
+
+func TestOverwriteKeepsVersions(t *testing.T) {
+	testCase := "TestOverwriteKeepsVersions"
+
+	f := setup(t)
+	defer f.close()
+
+	_, mars, _ := upload(f, "space", "mars.png", "first", "")
+	overwrite(f, mars, "second")
+	mars = overwrite(f, mars, "third")
+	test.AssertEqual(t, testCase, mars.Version, 3)
+	test.AssertEqual(t, testCase, len(mars.Versions), 2)
+
+	status, versions, err := f.sd.Versions(mars.ID)
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+	test.AssertEqual(t, testCase, len(versions), 3)
+	test.AssertEqual(t, testCase, versions[0].Version, 3)
+	test.AssertEqual(t, testCase, versions[0].Current, true)
+	test.AssertEqual(t, testCase, versions[2].Version, 1)
+
+	test.AssertEqual(t, testCase, readVersion(t, f, mars.ID, 1), "first")
+	test.AssertEqual(t, testCase, readVersion(t, f, mars.ID, 2), "second")
+	test.AssertEqual(t, testCase, readVersion(t, f, mars.ID, 3), "third")
+
+	status, _, _, err = f.sd.OpenVersion(mars.ID, 7)
+	test.AssertEqual(t, testCase, status, http.StatusNotFound)
+	test.AssertEqual(t, testCase, errors.Is(err, storagedata.ErrNotFound), true)
+
+	// Purging the deleted file releases the blobs of every version.
+	hashes := []string{mars.SHA256, mars.Versions[0].SHA256, mars.Versions[1].SHA256}
+	f.sd.DeleteByID(mars.ID)
+	for _, hash := range hashes {
+		test.AssertEqual(t, testCase, blobExists(f, hash), true)
+	}
+	f.sd.PurgeTrash(mars.ID)
+	for _, hash := range hashes {
+		test.AssertEqual(t, testCase, blobExists(f, hash), false)
+	}
+}
+
+func TestRestoreVersion(t *testing.T) {
+	testCase := "TestRestoreVersion"
+
+	f := setup(t)
+	defer f.close()
+
+	_, mars, _ := upload(f, "space", "mars.png", "first", "")
+	overwrite(f, mars, "second")
+
+	status, restored, err := f.sd.RestoreVersion(mars.ID, 1)
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+	test.AssertEqual(t, testCase, restored.Version, 3)
+	test.AssertEqual(t, testCase, restored.SHA256, mars.SHA256)
+	test.AssertEqual(t, testCase, readVersion(t, f, mars.ID, 3), "first")
+	test.AssertEqual(t, testCase, readVersion(t, f, mars.ID, 2), "second")
+
+	status, _, err = f.sd.RestoreVersion(mars.ID, 9)
+	test.AssertEqual(t, testCase, status, http.StatusNotFound)
+	test.AssertEqual(t, testCase, errors.Is(err, storagedata.ErrNotFound), true)
+}
+
+func TestPruneVersions(t *testing.T) {
+	testCase := "TestPruneVersions"
+
+	f := setup(t)
+	defer f.close()
+
+	_, mars, _ := upload(f, "space", "mars.png", "v1", "")
+	for _, content := range []string{"v2", "v3", "v4", "v5"} {
+		mars = overwrite(f, mars, content)
+	}
+	first := mars.Versions[0].SHA256
+
+	status, pruned, err := f.sd.PruneVersions(mars.ID, 2, 0)
+	test.AssertNoError(t, testCase, err)
+	test.AssertEqual(t, testCase, status, http.StatusOK)
+	test.AssertEqual(t, testCase, len(pruned), 2)
+	test.AssertEqual(t, testCase, pruned[0].Version, 2)
+	test.AssertEqual(t, testCase, blobExists(f, first), false)
+
+	_, versions, _ := f.sd.Versions(mars.ID)
+	test.AssertEqual(t, testCase, len(versions), 3)
+	test.AssertEqual(t, testCase, readVersion(t, f, mars.ID, 4), "v4")
+
+	_, pruned, _ = f.sd.PruneVersions(mars.ID, 0, time.Hour)
+	test.AssertEqual(t, testCase, len(pruned), 0)
+	_, pruned, _ = f.sd.PruneVersions(mars.ID, 0, time.Nanosecond)
+	test.AssertEqual(t, testCase, len(pruned), 2)
+	_, versions, _ = f.sd.Versions(mars.ID)
+	test.AssertEqual(t, testCase, len(versions), 1)
+	test.AssertEqual(t, testCase, readVersion(t, f, mars.ID, 5), "v5")
+
+	status, _, err = f.sd.PruneVersions(mars.ID, 0, 0)
+	test.AssertEqual(t, testCase, status, http.StatusBadRequest)
+	test.AssertEqual(t, testCase, errors.Is(err, storagedata.ErrInvalidRequest), true)
+}
+
+func TestSharedBlobOutlivesVersion(t *testing.T) {
+	testCase := "TestSharedBlobOutlivesVersion"
+
+	f := setup(t)
+	defer f.close()
+
+	// The first content of mars is also the content of venus.
+	_, mars, _ := upload(f, "space", "mars.png", "red", "")
+	_, venus, _ := upload(f, "space", "venus.png", "red", "")
+	overwrite(f, mars, "dusty")
+
+	f.sd.DeleteByID(venus.ID)
+	f.sd.PurgeTrash(venus.ID)
+	test.AssertEqual(t, testCase, readVersion(t, f, mars.ID, 1), "red")
+
+	f.sd.PruneVersions(mars.ID, 0, time.Nanosecond)
+	test.AssertEqual(t, testCase, blobExists(f, venus.SHA256), false)
+}
+
+func TestOverwriteLegacyKeepsVersion(t *testing.T) {
+	testCase := "TestOverwriteLegacyKeepsVersion"
+
+	dir, err := ioutil.TempDir("", "storagedata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fixture{dir: dir}
+	os.MkdirAll(filepath.Join(dir, "space"), os.ModePerm)
+	ioutil.WriteFile(filepath.Join(dir, "space/mars.png"), []byte("legacy"), 0644)
+
+	store := storagedata.NewJSONStore(filepath.Join(dir, "metadata.json"))
+	store.Put("legacy", storagedata.FileMetadata{Name: "mars.png", Path: "space/mars.png", Size: 6, ModTime: time.Now()})
+	f.sd = storagedata.New(storagedata.WithRoot(dir), storagedata.WithMetadataStore(store))
+	defer f.close()
+
+	_, mars, _ := f.sd.ByID("legacy")
+	mars = overwrite(f, mars, "new")
+	test.AssertEqual(t, testCase, mars.Version, 2)
+	test.AssertEqual(t, testCase, readVersion(t, f, "legacy", 1), "legacy")
+	test.AssertEqual(t, testCase, readVersion(t, f, "legacy", 2), "new")
+
+	_, err = os.Stat(filepath.Join(dir, "space/mars.png"))
+	test.AssertEqual(t, testCase, os.IsNotExist(err), true)
+}